@@ -1,4 +1,10 @@
+use std::path::PathBuf;
+
 fn main() -> Result<(), Box<dyn std::error::Error>> {
-    tonic_build::compile_protos("../proto/auth.proto")?;
+    // descriptor set is served by reflection service
+    let out_dir = PathBuf::from(std::env::var("OUT_DIR")?);
+    tonic_build::configure()
+        .file_descriptor_set_path(out_dir.join("auth_descriptor.bin"))
+        .compile(&["../proto/auth.proto"], &["../proto"])?;
     Ok(())
 }
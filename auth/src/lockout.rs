@@ -0,0 +1,318 @@
+use std::collections::HashMap;
+use std::sync::Mutex;
+use std::time::{Duration, Instant};
+
+use once_cell::sync::Lazy;
+use prometheus::{register_int_counter_vec, IntCounterVec};
+
+use crate::duration_var;
+
+/// Entries above this number are pruned of forgotten failures, so logins
+/// with random user names do not grow the map without bound.
+const MAX_ENTRIES: usize = 10_000;
+
+static LOGIN_FAILURES: Lazy<IntCounterVec> = Lazy::new(|| {
+    register_int_counter_vec!(
+        "auth_login_failures_total",
+        "Total number of failed logins counted by key, user or ip.",
+        &["key"]
+    )
+    .unwrap()
+});
+
+static LOCKOUTS: Lazy<IntCounterVec> = Lazy::new(|| {
+    register_int_counter_vec!(
+        "auth_lockouts_total",
+        "Total number of users and client IPs locked out after failed logins.",
+        &["key"]
+    )
+    .unwrap()
+});
+
+static LOCKED_LOGINS: Lazy<IntCounterVec> = Lazy::new(|| {
+    register_int_counter_vec!(
+        "auth_locked_logins_total",
+        "Total number of logins rejected because user or client IP is locked out.",
+        &["key"]
+    )
+    .unwrap()
+});
+
+/// Key of failed logins: kind (user or ip) and its value.
+pub type Key = (&'static str, String);
+
+/// Returns keys of login of user from client IP. Logins without IP are
+/// counted by user only.
+pub fn login_keys(user: &str, client_ip: &str) -> Vec<Key> {
+    let mut keys = vec![("user", user.to_owned())];
+    if !client_ip.is_empty() {
+        keys.push(("ip", client_ip.to_owned()));
+    }
+    keys
+}
+
+struct Failures {
+    count: u32,
+    last: Instant,
+    locked_until: Option<Instant>,
+}
+
+/// Login is rejected until key is unlocked.
+pub struct Locked {
+    pub key: &'static str,
+    pub retry_after: Duration,
+}
+
+/// Counts failed logins per user and per client IP. Every failure delays the
+/// next login of the key twice as long, and after too many failures the key
+/// is locked out for a while. Failures are forgotten after lockout time
+/// without new ones. Counts are kept in memory of one auth instance.
+pub struct Lockouts {
+    max_user_failures: u32,
+    max_ip_failures: u32,
+    lockout: Duration,
+    delay: Duration,
+    max_delay: Duration,
+    entries: Mutex<HashMap<String, Failures>>,
+}
+
+impl Lockouts {
+    /// Reads LOGIN_MAX_FAILURES of user (5 by default), LOGIN_MAX_IP_FAILURES
+    /// of client IP (20 by default), LOGIN_LOCKOUT (15m by default) and
+    /// LOGIN_DELAY after the first failure (200ms by default, up to 5s).
+    pub fn from_env() -> Result<Self, Box<dyn std::error::Error>> {
+        Ok(Lockouts {
+            max_user_failures: count_var("LOGIN_MAX_FAILURES", 5)?,
+            max_ip_failures: count_var("LOGIN_MAX_IP_FAILURES", 20)?,
+            lockout: duration_var("LOGIN_LOCKOUT", Duration::from_secs(15 * 60))?,
+            delay: duration_var("LOGIN_DELAY", Duration::from_millis(200))?,
+            max_delay: Duration::from_secs(5),
+            entries: Mutex::new(HashMap::new()),
+        })
+    }
+
+    fn max_failures(&self, kind: &str) -> u32 {
+        if kind == "ip" {
+            self.max_ip_failures
+        } else {
+            self.max_user_failures
+        }
+    }
+
+    /// Returns delay of login with previous failures of keys, or Locked if
+    /// any key is locked out. Expired lockouts are forgotten.
+    pub fn check(&self, keys: &[Key]) -> Result<(u32, Duration), Locked> {
+        let now = Instant::now();
+        let mut entries = self.entries.lock().unwrap();
+        let mut failures = 0;
+        for (kind, value) in keys {
+            let entry = format!("{}:{}", kind, value);
+            let forget = match entries.get(&entry) {
+                Some(Failures {
+                    locked_until: Some(until),
+                    ..
+                }) if *until > now => {
+                    LOCKED_LOGINS.with_label_values(&[*kind]).inc();
+                    return Err(Locked {
+                        key: *kind,
+                        retry_after: *until - now,
+                    });
+                }
+                Some(f) => f.locked_until.is_some() || now - f.last > self.lockout,
+                None => false,
+            };
+            if forget {
+                entries.remove(&entry);
+            } else if let Some(f) = entries.get(&entry) {
+                failures = failures.max(f.count);
+            }
+        }
+        if failures == 0 {
+            return Ok((0, Duration::ZERO));
+        }
+        let delay = self
+            .delay
+            .saturating_mul(1 << (failures - 1).min(16))
+            .min(self.max_delay);
+        Ok((failures, delay))
+    }
+
+    /// Records failed login of keys and returns kind of key locked out by it.
+    pub fn fail(&self, keys: &[Key]) -> Option<&'static str> {
+        let now = Instant::now();
+        let mut entries = self.entries.lock().unwrap();
+        if entries.len() > MAX_ENTRIES {
+            let lockout = self.lockout;
+            entries.retain(|_, f| match f.locked_until {
+                Some(until) => until > now,
+                None => now - f.last <= lockout,
+            });
+        }
+        let mut locked = None;
+        for (kind, value) in keys {
+            LOGIN_FAILURES.with_label_values(&[*kind]).inc();
+            let f = entries
+                .entry(format!("{}:{}", kind, value))
+                .or_insert(Failures {
+                    count: 0,
+                    last: now,
+                    locked_until: None,
+                });
+            f.count += 1;
+            f.last = now;
+            if f.count >= self.max_failures(kind) && f.locked_until.is_none() {
+                f.locked_until = Some(now + self.lockout);
+                LOCKOUTS.with_label_values(&[*kind]).inc();
+                locked = Some(*kind);
+            }
+        }
+        locked
+    }
+
+    /// Forgets failures of user after successful login. Failures of client IP
+    /// are kept, so logins into own account do not reset them.
+    pub fn succeed(&self, keys: &[Key]) {
+        let mut entries = self.entries.lock().unwrap();
+        for (kind, value) in keys {
+            if *kind == "user" {
+                entries.remove(&format!("{}:{}", kind, value));
+            }
+        }
+    }
+}
+
+fn count_var(name: &str, default: u32) -> Result<u32, Box<dyn std::error::Error>> {
+    match std::env::var(name) {
+        Ok(value) if !value.is_empty() => Ok(value
+            .parse()
+            .map_err(|err| format!("invalid {}: {}", name, err))?),
+        _ => Ok(default),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn lockouts(lockout: Duration) -> Lockouts {
+        Lockouts {
+            max_user_failures: 3,
+            max_ip_failures: 5,
+            lockout,
+            delay: Duration::from_millis(100),
+            max_delay: Duration::from_millis(500),
+            entries: Mutex::new(HashMap::new()),
+        }
+    }
+
+    fn delay(l: &Lockouts, keys: &[Key]) -> (u32, Duration) {
+        match l.check(keys) {
+            Ok(delay) => delay,
+            Err(locked) => panic!("{} is locked out", locked.key),
+        }
+    }
+
+    fn locked(l: &Lockouts, keys: &[Key]) -> Locked {
+        match l.check(keys) {
+            Ok((failures, _)) => panic!("not locked out after {} failures", failures),
+            Err(locked) => locked,
+        }
+    }
+
+    #[test]
+    fn login_keys_without_client_ip() {
+        assert_eq!(login_keys("alice", ""), vec![("user", "alice".to_owned())]);
+        assert_eq!(
+            login_keys("alice", "203.0.113.1"),
+            vec![
+                ("user", "alice".to_owned()),
+                ("ip", "203.0.113.1".to_owned())
+            ]
+        );
+    }
+
+    #[test]
+    fn delay_doubles_up_to_max() {
+        let l = Lockouts {
+            max_user_failures: 10,
+            ..lockouts(Duration::from_secs(60))
+        };
+        let keys = login_keys("alice", "");
+        assert_eq!(delay(&l, &keys), (0, Duration::ZERO));
+        for (failures, want) in [(1, 100), (2, 200), (3, 400), (4, 500), (5, 500)] {
+            assert_eq!(l.fail(&keys), None);
+            assert_eq!(delay(&l, &keys), (failures, Duration::from_millis(want)));
+        }
+    }
+
+    #[test]
+    fn user_is_locked_out_after_max_failures() {
+        let l = lockouts(Duration::from_secs(60));
+        let keys = login_keys("alice", "203.0.113.1");
+        assert_eq!(l.fail(&keys), None);
+        assert_eq!(l.fail(&keys), None);
+        assert_eq!(l.fail(&keys), Some("user"));
+
+        let lock = locked(&l, &keys);
+        assert_eq!(lock.key, "user");
+        assert!(lock.retry_after <= Duration::from_secs(60));
+        // the same user is locked out from another client IP too
+        assert_eq!(locked(&l, &login_keys("alice", "198.51.100.7")).key, "user");
+        // other users are not
+        assert_eq!(
+            delay(&l, &login_keys("bob", "198.51.100.7")),
+            (0, Duration::ZERO)
+        );
+    }
+
+    #[test]
+    fn client_ip_is_locked_out_after_max_ip_failures() {
+        let l = lockouts(Duration::from_secs(60));
+        for user in ["a", "b", "c", "d"] {
+            assert_eq!(l.fail(&login_keys(user, "203.0.113.1")), None);
+        }
+        assert_eq!(l.fail(&login_keys("e", "203.0.113.1")), Some("ip"));
+
+        assert_eq!(locked(&l, &login_keys("f", "203.0.113.1")).key, "ip");
+        assert_eq!(
+            delay(&l, &login_keys("f", "198.51.100.7")),
+            (0, Duration::ZERO)
+        );
+    }
+
+    #[test]
+    fn success_forgets_user_but_not_client_ip() {
+        let l = lockouts(Duration::from_secs(60));
+        let keys = login_keys("alice", "203.0.113.1");
+        l.fail(&keys);
+        l.fail(&keys);
+        l.succeed(&keys);
+
+        assert_eq!(delay(&l, &login_keys("alice", "")), (0, Duration::ZERO));
+        assert_eq!(delay(&l, &keys), (2, Duration::from_millis(200)));
+    }
+
+    #[test]
+    fn lockout_expires() {
+        let l = lockouts(Duration::from_millis(50));
+        let keys = login_keys("alice", "");
+        for _ in 0..3 {
+            l.fail(&keys);
+        }
+        assert_eq!(locked(&l, &keys).key, "user");
+
+        std::thread::sleep(Duration::from_millis(60));
+        // failures are forgotten with expired lockout
+        assert_eq!(delay(&l, &keys), (0, Duration::ZERO));
+        assert_eq!(l.fail(&keys), None);
+    }
+
+    #[test]
+    fn failures_are_forgotten_after_lockout_time() {
+        let l = lockouts(Duration::from_millis(50));
+        let keys = login_keys("alice", "");
+        l.fail(&keys);
+        std::thread::sleep(Duration::from_millis(60));
+        assert_eq!(delay(&l, &keys), (0, Duration::ZERO));
+    }
+}
@@ -1,25 +1,64 @@
 use auth::auth_server::{Auth, AuthServer};
-use auth::{LoginRequest, LoginResponse, ValidateRequest, ValidateResponse};
+use auth::{
+    ListRevokedRequest, ListRevokedResponse, LoginExternalRequest, LoginRequest, LoginResponse,
+    RefreshRequest,
+    RegisterRequest, RevokeRequest, RevokeResponse, RevokedToken, ValidateRequest,
+    ValidateResponse,
+};
+use argon2::password_hash::{rand_core::OsRng, PasswordHash, PasswordHasher, PasswordVerifier, SaltString};
+use argon2::Argon2;
+use hyper::service::{make_service_fn, service_fn};
+use jsonwebtoken::{decode, encode, Algorithm, DecodingKey, EncodingKey, Header, Validation};
 use once_cell::sync::Lazy;
+use opentelemetry::baggage::BaggageExt;
 use opentelemetry::global;
+use opentelemetry::propagation::TextMapCompositePropagator;
+use opentelemetry::sdk::propagation::{BaggagePropagator, TraceContextPropagator};
 use opentelemetry::trace::TraceError;
 use opentelemetry::{
     propagation::Extractor,
-    trace::{Span, Tracer},
+    trace::{Span, SpanContext, Tracer},
     KeyValue,
 };
+use prometheus::{
+    register_histogram_vec, register_int_counter_vec, Encoder, HistogramVec, IntCounterVec,
+    TextEncoder,
+};
 use prost_types::Timestamp;
+use serde::{Deserialize, Serialize};
+use sha2::{Digest, Sha256};
 use std::collections::HashMap;
+use std::convert::Infallible;
+use std::net::SocketAddr;
 use std::ops::Add;
-use std::time::{Duration, SystemTime};
-use tonic::{transport::Server, Request, Response, Status};
+use std::sync::{Arc, Mutex};
+use std::time::{Duration, Instant, SystemTime};
+use tonic::{
+    transport::{Certificate, Identity, Server, ServerTlsConfig},
+    Request, Response, Status,
+};
+use tonic_health::server::HealthReporter;
+use tonic_reflection::server::{ServerReflection, ServerReflectionServer};
 use uuid::Uuid;
 use r2d2_redis::{r2d2, redis::Commands, RedisConnectionManager};
 
 const APPLICATION_ID: &str = "auth";
 
+/// Roles of users in access tokens. Admins manage links of everyone, users
+/// manage only their own.
+const ROLE_ADMIN: &str = "admin";
+const ROLE_USER: &str = "user";
+
+mod lockout;
+mod ydb_sessions;
+use lockout::{login_keys, Key, Lockouts};
+use ydb_sessions::YdbSessions;
+
 pub mod auth {
     tonic::include_proto!("auth");
+
+    pub(crate) const FILE_DESCRIPTOR_SET: &[u8] =
+        tonic::include_file_descriptor_set!("auth_descriptor");
 }
 
 struct User<'a> {
@@ -38,16 +77,138 @@ const USERS: &'static [User] = &[
     },
 ];
 
-static PASSWORDS: Lazy<HashMap<String, String>> = Lazy::new(|| {
+/// Argon2id hashes of passwords of built-in users, registered users are kept
+/// in sessions store.
+static PASSWORD_HASHES: Lazy<HashMap<String, String>> = Lazy::new(|| {
     let mut map = HashMap::new();
 
     for user in USERS {
-        map.insert(user.name.to_owned(), user.password.to_owned());
+        map.insert(user.name.to_owned(), hash_password(user.password).unwrap());
     }
 
     map
 });
 
+/// Argon2id hash of random password, login of unknown user verifies against
+/// it to take as long as login of known one.
+static DUMMY_PASSWORD_HASH: Lazy<String> =
+    Lazy::new(|| hash_password(&Uuid::new_v4().hyphenated().to_string()).unwrap());
+
+const MIN_PASSWORD_LENGTH: usize = 8;
+const MAX_PASSWORD_LENGTH: usize = 128;
+
+/// Hashes password with argon2id and random salt, the PHC string keeps both.
+fn hash_password(password: &str) -> Result<String, argon2::password_hash::Error> {
+    let salt = SaltString::generate(&mut OsRng);
+    Ok(Argon2::default()
+        .hash_password(password.as_bytes(), &salt)?
+        .to_string())
+}
+
+fn verify_password(password: &str, hash: &str) -> bool {
+    PasswordHash::new(hash)
+        .map(|hash| {
+            Argon2::default()
+                .verify_password(password.as_bytes(), &hash)
+                .is_ok()
+        })
+        .unwrap_or(false)
+}
+
+/// Rejects user names which are not 3 to 32 lowercase letters, digits, dots,
+/// dashes or underscores.
+fn check_user_name(user: &str) -> Result<(), Status> {
+    let allowed = |c: char| c.is_ascii_lowercase() || c.is_ascii_digit() || "._-".contains(c);
+    if user.len() < 3 || user.len() > 32 || !user.chars().all(allowed) {
+        return Err(Status::invalid_argument(
+            "user name must be 3 to 32 lowercase letters, digits, dots, dashes or underscores",
+        ));
+    }
+    Ok(())
+}
+
+/// Rejects passwords shorter than MIN_PASSWORD_LENGTH, without letters or
+/// digits, or containing user name.
+fn check_password(user: &str, password: &str) -> Result<(), Status> {
+    let length = password.chars().count();
+    if length < MIN_PASSWORD_LENGTH || length > MAX_PASSWORD_LENGTH {
+        return Err(Status::invalid_argument(format!(
+            "password must be {} to {} characters long",
+            MIN_PASSWORD_LENGTH, MAX_PASSWORD_LENGTH
+        )));
+    }
+    if !password.chars().any(char::is_alphabetic) || !password.chars().any(|c| c.is_ascii_digit())
+    {
+        return Err(Status::invalid_argument(
+            "password must contain both letters and digits",
+        ));
+    }
+    if password.to_lowercase().contains(user) {
+        return Err(Status::invalid_argument("password must not contain user name"));
+    }
+    Ok(())
+}
+
+static RPC_HANDLED: Lazy<IntCounterVec> = Lazy::new(|| {
+    register_int_counter_vec!(
+        "grpc_server_handled_total",
+        "Total number of RPCs completed on the server.",
+        &["grpc_service", "grpc_method", "grpc_code"]
+    )
+    .unwrap()
+});
+
+static RPC_DURATION: Lazy<HistogramVec> = Lazy::new(|| {
+    register_histogram_vec!(
+        "grpc_server_handling_seconds",
+        "Duration of RPCs handled by the server.",
+        &["grpc_service", "grpc_method"]
+    )
+    .unwrap()
+});
+
+/// Records RED metrics of RPC with the same names and labels as Go services.
+fn observe<T>(method: &str, start: Instant, result: Result<T, Status>) -> Result<T, Status> {
+    let code = match &result {
+        Ok(_) => "OK".to_owned(),
+        Err(status) => format!("{:?}", status.code()),
+    };
+    RPC_HANDLED
+        .with_label_values(&["auth.Auth", method, &code])
+        .inc();
+    RPC_DURATION
+        .with_label_values(&["auth.Auth", method])
+        .observe(start.elapsed().as_secs_f64());
+    result
+}
+
+async fn metrics(
+    _: hyper::Request<hyper::Body>,
+) -> Result<hyper::Response<hyper::Body>, Infallible> {
+    let encoder = TextEncoder::new();
+    let mut buffer = Vec::new();
+    encoder.encode(&prometheus::gather(), &mut buffer).unwrap();
+
+    Ok(hyper::Response::builder()
+        .header(hyper::header::CONTENT_TYPE, encoder.format_type())
+        .body(hyper::Body::from(buffer))
+        .unwrap())
+}
+
+async fn serve_metrics(addr: SocketAddr) -> Result<(), hyper::Error> {
+    let make_service = make_service_fn(|_| async { Ok::<_, Infallible>(service_fn(metrics)) });
+
+    hyper::Server::bind(&addr).serve(make_service).await
+}
+
+/// Returns request ID which http frontend passes in x-request-id metadata.
+fn request_id(metadata: &tonic::metadata::MetadataMap) -> Option<String> {
+    metadata
+        .get("x-request-id")
+        .and_then(|id| id.to_str().ok())
+        .map(|id| id.to_owned())
+}
+
 struct MetadataMap<'a>(&'a tonic::metadata::MetadataMap);
 
 impl<'a> Extractor for MetadataMap<'a> {
@@ -68,98 +229,768 @@ impl<'a> Extractor for MetadataMap<'a> {
     }
 }
 
+/// Redis sorted set of revoked token IDs scored by expiry of tokens.
+const REVOKED_KEY: &str = "revoked";
+/// Redis hash of password hashes of registered users.
+const USERS_KEY: &str = "users";
+
+/// Keeps registered users, refresh tokens and IDs of revoked access tokens.
+/// Redis and YDB keep them across restarts of auth, memory needs nothing but
+/// the auth binary, so the demo runs from a clean checkout.
+#[derive(Clone)]
+enum Sessions {
+    Redis(r2d2::Pool<RedisConnectionManager>),
+    Ydb(YdbSessions),
+    Memory(Arc<Mutex<MemorySessions>>),
+}
+
+#[derive(Default)]
+struct MemorySessions {
+    // password hashes of registered users
+    users: HashMap<String, String>,
+    refresh: HashMap<String, (String, Instant)>,
+    // revoked token IDs with expiry of tokens in unix seconds
+    revoked: HashMap<String, u64>,
+}
+
+impl Sessions {
+    /// Builds sessions store selected by SESSIONS, redis (default), ydb or
+    /// memory. Redis is reached at REDIS_ADDR, 127.0.0.1:6379 by default, YDB
+    /// at YDB_DSN, grpc://localhost:2136?database=/local by default.
+    async fn from_env() -> Result<Self, Box<dyn std::error::Error>> {
+        match std::env::var("SESSIONS").unwrap_or_default().as_str() {
+            "" | "redis" => {
+                let addr =
+                    std::env::var("REDIS_ADDR").unwrap_or_else(|_| "127.0.0.1:6379".to_owned());
+                let manager = RedisConnectionManager::new(format!("redis://{}", addr))?;
+                let pool = r2d2::Pool::builder().build_unchecked(manager);
+                Ok(Sessions::Redis(pool))
+            }
+            "ydb" => {
+                let dsn = std::env::var("YDB_DSN")
+                    .unwrap_or_else(|_| "grpc://localhost:2136?database=/local".to_owned());
+                Ok(Sessions::Ydb(YdbSessions::open(&dsn).await?))
+            }
+            "memory" => Ok(Sessions::Memory(Default::default())),
+            other => Err(format!("unknown sessions store {:?}", other).into()),
+        }
+    }
+
+    fn name(&self) -> &'static str {
+        match self {
+            Sessions::Redis(_) => "redis",
+            Sessions::Ydb(_) => "ydb",
+            Sessions::Memory(_) => "memory",
+        }
+    }
+
+    async fn set(
+        &self,
+        parent: &SpanContext,
+        token: &str,
+        session: &str,
+        ttl: Duration,
+    ) -> Result<(), Status> {
+        match self {
+            Sessions::Redis(pool) => {
+                let mut conn = pool
+                    .get()
+                    .map_err(|err| Status::unavailable(err.to_string()))?;
+                conn.set_ex::<_, _, ()>(token, session, ttl.as_secs() as usize)
+                    .map_err(|err| Status::unavailable(err.to_string()))
+            }
+            Sessions::Ydb(ydb) => ydb.set(parent, token, session, ttl).await,
+            Sessions::Memory(sessions) => {
+                let refresh = &mut sessions.lock().unwrap().refresh;
+                // expired sessions are dropped on login, so the map does not grow forever
+                let now = Instant::now();
+                refresh.retain(|_, (_, expire_at)| *expire_at > now);
+                refresh.insert(token.to_owned(), (session.to_owned(), now + ttl));
+                Ok(())
+            }
+        }
+    }
+
+    /// Removes token and returns its session or None if token is unknown or
+    /// expired, so every token is spent once.
+    async fn take(&self, parent: &SpanContext, token: &str) -> Result<Option<String>, Status> {
+        match self {
+            Sessions::Redis(pool) => {
+                let mut conn = pool
+                    .get()
+                    .map_err(|err| Status::unavailable(err.to_string()))?;
+                let result = r2d2_redis::redis::pipe()
+                    .atomic()
+                    .get(token)
+                    .del(token)
+                    .ignore()
+                    .query::<(r2d2_redis::redis::Value,)>(&mut *conn);
+                match result {
+                    Ok((r2d2_redis::redis::Value::Data(session),)) => String::from_utf8(session)
+                        .map(Some)
+                        .map_err(|err| Status::internal(err.to_string())),
+                    Ok((r2d2_redis::redis::Value::Nil,)) => Ok(None),
+                    Ok((value,)) => Err(Status::internal(format!(
+                        "wrong redis response: {:?}",
+                        value
+                    ))),
+                    Err(err) => Err(Status::unavailable(err.to_string())),
+                }
+            }
+            Sessions::Ydb(ydb) => ydb.take(parent, token).await,
+            Sessions::Memory(sessions) => Ok(sessions
+                .lock()
+                .unwrap()
+                .refresh
+                .remove(token)
+                .filter(|(_, expire_at)| *expire_at > Instant::now())
+                .map(|(session, _)| session)),
+        }
+    }
+
+    /// Stores password hash of new user, returns false if user exists.
+    async fn add_user(&self, parent: &SpanContext, user: &str, hash: &str) -> Result<bool, Status> {
+        match self {
+            Sessions::Redis(pool) => {
+                let mut conn = pool
+                    .get()
+                    .map_err(|err| Status::unavailable(err.to_string()))?;
+                conn.hset_nx(USERS_KEY, user, hash)
+                    .map_err(|err| Status::unavailable(err.to_string()))
+            }
+            Sessions::Ydb(ydb) => ydb.add_user(parent, user, hash).await,
+            Sessions::Memory(sessions) => {
+                let users = &mut sessions.lock().unwrap().users;
+                if users.contains_key(user) {
+                    return Ok(false);
+                }
+                users.insert(user.to_owned(), hash.to_owned());
+                Ok(true)
+            }
+        }
+    }
+
+    /// Returns password hash of registered user.
+    async fn password_hash(
+        &self,
+        parent: &SpanContext,
+        user: &str,
+    ) -> Result<Option<String>, Status> {
+        match self {
+            Sessions::Redis(pool) => {
+                let mut conn = pool
+                    .get()
+                    .map_err(|err| Status::unavailable(err.to_string()))?;
+                conn.hget(USERS_KEY, user)
+                    .map_err(|err| Status::unavailable(err.to_string()))
+            }
+            Sessions::Ydb(ydb) => ydb.password_hash(parent, user).await,
+            Sessions::Memory(sessions) => Ok(sessions.lock().unwrap().users.get(user).cloned()),
+        }
+    }
+
+    /// Marks token ID revoked until expiry of token. Revoked IDs of expired
+    /// tokens are dropped, they are rejected anyway.
+    async fn revoke(&self, parent: &SpanContext, id: &str, expire_at: u64) -> Result<(), Status> {
+        let now = unix_seconds(SystemTime::now());
+        match self {
+            Sessions::Redis(pool) => {
+                let mut conn = pool
+                    .get()
+                    .map_err(|err| Status::unavailable(err.to_string()))?;
+                r2d2_redis::redis::pipe()
+                    .atomic()
+                    .zadd(REVOKED_KEY, id, expire_at)
+                    .ignore()
+                    .zrembyscore(REVOKED_KEY, "-inf", now)
+                    .ignore()
+                    .query::<()>(&mut *conn)
+                    .map_err(|err| Status::unavailable(err.to_string()))
+            }
+            Sessions::Ydb(ydb) => ydb.revoke(parent, id, expire_at).await,
+            Sessions::Memory(sessions) => {
+                let revoked = &mut sessions.lock().unwrap().revoked;
+                revoked.retain(|_, expire_at| *expire_at >= now);
+                revoked.insert(id.to_owned(), expire_at);
+                Ok(())
+            }
+        }
+    }
+
+    /// Returns IDs of revoked tokens which are not expired yet with their
+    /// expiry in unix seconds.
+    async fn revoked(&self, parent: &SpanContext) -> Result<Vec<(String, u64)>, Status> {
+        let now = unix_seconds(SystemTime::now());
+        match self {
+            Sessions::Redis(pool) => {
+                let mut conn = pool
+                    .get()
+                    .map_err(|err| Status::unavailable(err.to_string()))?;
+                conn.zrangebyscore_withscores(REVOKED_KEY, now, "+inf")
+                    .map_err(|err| Status::unavailable(err.to_string()))
+            }
+            Sessions::Ydb(ydb) => ydb.revoked(parent).await,
+            Sessions::Memory(sessions) => Ok(sessions
+                .lock()
+                .unwrap()
+                .revoked
+                .iter()
+                .filter(|(_, expire_at)| **expire_at >= now)
+                .map(|(id, expire_at)| (id.clone(), *expire_at))
+                .collect()),
+        }
+    }
+
+    async fn is_revoked(&self, parent: &SpanContext, id: &str) -> Result<bool, Status> {
+        match self {
+            Sessions::Redis(pool) => {
+                let mut conn = pool
+                    .get()
+                    .map_err(|err| Status::unavailable(err.to_string()))?;
+                conn.zscore::<_, _, Option<u64>>(REVOKED_KEY, id)
+                    .map(|score| score.is_some())
+                    .map_err(|err| Status::unavailable(err.to_string()))
+            }
+            Sessions::Ydb(ydb) => ydb.is_revoked(parent, id).await,
+            Sessions::Memory(sessions) => {
+                Ok(sessions.lock().unwrap().revoked.contains_key(id))
+            }
+        }
+    }
+
+    /// Reports whether sessions can be stored, memory always can.
+    async fn healthy(&self) -> bool {
+        match self {
+            Sessions::Redis(pool) => pool
+                .get_timeout(Duration::from_secs(1))
+                .map(|mut conn| {
+                    r2d2_redis::redis::cmd("PING")
+                        .query::<String>(&mut *conn)
+                        .is_ok()
+                })
+                .unwrap_or(false),
+            Sessions::Ydb(ydb) => ydb.healthy().await,
+            Sessions::Memory(_) => true,
+        }
+    }
+}
+
+/// Claims of access token. Go services verify them with the same key.
+#[derive(Serialize, Deserialize)]
+struct Claims {
+    sub: String,
+    iat: u64,
+    exp: u64,
+    // jti is hash of refresh token issued with the token, so revoking access
+    // token finds its refresh token
+    jti: String,
+    // role is empty in tokens issued before roles, they are of ROLE_USER
+    #[serde(default)]
+    role: String,
+}
+
+/// Signs access tokens and sets lifetimes of access and refresh tokens.
+struct Tokens {
+    encoding_key: EncodingKey,
+    decoding_key: DecodingKey,
+    ttl: Duration,
+    refresh_ttl: Duration,
+}
+
+impl Tokens {
+    /// Reads signing key from JWT_KEY and lifetimes from JWT_TTL (10m by
+    /// default) and REFRESH_TTL (24h by default). Without JWT_KEY a random
+    /// key is generated, so tokens are valid until auth restarts and only
+    /// auth itself can validate them.
+    fn from_env() -> Result<Self, Box<dyn std::error::Error>> {
+        let key = match std::env::var("JWT_KEY") {
+            Ok(key) if !key.is_empty() => key.into_bytes(),
+            _ => {
+                println!("JWT_KEY is not set, tokens are signed with random key");
+                [Uuid::new_v4().as_bytes(), Uuid::new_v4().as_bytes()].concat()
+            }
+        };
+        Ok(Tokens {
+            encoding_key: EncodingKey::from_secret(&key),
+            decoding_key: DecodingKey::from_secret(&key),
+            ttl: duration_var("JWT_TTL", Duration::from_secs(600))?,
+            refresh_ttl: duration_var("REFRESH_TTL", Duration::from_secs(24 * 3600))?,
+        })
+    }
+
+    fn sign(&self, user: &str, role: &str, id: &str) -> Result<(String, SystemTime), Status> {
+        let now = SystemTime::now();
+        let expire_at = now.add(self.ttl);
+        let claims = Claims {
+            sub: user.to_owned(),
+            iat: unix_seconds(now),
+            exp: unix_seconds(expire_at),
+            jti: id.to_owned(),
+            role: role.to_owned(),
+        };
+        let token = encode(&Header::new(Algorithm::HS256), &claims, &self.encoding_key)
+            .map_err(|err| Status::internal(err.to_string()))?;
+        Ok((token, expire_at))
+    }
+
+    /// Returns claims of access token with valid signature and expiry.
+    fn verify(&self, token: &str) -> Result<Claims, Status> {
+        decode::<Claims>(token, &self.decoding_key, &Validation::new(Algorithm::HS256))
+            .map(|data| data.claims)
+            .map_err(|err| Status::unauthenticated(err.to_string()))
+    }
+}
+
+/// Returns ID of refresh token. Sessions keep refresh tokens under their IDs,
+/// so stolen sessions store reveals no usable tokens.
+fn refresh_id(refresh_token: &str) -> String {
+    format!("{:x}", Sha256::digest(refresh_token.as_bytes()))
+}
+
+fn unix_seconds(t: SystemTime) -> u64 {
+    t.duration_since(SystemTime::UNIX_EPOCH)
+        .unwrap_or_default()
+        .as_secs()
+}
+
+/// Reads duration like 10m or 24h from environment variable.
+fn duration_var(name: &str, default: Duration) -> Result<Duration, Box<dyn std::error::Error>> {
+    match std::env::var(name) {
+        Ok(value) if !value.is_empty() => Ok(humantime::parse_duration(&value)
+            .map_err(|err| format!("invalid {}: {}", name, err))?),
+        _ => Ok(default),
+    }
+}
+
 pub struct AuthService {
-    session_id: String,
-    pool: r2d2::Pool<RedisConnectionManager>,
+    sessions: Sessions,
+    tokens: Tokens,
+    // service_key is shared key of Go services, LoginExternal is disabled without it
+    service_key: Option<String>,
+    // admins are users of ROLE_ADMIN
+    admins: Vec<String>,
+    lockouts: Lockouts,
 }
 
-#[tonic::async_trait]
-impl Auth for AuthService {
-    async fn login(
+/// Returns local user of identity at identity provider. The name is derived
+/// from issuer and subject, so it is the same on every login and never
+/// collides with names of registered users, which have no colon.
+fn external_user(issuer: &str, subject: &str) -> String {
+    let digest = format!("{:x}", Sha256::digest(format!("{} {}", issuer, subject).as_bytes()));
+    format!("ext:{}", &digest[..16])
+}
+
+impl AuthService {
+    /// Counts failed login and marks span if it locked out user or client IP.
+    fn login_failed(&self, span: &mut impl Span, keys: &[Key]) {
+        if let Some(key) = self.lockouts.fail(keys) {
+            span.set_attribute(KeyValue::new("login.locked_out", key));
+            span.add_event("locked out", vec![KeyValue::new("key", key)]);
+        }
+    }
+
+    fn role(&self, user: &str) -> &'static str {
+        if self.admins.iter().any(|admin| admin == user) {
+            ROLE_ADMIN
+        } else {
+            ROLE_USER
+        }
+    }
+
+    /// Issues access token and refresh token of user. Refresh token is opaque
+    /// and kept in sessions, so it is spent by refresh.
+    async fn issue(&self, span: &mut impl Span, user: &str) -> Result<LoginResponse, Status> {
+        let refresh_token = Uuid::new_v4().hyphenated().to_string();
+        let id = refresh_id(&refresh_token);
+        let role = self.role(user);
+        let (token, expire_at) = self.tokens.sign(user, role, &id)?;
+
+        self.sessions
+            .set(span.span_context(), &id, user, self.tokens.refresh_ttl)
+            .await?;
+        span.set_attribute(KeyValue::new("sessions", self.sessions.name()));
+        span.set_attribute(KeyValue::new("role", role));
+        span.add_event("tokens issued", vec![]);
+
+        Ok(LoginResponse {
+            token,
+            expire_at: Some(Timestamp::from(expire_at)),
+            refresh_token,
+            refresh_expire_at: Some(Timestamp::from(
+                SystemTime::now().add(self.tokens.refresh_ttl),
+            )),
+        })
+    }
+
+    /// Returns password hash of built-in or registered user.
+    async fn password_hash(
         &self,
-        request: Request<LoginRequest>,
+        parent: &SpanContext,
+        user: &str,
+    ) -> Result<Option<String>, Status> {
+        match PASSWORD_HASHES.get(user) {
+            Some(hash) => Ok(Some(hash.clone())),
+            None => self.sessions.password_hash(parent, user).await,
+        }
+    }
+
+    async fn do_register(
+        &self,
+        request: Request<RegisterRequest>,
     ) -> Result<Response<LoginResponse>, Status> {
         let parent_cx =
             global::get_text_map_propagator(|prop| prop.extract(&MetadataMap(request.metadata())));
-        let mut span = global::tracer(APPLICATION_ID).start_with_context("login", &parent_cx);
-        span.set_attribute(KeyValue::new("request", format!("{:?}", request)));
+        let mut span = global::tracer(APPLICATION_ID).start_with_context("register", &parent_cx);
+        if let Some(id) = request_id(request.metadata()) {
+            span.set_attribute(KeyValue::new("request_id", id));
+        }
 
         let req = request.into_inner();
+        span.set_attribute(KeyValue::new("user", req.user.clone()));
 
-        if !PASSWORDS.contains_key(&req.user) {
-            let err = Status::unauthenticated("user not found");
+        let result = self.register(&mut span, req).await;
+        if let Err(err) = &result {
+            span.set_attribute(KeyValue::new("error", true));
+            span.record_error(err);
+        }
+        result.map(Response::new)
+    }
+
+    async fn register(
+        &self,
+        span: &mut impl Span,
+        req: RegisterRequest,
+    ) -> Result<LoginResponse, Status> {
+        check_user_name(&req.user)?;
+        check_password(&req.user, &req.password)?;
+        span.add_event("password policy passed", vec![]);
+
+        if PASSWORD_HASHES.contains_key(&req.user) {
+            return Err(Status::already_exists("user already exists"));
+        }
+        let password = req.password;
+        let hash = tokio::task::spawn_blocking(move || hash_password(&password))
+            .await
+            .map_err(|err| Status::internal(err.to_string()))?
+            .map_err(|err| Status::internal(err.to_string()))?;
+        if !self
+            .sessions
+            .add_user(span.span_context(), &req.user, &hash)
+            .await?
+        {
+            return Err(Status::already_exists("user already exists"));
+        }
+        span.add_event("user registered", vec![]);
+
+        self.issue(span, &req.user).await
+    }
+
+    /// Returns claims of access token with valid signature which is not
+    /// revoked.
+    async fn validate_token(&self, span: &mut impl Span, token: &str) -> Result<Claims, Status> {
+        let claims = self.tokens.verify(token)?;
+        span.add_event("token signature valid", vec![]);
+        if self
+            .sessions
+            .is_revoked(span.span_context(), &claims.jti)
+            .await?
+        {
+            return Err(Status::unauthenticated("token revoked"));
+        }
+        Ok(claims)
+    }
+
+    /// Spends refresh token of access token and revokes access token. Only
+    /// tokens signed by auth are revoked, so garbage does not fill the store.
+    async fn revoke_token(&self, span: &mut impl Span, token: &str) -> Result<(), Status> {
+        let claims = self.tokens.verify(token)?;
+        self.sessions.take(span.span_context(), &claims.jti).await?;
+        span.add_event("refresh token spent", vec![]);
+        self.sessions
+            .revoke(span.span_context(), &claims.jti, claims.exp)
+            .await
+    }
+
+    async fn do_login_external(
+        &self,
+        request: Request<LoginExternalRequest>,
+    ) -> Result<Response<LoginResponse>, Status> {
+        let parent_cx =
+            global::get_text_map_propagator(|prop| prop.extract(&MetadataMap(request.metadata())));
+        let mut span =
+            global::tracer(APPLICATION_ID).start_with_context("login_external", &parent_cx);
+        if let Some(id) = request_id(request.metadata()) {
+            span.set_attribute(KeyValue::new("request_id", id));
+        }
+
+        // anyone could log in as any identity without the key, so
+        // LoginExternal is disabled until SERVICE_KEY is set
+        let key = match &self.service_key {
+            Some(key) => key,
+            None => {
+                let err = Status::failed_precondition("LoginExternal requires SERVICE_KEY");
+                span.set_attribute(KeyValue::new("error", true));
+                span.record_error(&err);
+                return Err(err);
+            }
+        };
+        let given = request
+            .metadata()
+            .get("x-service-key")
+            .and_then(|key| key.to_str().ok());
+        if given != Some(key.as_str()) {
+            let err = Status::permission_denied("service key expected");
             span.set_attribute(KeyValue::new("error", true));
             span.record_error(&err);
             return Err(err);
         }
 
-        span.add_event("user well known", vec![]);
-
-        if PASSWORDS[&req.user] != req.password {
-            let err = Status::unauthenticated("wrong password");
+        let req = request.into_inner();
+        if req.issuer.is_empty() || req.subject.is_empty() {
+            let err = Status::invalid_argument("issuer and subject are required");
             span.set_attribute(KeyValue::new("error", true));
             span.record_error(&err);
             return Err(err);
         }
+        let user = external_user(&req.issuer, &req.subject);
+        span.set_attribute(KeyValue::new("issuer", req.issuer));
+        span.set_attribute(KeyValue::new("user", user.clone()));
+        span.add_event("external identity mapped", vec![]);
 
-        let token = Uuid::new_v4().hyphenated().to_string();
+        let result = self.issue(&mut span, &user).await;
+        match result {
+            Ok(response) => Ok(Response::new(response)),
+            Err(err) => {
+                span.set_attribute(KeyValue::new("error", true));
+                span.record_error(&err);
+                Err(err)
+            }
+        }
+    }
+
+    async fn do_login(
+        &self,
+        request: Request<LoginRequest>,
+    ) -> Result<Response<LoginResponse>, Status> {
+        let parent_cx =
+            global::get_text_map_propagator(|prop| prop.extract(&MetadataMap(request.metadata())));
+        let mut span = global::tracer(APPLICATION_ID).start_with_context("login", &parent_cx);
+        if let Some(id) = request_id(request.metadata()) {
+            span.set_attribute(KeyValue::new("request_id", id));
+        }
+        // acting user set by http service in baggage
+        if let Some(user) = parent_cx.baggage().get("user") {
+            span.set_attribute(KeyValue::new("user", user.to_string()));
+        }
 
-        let mut conn = self.pool.get().unwrap();
+        let req = request.into_inner();
+        if !req.client_ip.is_empty() {
+            span.set_attribute(KeyValue::new("client_ip", req.client_ip.clone()));
+        }
 
-        let ttl = Duration::from_secs(600);
+        let keys = login_keys(&req.user, &req.client_ip);
+        match self.lockouts.check(&keys) {
+            Ok((failures, delay)) => {
+                span.set_attribute(KeyValue::new("login.failures", failures as i64));
+                if !delay.is_zero() {
+                    // every failure doubles the delay, which slows down guessing
+                    span.set_attribute(KeyValue::new("login.delay_ms", delay.as_millis() as i64));
+                    tokio::time::sleep(delay).await;
+                }
+            }
+            Err(locked) => {
+                let err = Status::resource_exhausted(format!(
+                    "too many failed logins, retry in {}s",
+                    locked.retry_after.as_secs() + 1
+                ));
+                span.set_attribute(KeyValue::new("login.locked_out", locked.key));
+                span.set_attribute(KeyValue::new("error", true));
+                span.record_error(&err);
+                return Err(err);
+            }
+        }
 
-        let _: () = conn.set_ex(&token, &self.session_id, ttl.as_millis() as usize).unwrap();
+        let result = self.password_hash(span.span_context(), &req.user).await;
+        // unknown user is checked against dummy hash, so neither answer nor
+        // its time tells whether user exists
+        let (hash, known) = match result {
+            Ok(Some(hash)) => (hash, true),
+            Ok(None) => (DUMMY_PASSWORD_HASH.clone(), false),
+            Err(err) => {
+                span.set_attribute(KeyValue::new("error", true));
+                span.record_error(&err);
+                return Err(err);
+            }
+        };
 
-        let expire_at = std::option::Option::Some(Timestamp::from(SystemTime::now().add(ttl)));
+        if known {
+            span.add_event("user well known", vec![]);
+        }
 
-        Ok(Response::new(LoginResponse { 
-            token,
-            expire_at,
-         }))
+        // argon2 takes tens of milliseconds of CPU, which must not block other requests
+        let password = req.password;
+        let valid = tokio::task::spawn_blocking(move || verify_password(&password, &hash))
+            .await
+            .unwrap_or(false);
+        if !known || !valid {
+            let err = Status::unauthenticated("invalid credentials");
+            self.login_failed(&mut span, &keys);
+            span.set_attribute(KeyValue::new("error", true));
+            span.record_error(&err);
+            return Err(err);
+        }
+        self.lockouts.succeed(&keys);
+
+        let result = self.issue(&mut span, &req.user).await;
+        match result {
+            Ok(response) => Ok(Response::new(response)),
+            Err(err) => {
+                span.set_attribute(KeyValue::new("error", true));
+                span.record_error(&err);
+                Err(err)
+            }
+        }
     }
-    async fn validate(
+    async fn do_validate(
         &self,
         request: Request<ValidateRequest>,
     ) -> Result<Response<ValidateResponse>, Status> {
         let parent_cx =
             global::get_text_map_propagator(|prop| prop.extract(&MetadataMap(request.metadata())));
         let mut span = global::tracer(APPLICATION_ID).start_with_context("validate", &parent_cx);
-        span.set_attribute(KeyValue::new("request", format!("{:?}", request)));
+        if let Some(id) = request_id(request.metadata()) {
+            span.set_attribute(KeyValue::new("request_id", id));
+        }
+        // acting user set by http service in baggage
+        if let Some(user) = parent_cx.baggage().get("user") {
+            span.set_attribute(KeyValue::new("user", user.to_string()));
+        }
 
         let token = request.into_inner().token;
 
-        let mut conn = self.pool.get().unwrap();
-
-        match conn.get::<&std::string::String, r2d2_redis::redis::Value>(&token) {
-            Ok(value) => match value {
-                r2d2_redis::redis::Value::Data(session_id) => {
-                    let session_id = match String::from_utf8(session_id) {
-                        Ok(session_id) => session_id,
-                        Err(err) => {
-                            span.set_attribute(KeyValue::new("error", true));
-                            span.record_error(&err);
-                            return Err(Status::internal(err.to_string()));
-                        }
-                    };
-                    if session_id != self.session_id {
-                        let err = Status::unauthenticated("wrong session ID");
-                        span.set_attribute(KeyValue::new("error", true));
-                        span.record_error(&err);
-                        Err(err)
-                    } else {
-                        span.add_event("token exists in redis", vec![]);
-                        Ok(Response::new(ValidateResponse {}))
-                    }
-                }
-                _ => {
-                    let err = Status::unauthenticated(format!("wrong redis response: {:?}", value));
-                    span.set_attribute(KeyValue::new("error", true));
-                    span.record_error(&err);
-                    Err(err)
-                }
-            },
+        let result = self.validate_token(&mut span, &token).await;
+        match result {
+            Ok(claims) => {
+                let role = if claims.role.is_empty() {
+                    ROLE_USER.to_owned()
+                } else {
+                    claims.role
+                };
+                span.set_attribute(KeyValue::new("user", claims.sub.clone()));
+                span.set_attribute(KeyValue::new("role", role.clone()));
+                Ok(Response::new(ValidateResponse {
+                    user: claims.sub,
+                    role,
+                }))
+            }
+            Err(err) => {
+                span.set_attribute(KeyValue::new("error", true));
+                span.record_error(&err);
+                Err(err)
+            }
+        }
+    }
+    async fn do_revoke(
+        &self,
+        request: Request<RevokeRequest>,
+    ) -> Result<Response<RevokeResponse>, Status> {
+        let parent_cx =
+            global::get_text_map_propagator(|prop| prop.extract(&MetadataMap(request.metadata())));
+        let mut span = global::tracer(APPLICATION_ID).start_with_context("revoke", &parent_cx);
+        if let Some(id) = request_id(request.metadata()) {
+            span.set_attribute(KeyValue::new("request_id", id));
+        }
+        // acting user set by http service in baggage
+        if let Some(user) = parent_cx.baggage().get("user") {
+            span.set_attribute(KeyValue::new("user", user.to_string()));
+        }
+
+        let token = request.into_inner().token;
+
+        let result = self.revoke_token(&mut span, &token).await;
+        match result {
+            Ok(()) => {
+                span.add_event("token revoked", vec![]);
+                Ok(Response::new(RevokeResponse {}))
+            }
+            Err(err) => {
+                span.set_attribute(KeyValue::new("error", true));
+                span.record_error(&err);
+                Err(err)
+            }
+        }
+    }
+    async fn do_list_revoked(
+        &self,
+        request: Request<ListRevokedRequest>,
+    ) -> Result<Response<ListRevokedResponse>, Status> {
+        let parent_cx =
+            global::get_text_map_propagator(|prop| prop.extract(&MetadataMap(request.metadata())));
+        let mut span =
+            global::tracer(APPLICATION_ID).start_with_context("list_revoked", &parent_cx);
+
+        let result = self.sessions.revoked(span.span_context()).await;
+        match result {
+            Ok(revoked) => {
+                span.set_attribute(KeyValue::new("revoked", revoked.len() as i64));
+                Ok(Response::new(ListRevokedResponse {
+                    tokens: revoked
+                        .into_iter()
+                        .map(|(id, expire_at)| RevokedToken {
+                            id,
+                            expire_at: Some(Timestamp {
+                                seconds: expire_at as i64,
+                                nanos: 0,
+                            }),
+                        })
+                        .collect(),
+                }))
+            }
+            Err(err) => {
+                span.set_attribute(KeyValue::new("error", true));
+                span.record_error(&err);
+                Err(err)
+            }
+        }
+    }
+    async fn do_refresh(
+        &self,
+        request: Request<RefreshRequest>,
+    ) -> Result<Response<LoginResponse>, Status> {
+        let parent_cx =
+            global::get_text_map_propagator(|prop| prop.extract(&MetadataMap(request.metadata())));
+        let mut span = global::tracer(APPLICATION_ID).start_with_context("refresh", &parent_cx);
+        if let Some(id) = request_id(request.metadata()) {
+            span.set_attribute(KeyValue::new("request_id", id));
+        }
+
+        let refresh_token = request.into_inner().refresh_token;
+
+        let result = self
+            .sessions
+            .take(span.span_context(), &refresh_id(&refresh_token))
+            .await;
+        let user = match result {
+            Ok(Some(user)) => user,
+            Ok(None) => {
+                let err = Status::unauthenticated("refresh token not found");
+                span.set_attribute(KeyValue::new("error", true));
+                span.record_error(&err);
+                return Err(err);
+            }
+            Err(err) => {
+                span.set_attribute(KeyValue::new("error", true));
+                span.record_error(&err);
+                return Err(err);
+            }
+        };
+        span.add_event("refresh token spent", vec![]);
+        span.set_attribute(KeyValue::new("user", user.clone()));
+
+        let result = self.issue(&mut span, &user).await;
+        match result {
+            Ok(response) => Ok(Response::new(response)),
             Err(err) => {
-                let err = Status::unauthenticated(err.to_string());
                 span.set_attribute(KeyValue::new("error", true));
                 span.record_error(&err);
                 Err(err)
@@ -168,24 +999,149 @@ impl Auth for AuthService {
     }
 }
 
+#[tonic::async_trait]
+impl Auth for AuthService {
+    async fn register(
+        &self,
+        request: Request<RegisterRequest>,
+    ) -> Result<Response<LoginResponse>, Status> {
+        let start = Instant::now();
+        observe("Register", start, self.do_register(request).await)
+    }
+    async fn login(
+        &self,
+        request: Request<LoginRequest>,
+    ) -> Result<Response<LoginResponse>, Status> {
+        let start = Instant::now();
+        observe("Login", start, self.do_login(request).await)
+    }
+    async fn login_external(
+        &self,
+        request: Request<LoginExternalRequest>,
+    ) -> Result<Response<LoginResponse>, Status> {
+        let start = Instant::now();
+        observe("LoginExternal", start, self.do_login_external(request).await)
+    }
+    async fn validate(
+        &self,
+        request: Request<ValidateRequest>,
+    ) -> Result<Response<ValidateResponse>, Status> {
+        let start = Instant::now();
+        observe("Validate", start, self.do_validate(request).await)
+    }
+    async fn refresh(
+        &self,
+        request: Request<RefreshRequest>,
+    ) -> Result<Response<LoginResponse>, Status> {
+        let start = Instant::now();
+        observe("Refresh", start, self.do_refresh(request).await)
+    }
+    async fn revoke(
+        &self,
+        request: Request<RevokeRequest>,
+    ) -> Result<Response<RevokeResponse>, Status> {
+        let start = Instant::now();
+        observe("Revoke", start, self.do_revoke(request).await)
+    }
+    async fn list_revoked(
+        &self,
+        request: Request<ListRevokedRequest>,
+    ) -> Result<Response<ListRevokedResponse>, Status> {
+        let start = Instant::now();
+        observe("ListRevoked", start, self.do_list_revoked(request).await)
+    }
+}
+
 impl AuthService {
-    fn new(pool: r2d2::Pool<RedisConnectionManager>) -> Self {
-        let session_id = Uuid::new_v4().hyphenated().to_string();
+    fn new(sessions: Sessions, tokens: Tokens, lockouts: Lockouts) -> Self {
+        let service_key = std::env::var("SERVICE_KEY")
+            .ok()
+            .filter(|key| !key.is_empty());
+
+        // nobody is admin unless ADMINS names registered users, built-in users
+        // have well known passwords, so they are never admins
+        let admins = std::env::var("ADMINS")
+            .unwrap_or_default()
+            .split(',')
+            .map(|admin| admin.trim().to_owned())
+            .filter(|admin| !admin.is_empty())
+            .filter(|admin| {
+                let builtin = USERS.iter().any(|user| user.name == admin.as_str());
+                if builtin {
+                    println!("built-in user {} is not admin", admin);
+                }
+                !builtin
+            })
+            .collect();
 
-        AuthService { session_id, pool }
+        AuthService {
+            sessions,
+            tokens,
+            service_key,
+            admins,
+            lockouts,
+        }
     }
 }
 
 fn tracing_init() -> Result<impl Tracer, TraceError> {
-    global::set_text_map_propagator(opentelemetry_jaeger::Propagator::new());
+    // the same propagators as in Go services: Jaeger, W3C TraceContext and Baggage
+    global::set_text_map_propagator(TextMapCompositePropagator::new(vec![
+        Box::new(opentelemetry_jaeger::Propagator::new()),
+        Box::new(TraceContextPropagator::new()),
+        Box::new(BaggagePropagator::new()),
+    ]));
     opentelemetry_jaeger::new_agent_pipeline()
         .with_service_name(APPLICATION_ID)
         .install_simple()
 }
 
-fn intercept(req: Request<()>) -> Result<Request<()>, Status> {
-    println!("Intercepting request: {:?}", req);
+/// Builds mutual TLS settings from TLS_CERT, TLS_KEY and TLS_CA paths,
+/// the same environment variables are used by Go services.
+/// Returns None if TLS_CERT is not set.
+fn tls_config() -> Result<Option<ServerTlsConfig>, Box<dyn std::error::Error>> {
+    let cert = match std::env::var("TLS_CERT") {
+        Ok(cert) if !cert.is_empty() => std::fs::read(cert)?,
+        _ => return Ok(None),
+    };
+    let key = std::fs::read(std::env::var("TLS_KEY")?)?;
+    let ca = std::fs::read(std::env::var("TLS_CA")?)?;
+
+    Ok(Some(
+        ServerTlsConfig::new()
+            .identity(Identity::from_pem(cert, key))
+            .client_ca_root(Certificate::from_pem(ca)),
+    ))
+}
+
+/// Builds gRPC reflection service if GRPC_REFLECTION is true, the same
+/// environment variable is used by Go services, so grpcurl and evans can call
+/// auth without proto files.
+fn reflection_service(
+) -> Result<Option<ServerReflectionServer<impl ServerReflection>>, Box<dyn std::error::Error>> {
+    match std::env::var("GRPC_REFLECTION") {
+        Ok(enabled) if enabled == "true" => {}
+        _ => return Ok(None),
+    }
+    let service = tonic_reflection::server::Builder::configure()
+        .register_encoded_file_descriptor_set(auth::FILE_DESCRIPTOR_SET)
+        .build()?;
+    Ok(Some(service))
+}
 
+/// Reports auth service as serving while sessions store is healthy.
+async fn watch_health(mut reporter: HealthReporter, sessions: Sessions) {
+    loop {
+        if sessions.healthy().await {
+            reporter.set_serving::<AuthServer<AuthService>>().await;
+        } else {
+            reporter.set_not_serving::<AuthServer<AuthService>>().await;
+        }
+        tokio::time::sleep(Duration::from_secs(5)).await;
+    }
+}
+
+fn intercept(req: Request<()>) -> Result<Request<()>, Status> {
     Ok(req)
 }
 
@@ -195,17 +1151,44 @@ async fn main() -> Result<(), Box<dyn std::error::Error>> {
     let _tracer = tracing_init()?;
     println!("tracer initialized");
     let addr = "127.0.0.1:50051".parse()?;
-    let manager = RedisConnectionManager::new("redis://127.0.0.1").unwrap();
-    let pool = r2d2::Pool::builder()
-        .build(manager)
-        .unwrap();
-    println!("redis client opened");
-    let auth_service = AuthServer::with_interceptor(AuthService::new(pool), intercept);
+    let metrics_addr: SocketAddr = "127.0.0.1:50052".parse()?;
+    let sessions = Sessions::from_env().await?;
+    println!("{} sessions store opened", sessions.name());
+    let tokens = Tokens::from_env()?;
+    let lockouts = Lockouts::from_env()?;
+    // built-in users are hashed before serving, not on the first login
+    Lazy::force(&PASSWORD_HASHES);
+    Lazy::force(&DUMMY_PASSWORD_HASH);
+    let (health_reporter, health_service) = tonic_health::server::health_reporter();
+    tokio::spawn(watch_health(health_reporter, sessions.clone()));
+
+    let auth_service =
+        AuthServer::with_interceptor(AuthService::new(sessions, tokens, lockouts), intercept);
+
+    tokio::spawn(async move {
+        if let Err(err) = serve_metrics(metrics_addr).await {
+            println!("metrics server failed: {}", err);
+        }
+    });
+    println!("metrics on address {}", metrics_addr);
 
     println!("starting server on addres {}...", addr);
 
-    Server::builder()
+    let mut builder = Server::builder();
+    if let Some(tls) = tls_config()? {
+        builder = builder.tls_config(tls)?;
+        println!("mTLS enabled");
+    }
+
+    let reflection = reflection_service()?;
+    if reflection.is_some() {
+        println!("reflection enabled");
+    }
+
+    builder
+        .add_service(health_service)
         .add_service(auth_service)
+        .add_optional_service(reflection)
         .serve(addr)
         .await?;
 
@@ -0,0 +1,362 @@
+use std::time::{Duration, SystemTime};
+
+use opentelemetry::global::{self, BoxedSpan};
+use opentelemetry::trace::{Span, SpanContext, TraceContextExt, Tracer};
+use opentelemetry::{Context, KeyValue};
+use tonic::Status;
+use ydb::{ydb_params, ClientBuilder, Query, TableClient};
+
+use crate::{unix_seconds, APPLICATION_ID};
+
+/// Tables of users, refresh tokens and revoked tokens. YDB drops expired rows
+/// of sessions and revoked tokens by TTL.
+const SCHEMA: &[(&str, &str)] = &[
+    (
+        "auth_users",
+        "CREATE TABLE auth_users (
+            name Utf8,
+            password_hash Utf8,
+            PRIMARY KEY (name)
+        )",
+    ),
+    (
+        "auth_sessions",
+        "CREATE TABLE auth_sessions (
+            id Utf8,
+            user_name Utf8,
+            expire_at Timestamp,
+            PRIMARY KEY (id)
+        ) WITH (
+            TTL = Interval(\"PT0S\") ON expire_at
+        )",
+    ),
+    (
+        "auth_revoked",
+        "CREATE TABLE auth_revoked (
+            id Utf8,
+            expire_at Timestamp,
+            PRIMARY KEY (id)
+        ) WITH (
+            TTL = Interval(\"PT0S\") ON expire_at
+        )",
+    ),
+];
+
+/// Keeps users and sessions in YDB tables, so restarts of auth keep everyone
+/// logged in. Every query is traced as child span of the calling RPC.
+#[derive(Clone)]
+pub struct YdbSessions {
+    table_client: TableClient,
+}
+
+fn ydb_error(err: impl std::fmt::Display) -> Status {
+    Status::unavailable(err.to_string())
+}
+
+/// Starts span of query as child of parent span.
+fn query_span(parent: &SpanContext, operation: &str, statement: &str) -> BoxedSpan {
+    let cx = Context::new().with_remote_span_context(parent.clone());
+    let mut span = global::tracer(APPLICATION_ID).start_with_context(operation.to_owned(), &cx);
+    span.set_attribute(KeyValue::new("db.system", "ydb"));
+    span.set_attribute(KeyValue::new("db.operation", operation.to_owned()));
+    span.set_attribute(KeyValue::new("db.statement", statement.to_owned()));
+    span
+}
+
+/// Records error of query on its span.
+fn finish<T>(mut span: BoxedSpan, result: Result<T, Status>) -> Result<T, Status> {
+    if let Err(err) = &result {
+        span.set_attribute(KeyValue::new("error", true));
+        span.record_error(err);
+    }
+    span.end();
+    result
+}
+
+impl YdbSessions {
+    /// Connects to YDB at dsn and creates missing tables.
+    pub async fn open(dsn: &str) -> Result<Self, Box<dyn std::error::Error>> {
+        let client = ClientBuilder::new_from_connection_string(dsn)?.client()?;
+        client.wait().await?;
+        let sessions = YdbSessions {
+            table_client: client.table_client(),
+        };
+        sessions.init_schema().await?;
+        Ok(sessions)
+    }
+
+    async fn init_schema(&self) -> Result<(), Box<dyn std::error::Error>> {
+        for (table, create) in SCHEMA {
+            if let Err(err) = self
+                .table_client
+                .retry_execute_scheme_query(create.to_string())
+                .await
+            {
+                // table created by previous start is readable, others are not
+                let select = format!("SELECT COUNT(*) FROM {};", table);
+                let exists = self
+                    .table_client
+                    .retry_transaction(|mut t| {
+                        let select = select.clone();
+                        async move {
+                            t.query(Query::new(select)).await?;
+                            Ok(())
+                        }
+                    })
+                    .await;
+                if exists.is_err() {
+                    return Err(format!("create table {} failed: {}", table, err).into());
+                }
+            }
+        }
+        Ok(())
+    }
+
+    pub async fn set(
+        &self,
+        parent: &SpanContext,
+        token: &str,
+        session: &str,
+        ttl: Duration,
+    ) -> Result<(), Status> {
+        const QUERY: &str = "
+            DECLARE $id AS Utf8;
+            DECLARE $user AS Utf8;
+            DECLARE $expire_at AS Timestamp;
+            UPSERT INTO auth_sessions (id, user_name, expire_at)
+            VALUES ($id, $user, $expire_at);
+        ";
+        let span = query_span(parent, "sessions.set", QUERY);
+        let (id, user, expire_at) = (
+            token.to_owned(),
+            session.to_owned(),
+            SystemTime::now() + ttl,
+        );
+        let result = self
+            .table_client
+            .retry_transaction(|mut t| {
+                let (id, user) = (id.clone(), user.clone());
+                async move {
+                    t.query(Query::new(QUERY).with_params(ydb_params!(
+                        "$id" => id,
+                        "$user" => user,
+                        "$expire_at" => expire_at,
+                    )))
+                    .await?;
+                    t.commit().await?;
+                    Ok(())
+                }
+            })
+            .await
+            .map_err(ydb_error);
+        finish(span, result)
+    }
+
+    /// Removes token and returns its user or None if token is unknown or
+    /// expired.
+    pub async fn take(&self, parent: &SpanContext, token: &str) -> Result<Option<String>, Status> {
+        const QUERY: &str = "
+            DECLARE $id AS Utf8;
+            SELECT user_name FROM auth_sessions
+            WHERE id = $id AND expire_at > CurrentUtcTimestamp();
+            DELETE FROM auth_sessions WHERE id = $id;
+        ";
+        let span = query_span(parent, "sessions.take", QUERY);
+        let id = token.to_owned();
+        let result = self
+            .table_client
+            .retry_transaction(|mut t| {
+                let id = id.clone();
+                async move {
+                    let result = t
+                        .query(Query::new(QUERY).with_params(ydb_params!("$id" => id)))
+                        .await?;
+                    t.commit().await?;
+                    match result.into_only_result()?.rows().next() {
+                        Some(mut row) => {
+                            let user: Option<String> =
+                                row.remove_field_by_name("user_name")?.try_into()?;
+                            Ok(user)
+                        }
+                        None => Ok(None),
+                    }
+                }
+            })
+            .await
+            .map_err(ydb_error);
+        finish(span, result)
+    }
+
+    pub async fn add_user(
+        &self,
+        parent: &SpanContext,
+        user: &str,
+        hash: &str,
+    ) -> Result<bool, Status> {
+        const SELECT: &str = "
+            DECLARE $name AS Utf8;
+            SELECT name FROM auth_users WHERE name = $name;
+        ";
+        const INSERT: &str = "
+            DECLARE $name AS Utf8;
+            DECLARE $hash AS Utf8;
+            UPSERT INTO auth_users (name, password_hash) VALUES ($name, $hash);
+        ";
+        let span = query_span(parent, "sessions.add_user", INSERT);
+        let (name, hash) = (user.to_owned(), hash.to_owned());
+        let result = self
+            .table_client
+            .retry_transaction(|mut t| {
+                let (name, hash) = (name.clone(), hash.clone());
+                async move {
+                    // select and upsert in one transaction, so concurrent
+                    // registrations of the same name do not overwrite each other
+                    let existing = t
+                        .query(Query::new(SELECT).with_params(ydb_params!("$name" => name.clone())))
+                        .await?;
+                    if existing.into_only_result()?.rows().next().is_some() {
+                        return Ok(false);
+                    }
+                    t.query(Query::new(INSERT).with_params(ydb_params!(
+                        "$name" => name,
+                        "$hash" => hash,
+                    )))
+                    .await?;
+                    t.commit().await?;
+                    Ok(true)
+                }
+            })
+            .await
+            .map_err(ydb_error);
+        finish(span, result)
+    }
+
+    pub async fn password_hash(
+        &self,
+        parent: &SpanContext,
+        user: &str,
+    ) -> Result<Option<String>, Status> {
+        const QUERY: &str = "
+            DECLARE $name AS Utf8;
+            SELECT password_hash FROM auth_users WHERE name = $name;
+        ";
+        let span = query_span(parent, "sessions.password_hash", QUERY);
+        let name = user.to_owned();
+        let result = self
+            .table_client
+            .retry_transaction(|mut t| {
+                let name = name.clone();
+                async move {
+                    let result = t
+                        .query(Query::new(QUERY).with_params(ydb_params!("$name" => name)))
+                        .await?;
+                    match result.into_only_result()?.rows().next() {
+                        Some(mut row) => {
+                            let hash: Option<String> =
+                                row.remove_field_by_name("password_hash")?.try_into()?;
+                            Ok(hash)
+                        }
+                        None => Ok(None),
+                    }
+                }
+            })
+            .await
+            .map_err(ydb_error);
+        finish(span, result)
+    }
+
+    pub async fn revoke(
+        &self,
+        parent: &SpanContext,
+        id: &str,
+        expire_at: u64,
+    ) -> Result<(), Status> {
+        const QUERY: &str = "
+            DECLARE $id AS Utf8;
+            DECLARE $expire_at AS Timestamp;
+            UPSERT INTO auth_revoked (id, expire_at) VALUES ($id, $expire_at);
+        ";
+        let span = query_span(parent, "sessions.revoke", QUERY);
+        let id = id.to_owned();
+        let expire_at = SystemTime::UNIX_EPOCH + Duration::from_secs(expire_at);
+        let result = self
+            .table_client
+            .retry_transaction(|mut t| {
+                let id = id.clone();
+                async move {
+                    t.query(Query::new(QUERY).with_params(ydb_params!(
+                        "$id" => id,
+                        "$expire_at" => expire_at,
+                    )))
+                    .await?;
+                    t.commit().await?;
+                    Ok(())
+                }
+            })
+            .await
+            .map_err(ydb_error);
+        finish(span, result)
+    }
+
+    /// Returns IDs of revoked tokens which are not expired yet with their
+    /// expiry in unix seconds.
+    pub async fn revoked(&self, parent: &SpanContext) -> Result<Vec<(String, u64)>, Status> {
+        const QUERY: &str = "
+            SELECT id, expire_at FROM auth_revoked
+            WHERE expire_at > CurrentUtcTimestamp();
+        ";
+        let span = query_span(parent, "sessions.revoked", QUERY);
+        let result = self
+            .table_client
+            .retry_transaction(|mut t| async move {
+                let result = t.query(Query::new(QUERY)).await?;
+                let mut revoked = Vec::new();
+                for mut row in result.into_only_result()?.rows() {
+                    let id: Option<String> = row.remove_field_by_name("id")?.try_into()?;
+                    let expire_at: Option<SystemTime> =
+                        row.remove_field_by_name("expire_at")?.try_into()?;
+                    if let (Some(id), Some(expire_at)) = (id, expire_at) {
+                        revoked.push((id, unix_seconds(expire_at)));
+                    }
+                }
+                Ok(revoked)
+            })
+            .await
+            .map_err(ydb_error);
+        finish(span, result)
+    }
+
+    pub async fn is_revoked(&self, parent: &SpanContext, id: &str) -> Result<bool, Status> {
+        const QUERY: &str = "
+            DECLARE $id AS Utf8;
+            SELECT id FROM auth_revoked WHERE id = $id;
+        ";
+        let span = query_span(parent, "sessions.is_revoked", QUERY);
+        let id = id.to_owned();
+        let result = self
+            .table_client
+            .retry_transaction(|mut t| {
+                let id = id.clone();
+                async move {
+                    let result = t
+                        .query(Query::new(QUERY).with_params(ydb_params!("$id" => id)))
+                        .await?;
+                    Ok(result.into_only_result()?.rows().next().is_some())
+                }
+            })
+            .await
+            .map_err(ydb_error);
+        finish(span, result)
+    }
+
+    /// Reports whether YDB answers a trivial query.
+    pub async fn healthy(&self) -> bool {
+        self.table_client
+            .retry_transaction(|mut t| async move {
+                t.query(Query::new("SELECT 1;")).await?;
+                Ok(())
+            })
+            .await
+            .is_ok()
+    }
+}
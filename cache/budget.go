@@ -0,0 +1,107 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/jellydator/ttlcache/v3"
+)
+
+// budget keeps size of cached links within limit bytes under memory policy.
+// Size of link is length of its hash and url, so a few very long urls push
+// out many short ones. Least recently used links are evicted first.
+type budget struct {
+	mu    sync.Mutex
+	limit uint64
+	bytes uint64
+	// lru holds *budgetEntry, the most recently used in front
+	lru     *list.List
+	entries map[string]*list.Element
+	// evicted are items evicted over limit until cache reports their eviction
+	evicted map[*ttlcache.Item[string, link]]bool
+}
+
+type budgetEntry struct {
+	item *ttlcache.Item[string, link]
+	size uint64
+}
+
+func newBudget(limit uint64) *budget {
+	return &budget{
+		limit:   limit,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		evicted: make(map[*ttlcache.Item[string, link]]bool),
+	}
+}
+
+func linkSize(item *ttlcache.Item[string, link]) uint64 {
+	return uint64(len(item.Key()) + len(item.Value().url))
+}
+
+// add accounts item stored in cache and returns hashes of links to evict,
+// so the rest fits into limit. Link larger than limit evicts only itself.
+func (b *budget) add(item *ttlcache.Item[string, link]) (evict []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if e, ok := b.entries[item.Key()]; ok {
+		b.bytes -= e.Value.(*budgetEntry).size
+		b.lru.Remove(e)
+	}
+	size := linkSize(item)
+	if size > b.limit {
+		// caching it would push out everything else
+		b.evicted[item] = true
+		return []string{item.Key()}
+	}
+	b.entries[item.Key()] = b.lru.PushFront(&budgetEntry{
+		item: item,
+		size: size,
+	})
+	b.bytes += size
+	for b.bytes > b.limit && b.lru.Len() > 0 {
+		e := b.lru.Back().Value.(*budgetEntry)
+		b.forget(e)
+		b.evicted[e.item] = true
+		evict = append(evict, e.item.Key())
+	}
+	return evict
+}
+
+// touch marks link of hash as recently used
+func (b *budget) touch(hash string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if e, ok := b.entries[hash]; ok {
+		b.lru.MoveToFront(e)
+	}
+}
+
+// release forgets item evicted from cache and reports whether it was evicted
+// over limit. Eviction of item replaced since then changes nothing.
+func (b *budget) release(item *ttlcache.Item[string, link]) (overLimit bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.evicted[item] {
+		delete(b.evicted, item)
+		return true
+	}
+	if e, ok := b.entries[item.Key()]; ok && e.Value.(*budgetEntry).item == item {
+		b.forget(e.Value.(*budgetEntry))
+	}
+	return false
+}
+
+func (b *budget) forget(e *budgetEntry) {
+	el := b.entries[e.item.Key()]
+	delete(b.entries, e.item.Key())
+	b.lru.Remove(el)
+	b.bytes -= e.size
+}
+
+// size returns bytes of accounted links
+func (b *budget) size() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.bytes
+}
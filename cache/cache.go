@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
+)
+
+// linkCache keeps copies of links served as Storage service
+type linkCache interface {
+	pb.StorageServer
+	// drop removes cached link of hash and reports whether it was cached
+	drop(ctx context.Context, hash string) (bool, error)
+	// dropAll removes every cached link
+	dropAll(ctx context.Context) error
+	// stats reports content of cache and lookups served since start
+	stats(ctx context.Context) (*pb.CacheStatsResponse, error)
+	// start runs background work of cache. Returned stop waits until it
+	// ends and releases cache.
+	start() (stop func())
+}
+
+type cacheConstructor func(ctx context.Context, tr trace.Tracer, cfg *Config) (linkCache, error)
+
+// modes are keyed by Config.Mode value. Links cached locally are seen by one
+// replica only, links cached in Redis are shared by all of them.
+var modes = map[string]cacheConstructor{
+	"local": newLocalCache,
+	"redis": newRedisCache,
+}
+
+func newCache(ctx context.Context, tr trace.Tracer, cfg *Config) (linkCache, error) {
+	constructor, ok := modes[cfg.Mode]
+	if !ok {
+		return nil, fmt.Errorf("unknown cache mode '%s'", cfg.Mode)
+	}
+	if cfg.Cache.TTLJitter < 0 || cfg.Cache.TTLJitter > 1 {
+		return nil, fmt.Errorf("ttl jitter %v is not within [0, 1]", cfg.Cache.TTLJitter)
+	}
+	c, err := constructor(ctx, tr, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Cache.RefreshWait > 0 {
+		c = newRefreshLocks(c, cfg.Cache.RefreshWait)
+	}
+	return c, nil
+}
+
+// jitter cuts random share up to share from ttl, so links cached at once do
+// not expire at once and their lookups do not hit storage together. Zero ttl
+// is kept unbounded.
+func jitter(ttl time.Duration, share float64) time.Duration {
+	if ttl <= 0 || share <= 0 {
+		return ttl
+	}
+	return ttl - time.Duration(rand.Float64()*share*float64(ttl))
+}
+
+func newLocalCache(ctx context.Context, tr trace.Tracer, cfg *Config) (linkCache, error) {
+	s, err := newStorage(ctx, tr, cfg.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
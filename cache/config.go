@@ -0,0 +1,75 @@
+package main
+
+import (
+	"time"
+
+	"github.com/asmyasnikov/webinar-jaeger/internal/config"
+	"github.com/asmyasnikov/webinar-jaeger/internal/keepalive"
+	"github.com/asmyasnikov/webinar-jaeger/internal/mtls"
+	"github.com/asmyasnikov/webinar-jaeger/internal/telemetry"
+)
+
+type Config struct {
+	Port            int              `yaml:"port" usage:"gRPC listen port"`
+	Telemetry       telemetry.Config `yaml:",inline"`
+	MetricsPort     int              `yaml:"metrics_port" usage:"Prometheus metrics listen port, 0 disables metrics"`
+	StorageAddr     string           `yaml:"storage_addr" usage:"address of storage service to watch for changes, empty disables invalidation"`
+	Mode            string           `yaml:"mode" usage:"where links are cached: local keeps them in process, redis shares them between replicas"`
+	RedisAddr       string           `yaml:"redis_addr" usage:"Redis host:port in redis mode"`
+	Cache           cacheConfig      `yaml:"cache"`
+	Warmup          warmupConfig     `yaml:"warmup"`
+	TLS             mtls.Config      `yaml:"tls"`
+	Keepalive       keepalive.Config `yaml:"keepalive"`
+	ShutdownTimeout time.Duration    `yaml:"shutdown_timeout" usage:"time to drain in-flight requests on shutdown"`
+	GRPCReflection  bool             `yaml:"grpc_reflection" usage:"register gRPC reflection service for grpcurl and evans"`
+	ServiceKey      string           `yaml:"service_key" usage:"shared key other services must send, calls without it are accepted if empty"`
+	ServicePeers    []string         `yaml:"service_peers" usage:"comma-separated common or DNS names of mTLS client certificates accepted without key"`
+}
+
+// cacheConfig bounds cached links. Redis mode uses only TTL, Redis evicts
+// links by its own maxmemory-policy.
+type cacheConfig struct {
+	Capacity    uint64        `yaml:"capacity" usage:"max number of cached links under lru policy"`
+	MemoryLimit uint64        `yaml:"memory_limit" usage:"max bytes of hashes and urls of cached links under memory policy"`
+	TTL         time.Duration `yaml:"ttl" usage:"time link stays in cache, shorter if link expires earlier"`
+	TTLJitter   float64       `yaml:"ttl_jitter" usage:"max share of ttl randomly cut from every link, so links cached together do not expire together"`
+	ReadThrough bool          `yaml:"read_through" usage:"fetch missed links from storage at storage_addr once for all concurrent lookups and cache them"`
+	RefreshWait time.Duration `yaml:"refresh_wait" usage:"time lookups of a missed link wait while the first one refreshes it from storage, 0 disables waiting"`
+	Policy      string        `yaml:"policy" usage:"eviction policy: lru evicts least recently used links beyond capacity and extends ttl on hit, memory does the same beyond memory limit, ttl keeps any number of links until ttl passes"`
+}
+
+// warmupConfig makes cache preload links from storage before serving
+type warmupConfig struct {
+	Links   uint32        `yaml:"links" usage:"number of links preloaded from storage before serving, 0 disables warm-up"`
+	Order   string        `yaml:"order" usage:"links preloaded first: clicks for the most clicked (YDB storage only) or hash"`
+	Timeout time.Duration `yaml:"timeout" usage:"time warm-up may take, cache serves links loaded by then"`
+}
+
+func loadConfig() (*Config, error) {
+	cfg := &Config{
+		Port:        5302,
+		Telemetry:   telemetry.DefaultConfig(),
+		MetricsPort: 5304,
+		StorageAddr: "localhost:5300",
+		Mode:        "local",
+		RedisAddr:   "localhost:6379",
+		Cache: cacheConfig{
+			Capacity:    5,
+			MemoryLimit: 64 << 20,
+			TTL:         time.Minute,
+			TTLJitter:   0.1,
+			RefreshWait: 40 * time.Millisecond,
+			Policy:      "lru",
+		},
+		Warmup: warmupConfig{
+			Order:   "clicks",
+			Timeout: 30 * time.Second,
+		},
+		Keepalive:       keepalive.DefaultConfig(),
+		ShutdownTimeout: 10 * time.Second,
+	}
+	if err := config.Load(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
@@ -2,67 +2,72 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"google.golang.org/grpc"
-	"log"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
-	"time"
+	"sync"
+	"syscall"
 
 	_ "github.com/ydb-platform/ydb-go-sdk/v3"
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
-	jaegerPropogator "go.opentelemetry.io/contrib/propagators/jaeger"
-	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/exporters/jaeger"
-	"go.opentelemetry.io/otel/sdk/resource"
-	tracesdk "go.opentelemetry.io/otel/sdk/trace"
-	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slog"
 
+	"github.com/asmyasnikov/webinar-jaeger/internal/logging"
+	"github.com/asmyasnikov/webinar-jaeger/internal/metrics"
+	"github.com/asmyasnikov/webinar-jaeger/internal/requestid"
+	"github.com/asmyasnikov/webinar-jaeger/internal/serviceauth"
+	"github.com/asmyasnikov/webinar-jaeger/internal/telemetry"
 	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
 )
 
-const (
-	applicationID = "cache"
-	port          = 5302
-)
+const applicationID = "cache"
 
-func tracerProvider(url string) (*tracesdk.TracerProvider, error) {
-	exp, err := jaeger.New(jaeger.WithCollectorEndpoint(jaeger.WithEndpoint(url)))
-	if err != nil {
-		return nil, err
+// gracefulStop stops accepting new RPCs and waits for in-flight ones until ctx
+// is done, then closes remaining connections
+func gracefulStop(ctx context.Context, s *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.Stop()
+		<-done
 	}
-
-	otel.SetTextMapPropagator(jaegerPropogator.Jaeger{})
-
-	tp := tracesdk.NewTracerProvider(
-		// Always be sure to batch in production.
-		tracesdk.WithBatcher(exp),
-		// Record information about this application in a Resource.
-		tracesdk.WithResource(resource.NewWithAttributes(
-			semconv.SchemaURL,
-			semconv.ServiceNameKey.String(applicationID),
-		)),
-	)
-
-	return tp, nil
 }
 
 func main() {
+	logging.Init(applicationID)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		slog.Error("load config failed", slog.Any("error", err))
+		return
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	tp, err := tracerProvider("http://localhost:14268/api/traces")
+	tp, err := telemetry.Init(ctx, applicationID, cfg.Telemetry)
 	if err != nil {
 		panic(err)
 	}
 	defer func(ctx context.Context) {
-		// Do not make the application hang when it is shutdown.
-		ctx, cancel = context.WithTimeout(ctx, time.Second*5)
-		defer cancel()
-		if err := tp.Shutdown(ctx); err != nil {
-			log.Fatal(err)
+		if err := telemetry.Shutdown(ctx, tp); err != nil {
+			slog.ErrorCtx(ctx, "shutdown tracer provider failed", slog.Any("error", err))
+			os.Exit(1)
 		}
 	}(ctx)
 
@@ -71,47 +76,186 @@ func main() {
 	ctx, span := tr.Start(ctx, "main")
 	defer span.End()
 
-	s, err := newStorage(ctx, tr)
+	c, err := newCache(ctx, tr, cfg)
 	if err != nil {
 		span.SetAttributes(attribute.Bool("error", true))
 		span.RecordError(err)
-		fmt.Println(err)
+		slog.ErrorCtx(ctx, "init cache failed", slog.Any("error", err))
+		return
+	}
+	stopCache := c.start()
+	defer stopCache()
+	span.AddEvent("cache started", trace.WithAttributes(
+		attribute.String("mode", cfg.Mode),
+	))
+
+	if cfg.Cache.ReadThrough && cfg.StorageAddr == "" {
+		err = errors.New("read-through requires storage address")
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		slog.ErrorCtx(ctx, "init cache failed", slog.Any("error", err))
 		return
 	}
 
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if cfg.StorageAddr != "" {
+		creds, err := cfg.TLS.ClientCredentials()
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+			slog.ErrorCtx(ctx, "load TLS config failed", slog.Any("error", err))
+			return
+		}
+		w, err := newWatcher(ctx, tr, creds, cfg.Keepalive, cfg.ServiceKey, cfg.StorageAddr, c)
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+			slog.ErrorCtx(ctx, "init storage watcher failed", slog.Any("error", err))
+			return
+		}
+		defer w.Close()
+
+		// watch is cancelled before connection is closed, so it exits quietly
+		watchCtx, watchCancel := context.WithCancel(ctx)
+		defer watchCancel()
+
+		go w.run(watchCtx)
+		span.AddEvent("storage watcher started")
+
+		if cfg.Cache.ReadThrough {
+			c = newReadThrough(tr, c, w.client)
+			span.AddEvent("read-through enabled")
+		}
+
+		// cache is not served until warm-up ends, so the first lookups hit
+		if cfg.Warmup.Links > 0 {
+			loaded, err := w.warmUp(ctx, cfg.Warmup)
+			if err != nil {
+				slog.WarnCtx(ctx, "warm-up failed, cache serves links loaded so far",
+					slog.Int("loaded", loaded), slog.Any("error", err))
+			} else {
+				slog.InfoCtx(ctx, "warm-up done", slog.Int("loaded", loaded))
+			}
+			span.AddEvent("warm-up ended", trace.WithAttributes(
+				attribute.Int("loaded", loaded),
+			))
+		}
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.Port))
 	if err != nil {
 		span.SetAttributes(attribute.Bool("error", true))
 		span.RecordError(err)
-		fmt.Println(err)
+		slog.ErrorCtx(ctx, "listen failed", slog.Any("error", err))
 		return
 	}
 
-	grpcServer := grpc.NewServer(
-		grpc.UnaryInterceptor(otelgrpc.UnaryServerInterceptor()),
-		grpc.StreamInterceptor(otelgrpc.StreamServerInterceptor()),
-	)
+	opts := []grpc.ServerOption{
+		// tracing goes first, so others see span of the call
+		grpc.ChainUnaryInterceptor(
+			otelgrpc.UnaryServerInterceptor(),
+			requestid.UnaryServerInterceptor(),
+			metrics.UnaryServerInterceptor(),
+			serviceauth.UnaryServerInterceptor(cfg.ServiceKey, cfg.ServicePeers),
+		),
+		grpc.ChainStreamInterceptor(
+			otelgrpc.StreamServerInterceptor(),
+			requestid.StreamServerInterceptor(),
+			metrics.StreamServerInterceptor(),
+			serviceauth.StreamServerInterceptor(cfg.ServiceKey, cfg.ServicePeers),
+		),
+	}
+	if cfg.TLS.Enabled() {
+		tlsConfig, err := cfg.TLS.ServerConfig()
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+			slog.ErrorCtx(ctx, "load TLS config failed", slog.Any("error", err))
+			return
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		span.AddEvent("mTLS enabled")
+	}
 
-	pb.RegisterStorageServer(grpcServer, s)
+	opts = append(opts, cfg.Keepalive.ServerOptions()...)
+	grpcServer := grpc.NewServer(opts...)
+
+	pb.RegisterStorageServer(grpcServer, c)
+	pb.RegisterCacheServer(grpcServer, newCacheServer(tr, c))
 	span.AddEvent("storage server registered")
 
+	// cache is serving as soon as it is initialized, Redis is pinged by then
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(pb.Storage_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthServer.SetServingStatus(pb.Cache_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	span.AddEvent("health server registered")
+
+	// reflection lets grpcurl and evans call services without proto files
+	if cfg.GRPCReflection {
+		reflection.Register(grpcServer)
+		span.AddEvent("reflection registered")
+	}
+
 	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, os.Interrupt)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(ch)
+
+	// both gRPC server and metrics server stop service on failure, signals channel
+	// is never closed, signal arriving during shutdown would panic
+	done := make(chan struct{})
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			close(done)
+		})
+	}
 
 	go func() {
 		if err := grpcServer.Serve(listener); err != nil {
 			span.SetAttributes(attribute.Bool("error", true))
 			span.RecordError(err)
-			fmt.Println(err)
-			close(ch)
+			slog.ErrorCtx(ctx, "serve gRPC failed", slog.Any("error", err))
+			stop()
 		}
 	}()
 
-	fmt.Printf("Start cache service on port %d...\n", port)
+	slog.InfoCtx(ctx, "start cache service", slog.Int("port", cfg.Port))
+
+	if cfg.MetricsPort != 0 {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+
+		metricsServer := &http.Server{
+			Addr:    fmt.Sprintf(":%d", cfg.MetricsPort),
+			Handler: mux,
+		}
+		defer metricsServer.Close()
+
+		go func() {
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				span.SetAttributes(attribute.Bool("error", true))
+				span.RecordError(err)
+				slog.ErrorCtx(ctx, "serve metrics failed", slog.Any("error", err))
+				stop()
+			}
+		}()
 
-	for range ch {
-		fmt.Println("shutdown...")
+		slog.InfoCtx(ctx, "start metrics", slog.Int("port", cfg.MetricsPort))
+	}
+
+	select {
+	case <-ch:
 		span.AddEvent("received interrupt signal")
-		return
+	case <-done:
 	}
+	slog.InfoCtx(ctx, "shutdown")
+
+	// let clients route around this instance while it drains
+	healthServer.Shutdown()
+
+	stopCtx, stopCancel := context.WithTimeout(ctx, cfg.ShutdownTimeout)
+	defer stopCancel()
+
+	gracefulStop(stopCtx, grpcServer)
+	span.AddEvent("grpc server stopped")
 }
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jellydator/ttlcache/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	cacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_lookups_total",
+		Help: "Total number of link lookups by result, hit or miss.",
+	}, []string{"method", "result"})
+	cacheEvictions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_evictions_total",
+		Help: "Total number of links removed from cache by reason.",
+	}, []string{"reason"})
+)
+
+// evictionReasons are label values of ttlcache eviction reasons
+var evictionReasons = map[ttlcache.EvictionReason]string{
+	ttlcache.EvictionReasonDeleted:         "deleted",
+	ttlcache.EvictionReasonCapacityReached: "capacity",
+	ttlcache.EvictionReasonExpired:         "expired",
+}
+
+// lookupStats counts lookups served by cache since start
+type lookupStats struct {
+	hits   uint64
+	misses uint64
+}
+
+// observe records result of lookup as cache.hit attribute of span and as
+// metrics
+func (l *lookupStats) observe(span trace.Span, method string, hit bool) {
+	span.SetAttributes(attribute.Bool("cache.hit", hit))
+	if hit {
+		atomic.AddUint64(&l.hits, 1)
+		cacheLookups.WithLabelValues(method, "hit").Inc()
+	} else {
+		atomic.AddUint64(&l.misses, 1)
+		cacheLookups.WithLabelValues(method, "miss").Inc()
+	}
+}
+
+func (l *lookupStats) counts() (hits, misses uint64) {
+	return atomic.LoadUint64(&l.hits), atomic.LoadUint64(&l.misses)
+}
+
+// ratio is share of lookups which hit, 0 before the first lookup
+func (l *lookupStats) ratio() float64 {
+	hits, misses := l.counts()
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+// evictionStats counts evictions by reason since start
+type evictionStats struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+func (e *evictionStats) add(reason string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.counts == nil {
+		e.counts = make(map[string]uint64)
+	}
+	e.counts[reason]++
+}
+
+// snapshot returns copy of counts
+func (e *evictionStats) snapshot() map[string]uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	counts := make(map[string]uint64, len(e.counts))
+	for reason, n := range e.counts {
+		counts[reason] = n
+	}
+	return counts
+}
+
+// register exports hit ratio of lookups
+func (l *lookupStats) register() {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "cache_hit_ratio",
+		Help: "Share of link lookups served from cache since start.",
+	}, l.ratio)
+}
+
+// registerMetrics exports number of cached links and hit ratio of s and
+// counts evictions of its links. Evictions are reported to budget here too,
+// which tells evictions over memory limit from deletions.
+func (s *storage) registerMetrics() {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "cache_links",
+		Help: "Number of cached links.",
+	}, func() float64 {
+		return float64(s.urls.Len())
+	})
+	s.lookups.register()
+	if s.budget != nil {
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "cache_bytes",
+			Help: "Length of hashes and urls of cached links under memory policy.",
+		}, func() float64 {
+			return float64(s.budget.size())
+		})
+	}
+	s.urls.OnEviction(func(_ context.Context, reason ttlcache.EvictionReason, item *ttlcache.Item[string, link]) {
+		label := evictionReasons[reason]
+		if s.budget != nil && s.budget.release(item) {
+			label = "memory"
+		}
+		cacheEvictions.WithLabelValues(label).Inc()
+		s.evictions.add(label)
+	})
+}
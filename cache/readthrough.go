@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slog"
+	"golang.org/x/sync/singleflight"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/asmyasnikov/webinar-jaeger/internal/requestid"
+	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
+)
+
+// readThroughTimeout bounds fetch of missed link from storage. The fetch is
+// shared by callers, so it is not bounded by deadline of any of them.
+const readThroughTimeout = 500 * time.Millisecond
+
+// readThrough fetches links missed by cache from storage and caches them.
+// Concurrent lookups of the same hash share one fetch, so a hot link which
+// left cache costs storage one call instead of one per caller. Links
+// missing, expired or failed to fetch are reported as cache misses, so
+// callers fall back to storage as without read-through.
+type readThrough struct {
+	linkCache
+
+	tr       trace.Tracer
+	upstream pb.StorageClient
+	group    singleflight.Group
+}
+
+func newReadThrough(tr trace.Tracer, c linkCache, upstream pb.StorageClient) *readThrough {
+	return &readThrough{
+		linkCache: c,
+		tr:        tr,
+		upstream:  upstream,
+	}
+}
+
+func (r *readThrough) Get(ctx context.Context, request *pb.GetRequest) (*pb.GetResponse, error) {
+	response, err := r.linkCache.Get(ctx, request)
+	if status.Code(err) != codes.NotFound {
+		return response, err
+	}
+	ch := r.group.DoChan(request.GetHash(), func() (interface{}, error) {
+		return r.fetch(detach(ctx), request.GetHash())
+	})
+	select {
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	case res := <-ch:
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("cache.read_through.shared", res.Shared))
+		if res.Err != nil {
+			return response, err
+		}
+		return res.Val.(*pb.GetResponse), nil
+	}
+}
+
+// fetch gets link of hash from storage and caches it
+func (r *readThrough) fetch(ctx context.Context, hash string) (response *pb.GetResponse, err error) {
+	ctx, span := r.tr.Start(ctx, "readThrough", trace.WithAttributes(
+		attribute.String("hash", hash),
+	))
+	defer func() {
+		if err != nil && status.Code(err) != codes.NotFound {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+			slog.WarnCtx(ctx, "read-through failed", slog.String("hash", hash), slog.Any("error", err))
+		} else if err == nil {
+			span.AddEvent("link fetched")
+		}
+		span.End()
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, readThroughTimeout)
+	defer cancel()
+
+	response, err = r.upstream.Get(ctx, &pb.GetRequest{
+		Hash: hash,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if response.GetExpiresAt() != nil && response.GetExpiresAt().AsTime().Before(time.Now()) {
+		return nil, notFound("link expired")
+	}
+	if _, err = r.linkCache.Put(ctx, &pb.PutRequest{
+		Url:       response.GetUrl(),
+		Hash:      hash,
+		ExpiresAt: response.GetExpiresAt(),
+	}); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// detach keeps trace and request id of ctx without its deadline and
+// cancellation
+func detach(ctx context.Context) context.Context {
+	detached := trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx))
+	return requestid.NewContext(detached, requestid.FromContext(ctx))
+}
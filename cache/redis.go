@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/asmyasnikov/webinar-jaeger/internal/redistrace"
+	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
+)
+
+const (
+	// keys of cache are prefixed, so cache may share Redis with storage
+	// service and dropAll keeps keys of others
+	redisKeyPrefix = "cache:"
+	// every cached link is stored as redis hash with url and optional
+	// expires_at fields
+	redisLinkPrefix = redisKeyPrefix + "link:"
+	// string with hash of the latest cached link to url. Key left by dropped
+	// or replaced link is detected on lookup, as link of hash is missing or
+	// has another url.
+	redisURLPrefix = redisKeyPrefix + "url:"
+	// dropAll deletes keys by batches of redisScanCount
+	redisScanCount = 100
+)
+
+func redisLinkKey(hash string) string {
+	return redisLinkPrefix + hash
+}
+
+func redisURLKey(url string) string {
+	return redisURLPrefix + url
+}
+
+// redisCache keeps links in Redis, so every replica of cache serves links
+// cached by others. Redis removes expired links itself and evicts the rest
+// by its maxmemory-policy, so only TTL of cache settings applies.
+type redisCache struct {
+	pb.UnimplementedStorageServer
+
+	tr     trace.Tracer
+	client *redis.Client
+	// ttl bounds time link stays in cache, 0 is unbounded
+	ttl time.Duration
+	// ttlJitter is max share of ttl cut from every link
+	ttlJitter float64
+	lookups   lookupStats
+}
+
+func newRedisCache(ctx context.Context, tr trace.Tracer, cfg *Config) (_ linkCache, err error) {
+	ctx, span := tr.Start(ctx, "newRedisCache", trace.WithAttributes(
+		attribute.String("address", cfg.RedisAddr),
+		attribute.String("ttl", cfg.Cache.TTL.String()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	client := redis.NewClient(&redis.Options{
+		Addr: cfg.RedisAddr,
+	})
+	client.AddHook(redistrace.Hook(tr))
+
+	if err = client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+
+	c := &redisCache{
+		tr:        tr,
+		client:    client,
+		ttl:       cfg.Cache.TTL,
+		ttlJitter: cfg.Cache.TTLJitter,
+	}
+	c.lookups.register()
+	slog.InfoCtx(ctx, "cache configured",
+		slog.String("mode", cfg.Mode),
+		slog.String("redis_addr", cfg.RedisAddr),
+		slog.String("ttl", cfg.Cache.TTL.String()),
+		slog.Float64("ttl_jitter", cfg.Cache.TTLJitter),
+	)
+	return c, nil
+}
+
+// redisError turns failure of Redis into Unavailable, so callers fall back
+// to storage service
+func redisError(err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	return detailedError(codes.Unavailable, pb.ErrorReason_ERROR_REASON_UNAVAILABLE,
+		"cache unavailable", err.Error())
+}
+
+func (c *redisCache) Put(ctx context.Context, request *pb.PutRequest) (response *pb.PutResponse, err error) {
+	ctx, span := c.tr.Start(ctx, "Put", trace.WithAttributes(
+		attribute.String("url", request.GetUrl()),
+		attribute.String("hash", request.GetHash()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("put done")
+		}
+		span.End()
+	}()
+	if request.GetHash() == "" || request.GetUrl() == "" {
+		return nil, invalidRequest("hash and url are required")
+	}
+	ttl := jitter(c.ttl, c.ttlJitter)
+	fields := []interface{}{"url", request.GetUrl()}
+	if request.GetExpiresAt() != nil {
+		// link must disappear from cache not later than it expires
+		until := time.Until(request.GetExpiresAt().AsTime())
+		if until <= 0 {
+			span.AddEvent("link already expired")
+			return &pb.PutResponse{}, nil
+		}
+		if ttl == 0 || until < ttl {
+			ttl = until
+		}
+		fields = append(fields, "expires_at", request.GetExpiresAt().AsTime().Format(time.RFC3339Nano))
+	}
+	linkKey := redisLinkKey(request.GetHash())
+	_, err = c.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		// fields of replaced link must not outlive it
+		p.Del(ctx, linkKey)
+		p.HSet(ctx, linkKey, fields...)
+		if ttl > 0 {
+			p.PExpire(ctx, linkKey, ttl)
+		}
+		p.Set(ctx, redisURLKey(request.GetUrl()), request.GetHash(), ttl)
+		return nil
+	})
+	if err != nil {
+		return nil, redisError(err)
+	}
+	return &pb.PutResponse{}, nil
+}
+
+// BatchPut caches every link, cache never reports collisions
+func (c *redisCache) BatchPut(ctx context.Context, request *pb.BatchPutRequest) (response *pb.BatchPutResponse, err error) {
+	ctx, span := c.tr.Start(ctx, "BatchPut", trace.WithAttributes(
+		attribute.Int("count", len(request.GetLinks())),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("batch put done")
+		}
+		span.End()
+	}()
+	response = &pb.BatchPutResponse{
+		Results: make([]*pb.PutResult, 0, len(request.GetLinks())),
+	}
+	for _, l := range request.GetLinks() {
+		if _, err = c.Put(ctx, l); err != nil {
+			return nil, err
+		}
+		response.Results = append(response.Results, &pb.PutResult{
+			Hash: l.GetHash(),
+		})
+	}
+	return response, nil
+}
+
+// link reads cached link of hash, nil if it is not cached
+func (c *redisCache) link(ctx context.Context, hash string) (*link, error) {
+	values, err := c.client.HMGet(ctx, redisLinkKey(hash), "url", "expires_at").Result()
+	if err != nil {
+		return nil, err
+	}
+	url, _ := values[0].(string)
+	if url == "" {
+		return nil, nil
+	}
+	l := &link{
+		url: url,
+	}
+	if s, ok := values[1].(string); ok {
+		expiresAt, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, fmt.Errorf("parse expires_at of cached link '%s': %w", hash, err)
+		}
+		l.expiresAt = timestamppb.New(expiresAt)
+	}
+	return l, nil
+}
+
+func (c *redisCache) Get(ctx context.Context, request *pb.GetRequest) (response *pb.GetResponse, err error) {
+	ctx, span := c.tr.Start(ctx, "Get", trace.WithAttributes(
+		attribute.String("hash", request.GetHash()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("get done", trace.WithAttributes(
+				attribute.String("url", response.GetUrl()),
+			))
+		}
+		span.End()
+	}()
+	if request.GetHash() == "" {
+		return nil, invalidRequest("hash is required")
+	}
+	l, err := c.link(ctx, request.GetHash())
+	if err != nil {
+		return nil, redisError(err)
+	}
+	c.lookups.observe(span, "Get", l != nil)
+	if l != nil {
+		return &pb.GetResponse{
+			Url:       l.url,
+			ExpiresAt: l.expiresAt,
+		}, nil
+	}
+	return nil, notFound(fmt.Sprintf("url for hash '%s' not found", request.GetHash()))
+}
+
+func (c *redisCache) GetByURL(ctx context.Context, request *pb.GetByURLRequest) (response *pb.GetByURLResponse, err error) {
+	ctx, span := c.tr.Start(ctx, "GetByURL", trace.WithAttributes(
+		attribute.String("url", request.GetUrl()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("get by url done", trace.WithAttributes(
+				attribute.String("hash", response.GetHash()),
+			))
+		}
+		span.End()
+	}()
+	if request.GetUrl() == "" {
+		return nil, invalidRequest("url is required")
+	}
+	hash, err := c.client.Get(ctx, redisURLKey(request.GetUrl())).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, redisError(err)
+	}
+	if hash != "" {
+		l, err := c.link(ctx, hash)
+		if err != nil {
+			return nil, redisError(err)
+		}
+		if l != nil && l.url == request.GetUrl() {
+			c.lookups.observe(span, "GetByURL", true)
+			return &pb.GetByURLResponse{
+				Hash:      hash,
+				ExpiresAt: l.expiresAt,
+			}, nil
+		}
+	}
+	c.lookups.observe(span, "GetByURL", false)
+	return nil, notFound(fmt.Sprintf("link to url '%s' not found", request.GetUrl()))
+}
+
+func (c *redisCache) Delete(ctx context.Context, request *pb.DeleteRequest) (response *pb.DeleteResponse, err error) {
+	ctx, span := c.tr.Start(ctx, "Delete", trace.WithAttributes(
+		attribute.String("hash", request.GetHash()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("delete done")
+		}
+		span.End()
+	}()
+	if _, err = c.drop(ctx, request.GetHash()); err != nil {
+		return nil, err
+	}
+	return &pb.DeleteResponse{}, nil
+}
+
+// drop deletes link key only, url key left behind points to missing link
+func (c *redisCache) drop(ctx context.Context, hash string) (bool, error) {
+	n, err := c.client.Del(ctx, redisLinkKey(hash)).Result()
+	if err != nil {
+		return false, redisError(err)
+	}
+	return n > 0, nil
+}
+
+func (c *redisCache) dropAll(ctx context.Context) error {
+	iter := c.client.Scan(ctx, 0, redisKeyPrefix+"*", redisScanCount).Iterator()
+	keys := make([]string, 0, redisScanCount)
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) == redisScanCount {
+			if err := c.client.Unlink(ctx, keys...).Err(); err != nil {
+				return redisError(err)
+			}
+			keys = keys[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return redisError(err)
+	}
+	if len(keys) > 0 {
+		if err := c.client.Unlink(ctx, keys...).Err(); err != nil {
+			return redisError(err)
+		}
+	}
+	return nil
+}
+
+// Stats counts cached links by scan of their keys, so it takes time
+// proportional to size of Redis
+func (c *redisCache) Stats(ctx context.Context, _ *pb.StorageStatsRequest) (response *pb.StorageStatsResponse, err error) {
+	ctx, span := c.tr.Start(ctx, "Stats")
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("stats done", trace.WithAttributes(
+				attribute.Int64("row_count", int64(response.GetRowCount())),
+			))
+		}
+		span.End()
+	}()
+	n, err := c.count(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.StorageStatsResponse{
+		Backend:  "cache",
+		RowCount: n,
+	}, nil
+}
+
+// count scans keys of cached links
+func (c *redisCache) count(ctx context.Context) (uint64, error) {
+	var n uint64
+	iter := c.client.Scan(ctx, 0, redisLinkPrefix+"*", redisScanCount).Iterator()
+	for iter.Next(ctx) {
+		n++
+	}
+	if err := iter.Err(); err != nil {
+		return 0, redisError(err)
+	}
+	return n, nil
+}
+
+// stats counts links like Stats, evictions are up to Redis
+func (c *redisCache) stats(ctx context.Context) (*pb.CacheStatsResponse, error) {
+	n, err := c.count(ctx)
+	if err != nil {
+		return nil, err
+	}
+	hits, misses := c.lookups.counts()
+	return &pb.CacheStatsResponse{
+		Mode:     "redis",
+		Links:    n,
+		Hits:     hits,
+		Misses:   misses,
+		HitRatio: c.lookups.ratio(),
+	}, nil
+}
+
+// start has nothing to run, Redis expires links itself. Returned stop
+// closes connections to Redis.
+func (c *redisCache) start() (stop func()) {
+	return func() {
+		if err := c.client.Close(); err != nil {
+			slog.Error("close redis client failed", slog.Any("error", err))
+		}
+	}
+}
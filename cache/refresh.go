@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
+)
+
+// refreshLocks lets the first lookup which misses a link refresh it from
+// storage, while concurrent lookups of the same hash wait up to wait for the
+// link to be put back instead of going to storage too. The lock is released
+// by Put of the link or after wait, so lost refresh delays lookups no longer.
+// Locks are held by this instance, replicas of cache lock separately.
+type refreshLocks struct {
+	linkCache
+
+	wait time.Duration
+
+	mu sync.Mutex
+	// pending are refreshes in progress by hash, closed when link is put
+	pending map[string]chan struct{}
+}
+
+func newRefreshLocks(c linkCache, wait time.Duration) *refreshLocks {
+	return &refreshLocks{
+		linkCache: c,
+		wait:      wait,
+		pending:   make(map[string]chan struct{}),
+	}
+}
+
+// lock returns channel of refresh of hash and whether it was made by caller
+func (l *refreshLocks) lock(hash string) (refreshed chan struct{}, acquired bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if ch, ok := l.pending[hash]; ok {
+		return ch, false
+	}
+	ch := make(chan struct{})
+	l.pending[hash] = ch
+	time.AfterFunc(l.wait, func() {
+		l.release(hash, ch)
+	})
+	return ch, true
+}
+
+// release wakes lookups waiting for refresh ch of hash, nil ch releases any
+func (l *refreshLocks) release(hash string, ch chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if pending, ok := l.pending[hash]; ok && (ch == nil || pending == ch) {
+		delete(l.pending, hash)
+		close(pending)
+	}
+}
+
+func (l *refreshLocks) Get(ctx context.Context, request *pb.GetRequest) (*pb.GetResponse, error) {
+	response, err := l.linkCache.Get(ctx, request)
+	if status.Code(err) != codes.NotFound {
+		return response, err
+	}
+	span := trace.SpanFromContext(ctx)
+	refreshed, acquired := l.lock(request.GetHash())
+	if acquired {
+		span.SetAttributes(attribute.String("cache.refresh", "locked"))
+		return response, err
+	}
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+	select {
+	case <-refreshed:
+		span.SetAttributes(attribute.String("cache.refresh", "waited"))
+		return l.linkCache.Get(ctx, request)
+	case <-timer.C:
+		span.SetAttributes(attribute.String("cache.refresh", "timed out"))
+	case <-ctx.Done():
+	}
+	return response, err
+}
+
+func (l *refreshLocks) Put(ctx context.Context, request *pb.PutRequest) (*pb.PutResponse, error) {
+	defer l.release(request.GetHash(), nil)
+	return l.linkCache.Put(ctx, request)
+}
+
+func (l *refreshLocks) BatchPut(ctx context.Context, request *pb.BatchPutRequest) (*pb.BatchPutResponse, error) {
+	defer func() {
+		for _, link := range request.GetLinks() {
+			l.release(link.GetHash(), nil)
+		}
+	}()
+	return l.linkCache.BatchPut(ctx, request)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
+)
+
+// cacheServer serves Cache service, which drops cached copies of links on
+// request of services which changed or deleted them and reports how cache
+// does
+type cacheServer struct {
+	pb.UnimplementedCacheServer
+
+	tr    trace.Tracer
+	cache linkCache
+}
+
+func newCacheServer(tr trace.Tracer, c linkCache) *cacheServer {
+	return &cacheServer{
+		tr:    tr,
+		cache: c,
+	}
+}
+
+func (i *cacheServer) Invalidate(ctx context.Context, request *pb.InvalidateRequest) (response *pb.InvalidateResponse, err error) {
+	ctx, span := i.tr.Start(ctx, "Invalidate", trace.WithAttributes(
+		attribute.Int("count", len(request.GetHashes())),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("invalidate done", trace.WithAttributes(
+				attribute.Int64("invalidated", int64(response.GetInvalidated())),
+			))
+		}
+		span.End()
+	}()
+	response = &pb.InvalidateResponse{}
+	for _, hash := range request.GetHashes() {
+		dropped, err := i.cache.drop(ctx, hash)
+		if err != nil {
+			return nil, err
+		}
+		if dropped {
+			response.Invalidated++
+		}
+	}
+	return response, nil
+}
+
+func (i *cacheServer) Stats(ctx context.Context, _ *pb.CacheStatsRequest) (response *pb.CacheStatsResponse, err error) {
+	ctx, span := i.tr.Start(ctx, "CacheStats")
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("stats done", trace.WithAttributes(
+				attribute.Int64("links", int64(response.GetLinks())),
+				attribute.Float64("hit_ratio", response.GetHitRatio()),
+			))
+		}
+		span.End()
+	}()
+	return i.cache.stats(ctx)
+}
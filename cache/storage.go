@@ -3,20 +3,45 @@ package main
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/jellydator/ttlcache/v3"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
 )
 
+type link struct {
+	url       string
+	expiresAt *timestamppb.Timestamp
+}
+
 type storage struct {
 	pb.UnimplementedStorageServer
 
 	tr   trace.Tracer
-	urls *ttlcache.Cache[string, string]
+	urls *ttlcache.Cache[string, link]
+	// hashes points url to hash of its latest cached link. Entry left by
+	// evicted or replaced link is detected on lookup, as cached link of hash
+	// is missing or has another url.
+	hashes *ttlcache.Cache[string, string]
+
+	// ttl bounds time link stays in cache, 0 is unbounded
+	ttl time.Duration
+	// ttlJitter is max share of ttl cut from every link
+	ttlJitter float64
+	// budget bounds size of links under memory policy, nil under others
+	budget *budget
+	// policy evicts links, reported by stats
+	policy    string
+	lookups   lookupStats
+	evictions evictionStats
 }
 
 func (s *storage) Put(ctx context.Context, request *pb.PutRequest) (response *pb.PutResponse, err error) {
@@ -33,10 +58,68 @@ func (s *storage) Put(ctx context.Context, request *pb.PutRequest) (response *pb
 		}
 		span.End()
 	}()
-	s.urls.Set(request.GetHash(), request.GetUrl(), 0)
+	if request.GetHash() == "" || request.GetUrl() == "" {
+		return nil, invalidRequest("hash and url are required")
+	}
+	ttl := jitter(s.ttl, s.ttlJitter)
+	if request.GetExpiresAt() != nil {
+		// link must disappear from cache not later than it expires
+		until := time.Until(request.GetExpiresAt().AsTime())
+		if until <= 0 {
+			span.AddEvent("link already expired")
+			return &pb.PutResponse{}, nil
+		}
+		if ttl == 0 || until < ttl {
+			ttl = until
+		}
+	}
+	item := s.urls.Set(request.GetHash(), link{
+		url:       request.GetUrl(),
+		expiresAt: request.GetExpiresAt(),
+	}, ttl)
+	s.hashes.Set(request.GetUrl(), request.GetHash(), ttl)
+	if s.budget != nil {
+		evict := s.budget.add(item)
+		for _, hash := range evict {
+			s.remove(hash)
+		}
+		if len(evict) > 0 {
+			span.AddEvent("memory limit exceeded", trace.WithAttributes(
+				attribute.Int("evicted", len(evict)),
+			))
+		}
+	}
 	return &pb.PutResponse{}, nil
 }
 
+// BatchPut caches every link, cache never reports collisions
+func (s *storage) BatchPut(ctx context.Context, request *pb.BatchPutRequest) (response *pb.BatchPutResponse, err error) {
+	ctx, span := s.tr.Start(ctx, "BatchPut", trace.WithAttributes(
+		attribute.Int("count", len(request.GetLinks())),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("batch put done")
+		}
+		span.End()
+	}()
+	response = &pb.BatchPutResponse{
+		Results: make([]*pb.PutResult, 0, len(request.GetLinks())),
+	}
+	for _, l := range request.GetLinks() {
+		if _, err = s.Put(ctx, l); err != nil {
+			return nil, err
+		}
+		response.Results = append(response.Results, &pb.PutResult{
+			Hash: l.GetHash(),
+		})
+	}
+	return response, nil
+}
+
 func (s *storage) Get(ctx context.Context, request *pb.GetRequest) (response *pb.GetResponse, err error) {
 	ctx, span := s.tr.Start(ctx, "Get", trace.WithAttributes(
 		attribute.String("hash", request.GetHash()),
@@ -52,29 +135,257 @@ func (s *storage) Get(ctx context.Context, request *pb.GetRequest) (response *pb
 		}
 		span.End()
 	}()
-	if url := s.urls.Get(request.GetHash()); url != nil {
+	if request.GetHash() == "" {
+		return nil, invalidRequest("hash is required")
+	}
+	item := s.urls.Get(request.GetHash())
+	s.lookups.observe(span, "Get", item != nil)
+	if item != nil {
+		s.touch(request.GetHash())
 		return &pb.GetResponse{
-			Url: url.Value(),
+			Url:       item.Value().url,
+			ExpiresAt: item.Value().expiresAt,
 		}, nil
 	}
-	return nil, fmt.Errorf("url for hash '%s' not found", request.GetHash())
+	return nil, notFound(fmt.Sprintf("url for hash '%s' not found", request.GetHash()))
 }
 
-func newStorage(ctx context.Context, tr trace.Tracer) (_ *storage, err error) {
-	ctx, span := tr.Start(ctx, "newStorage")
+func (s *storage) GetByURL(ctx context.Context, request *pb.GetByURLRequest) (response *pb.GetByURLResponse, err error) {
+	ctx, span := s.tr.Start(ctx, "GetByURL", trace.WithAttributes(
+		attribute.String("url", request.GetUrl()),
+	))
 	defer func() {
 		if err != nil {
 			span.SetAttributes(attribute.Bool("error", true))
 			span.RecordError(err)
+		} else {
+			span.AddEvent("get by url done", trace.WithAttributes(
+				attribute.String("hash", response.GetHash()),
+			))
 		}
 		span.End()
 	}()
+	if request.GetUrl() == "" {
+		return nil, invalidRequest("url is required")
+	}
+	if h := s.hashes.Get(request.GetUrl()); h != nil {
+		if item := s.urls.Get(h.Value()); item != nil && item.Value().url == request.GetUrl() {
+			s.lookups.observe(span, "GetByURL", true)
+			s.touch(h.Value())
+			return &pb.GetByURLResponse{
+				Hash:      h.Value(),
+				ExpiresAt: item.Value().expiresAt,
+			}, nil
+		}
+	}
+	s.lookups.observe(span, "GetByURL", false)
+	return nil, notFound(fmt.Sprintf("link to url '%s' not found", request.GetUrl()))
+}
+
+func (s *storage) Delete(ctx context.Context, request *pb.DeleteRequest) (response *pb.DeleteResponse, err error) {
+	ctx, span := s.tr.Start(ctx, "Delete", trace.WithAttributes(
+		attribute.String("hash", request.GetHash()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("delete done")
+		}
+		span.End()
+	}()
+	s.remove(request.GetHash())
+	return &pb.DeleteResponse{}, nil
+}
+
+// touch marks link of hash as recently used under memory policy
+func (s *storage) touch(hash string) {
+	if s.budget != nil {
+		s.budget.touch(hash)
+	}
+}
+
+func (s *storage) drop(_ context.Context, hash string) (bool, error) {
+	return s.remove(hash), nil
+}
+
+func (s *storage) dropAll(context.Context) error {
+	s.urls.DeleteAll()
+	s.hashes.DeleteAll()
+	return nil
+}
+
+// remove removes cached link of hash with its url entry and reports whether
+// the link was cached
+func (s *storage) remove(hash string) bool {
+	item := s.urls.Get(hash, ttlcache.WithDisableTouchOnHit[string, link]())
+	if item == nil {
+		return false
+	}
+	s.urls.Delete(hash)
+	if h := s.hashes.Get(item.Value().url, ttlcache.WithDisableTouchOnHit[string, string]()); h != nil && h.Value() == hash {
+		s.hashes.Delete(item.Value().url)
+	}
+	return true
+}
 
-	return &storage{
-		tr: tr,
-		urls: ttlcache.New[string, string](
-			ttlcache.WithCapacity[string, string](5),
-			ttlcache.WithTTL[string, string](time.Minute),
-		),
+// Stats reports number of cached links, latencies are not recorded by cache
+func (s *storage) Stats(ctx context.Context, _ *pb.StorageStatsRequest) (response *pb.StorageStatsResponse, err error) {
+	_, span := s.tr.Start(ctx, "Stats")
+	defer func() {
+		span.AddEvent("stats done", trace.WithAttributes(
+			attribute.Int64("row_count", int64(response.GetRowCount())),
+		))
+		span.End()
+	}()
+	return &pb.StorageStatsResponse{
+		Backend:  "cache",
+		RowCount: uint64(s.urls.Len()),
 	}, nil
 }
+
+func (s *storage) stats(context.Context) (*pb.CacheStatsResponse, error) {
+	hits, misses := s.lookups.counts()
+	response := &pb.CacheStatsResponse{
+		Mode:      "local",
+		Policy:    s.policy,
+		Links:     uint64(s.urls.Len()),
+		Hits:      hits,
+		Misses:    misses,
+		HitRatio:  s.lookups.ratio(),
+		Evictions: s.evictions.snapshot(),
+	}
+	if s.budget != nil {
+		response.Bytes = s.budget.size()
+		response.MemoryLimit = s.budget.limit
+	}
+	return response, nil
+}
+
+// start removes expired links in background, so they do not hold memory
+// until capacity pushes them out. Every removed link is traced as a span of
+// its own. Returned stop waits until removal ends.
+func (s *storage) start() (stop func()) {
+	unsubscribe := s.urls.OnEviction(func(_ context.Context, reason ttlcache.EvictionReason, item *ttlcache.Item[string, link]) {
+		if reason != ttlcache.EvictionReasonExpired {
+			return
+		}
+		_, span := s.tr.Start(context.Background(), "Expire", trace.WithAttributes(
+			attribute.String("hash", item.Key()),
+		))
+		span.AddEvent("link expired", trace.WithAttributes(
+			attribute.String("url", item.Value().url),
+		))
+		span.End()
+	})
+
+	var wg sync.WaitGroup
+	for _, start := range []func(){s.urls.Start, s.hashes.Start} {
+		wg.Add(1)
+		go func(start func()) {
+			defer wg.Done()
+			start()
+		}(start)
+	}
+	return func() {
+		s.urls.Stop()
+		s.hashes.Stop()
+		wg.Wait()
+		unsubscribe()
+	}
+}
+
+// cacheOptions returns ttlcache options of cfg.Policy
+func cacheOptions[K comparable, V any](cfg cacheConfig) ([]ttlcache.Option[K, V], error) {
+	switch cfg.Policy {
+	case "lru":
+		return []ttlcache.Option[K, V]{
+			ttlcache.WithCapacity[K, V](cfg.Capacity),
+			ttlcache.WithTTL[K, V](cfg.TTL),
+		}, nil
+	case "memory":
+		// budget evicts least recently used links beyond memory limit
+		return []ttlcache.Option[K, V]{
+			ttlcache.WithTTL[K, V](cfg.TTL),
+		}, nil
+	case "ttl":
+		// hits do not extend ttl, so links leave in time without capacity
+		return []ttlcache.Option[K, V]{
+			ttlcache.WithTTL[K, V](cfg.TTL),
+			ttlcache.WithDisableTouchOnHit[K, V](),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown cache policy '%s'", cfg.Policy)
+	}
+}
+
+func newStorage(ctx context.Context, tr trace.Tracer, cfg cacheConfig) (_ *storage, err error) {
+	ctx, span := tr.Start(ctx, "newStorage", trace.WithAttributes(
+		attribute.String("policy", cfg.Policy),
+		attribute.Int64("capacity", int64(cfg.Capacity)),
+		attribute.Int64("memory_limit", int64(cfg.MemoryLimit)),
+		attribute.String("ttl", cfg.TTL.String()),
+		attribute.Float64("ttl_jitter", cfg.TTLJitter),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	urlOptions, err := cacheOptions[string, link](cfg)
+	if err != nil {
+		return nil, err
+	}
+	hashOptions, err := cacheOptions[string, string](cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &storage{
+		tr:        tr,
+		urls:      ttlcache.New[string, link](urlOptions...),
+		hashes:    ttlcache.New[string, string](hashOptions...),
+		ttl:       cfg.TTL,
+		ttlJitter: cfg.TTLJitter,
+		policy:    cfg.Policy,
+	}
+	if cfg.Policy == "memory" {
+		s.budget = newBudget(cfg.MemoryLimit)
+	}
+	s.registerMetrics()
+	slog.InfoCtx(ctx, "cache configured",
+		slog.String("policy", cfg.Policy),
+		slog.Uint64("capacity", cfg.Capacity),
+		slog.Uint64("memory_limit", cfg.MemoryLimit),
+		slog.String("ttl", cfg.TTL.String()),
+		slog.Float64("ttl_jitter", cfg.TTLJitter),
+	)
+	return s, nil
+}
+
+// detailedError returns status error described by desc with ErrorDetail of
+// reason attached, so clients tell cache misses and bad requests apart without
+// parsing messages. Only unreachable Redis may pass if the call is repeated.
+func detailedError(code codes.Code, reason pb.ErrorReason, message, desc string) error {
+	st, err := status.New(code, desc).WithDetails(&pb.ErrorDetail{
+		Reason:    reason,
+		Retryable: reason == pb.ErrorReason_ERROR_REASON_UNAVAILABLE,
+		Message:   message,
+	})
+	if err != nil {
+		return status.Error(code, desc)
+	}
+	return st.Err()
+}
+
+func notFound(message string) error {
+	return detailedError(codes.NotFound, pb.ErrorReason_ERROR_REASON_NOT_FOUND, message, message)
+}
+
+func invalidRequest(message string) error {
+	return detailedError(codes.InvalidArgument, pb.ErrorReason_ERROR_REASON_INVALID_REQUEST, message, message)
+}
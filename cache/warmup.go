@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
+)
+
+// warmupOrders are values of warmupConfig.Order
+var warmupOrders = map[string]pb.ExportOrder{
+	"clicks": pb.ExportOrder_EXPORT_ORDER_MOST_CLICKED,
+	"hash":   pb.ExportOrder_EXPORT_ORDER_HASH,
+}
+
+// warmUp puts links exported by storage into cache, so the first lookups
+// after start do not all miss. Links changed during warm-up are evicted by
+// watch, as it is started before.
+func (w *watcher) warmUp(ctx context.Context, cfg warmupConfig) (loaded int, err error) {
+	ctx, span := w.tr.Start(ctx, "warmUp", trace.WithAttributes(
+		attribute.Int64("limit", int64(cfg.Links)),
+		attribute.String("order", cfg.Order),
+	))
+	defer func() {
+		span.SetAttributes(attribute.Int("loaded", loaded))
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("warm-up done")
+		}
+		span.End()
+	}()
+
+	order, ok := warmupOrders[cfg.Order]
+	if !ok {
+		return 0, fmt.Errorf("unknown warm-up order '%s'", cfg.Order)
+	}
+
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	stream, err := w.client.Export(ctx, &pb.ExportRequest{
+		Limit: cfg.Links,
+		Order: order,
+	})
+	if err != nil {
+		return 0, err
+	}
+	for {
+		link, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return loaded, nil
+		}
+		if err != nil {
+			return loaded, err
+		}
+		if _, err = w.cache.Put(ctx, link); err != nil {
+			return loaded, err
+		}
+		loaded++
+	}
+}
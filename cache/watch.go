@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/asmyasnikov/webinar-jaeger/internal/keepalive"
+	"github.com/asmyasnikov/webinar-jaeger/internal/requestid"
+	"github.com/asmyasnikov/webinar-jaeger/internal/serviceauth"
+	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
+)
+
+const watchRetryInterval = time.Second
+
+// watcher evicts cached links which are changed or deleted in storage. Its
+// connection also serves warm-up and read-through.
+type watcher struct {
+	tr     trace.Tracer
+	cache  linkCache
+	client pb.StorageClient
+	conn   *grpc.ClientConn
+}
+
+func newWatcher(ctx context.Context, tr trace.Tracer, creds credentials.TransportCredentials, ka keepalive.Config, serviceKey, addr string, c linkCache) (*watcher, error) {
+	ctx, span := tr.Start(ctx, "newWatcher", trace.WithAttributes(
+		attribute.String("address", addr),
+	))
+	defer span.End()
+
+	// connection is established lazily, so cache starts while storage is down
+	conn, err := grpc.DialContext(ctx, addr,
+		grpc.WithTransportCredentials(creds),
+		ka.DialOption(),
+		grpc.WithChainUnaryInterceptor(
+			otelgrpc.UnaryClientInterceptor(),
+			requestid.UnaryClientInterceptor(),
+			serviceauth.UnaryClientInterceptor(serviceKey),
+		),
+		grpc.WithChainStreamInterceptor(
+			otelgrpc.StreamClientInterceptor(),
+			serviceauth.StreamClientInterceptor(serviceKey),
+		),
+	)
+	if err != nil {
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return nil, err
+	}
+
+	return &watcher{
+		tr:     tr,
+		cache:  c,
+		client: pb.NewStorageClient(conn),
+		conn:   conn,
+	}, nil
+}
+
+// run watches changes until ctx is done. Changes made while stream is broken
+// are lost, so all cached links are dropped when it breaks.
+func (w *watcher) run(ctx context.Context) {
+	for {
+		err := w.watch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if dropErr := w.cache.dropAll(ctx); dropErr != nil {
+			slog.ErrorCtx(ctx, "watch storage changes failed, drop cache failed",
+				slog.Any("error", err), slog.Any("drop_error", dropErr))
+		} else {
+			slog.WarnCtx(ctx, "watch storage changes failed, cache dropped", slog.Any("error", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watchRetryInterval):
+		}
+	}
+}
+
+func (w *watcher) watch(ctx context.Context) error {
+	stream, err := w.client.WatchChanges(ctx, &pb.WatchChangesRequest{}, grpc.WaitForReady(true))
+	if err != nil {
+		return err
+	}
+	for {
+		change, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		w.evict(ctx, change)
+	}
+}
+
+func (w *watcher) evict(ctx context.Context, change *pb.Change) {
+	// every change is a trace of its own rather than a child of endless watch
+	ctx, span := w.tr.Start(ctx, "evict", trace.WithNewRoot(), trace.WithAttributes(
+		attribute.String("hash", change.GetHash()),
+		attribute.Bool("deleted", change.GetDeleted()),
+	))
+	defer span.End()
+
+	// stored link is cached again on the next Put, so eviction is enough
+	if _, err := w.cache.drop(ctx, change.GetHash()); err != nil {
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		slog.WarnCtx(ctx, "evict changed link failed", slog.String("hash", change.GetHash()), slog.Any("error", err))
+	}
+}
+
+func (w *watcher) Close() error {
+	return w.conn.Close()
+}
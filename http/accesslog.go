@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/exp/slog"
+)
+
+// AccessLogConfig enables logging of every request, so requests whose traces
+// are sampled away still leave a trace_id to search logs of other services by
+type AccessLogConfig struct {
+	Enabled bool    `yaml:"enabled" usage:"log method, route, status, duration and bytes of every request"`
+	Sample  float64 `yaml:"sample" usage:"fraction of requests logged from 0 to 1, failed requests (5xx) are always logged"`
+}
+
+// newAccessLogMiddleware returns router middleware which logs requests. It
+// runs inside tracing middleware, so records carry trace_id of request span.
+func newAccessLogMiddleware(cfg AccessLogConfig) (mux.MiddlewareFunc, error) {
+	if !cfg.Enabled {
+		return func(next http.Handler) http.Handler {
+			return next
+		}, nil
+	}
+	if cfg.Sample < 0 || cfg.Sample > 1 {
+		return nil, fmt.Errorf("access log sample %v is not from 0 to 1", cfg.Sample)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+			next.ServeHTTP(rw, r)
+
+			failed := rw.code >= http.StatusInternalServerError
+			if !failed && cfg.Sample < 1 && rand.Float64() >= cfg.Sample {
+				return
+			}
+			level := slog.LevelInfo
+			if failed {
+				level = slog.LevelWarn
+			}
+			slog.LogAttrs(r.Context(), level, "http request",
+				slog.String("method", r.Method),
+				slog.String("route", routeTemplate(r)),
+				slog.Int("status", rw.code),
+				slog.Duration("duration", time.Since(start)),
+				slog.Int("bytes", rw.bytes),
+			)
+		})
+	}, nil
+}
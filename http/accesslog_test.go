@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/exp/slog"
+)
+
+func TestAccessLogSample(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		cfg    AccessLogConfig
+		status int
+		// want is level of record, empty if request is not logged
+		want string
+	}{
+		{name: "disabled", cfg: AccessLogConfig{Sample: 1}, status: http.StatusInternalServerError},
+		{name: "every request", cfg: AccessLogConfig{Enabled: true, Sample: 1}, status: http.StatusOK, want: "INFO"},
+		{name: "successful request sampled away", cfg: AccessLogConfig{Enabled: true}, status: http.StatusOK},
+		{name: "client error sampled away", cfg: AccessLogConfig{Enabled: true}, status: http.StatusNotFound},
+		{name: "failed request is always logged", cfg: AccessLogConfig{Enabled: true}, status: http.StatusBadGateway, want: "WARN"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			defaultLogger := slog.Default()
+			slog.SetDefault(slog.New(slog.NewTextHandler(&buf)))
+			defer slog.SetDefault(defaultLogger)
+
+			middleware, err := newAccessLogMiddleware(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/abc", nil))
+
+			record := buf.String()
+			if tt.want == "" {
+				if record != "" {
+					t.Errorf("logged %q, want nothing", record)
+				}
+				return
+			}
+			if !strings.Contains(record, "level="+tt.want) || !strings.Contains(record, "status="+strconv.Itoa(tt.status)) {
+				t.Errorf("logged %q, want %s record", record, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccessLogInvalidSample(t *testing.T) {
+	for _, sample := range []float64{-0.1, 1.1} {
+		if _, err := newAccessLogMiddleware(AccessLogConfig{Enabled: true, Sample: sample}); err == nil {
+			t.Errorf("newAccessLogMiddleware with sample %v succeeded, want error", sample)
+		}
+	}
+}
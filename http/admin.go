@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/idna"
+
+	"github.com/asmyasnikov/webinar-jaeger/server/api"
+)
+
+// banDeletePageSize is number of links to banned domain deleted per page of
+// AdminList
+const banDeletePageSize = 1000
+
+// errAdminRequired means session of user without admin role called /admin
+// endpoint
+var errAdminRequired = errors.New("admin role required")
+
+// Admin endpoints serve the same calls as /api ones on behalf of nobody, so
+// admins list, delete and view statistics of links of every user.
+
+func (h *handlers) AdminDeleteLink(w http.ResponseWriter, r *http.Request, hash api.HashParam) {
+	h.deleteLink(w, r, "adminDelete", h.validateAdmin, hash)
+}
+
+func (h *handlers) AdminLinkStats(w http.ResponseWriter, r *http.Request, hash api.HashParam) {
+	h.linkStats(w, r, "adminStats", h.validateAdmin, hash)
+}
+
+type adminLinksPage struct {
+	Links         []AdminLink `json:"links"`
+	NextPageToken string      `json:"next_page_token,omitempty"`
+}
+
+// AdminListLinks lists links of every user with their owners, filtered by
+// owner, destination domain and url substring
+func (h *handlers) AdminListLinks(w http.ResponseWriter, r *http.Request, params api.AdminListLinksParams) {
+	ctx, span := h.tr.Start(r.Context(), "adminList")
+	defer span.End()
+
+	ctx, err := h.validateAdmin(ctx, r)
+	if err != nil {
+		writeResponse(w, sessionStatus(err), err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	var (
+		filter    LinkFilter
+		pageSize  int
+		pageToken string
+	)
+	if params.Owner != nil {
+		filter.Owner = *params.Owner
+	}
+	if params.Domain != nil {
+		filter.Domain = asciiDomain(*params.Domain)
+	}
+	if params.Url != nil {
+		filter.URL = *params.Url
+	}
+	if params.PageSize != nil {
+		pageSize = *params.PageSize
+	}
+	if params.PageToken != nil {
+		pageToken = *params.PageToken
+	}
+
+	links, nextPageToken, err := h.storage.AdminList(ctx, filter, pageSize, pageToken)
+	if errors.Is(err, errInvalidRequest) {
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+	for i := range links {
+		links[i].URL = displayURL(links[i].URL)
+	}
+
+	body, err := json.Marshal(adminLinksPage{
+		Links:         links,
+		NextPageToken: nextPageToken,
+	})
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeResponse(w, http.StatusOK, string(body))
+}
+
+type bannedDomains struct {
+	Domains []string `json:"domains"`
+}
+
+func (h *handlers) AdminListBans(w http.ResponseWriter, r *http.Request) {
+	ctx, span := h.tr.Start(r.Context(), "adminListBans")
+	defer span.End()
+
+	ctx, err := h.validateAdmin(ctx, r)
+	if err != nil {
+		writeResponse(w, sessionStatus(err), err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	domains, err := h.storage.BannedDomains(ctx)
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+	for i, domain := range domains {
+		if unicode, err := idna.Display.ToUnicode(domain); err == nil {
+			domains[i] = unicode
+		}
+	}
+
+	body, err := json.Marshal(bannedDomains{
+		Domains: domains,
+	})
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeResponse(w, http.StatusOK, string(body))
+}
+
+type banResult struct {
+	Domain  string `json:"domain"`
+	Deleted int    `json:"deleted"`
+}
+
+// AdminBanDomain bans destination domain on every durable storage and, if
+// asked, deletes links to it stored before the ban
+func (h *handlers) AdminBanDomain(w http.ResponseWriter, r *http.Request) {
+	ctx, span := h.tr.Start(r.Context(), "adminBan")
+	defer span.End()
+
+	ctx, err := h.validateAdmin(ctx, r)
+	if err != nil {
+		writeResponse(w, sessionStatus(err), err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, "read body failed: "+err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	var ban api.Ban
+	if err = json.Unmarshal(body, &ban); err != nil {
+		writeResponse(w, http.StatusBadRequest, "cannot unmarshal body to ban json: "+err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+	span.SetAttributes(attribute.String("domain", ban.Domain))
+
+	ban.Domain = asciiDomain(ban.Domain)
+	err = h.storage.BanDomain(ctx, ban.Domain, true)
+	if errors.Is(err, errInvalidRequest) {
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	result := banResult{
+		Domain: ban.Domain,
+	}
+	if ban.DeleteLinks != nil && *ban.DeleteLinks {
+		result.Deleted, err = h.deleteLinksTo(ctx, ban.Domain)
+		span.SetAttributes(attribute.Int("deleted", result.Deleted))
+		if err != nil {
+			writeResponse(w, http.StatusInternalServerError, err.Error())
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+			return
+		}
+	}
+
+	body, err = json.Marshal(result)
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeResponse(w, http.StatusOK, string(body))
+}
+
+// deleteLinksTo deletes links to domain page by page through tiered storage,
+// so caches stop serving them too. Links deleted before a failure stay
+// deleted.
+func (h *handlers) deleteLinksTo(ctx context.Context, domain string) (deleted int, err error) {
+	var pageToken string
+	for {
+		links, nextPageToken, err := h.storage.AdminList(ctx, LinkFilter{Domain: domain}, banDeletePageSize, pageToken)
+		if err != nil {
+			return deleted, err
+		}
+		for _, l := range links {
+			if err = h.storage.Delete(ctx, l.Hash); err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+		trace.SpanFromContext(ctx).AddEvent("links to banned domain deleted", trace.WithAttributes(
+			attribute.Int("deleted", deleted),
+		))
+		if nextPageToken == "" {
+			return deleted, nil
+		}
+		pageToken = nextPageToken
+	}
+}
+
+func (h *handlers) AdminUnbanDomain(w http.ResponseWriter, r *http.Request, domain string) {
+	ctx, span := h.tr.Start(r.Context(), "adminUnban", trace.WithAttributes(
+		attribute.String("domain", domain),
+	))
+	defer span.End()
+
+	ctx, err := h.validateAdmin(ctx, r)
+	if err != nil {
+		writeResponse(w, sessionStatus(err), err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	err = h.storage.BanDomain(ctx, asciiDomain(domain), false)
+	if errors.Is(err, errInvalidRequest) {
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
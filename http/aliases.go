@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/asmyasnikov/webinar-jaeger/internal/identity"
+	"github.com/asmyasnikov/webinar-jaeger/server/api"
+)
+
+// aliasPath is path alias of user is resolved under
+func aliasPath(user, alias string) string {
+	return "u/" + url.PathEscape(user) + "/" + alias
+}
+
+// ClaimAlias lets user name own link of the current namespace, so it is
+// shared as /u/{user}/{alias} instead of generated code
+func (h *handlers) ClaimAlias(w http.ResponseWriter, r *http.Request, alias api.AliasParam) {
+	ctx, span := h.tr.Start(r.Context(), "claimAlias", trace.WithAttributes(
+		attribute.String("alias", alias),
+	))
+	defer span.End()
+
+	ctx, err := h.validateSession(ctx, r)
+	if err != nil {
+		writeResponse(w, http.StatusUnauthorized, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	hash := strings.TrimSpace(string(body))
+	if !isShortCorrect(hash) {
+		err = fmt.Errorf(invalidHashError, hash)
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	err = h.storage.PutAlias(ctx, alias, namespaceKey(h.namespace(ctx, r), hash))
+	if errors.Is(err, errForbidden) {
+		writeResponse(w, http.StatusForbidden, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+	if errors.Is(err, errNotFound) {
+		writeResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeResponse(w, lookupStatus(err), err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/text")
+	writeResponse(w, http.StatusOK, aliasPath(identity.Authenticated(ctx), alias))
+}
+
+// ResolveAlias redirects to link of alias claimed by user. Aliases are not
+// namespaced by domains, as users are the same on every domain.
+func (h *handlers) ResolveAlias(w http.ResponseWriter, r *http.Request, user string, alias api.AliasParam) {
+	ctx, span := h.tr.Start(r.Context(), "resolveAlias", trace.WithAttributes(
+		attribute.String("user", user),
+		attribute.String("alias", alias),
+	))
+	defer span.End()
+
+	hash, url, err := h.storage.GetByAlias(ctx, user, alias)
+	if errors.Is(err, errExpired) {
+		writeResponse(w, http.StatusGone, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+	if errors.Is(err, errNotFound) {
+		writeResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeResponse(w, lookupStatus(err), err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	h.redirect(ctx, w, r, hash, url)
+}
+
+// ResolveAliasHead answers like ResolveAlias without body
+func (h *handlers) ResolveAliasHead(w http.ResponseWriter, r *http.Request, user string, alias api.AliasParam) {
+	h.ResolveAlias(w, r, user, alias)
+}
+
+func (h *handlers) ResolveAliasOptions(w http.ResponseWriter, r *http.Request, user string, alias api.AliasParam) {
+	redirectOptions(w, r, "GET, HEAD, OPTIONS")
+}
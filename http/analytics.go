@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/asmyasnikov/webinar-jaeger/internal/keepalive"
+	"github.com/asmyasnikov/webinar-jaeger/internal/requestid"
+	"github.com/asmyasnikov/webinar-jaeger/internal/serviceauth"
+	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
+)
+
+type Click struct {
+	Hash      string
+	Timestamp time.Time
+	UserAgent string
+	Referrer  string
+}
+
+type Stats struct {
+	Hash        string     `json:"hash"`
+	TotalClicks uint64     `json:"total_clicks"`
+	LastAccess  *time.Time `json:"last_access,omitempty"`
+}
+
+type analytics struct {
+	tr          trace.Tracer
+	conn        *grpc.ClientConn
+	client      pb.AnalyticsClient
+	stopWatches context.CancelFunc
+	// topic receives clicks instead of analytics service if not nil
+	topic *clickTopic
+}
+
+func newAnalytics(ctx context.Context, tr trace.Tracer, creds credentials.TransportCredentials, ka keepalive.Config, serviceKey, addr string, topic *clickTopic) (*analytics, error) {
+	_, span := tr.Start(ctx, "newAnalytics", trace.WithAttributes(
+		attribute.String("address", addr),
+	))
+	defer span.End()
+
+	conn, err := grpc.DialContext(ctx, addr,
+		grpc.WithTransportCredentials(creds),
+		ka.DialOption(),
+		grpc.WithChainUnaryInterceptor(
+			otelgrpc.UnaryClientInterceptor(),
+			requestid.UnaryClientInterceptor(),
+			serviceauth.UnaryClientInterceptor(serviceKey),
+		),
+	)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	watchCtx, stopWatches := context.WithCancel(context.Background())
+	go watchConnState(watchCtx, tr, "analytics", addr, conn)
+
+	return &analytics{
+		tr:          tr,
+		conn:        conn,
+		client:      pb.NewAnalyticsClient(conn),
+		stopWatches: stopWatches,
+		topic:       topic,
+	}, nil
+}
+
+func (a *analytics) Close() error {
+	a.stopWatches()
+	if a.topic != nil {
+		_ = a.topic.Close(context.Background())
+	}
+	return a.conn.Close()
+}
+
+func (a *analytics) Click(ctx context.Context, click Click) (err error) {
+	ctx, span := a.tr.Start(ctx, "click", trace.WithAttributes(
+		attribute.String("hash", click.Hash),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("click successful")
+		}
+		span.End()
+	}()
+
+	if a.topic != nil {
+		return a.topic.Publish(ctx, click)
+	}
+
+	_, err = a.client.Click(ctx, &pb.ClickRequest{
+		Hash:      click.Hash,
+		Timestamp: timestamppb.New(click.Timestamp),
+		UserAgent: click.UserAgent,
+		Referrer:  click.Referrer,
+	})
+	return err
+}
+
+func (a *analytics) Stats(ctx context.Context, hash string) (stats Stats, err error) {
+	ctx, span := a.tr.Start(ctx, "stats", trace.WithAttributes(
+		attribute.String("hash", hash),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("stats successful", trace.WithAttributes(
+				attribute.Int64("total_clicks", int64(stats.TotalClicks)),
+			))
+		}
+		span.End()
+	}()
+
+	response, err := a.client.Stats(ctx, &pb.StatsRequest{
+		Hash: hash,
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	stats = Stats{
+		Hash:        hash,
+		TotalClicks: response.GetTotalClicks(),
+	}
+	if response.GetLastAccess() != nil {
+		lastAccess := response.GetLastAccess().AsTime()
+		stats.LastAccess = &lastAccess
+	}
+
+	return stats, nil
+}
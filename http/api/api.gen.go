@@ -0,0 +1,1426 @@
+// Package api provides primitives to interact with the openapi HTTP API.
+//
+// Code generated by github.com/deepmap/oapi-codegen version v1.12.4 DO NOT EDIT.
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/deepmap/oapi-codegen/pkg/runtime"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gorilla/mux"
+)
+
+const (
+	SessionScopes = "session.Scopes"
+)
+
+// Defines values for CacheStatsMode.
+const (
+	Local CacheStatsMode = "local"
+	Redis CacheStatsMode = "redis"
+)
+
+// Defines values for StorageStatsTier.
+const (
+	Cache   StorageStatsTier = "cache"
+	Durable StorageStatsTier = "durable"
+)
+
+// AdminLink defines model for AdminLink.
+type AdminLink struct {
+	Hash string `json:"hash"`
+
+	// Owner empty for links stored on behalf of nobody
+	Owner *string `json:"owner,omitempty"`
+	Url   string  `json:"url"`
+}
+
+// AdminLinksPage defines model for AdminLinksPage.
+type AdminLinksPage struct {
+	Links         []AdminLink `json:"links"`
+	NextPageToken *string     `json:"next_page_token,omitempty"`
+}
+
+// Alias defines model for Alias.
+type Alias = string
+
+// Ban defines model for Ban.
+type Ban struct {
+	// DeleteLinks delete links to the domain stored before the ban
+	DeleteLinks *bool  `json:"delete_links,omitempty"`
+	Domain      string `json:"domain"`
+}
+
+// BanResult defines model for BanResult.
+type BanResult struct {
+	// Deleted number of links deleted
+	Deleted int    `json:"deleted"`
+	Domain  string `json:"domain"`
+}
+
+// BannedDomains defines model for BannedDomains.
+type BannedDomains struct {
+	Domains []string `json:"domains"`
+}
+
+// CacheStats defines model for CacheStats.
+type CacheStats struct {
+	Address string `json:"address"`
+
+	// Bytes length of cached hashes and urls, 0 unless policy is memory
+	Bytes uint64 `json:"bytes"`
+
+	// Error set if cache did not answer
+	Error *string `json:"error,omitempty"`
+
+	// Evictions links removed by reason (expired, capacity, memory or deleted), empty in redis mode
+	Evictions map[string]uint64 `json:"evictions"`
+	HitRatio  float64           `json:"hit_ratio"`
+	Hits      uint64            `json:"hits"`
+	Links     uint64            `json:"links"`
+
+	// MemoryLimit bound of bytes, 0 unless policy is memory
+	MemoryLimit uint64          `json:"memory_limit"`
+	Misses      uint64          `json:"misses"`
+	Mode        *CacheStatsMode `json:"mode,omitempty"`
+
+	// Policy eviction policy of local mode
+	Policy *string `json:"policy,omitempty"`
+}
+
+// CacheStatsMode defines model for CacheStats.Mode.
+type CacheStatsMode string
+
+// Credentials defines model for Credentials.
+type Credentials struct {
+	Password string `json:"password"`
+	Username string `json:"username"`
+}
+
+// Hash defines model for Hash.
+type Hash = string
+
+// Link defines model for Link.
+type Link struct {
+	// Favicon url of favicon of destination page, empty until it is fetched in background
+	Favicon *string `json:"favicon,omitempty"`
+	Hash    string  `json:"hash"`
+
+	// Title title of destination page, empty until it is fetched in background
+	Title *string `json:"title,omitempty"`
+	Url   string  `json:"url"`
+}
+
+// LinksPage defines model for LinksPage.
+type LinksPage struct {
+	Links         []Link  `json:"links"`
+	NextPageToken *string `json:"next_page_token,omitempty"`
+}
+
+// MethodLatency defines model for MethodLatency.
+type MethodLatency struct {
+	Count  uint64  `json:"count"`
+	Method string  `json:"method"`
+	P50Ms  float32 `json:"p50_ms"`
+	P90Ms  float32 `json:"p90_ms"`
+	P99Ms  float32 `json:"p99_ms"`
+}
+
+// ShortenResult defines model for ShortenResult.
+type ShortenResult struct {
+	Error *string `json:"error,omitempty"`
+	Hash  *string `json:"hash,omitempty"`
+	Url   string  `json:"url"`
+}
+
+// Stats defines model for Stats.
+type Stats struct {
+	Hash        string     `json:"hash"`
+	LastAccess  *time.Time `json:"last_access,omitempty"`
+	TotalClicks uint64     `json:"total_clicks"`
+}
+
+// StorageStats defines model for StorageStats.
+type StorageStats struct {
+	Address string  `json:"address"`
+	Backend *string `json:"backend,omitempty"`
+
+	// Error set if storage did not answer
+	Error *string `json:"error,omitempty"`
+
+	// Latencies latencies of recent calls served by storage
+	Latencies []MethodLatency `json:"latencies"`
+
+	// RowCount estimate, 0 if backend can not estimate it
+	RowCount uint64 `json:"row_count"`
+
+	// SizeBytes estimate, 0 if backend can not estimate it
+	SizeBytes uint64           `json:"size_bytes"`
+	Tier      StorageStatsTier `json:"tier"`
+}
+
+// StorageStatsTier defines model for StorageStats.Tier.
+type StorageStatsTier string
+
+// URL defines model for URL.
+type URL = string
+
+// AliasParam defines model for AliasParam.
+type AliasParam = Alias
+
+// HashParam defines model for HashParam.
+type HashParam = Hash
+
+// TTLParam defines model for TTLParam.
+type TTLParam = string
+
+// AdminListLinksParams defines parameters for AdminListLinks.
+type AdminListLinksParams struct {
+	PageSize *int `form:"page_size,omitempty" json:"page_size,omitempty"`
+
+	// PageToken next_page_token from previous page
+	PageToken *string `form:"page_token,omitempty" json:"page_token,omitempty"`
+
+	// Owner list links of the user only
+	Owner *string `form:"owner,omitempty" json:"owner,omitempty"`
+
+	// Domain list links to the domain and its subdomains only
+	Domain *string `form:"domain,omitempty" json:"domain,omitempty"`
+
+	// Url list links with url containing the substring only
+	Url *string `form:"url,omitempty" json:"url,omitempty"`
+}
+
+// ListLinksParams defines parameters for ListLinks.
+type ListLinksParams struct {
+	PageSize *int `form:"page_size,omitempty" json:"page_size,omitempty"`
+
+	// PageToken next_page_token from previous page
+	PageToken *string `form:"page_token,omitempty" json:"page_token,omitempty"`
+}
+
+// LookupLinkParams defines parameters for LookupLink.
+type LookupLinkParams struct {
+	Url URL `form:"url" json:"url"`
+}
+
+// ShortenBatchJSONBody defines parameters for ShortenBatch.
+type ShortenBatchJSONBody = []string
+
+// ShortenBatchParams defines parameters for ShortenBatch.
+type ShortenBatchParams struct {
+	// Ttl link lifetime in Go duration format, link never expires if not set
+	Ttl *TTLParam `form:"ttl,omitempty" json:"ttl,omitempty"`
+}
+
+// OidcCallbackParams defines parameters for OidcCallback.
+type OidcCallbackParams struct {
+	Code  *string `form:"code,omitempty" json:"code,omitempty"`
+	State *string `form:"state,omitempty" json:"state,omitempty"`
+	Error *string `form:"error,omitempty" json:"error,omitempty"`
+}
+
+// ShortenParams defines parameters for Shorten.
+type ShortenParams struct {
+	// Ttl link lifetime in Go duration format, link never expires if not set
+	Ttl *TTLParam `form:"ttl,omitempty" json:"ttl,omitempty"`
+}
+
+// ResolveParams defines parameters for Resolve.
+type ResolveParams struct {
+	// Preview show preview page instead of redirecting
+	Preview *bool `form:"preview,omitempty" json:"preview,omitempty"`
+}
+
+// ResolveHeadParams defines parameters for ResolveHead.
+type ResolveHeadParams struct {
+	// Preview answer like preview page instead of redirect
+	Preview *bool `form:"preview,omitempty" json:"preview,omitempty"`
+}
+
+// AdminBanDomainJSONRequestBody defines body for AdminBanDomain for application/json ContentType.
+type AdminBanDomainJSONRequestBody = Ban
+
+// ClaimAliasTextRequestBody defines body for ClaimAlias for text/plain ContentType.
+type ClaimAliasTextRequestBody = Hash
+
+// ShortenBatchJSONRequestBody defines body for ShortenBatch for application/json ContentType.
+type ShortenBatchJSONRequestBody = ShortenBatchJSONBody
+
+// LoginJSONRequestBody defines body for Login for application/json ContentType.
+type LoginJSONRequestBody = Credentials
+
+// RegisterJSONRequestBody defines body for Register for application/json ContentType.
+type RegisterJSONRequestBody = Credentials
+
+// ShortenTextRequestBody defines body for Shorten for text/plain ContentType.
+type ShortenTextRequestBody = URL
+
+// ServerInterface represents all server handlers.
+type ServerInterface interface {
+	// List banned destination domains, admins only
+	// (GET /admin/bans)
+	AdminListBans(w http.ResponseWriter, r *http.Request)
+	// Ban destination domain and its subdomains, admins only
+	// (POST /admin/bans)
+	AdminBanDomain(w http.ResponseWriter, r *http.Request)
+	// Lift ban of destination domain, admins only
+	// (DELETE /admin/bans/{domain})
+	AdminUnbanDomain(w http.ResponseWriter, r *http.Request, domain string)
+	// List links of every user matching filters page by page, admins only
+	// (GET /admin/links)
+	AdminListLinks(w http.ResponseWriter, r *http.Request, params AdminListLinksParams)
+	// Delete link of any user, admins only
+	// (DELETE /admin/links/{hash})
+	AdminDeleteLink(w http.ResponseWriter, r *http.Request, hash HashParam)
+	// Get click statistics of link of any user, admins only
+	// (GET /admin/links/{hash}/stats)
+	AdminLinkStats(w http.ResponseWriter, r *http.Request, hash HashParam)
+	// Claim alias of own link
+	// (PUT /api/aliases/{alias})
+	ClaimAlias(w http.ResponseWriter, r *http.Request, alias AliasParam)
+	// Get links, hit ratio, evictions and memory of every cache service
+	// (GET /api/cache/stats)
+	CacheStats(w http.ResponseWriter, r *http.Request)
+	// List stored links page by page
+	// (GET /api/links)
+	ListLinks(w http.ResponseWriter, r *http.Request, params ListLinksParams)
+	// Find link made for url before
+	// (GET /api/links/lookup)
+	LookupLink(w http.ResponseWriter, r *http.Request, params LookupLinkParams)
+	// Get click statistics of link
+	// (GET /api/links/{hash}/stats)
+	LinkStats(w http.ResponseWriter, r *http.Request, hash HashParam)
+	// Make short codes for many urls at once
+	// (POST /api/shorten/batch)
+	ShortenBatch(w http.ResponseWriter, r *http.Request, params ShortenBatchParams)
+	// Get size and latencies of every storage service
+	// (GET /api/stats)
+	StorageStats(w http.ResponseWriter, r *http.Request)
+	// Authenticate user and set session cookie
+	// (POST /login)
+	Login(w http.ResponseWriter, r *http.Request)
+	// Revoke session and clear session and refresh cookies
+	// (POST /logout)
+	Logout(w http.ResponseWriter, r *http.Request)
+	// Finish OpenID Connect login and set session cookie
+	// (GET /oidc/callback)
+	OidcCallback(w http.ResponseWriter, r *http.Request, params OidcCallbackParams)
+	// Redirect to OpenID Connect identity provider to log in
+	// (GET /oidc/login)
+	OidcLogin(w http.ResponseWriter, r *http.Request)
+	// Exchange refresh cookie for new session and refresh cookies
+	// (POST /refresh)
+	Refresh(w http.ResponseWriter, r *http.Request)
+	// Create user and set session cookie
+	// (POST /register)
+	Register(w http.ResponseWriter, r *http.Request)
+	// Make short code for url
+	// (POST /shorten)
+	Shorten(w http.ResponseWriter, r *http.Request, params ShortenParams)
+	// Redirect to original url of alias claimed by user
+	// (GET /u/{user}/{alias})
+	ResolveAlias(w http.ResponseWriter, r *http.Request, user string, alias AliasParam)
+	// Answer like redirect of alias without body
+	// (HEAD /u/{user}/{alias})
+	ResolveAliasHead(w http.ResponseWriter, r *http.Request, user string, alias AliasParam)
+	// List methods of alias redirect, answers CORS preflight requests
+	// (OPTIONS /u/{user}/{alias})
+	ResolveAliasOptions(w http.ResponseWriter, r *http.Request, user string, alias AliasParam)
+	// Delete link
+	// (DELETE /{hash})
+	DeleteLink(w http.ResponseWriter, r *http.Request, hash HashParam)
+	// Redirect to original url
+	// (GET /{hash})
+	Resolve(w http.ResponseWriter, r *http.Request, hash HashParam, params ResolveParams)
+	// Answer like redirect to original url without body
+	// (HEAD /{hash})
+	ResolveHead(w http.ResponseWriter, r *http.Request, hash HashParam, params ResolveHeadParams)
+	// List methods of redirect, answers CORS preflight requests
+	// (OPTIONS /{hash})
+	ResolveOptions(w http.ResponseWriter, r *http.Request, hash HashParam)
+}
+
+// ServerInterfaceWrapper converts contexts to parameters.
+type ServerInterfaceWrapper struct {
+	Handler            ServerInterface
+	HandlerMiddlewares []MiddlewareFunc
+	ErrorHandlerFunc   func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+type MiddlewareFunc func(http.HandlerFunc) http.HandlerFunc
+
+// AdminListBans operation middleware
+func (siw *ServerInterfaceWrapper) AdminListBans(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, SessionScopes, []string{""})
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.AdminListBans(w, r)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// AdminBanDomain operation middleware
+func (siw *ServerInterfaceWrapper) AdminBanDomain(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, SessionScopes, []string{""})
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.AdminBanDomain(w, r)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// AdminUnbanDomain operation middleware
+func (siw *ServerInterfaceWrapper) AdminUnbanDomain(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	// ------------- Path parameter "domain" -------------
+	var domain string
+
+	err = runtime.BindStyledParameter("simple", false, "domain", mux.Vars(r)["domain"], &domain)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "domain", Err: err})
+		return
+	}
+
+	ctx = context.WithValue(ctx, SessionScopes, []string{""})
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.AdminUnbanDomain(w, r, domain)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// AdminListLinks operation middleware
+func (siw *ServerInterfaceWrapper) AdminListLinks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	ctx = context.WithValue(ctx, SessionScopes, []string{""})
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params AdminListLinksParams
+
+	// ------------- Optional query parameter "page_size" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "page_size", r.URL.Query(), &params.PageSize)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "page_size", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "page_token" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "page_token", r.URL.Query(), &params.PageToken)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "page_token", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "owner" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "owner", r.URL.Query(), &params.Owner)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "owner", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "domain" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "domain", r.URL.Query(), &params.Domain)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "domain", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "url" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "url", r.URL.Query(), &params.Url)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "url", Err: err})
+		return
+	}
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.AdminListLinks(w, r, params)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// AdminDeleteLink operation middleware
+func (siw *ServerInterfaceWrapper) AdminDeleteLink(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	// ------------- Path parameter "hash" -------------
+	var hash HashParam
+
+	err = runtime.BindStyledParameter("simple", false, "hash", mux.Vars(r)["hash"], &hash)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "hash", Err: err})
+		return
+	}
+
+	ctx = context.WithValue(ctx, SessionScopes, []string{""})
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.AdminDeleteLink(w, r, hash)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// AdminLinkStats operation middleware
+func (siw *ServerInterfaceWrapper) AdminLinkStats(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	// ------------- Path parameter "hash" -------------
+	var hash HashParam
+
+	err = runtime.BindStyledParameter("simple", false, "hash", mux.Vars(r)["hash"], &hash)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "hash", Err: err})
+		return
+	}
+
+	ctx = context.WithValue(ctx, SessionScopes, []string{""})
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.AdminLinkStats(w, r, hash)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// ClaimAlias operation middleware
+func (siw *ServerInterfaceWrapper) ClaimAlias(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	// ------------- Path parameter "alias" -------------
+	var alias AliasParam
+
+	err = runtime.BindStyledParameter("simple", false, "alias", mux.Vars(r)["alias"], &alias)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "alias", Err: err})
+		return
+	}
+
+	ctx = context.WithValue(ctx, SessionScopes, []string{""})
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.ClaimAlias(w, r, alias)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// CacheStats operation middleware
+func (siw *ServerInterfaceWrapper) CacheStats(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, SessionScopes, []string{""})
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.CacheStats(w, r)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// ListLinks operation middleware
+func (siw *ServerInterfaceWrapper) ListLinks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	ctx = context.WithValue(ctx, SessionScopes, []string{""})
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params ListLinksParams
+
+	// ------------- Optional query parameter "page_size" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "page_size", r.URL.Query(), &params.PageSize)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "page_size", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "page_token" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "page_token", r.URL.Query(), &params.PageToken)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "page_token", Err: err})
+		return
+	}
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.ListLinks(w, r, params)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// LookupLink operation middleware
+func (siw *ServerInterfaceWrapper) LookupLink(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	ctx = context.WithValue(ctx, SessionScopes, []string{""})
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params LookupLinkParams
+
+	// ------------- Required query parameter "url" -------------
+
+	if paramValue := r.URL.Query().Get("url"); paramValue != "" {
+
+	} else {
+		siw.ErrorHandlerFunc(w, r, &RequiredParamError{ParamName: "url"})
+		return
+	}
+
+	err = runtime.BindQueryParameter("form", true, true, "url", r.URL.Query(), &params.Url)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "url", Err: err})
+		return
+	}
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.LookupLink(w, r, params)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// LinkStats operation middleware
+func (siw *ServerInterfaceWrapper) LinkStats(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	// ------------- Path parameter "hash" -------------
+	var hash HashParam
+
+	err = runtime.BindStyledParameter("simple", false, "hash", mux.Vars(r)["hash"], &hash)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "hash", Err: err})
+		return
+	}
+
+	ctx = context.WithValue(ctx, SessionScopes, []string{""})
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.LinkStats(w, r, hash)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// ShortenBatch operation middleware
+func (siw *ServerInterfaceWrapper) ShortenBatch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	ctx = context.WithValue(ctx, SessionScopes, []string{""})
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params ShortenBatchParams
+
+	// ------------- Optional query parameter "ttl" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "ttl", r.URL.Query(), &params.Ttl)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "ttl", Err: err})
+		return
+	}
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.ShortenBatch(w, r, params)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// StorageStats operation middleware
+func (siw *ServerInterfaceWrapper) StorageStats(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, SessionScopes, []string{""})
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.StorageStats(w, r)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// Login operation middleware
+func (siw *ServerInterfaceWrapper) Login(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.Login(w, r)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// Logout operation middleware
+func (siw *ServerInterfaceWrapper) Logout(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, SessionScopes, []string{""})
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.Logout(w, r)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// OidcCallback operation middleware
+func (siw *ServerInterfaceWrapper) OidcCallback(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params OidcCallbackParams
+
+	// ------------- Optional query parameter "code" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "code", r.URL.Query(), &params.Code)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "code", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "state" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "state", r.URL.Query(), &params.State)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "state", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "error" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "error", r.URL.Query(), &params.Error)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "error", Err: err})
+		return
+	}
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.OidcCallback(w, r, params)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// OidcLogin operation middleware
+func (siw *ServerInterfaceWrapper) OidcLogin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.OidcLogin(w, r)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// Refresh operation middleware
+func (siw *ServerInterfaceWrapper) Refresh(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.Refresh(w, r)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// Register operation middleware
+func (siw *ServerInterfaceWrapper) Register(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.Register(w, r)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// Shorten operation middleware
+func (siw *ServerInterfaceWrapper) Shorten(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	ctx = context.WithValue(ctx, SessionScopes, []string{""})
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params ShortenParams
+
+	// ------------- Optional query parameter "ttl" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "ttl", r.URL.Query(), &params.Ttl)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "ttl", Err: err})
+		return
+	}
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.Shorten(w, r, params)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// ResolveAlias operation middleware
+func (siw *ServerInterfaceWrapper) ResolveAlias(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	// ------------- Path parameter "user" -------------
+	var user string
+
+	err = runtime.BindStyledParameter("simple", false, "user", mux.Vars(r)["user"], &user)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "user", Err: err})
+		return
+	}
+
+	// ------------- Path parameter "alias" -------------
+	var alias AliasParam
+
+	err = runtime.BindStyledParameter("simple", false, "alias", mux.Vars(r)["alias"], &alias)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "alias", Err: err})
+		return
+	}
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.ResolveAlias(w, r, user, alias)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// ResolveAliasHead operation middleware
+func (siw *ServerInterfaceWrapper) ResolveAliasHead(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	// ------------- Path parameter "user" -------------
+	var user string
+
+	err = runtime.BindStyledParameter("simple", false, "user", mux.Vars(r)["user"], &user)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "user", Err: err})
+		return
+	}
+
+	// ------------- Path parameter "alias" -------------
+	var alias AliasParam
+
+	err = runtime.BindStyledParameter("simple", false, "alias", mux.Vars(r)["alias"], &alias)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "alias", Err: err})
+		return
+	}
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.ResolveAliasHead(w, r, user, alias)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// ResolveAliasOptions operation middleware
+func (siw *ServerInterfaceWrapper) ResolveAliasOptions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	// ------------- Path parameter "user" -------------
+	var user string
+
+	err = runtime.BindStyledParameter("simple", false, "user", mux.Vars(r)["user"], &user)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "user", Err: err})
+		return
+	}
+
+	// ------------- Path parameter "alias" -------------
+	var alias AliasParam
+
+	err = runtime.BindStyledParameter("simple", false, "alias", mux.Vars(r)["alias"], &alias)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "alias", Err: err})
+		return
+	}
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.ResolveAliasOptions(w, r, user, alias)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// DeleteLink operation middleware
+func (siw *ServerInterfaceWrapper) DeleteLink(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	// ------------- Path parameter "hash" -------------
+	var hash HashParam
+
+	err = runtime.BindStyledParameter("simple", false, "hash", mux.Vars(r)["hash"], &hash)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "hash", Err: err})
+		return
+	}
+
+	ctx = context.WithValue(ctx, SessionScopes, []string{""})
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.DeleteLink(w, r, hash)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// Resolve operation middleware
+func (siw *ServerInterfaceWrapper) Resolve(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	// ------------- Path parameter "hash" -------------
+	var hash HashParam
+
+	err = runtime.BindStyledParameter("simple", false, "hash", mux.Vars(r)["hash"], &hash)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "hash", Err: err})
+		return
+	}
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params ResolveParams
+
+	// ------------- Optional query parameter "preview" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "preview", r.URL.Query(), &params.Preview)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "preview", Err: err})
+		return
+	}
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.Resolve(w, r, hash, params)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// ResolveHead operation middleware
+func (siw *ServerInterfaceWrapper) ResolveHead(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	// ------------- Path parameter "hash" -------------
+	var hash HashParam
+
+	err = runtime.BindStyledParameter("simple", false, "hash", mux.Vars(r)["hash"], &hash)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "hash", Err: err})
+		return
+	}
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params ResolveHeadParams
+
+	// ------------- Optional query parameter "preview" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "preview", r.URL.Query(), &params.Preview)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "preview", Err: err})
+		return
+	}
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.ResolveHead(w, r, hash, params)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+// ResolveOptions operation middleware
+func (siw *ServerInterfaceWrapper) ResolveOptions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	// ------------- Path parameter "hash" -------------
+	var hash HashParam
+
+	err = runtime.BindStyledParameter("simple", false, "hash", mux.Vars(r)["hash"], &hash)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "hash", Err: err})
+		return
+	}
+
+	var handler = func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.ResolveOptions(w, r, hash)
+	}
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler(w, r.WithContext(ctx))
+}
+
+type UnescapedCookieParamError struct {
+	ParamName string
+	Err       error
+}
+
+func (e *UnescapedCookieParamError) Error() string {
+	return fmt.Sprintf("error unescaping cookie parameter '%s'", e.ParamName)
+}
+
+func (e *UnescapedCookieParamError) Unwrap() error {
+	return e.Err
+}
+
+type UnmarshallingParamError struct {
+	ParamName string
+	Err       error
+}
+
+func (e *UnmarshallingParamError) Error() string {
+	return fmt.Sprintf("Error unmarshalling parameter %s as JSON: %s", e.ParamName, e.Err.Error())
+}
+
+func (e *UnmarshallingParamError) Unwrap() error {
+	return e.Err
+}
+
+type RequiredParamError struct {
+	ParamName string
+}
+
+func (e *RequiredParamError) Error() string {
+	return fmt.Sprintf("Query argument %s is required, but not found", e.ParamName)
+}
+
+type RequiredHeaderError struct {
+	ParamName string
+	Err       error
+}
+
+func (e *RequiredHeaderError) Error() string {
+	return fmt.Sprintf("Header parameter %s is required, but not found", e.ParamName)
+}
+
+func (e *RequiredHeaderError) Unwrap() error {
+	return e.Err
+}
+
+type InvalidParamFormatError struct {
+	ParamName string
+	Err       error
+}
+
+func (e *InvalidParamFormatError) Error() string {
+	return fmt.Sprintf("Invalid format for parameter %s: %s", e.ParamName, e.Err.Error())
+}
+
+func (e *InvalidParamFormatError) Unwrap() error {
+	return e.Err
+}
+
+type TooManyValuesForParamError struct {
+	ParamName string
+	Count     int
+}
+
+func (e *TooManyValuesForParamError) Error() string {
+	return fmt.Sprintf("Expected one value for %s, got %d", e.ParamName, e.Count)
+}
+
+// Handler creates http.Handler with routing matching OpenAPI spec.
+func Handler(si ServerInterface) http.Handler {
+	return HandlerWithOptions(si, GorillaServerOptions{})
+}
+
+type GorillaServerOptions struct {
+	BaseURL          string
+	BaseRouter       *mux.Router
+	Middlewares      []MiddlewareFunc
+	ErrorHandlerFunc func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// HandlerFromMux creates http.Handler with routing matching OpenAPI spec based on the provided mux.
+func HandlerFromMux(si ServerInterface, r *mux.Router) http.Handler {
+	return HandlerWithOptions(si, GorillaServerOptions{
+		BaseRouter: r,
+	})
+}
+
+func HandlerFromMuxWithBaseURL(si ServerInterface, r *mux.Router, baseURL string) http.Handler {
+	return HandlerWithOptions(si, GorillaServerOptions{
+		BaseURL:    baseURL,
+		BaseRouter: r,
+	})
+}
+
+// HandlerWithOptions creates http.Handler with additional options
+func HandlerWithOptions(si ServerInterface, options GorillaServerOptions) http.Handler {
+	r := options.BaseRouter
+
+	if r == nil {
+		r = mux.NewRouter()
+	}
+	if options.ErrorHandlerFunc == nil {
+		options.ErrorHandlerFunc = func(w http.ResponseWriter, r *http.Request, err error) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}
+	wrapper := ServerInterfaceWrapper{
+		Handler:            si,
+		HandlerMiddlewares: options.Middlewares,
+		ErrorHandlerFunc:   options.ErrorHandlerFunc,
+	}
+
+	r.HandleFunc(options.BaseURL+"/admin/bans", wrapper.AdminListBans).Methods("GET")
+
+	r.HandleFunc(options.BaseURL+"/admin/bans", wrapper.AdminBanDomain).Methods("POST")
+
+	r.HandleFunc(options.BaseURL+"/admin/bans/{domain}", wrapper.AdminUnbanDomain).Methods("DELETE")
+
+	r.HandleFunc(options.BaseURL+"/admin/links", wrapper.AdminListLinks).Methods("GET")
+
+	r.HandleFunc(options.BaseURL+"/admin/links/{hash}", wrapper.AdminDeleteLink).Methods("DELETE")
+
+	r.HandleFunc(options.BaseURL+"/admin/links/{hash}/stats", wrapper.AdminLinkStats).Methods("GET")
+
+	r.HandleFunc(options.BaseURL+"/api/aliases/{alias}", wrapper.ClaimAlias).Methods("PUT")
+
+	r.HandleFunc(options.BaseURL+"/api/cache/stats", wrapper.CacheStats).Methods("GET")
+
+	r.HandleFunc(options.BaseURL+"/api/links", wrapper.ListLinks).Methods("GET")
+
+	r.HandleFunc(options.BaseURL+"/api/links/lookup", wrapper.LookupLink).Methods("GET")
+
+	r.HandleFunc(options.BaseURL+"/api/links/{hash}/stats", wrapper.LinkStats).Methods("GET")
+
+	r.HandleFunc(options.BaseURL+"/api/shorten/batch", wrapper.ShortenBatch).Methods("POST")
+
+	r.HandleFunc(options.BaseURL+"/api/stats", wrapper.StorageStats).Methods("GET")
+
+	r.HandleFunc(options.BaseURL+"/login", wrapper.Login).Methods("POST")
+
+	r.HandleFunc(options.BaseURL+"/logout", wrapper.Logout).Methods("POST")
+
+	r.HandleFunc(options.BaseURL+"/oidc/callback", wrapper.OidcCallback).Methods("GET")
+
+	r.HandleFunc(options.BaseURL+"/oidc/login", wrapper.OidcLogin).Methods("GET")
+
+	r.HandleFunc(options.BaseURL+"/refresh", wrapper.Refresh).Methods("POST")
+
+	r.HandleFunc(options.BaseURL+"/register", wrapper.Register).Methods("POST")
+
+	r.HandleFunc(options.BaseURL+"/shorten", wrapper.Shorten).Methods("POST")
+
+	r.HandleFunc(options.BaseURL+"/u/{user}/{alias}", wrapper.ResolveAlias).Methods("GET")
+
+	r.HandleFunc(options.BaseURL+"/u/{user}/{alias}", wrapper.ResolveAliasHead).Methods("HEAD")
+
+	r.HandleFunc(options.BaseURL+"/u/{user}/{alias}", wrapper.ResolveAliasOptions).Methods("OPTIONS")
+
+	r.HandleFunc(options.BaseURL+"/{hash}", wrapper.DeleteLink).Methods("DELETE")
+
+	r.HandleFunc(options.BaseURL+"/{hash}", wrapper.Resolve).Methods("GET")
+
+	r.HandleFunc(options.BaseURL+"/{hash}", wrapper.ResolveHead).Methods("HEAD")
+
+	r.HandleFunc(options.BaseURL+"/{hash}", wrapper.ResolveOptions).Methods("OPTIONS")
+
+	return r
+}
+
+// Base64 encoded, gzipped, json marshaled Swagger object
+var swaggerSpec = []string{
+
+	"H4sIAAAAAAAC/+xcb3PbNtL/Khg+ffH8oS0ldjuN3zzj2GmSOaf22M7czOV8HohciahIgAVAy6pH3/1m",
+	"AZAiKVCibDl1c3knCSB2sX9/2AX1EEQiywUHrlVw9BDkVNIMNEjz7ThlVF3gT/iN8eAoyKlOgjDgNIPg",
+	"KKA4IQgDCb8XTEIcHGlZQBioKIGM4kM/SBgHR8F/DZZ0BnZUDczywWIRBh+oStbRSahKHk0GFzdUrq/P",
+	"KiIxqEiyXDOB1FLGpyRlY9AsA8I4eS9IXEiKw2QsZEZ1SMwkDncgCdznTIIibEy40ESBDkLL9+8FyPmS",
+	"ca3ToM4n3NMsT3Hk9SHuSM9z/KK0ZHwSLJBLCSoXXIHRwDsphcQPkeAauMaPGu71IE8p0nuore1Zq7lL",
+	"sxjJQCk6gWARBpcQMwmR/gA0XhVKOUq0IEKyCeM0JYVMyYzpRBSajESMW02Axs5gzkRE7dNrGauRPjfU",
+	"1Cr1T6ATESsixkSKQsM+uZAwTtkk0QTtAJQ2g5TPHXeESiA0TcUMYuR5LPAzkY6SCglVy29mdl6MUhbt",
+	"/5M3t3EcRaDU3ongWop07xjX2XMMrXJ6BdzIKPfwx9N5h/7fv7sOyYd3x6ceKwj9HJybbe6Kgf/tQ/gT",
+	"vd87nsDTaDoqjGuYgLRkcENNK/GIJiTnF9cfz3+98nkKOrQQnyifXzrKu/KTS6qBpCxjGg0sShnu9eMF",
+	"EZIoUApjAlME7iOAGOKm6VyClvO947EG6RNaJHisUG4zyjQZwVhIIBKfWS8xw6UdtxYaZ4yfMT7FL7kU",
+	"OUjNbMgwsXJ1m2EgZtzHFGS5nmOQMwFOEaWFhJgITkaQ0HSMMuDC+frKooVMfTKtx+ovZfjGuTfVGmL0",
+	"G0Qa16g2oy7oBFZ3ZPjCD0xDpjZmlUo0i4oWlZLO8TuHe32b0wncajEFvpl1S9vLtcleJmdqDRJF+a8v",
+	"dO+P471/DPfe3Cw/3u7dPAzDnw4WP/gE+Jby1R3HkIKG22rjTY3ZUacuLYhOgMQio4yXynOGhQMjypdk",
+	"R0KkQDnStQ80Hc992o9EFoQbBOOe90nmLeWXoIpUd23Mk2x4kY1Aoq3ZbZUTwxVfqLPei8WwotrBLIf4",
+	"1ExVHoaXA5X1reiwaWVeLvw2dEKjBK401R7KNI4lKD/B0VyDxzBS4BOdmKCFC8cEHQ8UoTzGxK1CMiQF",
+	"T0EpkouURXOMYxlkwoQfi3OCo6BgXP906BU9lHikSViBJsxRJTGLDSqiXM1A+mwe7lhUpX0axwy/0PSi",
+	"sf8+7KyiOMzwmbhDH5gTCVQJTv7bwrU4JBHNacT0PHS7xpDujON/QmIjIeMGJCiSiRgCj9ISpm8NNGxw",
+	"GYtilNbmW4N283tvqPL4PpPtHm5NplrVyUgUPEZbMMbydNVnTKn+mjHCw+DCi8zEURHR1ED4mNV9YWkU",
+	"lq3VfZTGUvKNIQIXa+mnIwaUXlSK1qmj2k5dnXXDLH2sJWWvE0uIgWtGU48X51SpmZCx140LBdIeFDaF",
+	"smpmuFzRx8sHl/z9OenhMDx47U9DfiwxpncsEh7IiccAMSZuHD/GoDTj9sSECbZ0p4JrlhKm0d7GoE1Y",
+	"YpyMaDSdSDRRHzedGEYznXrQqPl551zsAtvsDNZ8BURjTzhnVAOP5qv8RqLgurf/m7W8Osx/HN42Euky",
+	"VOZv1gy98Q+1tucIh47dily1eLWUTwRXiZAaOuFLlf76W2wvI+qyng5o0EkspUrfUnOEayYnqmFPswx8",
+	"Zq6FpultlLKob+rxu0BjHf9uhKSTR+EdGk2B+81pPSRRlmYPUJIau2deXFUOmWoERMA1iWiaKqJAOqzh",
+	"CAVhP3du+prHr6WY3VYe18qISrOMasCczsbECYdElJstlsOE6Z6pXbE/4LYDU+6cmGYg68jAgMYgDLDe",
+	"hgjqpn9KN0vVRdXYSl2lPnP8fHnWSpWJ1rn6/6PBwAsrFESFZHp+hSq0onLlgKpqGQkxZbAs/7lxF5qX",
+	"Ss7Z32BuSw+Mj8Wq1C9MYYp8uL6+IMcXH9HuPl+eEWXDE0gyg9EeGh+LjE/btBg05gRhcAfS8he82h/u",
+	"D3HXIgdOcxYcBQf7w/0DAyh0YnYzoHh0HoyoBeYTMLaHPmoS6sc4OCrP6kq/pVwFrWrl6+GwVYOheZ4y",
+	"WxMc/KbahcF1DtI8l3mqNHYCKY9XizA4HL7qWrVic2Arqmb2wRazfxwOe89GWymyjMp5cBSgrMjI8VpD",
+	"J47vkBihu6qZgcHK4/K/wmx53h/Vd27qmBLQqCHeJ2f1Go4rA+CMKeS6PADUqwsIiBRoWwL1qPot5afl",
+	"MdpV+N5iKWiHag4WTQ/H2v7ieS3L5XePVdnNOhFbMxluZVQv0ATfUu4xPVMVYFoRVYz8xrgI6yFh8GBn",
+	"LZZ1nI7w8JmPalZTbyl98XZ34rqB+fs77Wh847ePlQiBTR39zSjyjI1NLGmfdKwAO7VXHTRcRG+lGoRH",
+	"GZ2TRKQxGcMMykqwTignVE2xciIk4YJDSGYJS4G0Th0uioSIEMYsRWWTjOoowfXsap0RBgPkmTub+2yl",
+	"1VEzRDHLNwrmGb1nGeKJV8PhEA/33H31gde2BNqbGUuRkVzCHROFIrlDdV28lLm921jD1Xaj0k7GYmyq",
+	"tHi+LxsnPkq2dv9YIs0a8arfr6NcOefjSGOv0DQNMXRTxhmfGF5UMbLPr6ONx6JHRIGdZIlWU8KTKvD3",
+	"ZcHabFQnwCQxylLfTMyp2yp2v+fWWo1/owJLh0dvwJOQrbusi0WDBzwybk4kp2YQlbAaG3w7WU4ZLG8U",
+	"9MsVSKRqOnwbmjtdtonKRjkqrpdqBqo8pK87BfCpPcvvXjk7cWHLnMdzT7BGQXCLTGkWfSuu+h40iVpb",
+	"KwPUJgvI2cBc5gE1eDAfjHfmhQcwmMYnSdkdKKyhYqxWOY1MLDSRwSQYRSQokWJ1pOAxSDIoBg84vCgJ",
+	"7BO7UpRSli1PK0yRXGC2jsu8hfz74MMJPnjsriBtZ4G1m02Lm3Unm65LBD0uHD3xUIOUu25IFKisCAYz",
+	"GDFOpf8iUTtb2c6gkf3LMvjD4eEzuQfOPnicMxnjstJCsYkZN3a4dBdTt9oQJ2v93SfGuV5FxRq51a70",
+	"ikWYiVWzWBHFeAQOwihNpS6bJ+V9Lztve2t4UkAzWSkkCdPECDYkVZPOBJqykVtiE8MkKUtjlbbaZ6Cm",
+	"nr79M8hz5tn+KPm5487TUK6rmlmwW0ezLTMapEJMi7zbmsywH7Z2H3Med7kVi9jPr12fYvF3zNDI/bOn",
+	"kxeYIH5h3NoKyWgM5gIdnnMtimlbTC9U/R1Q/6kQeakz10IZjPCIi4TKonxTX65Z/NbM2lZl1WX09fiz",
+	"JypY6WZm9P6jHbTZaBUM7Lbg3gufNLvrPSCKnWmOGaZoRDMgQuJpgir0NjPihPfCMO3rN5tnt+8vP8Wy",
+	"P9Ep2N4fiUQMygSkzBz5UE5UE8HrgGh9KGq06r+KadQJ9rCM8ztEeDCr4VdEg66JXHbjvzZYRUxo+Gjc",
+	"GLDA1LHUhKapcDf6/RHmzAw/T+etfm2tf0BoX2i31+Ft39t1AZoX4q9A752Y4c0vhWzpvl/JxVzbPzj6",
+	"clPX93GhE5RfRDUs6x4KraAhlUrRotBrNY3j2wjdlFjuxBRiQ9lSs++1RClQCfFTNPFVfObS8F8JzGwD",
+	"WW/8ImEsQSXlBq08BYujAd7BwQspnWHsnMXRSTmpFxaP7KXStc0O33MYTh/1oL2+tM3R7WD4uqcnhtXr",
+	"TgjUGY/hvjxKfhW7eD7cvsYvf2GcqYSc58A/npITwTnu3wTatR5qLKqKx53mtAzJm1RSf3/Oks/dWZiZ",
+	"wKvnJJfijsUgmwrp/xbdnyLg+r5aUl7ZmNs7YdwK2flydxy8dBO2CYSeIGGi4BNz0au/Ti56dx8llE+g",
+	"JQaDATnMNodTCROm3DtrXYpxM14uGvmMSZgpEkmg2mVF9acilOGbv44NnUjohWTc4Xjjsfi5TsSP6cjY",
+	"KtnOGzL9mkCtoFUd0b4fWOsH1rKAZo2s3SvsTMmXtsnY0QX0XDYrVBXD+l01C7dvJjaQwUH/N+sfDwOG",
+	"29vFLt6UPq1dQsM3a1I6mWDgVSTDBqXpXYxMBYKoSAJwV53aDrccvhq+mPJvD0xU12jVcV12uW0PPlhY",
+	"XXfcRIkSiKYgbVHDtIFgZkENF+gvhemPm2XxdYv9IFzjF+bvHV6ub6yXfeM/KowBjql7N2e1nVm4NzSc",
+	"Mhp/VLH2LG/eCiEpm8Ly6Up1zWVQ0tXLq91CL//Y4gXK/fXwsL/cy32sE5/pnmXLP+ywYisFGbqXbhQ5",
+	"Ob+88vxjhA35m++Efb8OtqPrYLiO9y7w3/ESows3toAgJBGcvH93TZyC/i/EpD1zDVJz67Ee8EKLvoXF",
+	"kOazuaGM0LK8isS40kDjuqsyPvHd8HEO9RR1r3Takftqj2YTfn66+u32SV/Fpvp3hR5NOJNyE52lW2bc",
+	"izrjVeMq/I4z/rNxxo7hBFkNBGUMNzmy7j5r/NYPPJ7gu7SWpze58DP4b5OZD9aFkGa+3itfKsJZ+8de",
+	"PYBOJ8Z5SjZ+ZmyyDSppLlt73fPLDTJuXv51OzaveQeJ1vnRYGD+nyIRSh/9PPx5GCxuFv8eADvi81do",
+	"TwAA",
+}
+
+// GetSwagger returns the content of the embedded swagger specification file
+// or error if failed to decode
+func decodeSpec() ([]byte, error) {
+	zipped, err := base64.StdEncoding.DecodeString(strings.Join(swaggerSpec, ""))
+	if err != nil {
+		return nil, fmt.Errorf("error base64 decoding spec: %s", err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(zipped))
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing spec: %s", err)
+	}
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(zr)
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing spec: %s", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+var rawSpec = decodeSpecCached()
+
+// a naive cached of a decoded swagger spec
+func decodeSpecCached() func() ([]byte, error) {
+	data, err := decodeSpec()
+	return func() ([]byte, error) {
+		return data, err
+	}
+}
+
+// Constructs a synthetic filesystem for resolving external references when loading openapi specifications.
+func PathToRawSpec(pathToFile string) map[string]func() ([]byte, error) {
+	var res = make(map[string]func() ([]byte, error))
+	if len(pathToFile) > 0 {
+		res[pathToFile] = rawSpec
+	}
+
+	return res
+}
+
+// GetSwagger returns the Swagger specification corresponding to the generated code
+// in this file. The external references of Swagger specification are resolved.
+// The logic of resolving external references is tightly connected to "import-mapping" feature.
+// Externally referenced files must be embedded in the corresponding golang packages.
+// Urls can be supported but this task was out of the scope.
+func GetSwagger() (swagger *openapi3.T, err error) {
+	var resolvePath = PathToRawSpec("")
+
+	loader := openapi3.NewLoader()
+	loader.IsExternalRefsAllowed = true
+	loader.ReadFromURIFunc = func(loader *openapi3.Loader, url *url.URL) ([]byte, error) {
+		var pathToFile = url.String()
+		pathToFile = path.Clean(pathToFile)
+		getSpec, ok := resolvePath[pathToFile]
+		if !ok {
+			err1 := fmt.Errorf("path not found: %s", pathToFile)
+			return nil, err1
+		}
+		return getSpec()
+	}
+	var specData []byte
+	specData, err = rawSpec()
+	if err != nil {
+		return
+	}
+	swagger, err = loader.LoadFromData(specData)
+	if err != nil {
+		return
+	}
+	return
+}
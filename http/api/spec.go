@@ -0,0 +1,8 @@
+package api
+
+import _ "embed"
+
+// Spec is the OpenAPI specification which api.gen.go is generated from
+//
+//go:embed openapi.yaml
+var Spec string
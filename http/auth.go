@@ -2,48 +2,202 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"time"
 
+	"github.com/golang-jwt/jwt/v4"
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slog"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 
+	"github.com/asmyasnikov/webinar-jaeger/internal/keepalive"
+	"github.com/asmyasnikov/webinar-jaeger/internal/requestid"
+	"github.com/asmyasnikov/webinar-jaeger/internal/retry"
+	"github.com/asmyasnikov/webinar-jaeger/internal/serviceauth"
 	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
 )
 
+const (
+	// roleAdmin is role of users who manage links of everyone
+	roleAdmin = "admin"
+	// roleUser is role of users who manage only their links
+	roleUser = "user"
+)
+
+// userRole returns role of user, tokens issued before roles have none
+func userRole(role string) string {
+	if role == "" {
+		return roleUser
+	}
+	return role
+}
+
 type auth struct {
-	tr     trace.Tracer
-	conn   *grpc.ClientConn
-	client pb.AuthClient
+	tr          trace.Tracer
+	conn        *grpc.ClientConn
+	client      pb.AuthClient
+	stopWatches context.CancelFunc
+	// jwtKey verifies tokens locally, without it tokens are validated by auth
+	jwtKey []byte
+
+	mu sync.RWMutex
+	// revoked are expiry times of revoked tokens by their IDs, tokens
+	// verified locally are checked against them
+	revoked map[string]time.Time
 }
 
-func newAuth(ctx context.Context, tr trace.Tracer, addr string) (*auth, error) {
+func newAuth(ctx context.Context, tr trace.Tracer, creds credentials.TransportCredentials, ka keepalive.Config, serviceKey, addr string, retryCfg retry.Config, jwtKey string, revokedPoll time.Duration) (*auth, error) {
 	_, span := tr.Start(ctx, "newAuth")
 	defer span.End()
 
 	conn, err := grpc.DialContext(ctx, addr,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor()),
-		grpc.WithStreamInterceptor(otelgrpc.StreamClientInterceptor()),
+		grpc.WithTransportCredentials(creds),
+		ka.DialOption(),
+		grpc.WithChainUnaryInterceptor(
+			retry.UnaryClientInterceptor(retryCfg, "/"+pb.Auth_ServiceDesc.ServiceName+"/Validate"),
+			otelgrpc.UnaryClientInterceptor(),
+			requestid.UnaryClientInterceptor(),
+			serviceauth.UnaryClientInterceptor(serviceKey),
+		),
+		grpc.WithChainStreamInterceptor(otelgrpc.StreamClientInterceptor(), requestid.StreamClientInterceptor()),
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	return &auth{
-		tr:     tr,
-		conn:   conn,
-		client: pb.NewAuthClient(conn),
-	}, nil
+	watchCtx, stopWatches := context.WithCancel(context.Background())
+	go watchConnState(watchCtx, tr, "auth", addr, conn)
+
+	a := &auth{
+		tr:          tr,
+		conn:        conn,
+		client:      pb.NewAuthClient(conn),
+		stopWatches: stopWatches,
+		jwtKey:      []byte(jwtKey),
+		revoked:     make(map[string]time.Time),
+	}
+	if len(a.jwtKey) > 0 && revokedPoll > 0 {
+		go a.watchRevoked(watchCtx, revokedPoll)
+	}
+	return a, nil
+}
+
+// watchRevoked fetches revoked tokens from auth every interval, so tokens
+// revoked through another http instance are rejected within interval
+func (a *auth) watchRevoked(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := a.fetchRevoked(ctx); err != nil && ctx.Err() == nil {
+			slog.Warn("fetch revoked tokens failed", slog.String("error", err.Error()))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *auth) fetchRevoked(ctx context.Context) (err error) {
+	// every fetch is a trace of its own rather than a child of endless watch
+	ctx, span := a.tr.Start(ctx, "fetchRevoked", trace.WithNewRoot())
+	defer span.End()
+
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		}
+	}()
+	response, err := a.client.ListRevoked(ctx, &pb.ListRevokedRequest{})
+	if err != nil {
+		return err
+	}
+	revoked := make(map[string]time.Time, len(response.GetTokens()))
+	for _, t := range response.GetTokens() {
+		revoked[t.GetId()] = t.GetExpireAt().AsTime()
+	}
+	span.SetAttributes(attribute.Int("revoked", len(revoked)))
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// revocations made here after the list was read are kept until the next fetch
+	for id, expireAt := range a.revoked {
+		if _, ok := revoked[id]; !ok && expireAt.After(time.Now()) {
+			revoked[id] = expireAt
+		}
+	}
+	a.revoked = revoked
+	return nil
+}
+
+func (a *auth) isRevoked(id string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	_, ok := a.revoked[id]
+	return ok
 }
 
 func (a *auth) Close() error {
+	a.stopWatches()
 	return a.conn.Close()
 }
 
-func (a *auth) Login(ctx context.Context, user, password string) (token string, expireAt time.Time, err error) {
+// Check reports error if auth service is unreachable or not serving
+func (a *auth) Check(ctx context.Context) error {
+	return checkHealth(ctx, a.conn, pb.Auth_ServiceDesc.ServiceName)
+}
+
+// Register creates user and returns its access and refresh tokens
+func (a *auth) Register(ctx context.Context, user, password string) (tokens *pb.LoginResponse, err error) {
+	ctx, span := a.tr.Start(ctx, "register")
+	defer span.End()
+
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("register successful")
+		}
+	}()
+	return a.client.Register(ctx, &pb.RegisterRequest{
+		User:     user,
+		Password: password,
+	})
+}
+
+// LoginExternal returns access and refresh tokens of local user mapped to
+// identity verified by identity provider
+func (a *auth) LoginExternal(ctx context.Context, issuer, subject string) (tokens *pb.LoginResponse, err error) {
+	ctx, span := a.tr.Start(ctx, "loginExternal", trace.WithAttributes(
+		attribute.String("issuer", issuer),
+	))
+	defer span.End()
+
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("login successful")
+		}
+	}()
+	return a.client.LoginExternal(ctx, &pb.LoginExternalRequest{
+		Issuer:  issuer,
+		Subject: subject,
+	})
+}
+
+// Login returns access and refresh tokens of user
+func (a *auth) Login(ctx context.Context, user, password, clientIP string) (tokens *pb.LoginResponse, err error) {
 	ctx, span := a.tr.Start(ctx, "login")
 	defer span.End()
 
@@ -52,36 +206,123 @@ func (a *auth) Login(ctx context.Context, user, password string) (token string,
 			span.SetAttributes(attribute.Bool("error", true))
 			span.RecordError(err)
 		} else {
-			span.AddEvent("login successful", trace.WithAttributes(
-				attribute.String("token", token),
-			))
+			span.AddEvent("login successful")
 		}
 	}()
-	response, err := a.client.Login(ctx, &pb.LoginRequest{
+	return a.client.Login(ctx, &pb.LoginRequest{
 		User:     user,
 		Password: password,
+		ClientIp: clientIP,
 	})
-	if err != nil {
-		return token, expireAt, err
-	}
+}
+
+// Refresh exchanges refresh token for new access and refresh tokens
+func (a *auth) Refresh(ctx context.Context, refreshToken string) (tokens *pb.LoginResponse, err error) {
+	ctx, span := a.tr.Start(ctx, "refresh")
+	defer span.End()
 
-	return response.GetToken(), response.GetExpireAt().AsTime(), nil
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("refresh successful")
+		}
+	}()
+	return a.client.Refresh(ctx, &pb.RefreshRequest{
+		RefreshToken: refreshToken,
+	})
 }
 
-func (a *auth) Validate(ctx context.Context, token string) (err error) {
+// Validate checks access token and returns user who owns it and role of the
+// user. Token is verified locally if the signing key is known, otherwise auth
+// is asked.
+func (a *auth) Validate(ctx context.Context, token string) (user, role string, err error) {
 	ctx, span := a.tr.Start(ctx, "validate")
 	defer span.End()
 
+	span.SetAttributes(attribute.Bool("auth.local", len(a.jwtKey) > 0))
 	defer func() {
 		if err != nil {
 			span.SetAttributes(attribute.Bool("error", true))
 			span.RecordError(err)
 		} else {
+			span.SetAttributes(attribute.String("role", role))
 			span.AddEvent("validate successful")
 		}
 	}()
-	_, err = a.client.Validate(ctx, &pb.ValidateRequest{
+	if len(a.jwtKey) > 0 {
+		return a.verify(token)
+	}
+	response, err := a.client.Validate(ctx, &pb.ValidateRequest{
 		Token: token,
 	})
-	return err
+	if err != nil {
+		return "", "", err
+	}
+	return response.GetUser(), userRole(response.GetRole()), nil
+}
+
+// Revoke terminates session of token. Token is rejected here at once, other
+// http instances reject it after they fetch revoked tokens.
+func (a *auth) Revoke(ctx context.Context, token string) (err error) {
+	ctx, span := a.tr.Start(ctx, "revoke")
+	defer span.End()
+
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("revoke successful")
+		}
+	}()
+	_, err = a.client.Revoke(ctx, &pb.RevokeRequest{
+		Token: token,
+	})
+	if err != nil {
+		return err
+	}
+	if len(a.jwtKey) > 0 {
+		if claims, err := a.parse(token); err == nil {
+			a.mu.Lock()
+			a.revoked[claims.ID] = claims.ExpiresAt.Time
+			a.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+// verify checks signature, expiry and revocation of JWT issued by auth and
+// returns its subject and role. Errors are Unauthenticated like errors of
+// Validate call.
+func (a *auth) verify(token string) (user, role string, err error) {
+	claims, err := a.parse(token)
+	if err != nil {
+		return "", "", status.Error(codes.Unauthenticated, err.Error())
+	}
+	if a.isRevoked(claims.ID) {
+		return "", "", status.Error(codes.Unauthenticated, "token revoked")
+	}
+	return claims.Subject, userRole(claims.Role), nil
+}
+
+// tokenClaims are claims of access token issued by auth
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	Role string `json:"role"`
+}
+
+func (a *auth) parse(token string) (*tokenClaims, error) {
+	var claims tokenClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return a.jwtKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &claims, nil
 }
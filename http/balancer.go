@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc"
+	_ "google.golang.org/grpc/health" // client side health checking of balanced connections
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+
+	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
+)
+
+// replicasSeparator separates addresses of replicas serving one storage
+const replicasSeparator = ";"
+
+// balancedServiceConfig spreads calls over all resolved addresses of storage
+// and leaves out replicas which health service reports not serving
+var balancedServiceConfig = fmt.Sprintf(`{
+	"loadBalancingConfig": [{"round_robin": {}}],
+	"healthCheckConfig": {"serviceName": %q}
+}`, pb.Storage_ServiceDesc.ServiceName)
+
+// storageTarget returns dial target and options of storage addr. The addr is
+// either one address, DNS name resolved to all replicas (dns:///host:port)
+// or replica addresses joined with replicasSeparator.
+func storageTarget(addr string) (string, []grpc.DialOption) {
+	opts := []grpc.DialOption{
+		grpc.WithDefaultServiceConfig(balancedServiceConfig),
+	}
+	if !strings.Contains(addr, replicasSeparator) {
+		return addr, opts
+	}
+	var state resolver.State
+	for _, a := range strings.Split(addr, replicasSeparator) {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			continue
+		}
+		// certificate of every replica is verified against its own host
+		host, _, err := net.SplitHostPort(a)
+		if err != nil {
+			host = a
+		}
+		state.Addresses = append(state.Addresses, resolver.Address{Addr: a, ServerName: host})
+	}
+	r := manual.NewBuilderWithScheme("replicas")
+	r.InitialState(state)
+	return r.Scheme() + ":///" + addr, append(opts, grpc.WithResolvers(r))
+}
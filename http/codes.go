@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	base62Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+	minCodeLength = 4
+	maxCodeLength = 32
+
+	// maxReservedAttempts bounds regeneration of codes equal to reserved words
+	maxReservedAttempts = 10
+)
+
+// reservedCodes are paths of routes served besides /{hash} and words of
+// likely future routes. Short code equal to one of them would be shadowed by
+// the route, or would shadow the route added later.
+var reservedCodes = []string{
+	"about", "account", "admin", "api", "assets", "auth", "docs", "favicon", "healthz", "help",
+	"index", "links", "login", "logout", "metrics", "oidc", "preview", "readyz", "refresh",
+	"register", "robots", "settings", "shorten", "static", "stats", "status", "user", "users",
+}
+
+// errReservedCode means generator kept making reserved codes
+var errReservedCode = errors.New("generated code is reserved")
+
+// reservedWords are compared with codes ignoring case, so codes differing
+// from route paths in case only are not issued either
+type reservedWords map[string]struct{}
+
+// newReservedWords returns reservedCodes with extra words configured by
+// deployment
+func newReservedWords(extra []string) reservedWords {
+	r := make(reservedWords, len(reservedCodes)+len(extra))
+	for _, word := range append(append([]string(nil), reservedCodes...), extra...) {
+		if word = strings.ToLower(strings.TrimSpace(word)); word != "" {
+			r[word] = struct{}{}
+		}
+	}
+	return r
+}
+
+func (r reservedWords) contains(code string) bool {
+	_, ok := r[strings.ToLower(code)]
+	return ok
+}
+
+// codeGenerator makes short codes for urls.
+// attempt is greater than zero when previous code collided with another url.
+type codeGenerator interface {
+	Generate(url []byte, attempt int) (string, error)
+}
+
+// newCodeGenerator returns generator which never makes reserved codes
+func newCodeGenerator(name string, length int, reserved reservedWords) (codeGenerator, error) {
+	if length < minCodeLength || length > maxCodeLength {
+		return nil, fmt.Errorf("code length must be in range [%d, %d], got %d", minCodeLength, maxCodeLength, length)
+	}
+	var g codeGenerator
+	switch name {
+	case "fnv":
+		if length > 16 {
+			return nil, fmt.Errorf("fnv code length must not exceed 16, got %d", length)
+		}
+		g = fnvGenerator{length: length}
+	case "base62":
+		g = base62Generator{length: length}
+	case "counter":
+		g = &counterGenerator{length: length, n: uint64(time.Now().UnixNano())}
+	default:
+		return nil, fmt.Errorf("unknown code generator '%s'", name)
+	}
+	return reservedGuard{codeGenerator: g, reserved: reserved}, nil
+}
+
+// reservedGuard regenerates codes equal to reserved words. Url is prefixed
+// for every regeneration, so generators deriving codes from url still give
+// the same url the same code.
+type reservedGuard struct {
+	codeGenerator
+	reserved reservedWords
+}
+
+func (g reservedGuard) Generate(url []byte, attempt int) (string, error) {
+	for i := 0; i < maxReservedAttempts; i++ {
+		code, err := g.codeGenerator.Generate(url, attempt)
+		if err != nil || !g.reserved.contains(code) {
+			return code, err
+		}
+		url = append([]byte("reserved#"), url...)
+	}
+	return "", errReservedCode
+}
+
+// fnvGenerator derives code from url hash, so the same url gets the same code
+type fnvGenerator struct {
+	length int
+}
+
+func (g fnvGenerator) Generate(url []byte, attempt int) (string, error) {
+	if attempt > 0 {
+		url = append([]byte(strconv.Itoa(attempt)+"#"), url...)
+	}
+	var hasher hash.Hash = fnv.New64a()
+	if g.length <= 8 {
+		hasher = fnv.New32a()
+	}
+	_, err := hasher.Write(url)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil))[:g.length], nil
+}
+
+// base62Generator makes random codes
+type base62Generator struct {
+	length int
+}
+
+func (g base62Generator) Generate([]byte, int) (string, error) {
+	code := make([]byte, g.length)
+	max := big.NewInt(int64(len(base62Alphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = base62Alphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// counterGenerator makes sequential codes. Counter starts from current time
+// to not repeat codes issued before restart.
+type counterGenerator struct {
+	length int
+	n      uint64
+}
+
+func (g *counterGenerator) Generate([]byte, int) (string, error) {
+	n := atomic.AddUint64(&g.n, 1)
+	code := make([]byte, g.length)
+	for i := len(code) - 1; i >= 0; i-- {
+		code[i] = base62Alphabet[n%uint64(len(base62Alphabet))]
+		n /= uint64(len(base62Alphabet))
+	}
+	return string(code), nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReservedWords(t *testing.T) {
+	r := newReservedWords([]string{" Pricing ", "", "go"})
+	for _, tt := range []struct {
+		code     string
+		reserved bool
+	}{
+		{code: "admin", reserved: true},
+		{code: "ADMIN", reserved: true},
+		{code: "Shorten", reserved: true},
+		{code: "pricing", reserved: true},
+		{code: "GO", reserved: true},
+		{code: "", reserved: false},
+		{code: "admins", reserved: false},
+		{code: "abc123", reserved: false},
+	} {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := r.contains(tt.code); got != tt.reserved {
+				t.Errorf("contains(%q) = %v, want %v", tt.code, got, tt.reserved)
+			}
+		})
+	}
+}
+
+// urlCodeGenerator returns code given for url or fails
+type urlCodeGenerator struct {
+	codes map[string]string
+	err   error
+}
+
+func (g urlCodeGenerator) Generate(url []byte, attempt int) (string, error) {
+	if g.err != nil {
+		return "", g.err
+	}
+	return g.codes[string(url)], nil
+}
+
+func TestReservedGuard(t *testing.T) {
+	errGenerate := errors.New("generate failed")
+	always := make(map[string]string, maxReservedAttempts)
+	for i, url := 0, "https://a"; i < maxReservedAttempts; i, url = i+1, "reserved#"+url {
+		always[url] = "Admin"
+	}
+	for _, tt := range []struct {
+		name      string
+		generator codeGenerator
+		want      string
+		wantErr   error
+	}{
+		{
+			name:      "not reserved code",
+			generator: urlCodeGenerator{codes: map[string]string{"https://a": "abc"}},
+			want:      "abc",
+		},
+		{
+			name: "reserved code is regenerated from prefixed url",
+			generator: urlCodeGenerator{codes: map[string]string{
+				"https://a":                   "login",
+				"reserved#https://a":          "STATS",
+				"reserved#reserved#https://a": "xyz",
+			}},
+			want: "xyz",
+		},
+		{
+			name:      "generator keeps making reserved codes",
+			generator: urlCodeGenerator{codes: always},
+			wantErr:   errReservedCode,
+		},
+		{
+			name:      "generator fails",
+			generator: urlCodeGenerator{err: errGenerate},
+			wantErr:   errGenerate,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			g := reservedGuard{codeGenerator: tt.generator, reserved: newReservedWords(nil)}
+			got, err := g.Generate([]byte("https://a"), 0)
+			if !errors.Is(err, tt.wantErr) || got != tt.want {
+				t.Errorf("Generate = %q, %v, want %q, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
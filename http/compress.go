@@ -0,0 +1,253 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// CompressionConfig enables gzip and deflate encoding of responses
+type CompressionConfig struct {
+	Level   int `yaml:"level" usage:"gzip and deflate level from 1 (fastest) to 9 (smallest), 0 disables compression"`
+	MinSize int `yaml:"min_size" usage:"bytes of response body below which response is sent uncompressed"`
+}
+
+// compressibleTypes are media types worth compressing besides text/*, images
+// and archives are compressed already
+var compressibleTypes = map[string]bool{
+	"application/json":       true,
+	"application/yaml":       true,
+	"application/javascript": true,
+	"application/text":       true,
+	"image/svg+xml":          true,
+}
+
+// encoders pools compressors of one encoding, as their allocation costs more
+// than compressing a typical response
+type encoders struct {
+	pool sync.Pool
+	new  func(io.Writer) resetWriteCloser
+}
+
+// resetWriteCloser is gzip or zlib writer
+type resetWriteCloser interface {
+	io.WriteCloser
+	Reset(io.Writer)
+}
+
+func (e *encoders) get(w io.Writer) resetWriteCloser {
+	if enc, ok := e.pool.Get().(resetWriteCloser); ok {
+		enc.Reset(w)
+		return enc
+	}
+	return e.new(w)
+}
+
+// newCompressionMiddleware returns router middleware which compresses
+// responses of clients accepting gzip or deflate encoding. Redirects, empty
+// responses, responses to HEAD requests, already encoded bodies and bodies
+// shorter than MinSize are sent as is.
+func newCompressionMiddleware(cfg CompressionConfig) (mux.MiddlewareFunc, error) {
+	if cfg.Level == 0 {
+		return func(next http.Handler) http.Handler {
+			return next
+		}, nil
+	}
+	if cfg.Level < gzip.BestSpeed || cfg.Level > gzip.BestCompression {
+		return nil, fmt.Errorf("compression level %d is not from %d to %d", cfg.Level, gzip.BestSpeed, gzip.BestCompression)
+	}
+
+	pools := map[string]*encoders{
+		"gzip": {new: func(w io.Writer) resetWriteCloser {
+			// level is checked above
+			enc, _ := gzip.NewWriterLevel(w, cfg.Level)
+			return enc
+		}},
+		// deflate content coding is zlib format, not raw deflate
+		"deflate": {new: func(w io.Writer) resetWriteCloser {
+			enc, _ := zlib.NewWriterLevel(w, cfg.Level)
+			return enc
+		}},
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// response depends on Accept-Encoding even if it is sent as is
+			w.Header().Add("Vary", "Accept-Encoding")
+			encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+			if encoding == "" || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{
+				ResponseWriter: w,
+				encoding:       encoding,
+				encoders:       pools[encoding],
+				minSize:        cfg.MinSize,
+			}
+			defer cw.Close()
+			next.ServeHTTP(cw, r)
+		})
+	}, nil
+}
+
+// acceptedEncoding returns gzip or deflate, whichever Accept-Encoding prefers,
+// gzip if both are equally good, and empty string if neither is accepted. "*"
+// stands for codings not listed in header, so "gzip;q=0, *" refuses gzip and
+// accepts deflate.
+func acceptedEncoding(header string) string {
+	listed := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		listed[coding] = q
+	}
+
+	var best string
+	var bestQ float64
+	// gzip goes first, so it wins a tie
+	for _, coding := range []string{"gzip", "deflate"} {
+		q, ok := listed[coding]
+		if !ok {
+			q = listed["*"]
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// compressWriter holds body back until it reaches minSize, then decides
+// whether to compress it by status and headers set by handler
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	encoders *encoders
+	minSize  int
+
+	code    int
+	buf     []byte
+	decided bool
+	enc     resetWriteCloser
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.decided || w.code != 0 {
+		return
+	}
+	w.code = code
+	if !compressibleStatus(code) {
+		w.decide(false)
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	if !w.decided {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) < w.minSize {
+			return len(p), nil
+		}
+		if err := w.flushBuffer(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if w.enc != nil {
+		return w.enc.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Close sends body held back and finishes compressed stream
+func (w *compressWriter) Close() error {
+	if !w.decided {
+		if w.code == 0 {
+			// handler wrote nothing, net/http sends 200 with empty body
+			return nil
+		}
+		if err := w.flushBuffer(); err != nil {
+			return err
+		}
+	}
+	if w.enc == nil {
+		return nil
+	}
+	err := w.enc.Close()
+	w.encoders.pool.Put(w.enc)
+	w.enc = nil
+	return err
+}
+
+// flushBuffer decides on held back body and sends it
+func (w *compressWriter) flushBuffer() error {
+	h := w.Header()
+	if h.Get("Content-Type") == "" && len(w.buf) > 0 {
+		// the same type net/http would sniff, decision depends on it
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	w.decide(len(w.buf) > 0 && len(w.buf) >= w.minSize && compressibleType(h.Get("Content-Type")) && h.Get("Content-Encoding") == "")
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.enc != nil {
+		_, err = w.enc.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// decide sends status and headers of compressed or plain response
+func (w *compressWriter) decide(compress bool) {
+	w.decided = true
+	if compress {
+		h := w.Header()
+		h.Set("Content-Encoding", w.encoding)
+		// length set by handler is length of plain body
+		h.Del("Content-Length")
+		w.enc = w.encoders.get(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.code)
+}
+
+// compressibleStatus is false for redirects, responses without body and
+// partial content, whose ranges are offsets in plain body
+func compressibleStatus(code int) bool {
+	return code >= http.StatusOK && code < http.StatusMultipleChoices &&
+		code != http.StatusNoContent && code != http.StatusResetContent && code != http.StatusPartialContent
+}
+
+func compressibleType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || compressibleTypes[mediaType]
+}
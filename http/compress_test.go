@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestAcceptedEncoding(t *testing.T) {
+	for _, tt := range []struct {
+		header string
+		want   string
+	}{
+		{header: "", want: ""},
+		{header: "identity", want: ""},
+		{header: "br", want: ""},
+		{header: "gzip", want: "gzip"},
+		{header: "deflate", want: "deflate"},
+		{header: "GZIP", want: "gzip"},
+		{header: "gzip, deflate, br", want: "gzip"},
+		{header: "deflate, gzip", want: "gzip"},
+		{header: "gzip;q=0.5, deflate", want: "deflate"},
+		{header: "gzip; q=0.8, deflate; q=0.9", want: "deflate"},
+		{header: "gzip;q=0", want: ""},
+		{header: "gzip;q=0, deflate;q=0", want: ""},
+		{header: "gzip;q=invalid, deflate", want: "deflate"},
+		{header: "*", want: "gzip"},
+		{header: "*;q=0", want: ""},
+		{header: "br, *;q=0.1", want: "gzip"},
+		{header: "gzip;q=0, *", want: "deflate"},
+		{header: "deflate;q=0, *", want: "gzip"},
+		{header: "gzip;q=0, deflate;q=0, *", want: ""},
+		{header: "*, gzip;q=0", want: "deflate"},
+		{header: "deflate;q=0.5, *;q=0.8", want: "gzip"},
+		{header: "gzip;q=0.2, *;q=0.8", want: "deflate"},
+	} {
+		t.Run(tt.header, func(t *testing.T) {
+			if got := acceptedEncoding(tt.header); got != tt.want {
+				t.Errorf("acceptedEncoding(%q) = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
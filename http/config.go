@@ -0,0 +1,148 @@
+package main
+
+import (
+	"time"
+
+	"github.com/asmyasnikov/webinar-jaeger/internal/config"
+	"github.com/asmyasnikov/webinar-jaeger/internal/keepalive"
+	"github.com/asmyasnikov/webinar-jaeger/internal/mtls"
+	"github.com/asmyasnikov/webinar-jaeger/internal/retry"
+	"github.com/asmyasnikov/webinar-jaeger/internal/telemetry"
+)
+
+type Config struct {
+	Port          int                `yaml:"port" usage:"HTTP listen port"`
+	Telemetry     telemetry.Config   `yaml:",inline"`
+	AuthAddr      string             `yaml:"auth_addr" usage:"address of auth gRPC service"`
+	JWTKey        string             `yaml:"jwt_key" usage:"key of HS256 tokens issued by auth (JWT_KEY of auth) to validate them locally, empty validates tokens by auth"`
+	RevokedPoll   time.Duration      `yaml:"revoked_poll" usage:"how often revoked tokens are fetched from auth when tokens are validated locally, 0 disables fetching"`
+	Storages      StoragesConfig     `yaml:",inline"`
+	AnalyticsAddr string             `yaml:"analytics_addr" usage:"address of analytics gRPC service"`
+	Clicks        ClicksConfig       `yaml:"clicks"`
+	CodeGenerator string             `yaml:"code_generator" usage:"short code generator: fnv, base62 or counter"`
+	CodeLength    int                `yaml:"code_length" usage:"length of generated short codes"`
+	ReservedCodes []string           `yaml:"reserved_codes" usage:"comma-separated words short codes must never be besides built-in route names"`
+	MaxURLLength  int                `yaml:"max_url_length" usage:"max length of url in bytes, 0 is unlimited"`
+	ReadySentinel string             `yaml:"ready_sentinel" usage:"short code looked up in storage on readiness check, empty disables lookup"`
+	TraceIDHeader string             `yaml:"trace_id_header" usage:"when to return X-Trace-ID response header: always, debug (only to requests with X-Debug header) or never"`
+	Retry         retry.Config       `yaml:"retry"`
+	Keepalive     keepalive.Config   `yaml:"keepalive"`
+	TLS           mtls.Config        `yaml:"tls"`
+	ServiceKey    string             `yaml:"service_key" usage:"shared key sent to storage, cache, analytics and auth services, empty sends none"`
+	HTTPS         HTTPSConfig        `yaml:"https"`
+	RateLimit     RateLimitConfig    `yaml:"rate_limit"`
+	Compression   CompressionConfig  `yaml:"compression"`
+	AccessLog     AccessLogConfig    `yaml:"access_log"`
+	OIDC          OIDCConfig         `yaml:"oidc"`
+	Session       SessionConfig      `yaml:"session"`
+	CSRFKey       string             `yaml:"csrf_key" usage:"key signing CSRF cookies, the same for every http instance; random if empty"`
+	Screen        ScreenConfig       `yaml:"screen"`
+	Destinations  DestinationsConfig `yaml:"destinations"`
+	Metadata      MetadataConfig     `yaml:"metadata"`
+	Domains       []string           `yaml:"domains" usage:"comma-separated host=namespace pairs: links shortened and resolved on host live in its namespace, other hosts share global namespace"`
+}
+
+// ClicksConfig makes redirects publish clicks to YDB topic, storage service
+// consumes them asynchronously
+type ClicksConfig struct {
+	Topic  string `yaml:"topic" usage:"YDB topic clicks are published to, empty sends clicks to analytics service"`
+	YdbDSN string `yaml:"ydb_dsn" usage:"connection string of YDB database with clicks topic"`
+}
+
+// StoragesConfig sets caches and durable storages of links and how they are asked
+type StoragesConfig struct {
+	CacheAddrs      []string      `yaml:"cache_addrs" usage:"comma-separated addresses of cache gRPC services, links are sharded over them by hash"`
+	StorageAddrs    []string      `yaml:"storage_addrs" usage:"comma-separated addresses of durable storage gRPC services in lookup order"`
+	CacheBackfill   bool          `yaml:"cache_backfill" usage:"put links found in durable storage into caches in background"`
+	HedgeDelay      time.Duration `yaml:"hedge_delay" usage:"delay before lookup is sent to the next storage in parallel (e.g. p95 of storage latency), 0 disables hedging"`
+	StorageFanOut   bool          `yaml:"storage_fan_out" usage:"send lookup to all storages at once and take the first found link"`
+	CacheTimeout    time.Duration `yaml:"cache_timeout" usage:"deadline of a single get, put or delete call to cache, 0 disables deadline"`
+	StorageTimeout  time.Duration `yaml:"storage_timeout" usage:"deadline of a single get, put or delete call to durable storage, 0 disables deadline"`
+	ReadConsistency string        `yaml:"read_consistency" usage:"consistency of redirect lookups in durable storage: strong or stale, empty leaves default of storage"`
+}
+
+// RateLimitConfig sets token buckets for login and shorten requests
+type RateLimitConfig struct {
+	IPRate            float64 `yaml:"ip_rate" usage:"requests per second allowed from one client IP, 0 disables limit"`
+	IPBurst           int     `yaml:"ip_burst" usage:"requests allowed from one client IP at once"`
+	SessionRate       float64 `yaml:"session_rate" usage:"requests per second allowed within one session, 0 disables limit"`
+	SessionBurst      int     `yaml:"session_burst" usage:"requests allowed within one session at once"`
+	TrustForwardedFor bool    `yaml:"trust_forwarded_for" usage:"take client IP from the last X-Forwarded-For entry, appended by the single reverse proxy in front of http"`
+}
+
+// HTTPSConfig enables TLS termination on Port with certificate from files or
+// obtained from Let's Encrypt
+type HTTPSConfig struct {
+	Cert            string   `yaml:"cert" usage:"path to PEM certificate, HTTPS is disabled if neither cert nor autocert domains set"`
+	Key             string   `yaml:"key" usage:"path to PEM private key"`
+	AutocertDomains []string `yaml:"autocert_domains" usage:"comma-separated domains to obtain Let's Encrypt certificates for"`
+	AutocertCache   string   `yaml:"autocert_cache" usage:"directory to keep Let's Encrypt certificates between restarts"`
+	RedirectPort    int      `yaml:"redirect_port" usage:"plain HTTP port redirecting to HTTPS, 0 disables redirect"`
+}
+
+func loadConfig() (*Config, error) {
+	cfg := &Config{
+		Port:        8080,
+		Telemetry:   telemetry.DefaultConfig(),
+		AuthAddr:    "127.0.0.1:50051",
+		RevokedPoll: 5 * time.Second,
+		Storages: StoragesConfig{
+			CacheAddrs:     []string{"localhost:5302"},
+			StorageAddrs:   []string{"localhost:5300"},
+			CacheBackfill:  true,
+			CacheTimeout:   50 * time.Millisecond,
+			StorageTimeout: 500 * time.Millisecond,
+		},
+		AnalyticsAddr: "localhost:5300",
+		Clicks: ClicksConfig{
+			YdbDSN: "grpc://localhost:2136/local",
+		},
+		CodeGenerator: "fnv",
+		CodeLength:    8,
+		MaxURLLength:  2048,
+		TraceIDHeader: "always",
+		Retry:         retry.DefaultConfig(),
+		Keepalive:     keepalive.DefaultConfig(),
+		HTTPS: HTTPSConfig{
+			AutocertCache: "autocert",
+		},
+		RateLimit: RateLimitConfig{
+			IPRate:       10,
+			IPBurst:      20,
+			SessionRate:  5,
+			SessionBurst: 10,
+		},
+		Compression: CompressionConfig{
+			Level:   5,
+			MinSize: 1024,
+		},
+		AccessLog: AccessLogConfig{
+			Enabled: true,
+			Sample:  1,
+		},
+		OIDC: OIDCConfig{
+			Scopes: []string{"profile", "email"},
+		},
+		Session: SessionConfig{
+			Path:        "/",
+			SameSite:    "lax",
+			IdleTimeout: 30 * time.Minute,
+		},
+		Screen: ScreenConfig{
+			Action:          "reject",
+			Timeout:         2 * time.Second,
+			CacheTTL:        5 * time.Minute,
+			SafeBrowsingURL: "https://safebrowsing.googleapis.com/v4/threatMatches:find",
+		},
+		Metadata: MetadataConfig{
+			Workers:  2,
+			Queue:    1000,
+			Timeout:  5 * time.Second,
+			MaxBytes: 256 << 10,
+		},
+	}
+	if err := config.Load(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
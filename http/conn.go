@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+
+	"github.com/asmyasnikov/webinar-jaeger/internal/metrics"
+)
+
+// watchConnState starts connecting conn and reports its state changes until
+// ctx is done or conn is closed. Dial does not wait for the peer and gRPC
+// reconnects with backoff by itself, so a service down at start or later
+// only fails calls made meanwhile; changes are made visible as logs, the
+// grpc_client_connection_state metric and spans.
+func watchConnState(ctx context.Context, tr trace.Tracer, peer, target string, conn *grpc.ClientConn) {
+	conn.Connect()
+	state := conn.GetState()
+	metrics.SetConnState(peer, target, state)
+	for state != connectivity.Shutdown && conn.WaitForStateChange(ctx, state) {
+		prev := state
+		state = conn.GetState()
+		metrics.SetConnState(peer, target, state)
+
+		// every change is a trace of its own rather than a child of endless watch
+		_, span := tr.Start(ctx, "connection state changed", trace.WithNewRoot(), trace.WithAttributes(
+			attribute.String("peer", peer),
+			attribute.String("address", target),
+			attribute.String("from", prev.String()),
+			attribute.String("to", state.String()),
+		))
+		span.AddEvent(state.String())
+		span.End()
+
+		slog.Info("connection state changed",
+			slog.String("peer", peer),
+			slog.String("address", target),
+			slog.String("from", prev.String()),
+			slog.String("to", state.String()),
+		)
+
+		// idle connection would wait for the next call to reconnect
+		if state == connectivity.Idle {
+			conn.Connect()
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/csrf"
+	"github.com/gorilla/securecookie"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slog"
+)
+
+// csrfHeader is request header with CSRF token, index page and its response
+// header give the token
+const csrfHeader = "X-CSRF-Token"
+
+// safeMethods do not change state, so they are not checked for CSRF token
+var safeMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// csrfProtection issues CSRF tokens bound to cookie signed by key and checks
+// them on state-changing requests. Session cookie is sent by browser with
+// requests forged by other sites, the token is not.
+type csrfProtection struct {
+	protect func(http.Handler) http.Handler
+}
+
+func newCSRFProtection(key string, cookies *sessionCookies) *csrfProtection {
+	authKey := []byte(key)
+	if len(authKey) == 0 {
+		slog.Warn("CSRF key is not set, CSRF tokens are signed with random key")
+		authKey = securecookie.GenerateRandomKey(32)
+	}
+	return &csrfProtection{
+		protect: csrf.Protect(authKey,
+			csrf.Path("/"),
+			csrf.Domain(cookies.cfg.Domain),
+			csrf.Secure(cookies.cfg.Secure),
+			csrf.SameSite(csrf.SameSiteLaxMode),
+			csrf.RequestHeader(csrfHeader),
+			csrf.ErrorHandler(http.HandlerFunc(csrfFailed)),
+		),
+	}
+}
+
+// Issue makes CSRF token of request available to next handler with
+// csrf.Token and sets its cookie unless the client has one
+func (c *csrfProtection) Issue(next http.Handler) http.Handler {
+	return c.protect(next)
+}
+
+// Middleware rejects state-changing requests without valid CSRF token. Safe
+// requests pass unchecked and get no cookie, so redirects stay cheap.
+func (c *csrfProtection) Middleware(next http.HandlerFunc) http.HandlerFunc {
+	protected := c.protect(next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if safeMethods[r.Method] {
+			next(w, r)
+			return
+		}
+		protected.ServeHTTP(w, r)
+	}
+}
+
+func csrfFailed(w http.ResponseWriter, r *http.Request) {
+	err := csrf.FailureReason(r)
+	span := trace.SpanFromContext(r.Context())
+	span.SetAttributes(attribute.Bool("error", true))
+	span.RecordError(err)
+	writeResponse(w, http.StatusForbidden, "CSRF check failed: "+err.Error())
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// errDestinationDenied means host of url is not allowed by destination lists
+var errDestinationDenied = errors.New("destination is not allowed")
+
+// DestinationsConfig restricts hosts links may point to, e.g. to intranet
+// hosts in corporate deployments
+type DestinationsConfig struct {
+	Allow []string `yaml:"allow" usage:"comma-separated host patterns links may point to, e.g. *.corp.example; empty allows every host not denied"`
+	Deny  []string `yaml:"deny" usage:"comma-separated host patterns links may not point to, deny wins over allow"`
+}
+
+// destinations matches hosts against glob patterns of path.Match, so '*'
+// matches any part of host name including dots: *.corp.example matches
+// every subdomain of corp.example, but not corp.example itself
+type destinations struct {
+	allow []string
+	deny  []string
+}
+
+// newDestinations returns nil if no patterns are configured
+func newDestinations(cfg DestinationsConfig) (*destinations, error) {
+	if len(cfg.Allow) == 0 && len(cfg.Deny) == 0 {
+		return nil, nil
+	}
+	d := &destinations{}
+	for _, list := range []struct {
+		patterns []string
+		to       *[]string
+	}{
+		{cfg.Allow, &d.allow},
+		{cfg.Deny, &d.deny},
+	} {
+		for _, pattern := range list.patterns {
+			pattern = strings.ToLower(strings.TrimSpace(pattern))
+			if pattern == "" {
+				continue
+			}
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("destination pattern '%s': %w", pattern, err)
+			}
+			*list.to = append(*list.to, pattern)
+		}
+	}
+	return d, nil
+}
+
+func matchHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Check fails with errDestinationDenied if host of url is denied or not
+// allowed. Nil destinations allow every url.
+func (d *destinations) Check(rawURL string) error {
+	if d == nil {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errDestinationDenied, err)
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if matchHost(d.deny, host) || len(d.allow) > 0 && !matchHost(d.allow, host) {
+		return fmt.Errorf("%w: host '%s'", errDestinationDenied, host)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewDestinations(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		cfg     DestinationsConfig
+		wantNil bool
+		wantErr bool
+	}{
+		{name: "no patterns", wantNil: true},
+		{name: "empty patterns", cfg: DestinationsConfig{Allow: []string{" ", ""}}},
+		{name: "valid patterns", cfg: DestinationsConfig{Allow: []string{"*.corp.example"}, Deny: []string{"[ab].example"}}},
+		{name: "invalid pattern", cfg: DestinationsConfig{Deny: []string{"[.example"}}, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := newDestinations(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newDestinations failed: %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (d == nil) != tt.wantNil {
+				t.Errorf("newDestinations = %v, want nil %v", d, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestDestinationsCheck(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		cfg     DestinationsConfig
+		url     string
+		allowed bool
+	}{
+		{
+			name:    "subdomain matches wildcard",
+			cfg:     DestinationsConfig{Allow: []string{"*.corp.example"}},
+			url:     "https://wiki.corp.example/page",
+			allowed: true,
+		},
+		{
+			name:    "wildcard matches dots",
+			cfg:     DestinationsConfig{Allow: []string{"*.corp.example"}},
+			url:     "https://a.b.corp.example/",
+			allowed: true,
+		},
+		{
+			name: "wildcard does not match parent domain",
+			cfg:  DestinationsConfig{Allow: []string{"*.corp.example"}},
+			url:  "https://corp.example/",
+		},
+		{
+			name: "wildcard does not match suffix without dot",
+			cfg:  DestinationsConfig{Allow: []string{"*.corp.example"}},
+			url:  "https://evilcorp.example/",
+		},
+		{
+			name: "wildcard does not match other domain ending with pattern",
+			cfg:  DestinationsConfig{Allow: []string{"*.corp.example"}},
+			url:  "https://wiki.corp.example.evil.example/",
+		},
+		{
+			name:    "exact host",
+			cfg:     DestinationsConfig{Allow: []string{"corp.example"}},
+			url:     "https://corp.example:8443/",
+			allowed: true,
+		},
+		{
+			name:    "case and trailing dot are ignored",
+			cfg:     DestinationsConfig{Allow: []string{" *.Corp.Example "}},
+			url:     "https://WIKI.corp.example./",
+			allowed: true,
+		},
+		{
+			name:    "question mark matches one character",
+			cfg:     DestinationsConfig{Allow: []string{"wiki?.corp.example"}},
+			url:     "https://wiki2.corp.example/",
+			allowed: true,
+		},
+		{
+			name:    "not denied host without allow list",
+			cfg:     DestinationsConfig{Deny: []string{"*.evil.example"}},
+			url:     "https://good.example/",
+			allowed: true,
+		},
+		{
+			name: "denied host without allow list",
+			cfg:  DestinationsConfig{Deny: []string{"*.evil.example"}},
+			url:  "https://www.evil.example/",
+		},
+		{
+			name: "deny wins over allow",
+			cfg:  DestinationsConfig{Allow: []string{"*.corp.example"}, Deny: []string{"secret.corp.example"}},
+			url:  "https://secret.corp.example/",
+		},
+		{
+			name:    "ip address",
+			cfg:     DestinationsConfig{Allow: []string{"10.0.*"}},
+			url:     "http://10.0.1.2:8080/",
+			allowed: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := newDestinations(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = d.Check(tt.url)
+			if tt.allowed && err != nil {
+				t.Errorf("Check(%q) = %v, want nil", tt.url, err)
+			}
+			if !tt.allowed && !errors.Is(err, errDestinationDenied) {
+				t.Errorf("Check(%q) = %v, want %v", tt.url, err, errDestinationDenied)
+			}
+		})
+	}
+}
+
+func TestNilDestinationsAllowEverything(t *testing.T) {
+	var d *destinations
+	if err := d.Check("https://any.example/"); err != nil {
+		t.Errorf("Check = %v, want nil", err)
+	}
+}
@@ -3,68 +3,192 @@ package main
 import (
 	"context"
 	_ "embed"
-	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"hash/fnv"
+	"html/template"
 	"io"
 	"net/http"
 	"os"
 	"os/signal"
 	"regexp"
 	"strconv"
-	"strings"
+	"sync"
+	"time"
 
+	"github.com/gorilla/csrf"
 	"github.com/gorilla/mux"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
 	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/asmyasnikov/webinar-jaeger/internal/identity"
+	"github.com/asmyasnikov/webinar-jaeger/internal/metrics"
+	"github.com/asmyasnikov/webinar-jaeger/internal/requestid"
+	"github.com/asmyasnikov/webinar-jaeger/server/api"
 )
 
-//go:embed static/index.html
+//go:embed templates/index.html
 var indexPage string
 
+// indexTemplate renders index page with CSRF token of request
+var indexTemplate = template.Must(template.New("index").Funcs(templateFuncs).Parse(indexPage))
+
 const (
 	invalidHashError = "'%s' is not a valid short path."
-	invalidURLError  = "'%s' is not a valid URL."
+	invalidURLError  = "'%s' is not a valid URL: %s."
+	invalidTTLError  = "'%s' is not a valid TTL."
+)
+
+const (
+	maxHashAttempts = 10
+	maxBatchSize    = 1000
+	clickTimeout    = 5 * time.Second
+	// preflightMaxAge is how long browsers cache answers to preflight requests
+	preflightMaxAge = 24 * time.Hour
 )
 
 var (
-	short        = regexp.MustCompile(`[a-zA-Z0-9]{8}`)
-	long         = regexp.MustCompile(`https?://(?:[-\w.]|%[\da-fA-F]{2})+`)
+	short        = regexp.MustCompile(fmt.Sprintf(`^[a-zA-Z0-9]{%d,%d}$`, minCodeLength, maxCodeLength))
 	sessionToken = "session_token"
+	refreshToken = "refresh_token"
 )
 
 type handlers struct {
-	tr      trace.Tracer
-	auth    *auth
-	storage Storage
-	router  *mux.Router
+	tr        trace.Tracer
+	auth      *auth
+	storage   Storage
+	analytics *analytics
+	codes     codeGenerator
+	// oidc is nil unless SSO login is configured
+	oidc    *oidcProvider
+	cookies *sessionCookies
+	// screening is nil unless destinations are screened
+	screening *urlScreening
+	// destinations is nil unless hosts of links are restricted
+	destinations *destinations
+	// metadata is nil unless titles and favicons of destinations are fetched
+	metadata *metadataFetcher
+	// namespaces map custom domains to namespaces of links
+	namespaces namespaces
+	urls       urlValidator
+	router     *mux.Router
+	// sentinel is hash looked up on readiness check
+	sentinel string
+	// trustForwardedFor takes client IP sent to auth from X-Forwarded-For
+	trustForwardedFor bool
+}
+
+// handlersDeps are clients and components handlers are built of
+type handlersDeps struct {
+	Tracer     trace.Tracer
+	Auth       *auth
+	Storage    Storage
+	Analytics  *analytics
+	Codes      codeGenerator
+	Cookies    *sessionCookies
+	Protection *csrfProtection
+	Namespaces namespaces
+	// OIDC, Screening, Destinations and Metadata are nil unless configured
+	OIDC         *oidcProvider
+	Screening    *urlScreening
+	Destinations *destinations
+	Metadata     *metadataFetcher
+}
+
+// handlersConfig is part of Config handlers and their middlewares are set by
+type handlersConfig struct {
+	MaxURLLength  int
+	ReadySentinel string
+	TraceIDHeader string
+	RateLimit     RateLimitConfig
+	Compression   CompressionConfig
+	AccessLog     AccessLogConfig
 }
 
-func newHandlers(ctx context.Context, tr trace.Tracer, a *auth, s Storage) (*handlers, error) {
+func newHandlers(ctx context.Context, deps handlersDeps, cfg handlersConfig) (*handlers, error) {
+	tr := deps.Tracer
 	_, span := tr.Start(ctx, "newHandlers")
 	defer span.End()
 
 	h := &handlers{
-		tr:      tr,
-		auth:    a,
-		storage: s,
-		router:  mux.NewRouter(),
+		tr:           tr,
+		auth:         deps.Auth,
+		storage:      deps.Storage,
+		analytics:    deps.Analytics,
+		codes:        deps.Codes,
+		oidc:         deps.OIDC,
+		cookies:      deps.Cookies,
+		screening:    deps.Screening,
+		destinations: deps.Destinations,
+		metadata:     deps.Metadata,
+		namespaces:   deps.Namespaces,
+		urls:         urlValidator{maxLength: cfg.MaxURLLength},
+		router:       mux.NewRouter(),
+		sentinel:     cfg.ReadySentinel,
+
+		trustForwardedFor: cfg.RateLimit.TrustForwardedFor,
 	}
-	h.router.HandleFunc("/", h.handleIndex).Methods(http.MethodGet)
-	h.router.HandleFunc("/login", h.handleLogin).Methods(http.MethodPost)
-	h.router.HandleFunc("/shorten", h.handleShorten).Methods(http.MethodPost)
-	h.router.HandleFunc("/{[0-9a-fA-F]{8}}", h.handleLonger).Methods(http.MethodGet)
+	// index page issues CSRF token, which scripts send with state-changing requests
+	h.router.Handle("/", deps.Protection.Issue(http.HandlerFunc(h.handleIndex))).Methods(http.MethodGet)
+	h.router.HandleFunc("/api/openapi.yaml", h.handleSpec).Methods(http.MethodGet)
+	// probes, metrics, previews and static files are registered before API
+	// routes, otherwise they match /{hash}
+	h.router.HandleFunc("/healthz", h.handleHealthz).Methods(http.MethodGet)
+	h.router.HandleFunc("/readyz", h.handleReadyz).Methods(http.MethodGet)
+	h.router.Handle("/metrics", metrics.Handler()).Methods(http.MethodGet)
+	h.router.HandleFunc("/{hash}+", h.handlePreview).Methods(http.MethodGet)
+	h.router.Handle("/static/{path:.+}", staticAssets).Methods(http.MethodGet, http.MethodHead)
 
-	return h, nil
-}
+	tracing, err := newTraceMiddleware(tr, cfg.TraceIDHeader)
+	if err != nil {
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return nil, err
+	}
+	compress, err := newCompressionMiddleware(cfg.Compression)
+	if err != nil {
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return nil, err
+	}
+	logging, err := newAccessLogMiddleware(cfg.AccessLog)
+	if err != nil {
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return nil, err
+	}
+	// the first middleware is the outermost, so access log and metrics observe
+	// traced requests and handlers write to compressing writer
+	h.router.Use(tracing, logging, metrics.Middleware(routeTemplate), deps.Cookies.Middleware, compress)
+	// router middlewares run on matched routes only, unmatched requests are
+	// traced and logged by the same middlewares in the same order
+	h.router.NotFoundHandler = tracing(logging(http.NotFoundHandler()))
+	h.router.MethodNotAllowedHandler = tracing(logging(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	})))
 
-type Credentials struct {
-	Password string `json:"password"`
-	Username string `json:"username"`
+	validator, err := newValidator(tr)
+	if err != nil {
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return nil, err
+	}
+
+	api.HandlerWithOptions(h, api.GorillaServerOptions{
+		BaseRouter: h.router,
+		// the last middleware is the outermost, so limited requests are not
+		// checked for CSRF token nor validated
+		Middlewares: []api.MiddlewareFunc{validator, deps.Protection.Middleware, newRateLimiter(tr, cfg.RateLimit)},
+	})
+
+	return h, nil
 }
 
-func (h *handlers) handleLogin(w http.ResponseWriter, r *http.Request) {
+func (h *handlers) Login(w http.ResponseWriter, r *http.Request) {
 	ctx, span := h.tr.Start(r.Context(), "login")
 	defer span.End()
 
@@ -76,7 +200,7 @@ func (h *handlers) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var creds Credentials
+	var creds api.Credentials
 	err = json.Unmarshal(body, &creds)
 	if err != nil {
 		writeResponse(w, http.StatusBadRequest, "cannot unmarshal body to credentials json: "+err.Error())
@@ -85,30 +209,308 @@ func (h *handlers) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, expireAt, err := h.auth.Login(ctx, creds.Username, creds.Password)
+	tokens, err := h.auth.Login(ctx, creds.Username, creds.Password, clientIP(r, h.trustForwardedFor))
 	if err != nil {
-		writeResponse(w, http.StatusBadRequest, "authenticate failed: "+err.Error())
+		code := http.StatusBadRequest
+		if status.Code(err) == codes.ResourceExhausted {
+			// auth locked out user or client after failed logins
+			code = http.StatusTooManyRequests
+		}
+		writeResponse(w, code, "authenticate failed: "+err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	if err = h.cookies.set(w, tokens); err != nil {
+		writeResponse(w, http.StatusInternalServerError, err.Error())
 		span.SetAttributes(attribute.Bool("error", true))
 		span.RecordError(err)
 		return
 	}
+	w.WriteHeader(http.StatusOK)
+}
 
-	span.SetAttributes()
+// Register creates user with credentials of body and logs it in
+func (h *handlers) Register(w http.ResponseWriter, r *http.Request) {
+	ctx, span := h.tr.Start(r.Context(), "register")
+	defer span.End()
 
-	http.SetCookie(w, &http.Cookie{
-		Name:    sessionToken,
-		Value:   token,
-		Expires: expireAt,
-	})
+	var creds api.Credentials
+	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+		writeResponse(w, http.StatusBadRequest, "cannot unmarshal body to credentials json: "+err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	tokens, err := h.auth.Register(ctx, creds.Username, creds.Password)
+	if err != nil {
+		code := http.StatusInternalServerError
+		switch status.Code(err) {
+		case codes.InvalidArgument:
+			code = http.StatusBadRequest
+		case codes.AlreadyExists:
+			code = http.StatusConflict
+		}
+		writeResponse(w, code, "register failed: "+status.Convert(err).Message())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	if err = h.cookies.set(w, tokens); err != nil {
+		writeResponse(w, http.StatusInternalServerError, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// OidcLogin starts OpenID Connect login: state, PKCE verifier and nonce are
+// kept in cookie and user is redirected to identity provider
+func (h *handlers) OidcLogin(w http.ResponseWriter, r *http.Request) {
+	_, span := h.tr.Start(r.Context(), "oidcLogin")
+	defer span.End()
+
+	if h.oidc == nil {
+		err := errors.New("SSO login is not configured")
+		writeResponse(w, http.StatusNotFound, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	flow, err := newOIDCFlow()
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	http.SetCookie(w, flow.cookie())
+	http.Redirect(w, r, h.oidc.authURL(flow), http.StatusFound)
+}
+
+// OidcCallback finishes OpenID Connect login: code is exchanged for ID token
+// and auth logs in local user mapped to its subject
+func (h *handlers) OidcCallback(w http.ResponseWriter, r *http.Request, params api.OidcCallbackParams) {
+	ctx, span := h.tr.Start(r.Context(), "oidcCallback")
+	defer span.End()
+
+	if h.oidc == nil {
+		err := errors.New("SSO login is not configured")
+		writeResponse(w, http.StatusNotFound, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	fail := func(err error) {
+		writeResponse(w, http.StatusUnauthorized, "SSO login failed: "+err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+	}
+	if params.Error != nil {
+		fail(fmt.Errorf("identity provider answered %s", *params.Error))
+		return
+	}
+	flow, err := parseOIDCFlow(r)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if params.State == nil || *params.State != flow.state {
+		fail(errors.New("state does not match"))
+		return
+	}
+	if params.Code == nil {
+		fail(errors.New("code expected"))
+		return
+	}
+
+	subject, err := h.oidc.exchange(ctx, *params.Code, flow)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	tokens, err := h.auth.LoginExternal(ctx, h.oidc.cfg.Issuer, subject)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	if err = h.cookies.set(w, tokens); err != nil {
+		fail(err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcFlowCookie, Path: "/oidc", MaxAge: -1})
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// Refresh exchanges refresh cookie for new session and refresh cookies, so
+// short-lived session tokens are renewed without asking for password
+func (h *handlers) Refresh(w http.ResponseWriter, r *http.Request) {
+	ctx, span := h.tr.Start(r.Context(), "refresh")
+	defer span.End()
+
+	// session cookie is gone after idle timeout, so idle sessions are not
+	// renewed
+	if sessionFromContext(ctx) == "" {
+		err := errors.New("session expired")
+		writeResponse(w, http.StatusUnauthorized, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+	token, err := h.cookies.refreshToken(r)
+	if err != nil {
+		writeResponse(w, http.StatusUnauthorized, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	tokens, err := h.auth.Refresh(ctx, token)
+	if err != nil {
+		writeResponse(w, http.StatusUnauthorized, "refresh failed: "+err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	if err = h.cookies.set(w, tokens); err != nil {
+		writeResponse(w, http.StatusInternalServerError, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Logout revokes session token with its refresh token and clears their cookies
+func (h *handlers) Logout(w http.ResponseWriter, r *http.Request) {
+	ctx, span := h.tr.Start(r.Context(), "logout")
+	defer span.End()
+
+	token := sessionFromContext(ctx)
+	if token == "" {
+		err := errors.New("session token expected")
+		writeResponse(w, http.StatusUnauthorized, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	if err := h.auth.Revoke(ctx, token); err != nil {
+		writeResponse(w, http.StatusUnauthorized, "logout failed: "+err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	h.cookies.clear(w)
 	w.WriteHeader(http.StatusOK)
 }
 
+// validator authorizes request and returns context of calls made for it
+type validator func(ctx context.Context, r *http.Request) (context.Context, error)
+
+// session checks token of session cookie and returns its user and role
+func (h *handlers) session(ctx context.Context, r *http.Request) (user, role string, err error) {
+	token := sessionFromContext(ctx)
+	if token == "" {
+		return "", "", fmt.Errorf("session token expected")
+	}
+	user, role, err = h.auth.Validate(ctx, token)
+	if err != nil {
+		return "", "", err
+	}
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String(identity.Attribute, user),
+		attribute.String("role", role),
+	)
+	return user, role, nil
+}
+
+// validateSession checks session cookie and returns context which passes the
+// user to downstream services in baggage, so they scope calls to links of
+// the user
+func (h *handlers) validateSession(ctx context.Context, r *http.Request) (context.Context, error) {
+	user, _, err := h.session(ctx, r)
+	if err != nil {
+		return ctx, err
+	}
+	if user == "" {
+		// auth issued the session before it started to return users
+		return ctx, nil
+	}
+	return identity.NewContext(ctx, user), nil
+}
+
+// validateAdmin checks session cookie of admin and returns context of calls
+// made on behalf of nobody, so downstream services grant access to links of
+// everyone. Sessions of other roles fail with errAdminRequired.
+func (h *handlers) validateAdmin(ctx context.Context, r *http.Request) (context.Context, error) {
+	user, role, err := h.session(ctx, r)
+	if err != nil {
+		return ctx, err
+	}
+	if role != roleAdmin {
+		return ctx, fmt.Errorf("user '%s' is %s: %w", user, role, errAdminRequired)
+	}
+	return identity.NewAdminContext(ctx, user), nil
+}
+
+// sessionStatus is HTTP status of validator error
+func sessionStatus(err error) int {
+	if errors.Is(err, errAdminRequired) {
+		return http.StatusForbidden
+	}
+	return http.StatusUnauthorized
+}
+
+func (h *handlers) handleSpec(w http.ResponseWriter, r *http.Request) {
+	_, span := h.tr.Start(r.Context(), "spec")
+	defer span.End()
+
+	w.Header().Set("Content-Type", "application/yaml")
+	writeResponse(w, http.StatusOK, api.Spec)
+}
+
 func (h *handlers) handleIndex(w http.ResponseWriter, r *http.Request) {
 	_, span := h.tr.Start(r.Context(), "index")
 	defer span.End()
 
+	token := csrf.Token(r)
+	w.Header().Set(csrfHeader, token)
 	w.Header().Set("Content-Type", "text/html")
-	writeResponse(w, http.StatusOK, indexPage)
+	w.WriteHeader(http.StatusOK)
+	if err := indexTemplate.Execute(w, struct{ CSRFToken string }{token}); err != nil {
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+	}
+}
+
+// routeTemplate returns path template of matched route, e.g. "/{hash}"
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return "unknown"
+}
+
+// detach returns context for work which outlives the request: it keeps span,
+// request ID and baggage of ctx but not its cancellation
+func detach(ctx context.Context) context.Context {
+	detached := trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx))
+	detached = baggage.ContextWithBaggage(detached, baggage.FromContext(ctx))
+	return requestid.NewContext(detached, requestid.FromContext(ctx))
 }
 
 func writeResponse(w http.ResponseWriter, statusCode int, body string) {
@@ -120,29 +522,65 @@ func isShortCorrect(link string) bool {
 	return short.FindStringIndex(link) != nil
 }
 
-func isLongCorrect(link string) bool {
-	return long.FindStringIndex(link) != nil
+// put stores url under generated code in namespace. On collision with
+// another url the code is regenerated until a free one is found. Stored link
+// is queued for fetch of destination metadata, as storing drops it.
+func (h *handlers) put(ctx context.Context, namespace string, url []byte, expiresAt time.Time) (hash string, err error) {
+	for attempt := 0; attempt < maxHashAttempts; attempt++ {
+		hash, err = h.codes.Generate(url, attempt)
+		if err != nil {
+			return "", err
+		}
+		key := namespaceKey(namespace, hash)
+		err = h.storage.Put(ctx, string(url), key, expiresAt)
+		if err == nil {
+			h.metadata.Enqueue(ctx, key, string(url))
+		}
+		if !errors.Is(err, errCollision) {
+			return hash, err
+		}
+		trace.SpanFromContext(ctx).AddEvent("hash collision", trace.WithAttributes(
+			attribute.String("hash", hash),
+			attribute.Int("attempt", attempt),
+		))
+	}
+	return "", err
 }
 
-func getHash(s []byte) (string, error) {
-	hasher := fnv.New32a()
-	_, err := hasher.Write(s)
-	if err != nil {
-		return "", err
+// existing returns hash of url shortened before in namespace if its link
+// lives at least until expiresAt, so shortening the same url twice does not
+// write a duplicate. Lookup failure only means a new link is written.
+func (h *handlers) existing(ctx context.Context, namespace, url string, expiresAt time.Time) (hash string, ok bool) {
+	key, existingExpiresAt, err := h.storage.GetByURL(ctx, url)
+	span := trace.SpanFromContext(ctx)
+	switch {
+	case errors.Is(err, errNotFound):
+		return "", false
+	case err != nil:
+		span.AddEvent("lookup by url failed", trace.WithAttributes(
+			attribute.String("reason", err.Error()),
+		))
+		return "", false
+	case !existingExpiresAt.IsZero() && (expiresAt.IsZero() || existingExpiresAt.Before(expiresAt)):
+		// existing link expires earlier than requested
+		return "", false
 	}
-	return hex.EncodeToString(hasher.Sum(nil)), nil
+	// the latest link to url may live in another namespace
+	if hash, ok = namespaceHash(namespace, key); !ok {
+		return "", false
+	}
+	span.AddEvent("url already shortened", trace.WithAttributes(
+		attribute.String("hash", hash),
+	))
+	return hash, true
 }
 
-func (h *handlers) handleShorten(w http.ResponseWriter, r *http.Request) {
+func (h *handlers) Shorten(w http.ResponseWriter, r *http.Request, params api.ShortenParams) {
 	ctx, span := h.tr.Start(r.Context(), "shorten")
 	defer span.End()
 
-	if c, err := r.Cookie(sessionToken); err != nil {
-		writeResponse(w, http.StatusUnauthorized, "session token expected")
-		span.SetAttributes(attribute.Bool("error", true))
-		span.RecordError(err)
-		return
-	} else if err = h.auth.Validate(ctx, c.Value); err != nil {
+	ctx, err := h.validateSession(ctx, r)
+	if err != nil {
 		writeResponse(w, http.StatusUnauthorized, err.Error())
 		span.SetAttributes(attribute.Bool("error", true))
 		span.RecordError(err)
@@ -157,23 +595,48 @@ func (h *handlers) handleShorten(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !isLongCorrect(string(url)) {
-		err = fmt.Errorf(fmt.Sprintf(invalidURLError, url))
+	normalized, err := h.urls.normalize(string(url))
+	if err != nil {
 		writeResponse(w, http.StatusBadRequest, err.Error())
 		span.SetAttributes(attribute.Bool("error", true))
 		span.RecordError(err)
 		return
 	}
+	url = []byte(normalized)
 
-	hash, err := getHash(url)
+	expiresAt, err := parseTTL(ctx, params.Ttl)
 	if err != nil {
-		writeResponse(w, http.StatusInternalServerError, err.Error())
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	if err = h.destinations.Check(string(url)); err != nil {
+		writeResponse(w, http.StatusForbidden, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	if err = h.screening.Check(ctx, string(url)); err != nil {
+		writeResponse(w, http.StatusForbidden, err.Error())
 		span.SetAttributes(attribute.Bool("error", true))
 		span.RecordError(err)
 		return
 	}
 
-	err = h.storage.Put(ctx, string(url), hash)
+	namespace := h.namespace(ctx, r)
+	hash, ok := h.existing(ctx, namespace, string(url), expiresAt)
+	if !ok {
+		hash, err = h.put(ctx, namespace, url, expiresAt)
+	}
+	if errors.Is(err, errQuotaExceeded) || errors.Is(err, errDomainBanned) {
+		writeResponse(w, http.StatusForbidden, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
 	if err != nil {
 		writeResponse(w, http.StatusInternalServerError, err.Error())
 		span.SetAttributes(attribute.Bool("error", true))
@@ -185,20 +648,151 @@ func (h *handlers) handleShorten(w http.ResponseWriter, r *http.Request) {
 	writeResponse(w, http.StatusOK, hash)
 }
 
-func (h *handlers) handleLonger(w http.ResponseWriter, r *http.Request) {
-	ctx, span := h.tr.Start(r.Context(), "longer")
+// parseTTL returns expiration time from optional ttl query parameter,
+// zero time means link never expires
+func parseTTL(ctx context.Context, v *string) (expiresAt time.Time, _ error) {
+	if v == nil || *v == "" {
+		return expiresAt, nil
+	}
+	ttl, err := time.ParseDuration(*v)
+	if err != nil || ttl <= 0 {
+		return expiresAt, fmt.Errorf(invalidTTLError, *v)
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("ttl", ttl.String()))
+	return time.Now().Add(ttl), nil
+}
+
+type shortenResult struct {
+	URL   string `json:"url"`
+	Hash  string `json:"hash,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// batchPut stores urls in namespace in as few round trips as possible. Urls
+// which codes collided with another url are retried with regenerated codes.
+// Stored links are queued for fetch of destination metadata.
+func (h *handlers) batchPut(ctx context.Context, namespace string, results []shortenResult, expiresAt time.Time) error {
+	pending := make([]int, 0, len(results))
+	for i := range results {
+		if results[i].Error == "" {
+			pending = append(pending, i)
+		}
+	}
+	for attempt := 0; attempt < maxHashAttempts && len(pending) > 0; attempt++ {
+		links := make([]Link, 0, len(pending))
+		for _, i := range pending {
+			hash, err := h.codes.Generate([]byte(results[i].URL), attempt)
+			if err != nil {
+				return err
+			}
+			links = append(links, Link{
+				Hash: namespaceKey(namespace, hash),
+				URL:  results[i].URL,
+			})
+		}
+		collisions, err := h.storage.BatchPut(ctx, links, expiresAt)
+		if err != nil {
+			return err
+		}
+		collided := pending[:0]
+		for j, i := range pending {
+			if collisions[j] {
+				collided = append(collided, i)
+			} else {
+				results[i].Hash, _ = namespaceHash(namespace, links[j].Hash)
+				h.metadata.Enqueue(ctx, links[j].Hash, links[j].URL)
+			}
+		}
+		if len(collided) > 0 {
+			trace.SpanFromContext(ctx).AddEvent("hash collisions", trace.WithAttributes(
+				attribute.Int("count", len(collided)),
+				attribute.Int("attempt", attempt),
+			))
+		}
+		pending = collided
+	}
+	for _, i := range pending {
+		results[i].Error = errCollision.Error()
+	}
+	return nil
+}
+
+func (h *handlers) ShortenBatch(w http.ResponseWriter, r *http.Request, params api.ShortenBatchParams) {
+	ctx, span := h.tr.Start(r.Context(), "shorten batch")
 	defer span.End()
 
-	path := strings.Split(r.URL.Path, "/")
-	if !isShortCorrect(path[len(path)-1]) {
-		err := fmt.Errorf(invalidHashError, path[len(path)-1])
+	ctx, err := h.validateSession(ctx, r)
+	if err != nil {
+		writeResponse(w, http.StatusUnauthorized, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, "read body failed: "+err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	var urls []string
+	if err = json.Unmarshal(body, &urls); err != nil {
+		writeResponse(w, http.StatusBadRequest, "cannot unmarshal body to json array of urls: "+err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	span.SetAttributes(attribute.Int("count", len(urls)))
+
+	if len(urls) > maxBatchSize {
+		err = fmt.Errorf("batch size %d exceeds limit %d", len(urls), maxBatchSize)
 		writeResponse(w, http.StatusBadRequest, err.Error())
 		span.SetAttributes(attribute.Bool("error", true))
 		span.RecordError(err)
 		return
 	}
 
-	url, err := h.storage.Get(ctx, path[len(path)-1])
+	expiresAt, err := parseTTL(ctx, params.Ttl)
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	results := make([]shortenResult, len(urls))
+	for i, url := range urls {
+		results[i].URL = url
+		if url, err = h.urls.normalize(url); err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].URL = url
+		if err = h.destinations.Check(url); err != nil {
+			results[i].Error = err.Error()
+		} else if err = h.screening.Check(ctx, url); err != nil {
+			results[i].Error = err.Error()
+		}
+	}
+
+	err = h.batchPut(ctx, h.namespace(ctx, r), results, expiresAt)
+	if errors.Is(err, errQuotaExceeded) || errors.Is(err, errDomainBanned) {
+		writeResponse(w, http.StatusForbidden, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	body, err = json.Marshal(results)
 	if err != nil {
 		writeResponse(w, http.StatusInternalServerError, err.Error())
 		span.SetAttributes(attribute.Bool("error", true))
@@ -206,34 +800,486 @@ func (h *handlers) handleLonger(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	writeResponse(w, http.StatusOK, string(body))
+}
+
+func (h *handlers) Resolve(w http.ResponseWriter, r *http.Request, hash api.HashParam, params api.ResolveParams) {
+	if params.Preview != nil && *params.Preview {
+		h.preview(w, r, hash)
+		return
+	}
+
+	ctx, span := h.tr.Start(r.Context(), "longer")
+	defer span.End()
+
+	if !isShortCorrect(hash) {
+		err := fmt.Errorf(invalidHashError, hash)
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	key := namespaceKey(h.namespace(ctx, r), hash)
+	url, err := h.storage.Get(ctx, key)
+	if errors.Is(err, errExpired) {
+		writeResponse(w, http.StatusGone, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+	if errors.Is(err, errNotFound) {
+		writeResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeResponse(w, lookupStatus(err), err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	h.redirect(ctx, w, r, key, url)
+}
+
+// ResolveHead answers like Resolve without body, so link checkers and
+// messengers learn destination of link
+func (h *handlers) ResolveHead(w http.ResponseWriter, r *http.Request, hash api.HashParam, params api.ResolveHeadParams) {
+	h.Resolve(w, r, hash, api.ResolveParams{Preview: params.Preview})
+}
+
+func (h *handlers) ResolveOptions(w http.ResponseWriter, r *http.Request, hash api.HashParam) {
+	redirectOptions(w, r, "GET, HEAD, OPTIONS, DELETE")
+}
+
+// redirectOptions lists allowed methods of route. Preflight requests of any
+// origin may follow redirects, as redirects are public, while deletion stays
+// same-origin.
+func redirectOptions(w http.ResponseWriter, r *http.Request, allow string) {
+	w.Header().Set("Allow", allow)
+	if r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != "" {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD")
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(preflightMaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// redirect sends client to url of link of key and counts the click. HEAD
+// requests come from link checkers and previews, not people, so they are not
+// counted.
+func (h *handlers) redirect(ctx context.Context, w http.ResponseWriter, r *http.Request, key, url string) {
+	// links stored before their destination turned malicious are caught here
+	if h.screening.Redirects() {
+		if err := h.screening.Check(ctx, url); err != nil {
+			writeResponse(w, http.StatusForbidden, err.Error())
+			span := trace.SpanFromContext(ctx)
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+			return
+		}
+	}
+
+	if r.Method != http.MethodHead {
+		// redirect should not wait for analytics
+		go func(ctx context.Context, click Click) {
+			ctx, cancel := context.WithTimeout(ctx, clickTimeout)
+			defer cancel()
+			_ = h.analytics.Click(ctx, click)
+		}(detach(ctx), Click{
+			Hash:      key,
+			Timestamp: time.Now(),
+			UserAgent: r.UserAgent(),
+			Referrer:  r.Referer(),
+		})
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
 	http.Redirect(w, r, url, http.StatusSeeOther)
 }
 
-func (h *handlers) run(ctx context.Context, port int) {
+func (h *handlers) DeleteLink(w http.ResponseWriter, r *http.Request, hash api.HashParam) {
+	h.deleteLink(w, r, "delete", h.validateSession, hash)
+}
+
+func (h *handlers) deleteLink(w http.ResponseWriter, r *http.Request, name string, validate validator, hash string) {
+	ctx, span := h.tr.Start(r.Context(), name)
+	defer span.End()
+
+	ctx, err := validate(ctx, r)
+	if err != nil {
+		writeResponse(w, sessionStatus(err), err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	if !isShortCorrect(hash) {
+		err := fmt.Errorf(invalidHashError, hash)
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	key := namespaceKey(h.namespace(ctx, r), hash)
+	err = h.checkOwner(ctx, key)
+	if err == nil {
+		err = h.storage.Delete(ctx, key)
+	}
+	if errors.Is(err, errForbidden) {
+		writeResponse(w, http.StatusForbidden, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+type linksPage struct {
+	Links         []Link `json:"links"`
+	NextPageToken string `json:"next_page_token,omitempty"`
+}
+
+// checkOwner fails with errForbidden if link of hash is owned by another
+// user than the one calls are made for, so mutations of links of others are
+// not forwarded. Calls on behalf of nobody (admins) may change any link, and
+// missing links are left to storage.
+func (h *handlers) checkOwner(ctx context.Context, hash string) error {
+	user := identity.Authenticated(ctx)
+	if user == "" {
+		return nil
+	}
+	owner, err := h.storage.Owner(ctx, hash)
+	if errors.Is(err, errNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if owner != user {
+		trace.SpanFromContext(ctx).AddEvent("link of another user", trace.WithAttributes(
+			attribute.String("owner", owner),
+		))
+		return fmt.Errorf("link '%s': %w", hash, errForbidden)
+	}
+	return nil
+}
+
+func (h *handlers) ListLinks(w http.ResponseWriter, r *http.Request, params api.ListLinksParams) {
+	h.listLinks(w, r, "list", h.validateSession, params.PageSize, params.PageToken)
+}
+
+func (h *handlers) listLinks(w http.ResponseWriter, r *http.Request, name string, validate validator, pageSizeParam *int, pageTokenParam *string) {
+	ctx, span := h.tr.Start(r.Context(), name)
+	defer span.End()
+
+	ctx, err := validate(ctx, r)
+	if err != nil {
+		writeResponse(w, sessionStatus(err), err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	var (
+		pageSize  int
+		pageToken string
+	)
+	if pageSizeParam != nil {
+		pageSize = *pageSizeParam
+	}
+	if pageTokenParam != nil {
+		pageToken = *pageTokenParam
+	}
+	namespace := h.namespace(ctx, r)
+	if namespace != "" && pageToken == "" {
+		// keys of namespace follow its prefix
+		pageToken = namespaceKey(namespace, "")
+	}
+
+	links, nextPageToken, err := h.storage.List(ctx, pageSize, pageToken)
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+	links, nextPageToken = namespacePage(namespace, links, nextPageToken)
+	for i := range links {
+		links[i].URL = displayURL(links[i].URL)
+	}
+
+	body, err := json.Marshal(linksPage{
+		Links:         links,
+		NextPageToken: nextPageToken,
+	})
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeResponse(w, http.StatusOK, string(body))
+}
+
+func (h *handlers) LinkStats(w http.ResponseWriter, r *http.Request, hash api.HashParam) {
+	h.linkStats(w, r, "stats", h.validateSession, hash)
+}
+
+func (h *handlers) linkStats(w http.ResponseWriter, r *http.Request, name string, validate validator, hash string) {
+	ctx, span := h.tr.Start(r.Context(), name)
+	defer span.End()
+
+	ctx, err := validate(ctx, r)
+	if err != nil {
+		writeResponse(w, sessionStatus(err), err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	if !isShortCorrect(hash) {
+		err := fmt.Errorf(invalidHashError, hash)
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	stats, err := h.analytics.Stats(ctx, namespaceKey(h.namespace(ctx, r), hash))
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	body, err := json.Marshal(stats)
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeResponse(w, http.StatusOK, string(body))
+}
+
+// StorageStats gives capacity overview of all storages, storage which does not
+// answer is listed with error instead of failing the whole overview
+func (h *handlers) StorageStats(w http.ResponseWriter, r *http.Request) {
+	ctx, span := h.tr.Start(r.Context(), "storage stats")
+	defer span.End()
+
+	ctx, err := h.validateSession(ctx, r)
+	if err != nil {
+		writeResponse(w, http.StatusUnauthorized, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	body, err := json.Marshal(h.storage.Stats(ctx))
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeResponse(w, http.StatusOK, string(body))
+}
+
+// CacheStats shows how every cache does for live demos, cache which does not
+// answer is listed with error
+func (h *handlers) CacheStats(w http.ResponseWriter, r *http.Request) {
+	ctx, span := h.tr.Start(r.Context(), "cache stats")
+	defer span.End()
+
+	ctx, err := h.validateSession(ctx, r)
+	if err != nil {
+		writeResponse(w, http.StatusUnauthorized, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	body, err := json.Marshal(h.storage.CacheStats(ctx))
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeResponse(w, http.StatusOK, string(body))
+}
+
+// LookupLink tells whether url was shortened before, so client can reuse the
+// code instead of making a new one
+func (h *handlers) LookupLink(w http.ResponseWriter, r *http.Request, params api.LookupLinkParams) {
+	ctx, span := h.tr.Start(r.Context(), "lookup")
+	defer span.End()
+
+	ctx, err := h.validateSession(ctx, r)
+	if err != nil {
+		writeResponse(w, http.StatusUnauthorized, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	url, err := h.urls.normalize(params.Url)
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	key, _, err := h.storage.GetByURL(ctx, url)
+	hash, ok := namespaceHash(h.namespace(ctx, r), key)
+	if err == nil && !ok {
+		// the latest link to url lives in another namespace
+		err = fmt.Errorf("link to url '%s': %w", url, errNotFound)
+	}
+	if errors.Is(err, errNotFound) {
+		writeResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeResponse(w, lookupStatus(err), err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	body, err := json.Marshal(Link{
+		Hash: hash,
+		URL:  displayURL(url),
+	})
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeResponse(w, http.StatusOK, string(body))
+}
+
+// lookupStatus is HTTP status of failed link lookup: 400 for hash or url
+// rejected by storage, 503 for unavailable storage and 500 otherwise
+func lookupStatus(err error) int {
+	switch {
+	case errors.Is(err, errInvalidRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, errUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (h *handlers) run(ctx context.Context, port int, https HTTPSConfig) {
 	ctx, span := h.tr.Start(ctx, "run")
 	defer span.End()
 
 	server := &http.Server{
 		Addr:    ":" + strconv.Itoa(port),
-		Handler: h.router,
+		Handler: requestid.Middleware(h.router),
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	defer signal.Stop(signals)
+
+	// both HTTPS server and redirect server stop service on failure. Signals
+	// channel is never closed, signal arriving after shutdown would panic.
+	done := make(chan struct{})
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			close(done)
+		})
 	}
 
-	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, os.Interrupt)
+	if https.enabled() {
+		tlsConfig, redirect, err := https.tlsConfig(port)
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+			slog.ErrorCtx(ctx, "load TLS config failed", slog.Any("error", err))
+			return
+		}
+		server.TLSConfig = tlsConfig
+
+		if https.RedirectPort != 0 {
+			redirectServer := &http.Server{
+				Addr:    ":" + strconv.Itoa(https.RedirectPort),
+				Handler: redirect,
+			}
+			defer redirectServer.Close()
+
+			go func() {
+				if err := redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					span.SetAttributes(attribute.Bool("error", true))
+					span.RecordError(err)
+					slog.ErrorCtx(ctx, "serve redirect failed", slog.Any("error", err))
+					stop()
+				}
+			}()
+
+			slog.InfoCtx(ctx, "redirect HTTP to HTTPS", slog.Int("port", https.RedirectPort))
+		}
+	}
 
 	go func() {
-		if err := server.ListenAndServe(); err != nil {
-			close(ch)
+		var err error
+		if server.TLSConfig != nil {
+			// certificates are already in TLSConfig
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
 		}
+		if err != nil && err != http.ErrServerClosed {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+			slog.ErrorCtx(ctx, "serve failed", slog.Any("error", err))
+		}
+		stop()
 	}()
 
-	fmt.Printf("Start URL shortener on port %d...\n", port)
+	slog.InfoCtx(ctx, "start URL shortener",
+		slog.Int("port", port),
+		slog.Bool("https", server.TLSConfig != nil),
+	)
 
-	for s := range ch {
-		fmt.Println("shutdown...")
-		span.AddEvent("received signal", trace.WithAttributes(
-			attribute.String("signal", s.String()),
-		))
-		_ = server.Shutdown(ctx)
+	for {
+		select {
+		case s := <-signals:
+			slog.InfoCtx(ctx, "shutdown", slog.String("signal", s.String()))
+			span.AddEvent("received signal", trace.WithAttributes(
+				attribute.String("signal", s.String()),
+			))
+			_ = server.Shutdown(ctx)
+		case <-done:
+			return
+		}
 	}
 }
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/exp/slog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
+)
+
+const (
+	readyTimeout        = 2 * time.Second
+	healthRetryInterval = time.Second
+)
+
+// checkHealth asks standard gRPC health service about service status
+func checkHealth(ctx context.Context, conn *grpc.ClientConn, service string) error {
+	response, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{
+		Service: service,
+	})
+	if err != nil {
+		return err
+	}
+	if response.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("service '%s' is %s", service, response.GetStatus())
+	}
+	return nil
+}
+
+// watchHealth follows health status of storage until ctx is done. Storage is
+// considered serving until the first status arrives and not serving while
+// health stream is broken.
+func (a *storage) watchHealth(ctx context.Context) {
+	client := healthpb.NewHealthClient(a.conn)
+	for {
+		err := a.watchHealthStream(ctx, client)
+		if ctx.Err() != nil {
+			return
+		}
+		if status.Code(err) == codes.Unimplemented {
+			// storage without health service can only be judged by requests
+			a.setServing(true)
+			return
+		}
+		a.setServing(false)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(healthRetryInterval):
+		}
+	}
+}
+
+func (a *storage) watchHealthStream(ctx context.Context, client healthpb.HealthClient) error {
+	stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{
+		Service: pb.Storage_ServiceDesc.ServiceName,
+	})
+	if err != nil {
+		return err
+	}
+	for {
+		response, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		a.setServing(response.GetStatus() == healthpb.HealthCheckResponse_SERVING)
+	}
+}
+
+func (a *storage) setServing(serving bool) {
+	var v uint32
+	if serving {
+		v = 1
+	}
+	if atomic.SwapUint32(&a.serving, v) != v {
+		slog.Info("storage health changed", slog.String("address", a.addr), slog.Bool("serving", serving))
+	}
+}
+
+func (a *storage) isServing() bool {
+	return atomic.LoadUint32(&a.serving) == 1
+}
+
+// handleHealthz reports that process is alive. It does not touch dependencies,
+// so their outage does not make orchestrator restart the frontend.
+func (h *handlers) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, http.StatusOK, "ok")
+}
+
+// handleReadyz reports whether auth and storage services can serve requests
+func (h *handlers) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	if err := h.ready(ctx); err != nil {
+		writeResponse(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	writeResponse(w, http.StatusOK, "ok")
+}
+
+func (h *handlers) ready(ctx context.Context) error {
+	if err := h.auth.Check(ctx); err != nil {
+		return fmt.Errorf("auth is not ready: %w", err)
+	}
+	if err := h.storage.Check(ctx); err != nil {
+		return fmt.Errorf("storage is not ready: %w", err)
+	}
+	if h.sentinel == "" {
+		return nil
+	}
+	// sentinel link proves that storage not only answers but also reads data
+	if _, err := h.storage.Get(ctx, h.sentinel); err != nil && !errors.Is(err, errExpired) {
+		return fmt.Errorf("sentinel '%s' lookup failed: %w", h.sentinel, err)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func (c HTTPSConfig) enabled() bool {
+	return c.Cert != "" || len(c.AutocertDomains) > 0
+}
+
+// tlsConfig returns TLS settings for the server on port and the handler for
+// plain HTTP requests. In autocert mode the handler also answers ACME http-01
+// challenges, so redirect port must be 80 for them.
+func (c HTTPSConfig) tlsConfig(port int) (*tls.Config, http.Handler, error) {
+	if len(c.AutocertDomains) > 0 {
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(c.AutocertDomains...),
+			Cache:      autocert.DirCache(c.AutocertCache),
+		}
+		return m.TLSConfig(), m.HTTPHandler(redirectToHTTPS(port)), nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, redirectToHTTPS(port), nil
+}
+
+// redirectToHTTPS redirects request to the same host and path on HTTPS port.
+// Permanent redirect keeps method and body, so form posts are not lost.
+func redirectToHTTPS(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+		u := *r.URL
+		u.Scheme = "https"
+		u.Host = host
+		http.Redirect(w, r, u.String(), http.StatusPermanentRedirect)
+	})
+}
@@ -2,57 +2,37 @@ package main
 
 import (
 	"context"
-	"log"
-	"time"
+	"errors"
+	"os"
 
-	jaegerPropogator "go.opentelemetry.io/contrib/propagators/jaeger"
-	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/exporters/jaeger"
-	"go.opentelemetry.io/otel/sdk/resource"
-	tracesdk "go.opentelemetry.io/otel/sdk/trace"
-	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"golang.org/x/exp/slog"
+
+	"github.com/asmyasnikov/webinar-jaeger/internal/logging"
+	"github.com/asmyasnikov/webinar-jaeger/internal/telemetry"
 )
 
 const applicationID = "http"
 
-func tracerProvider(url string) (*tracesdk.TracerProvider, error) {
-	exp, err := jaeger.New(jaeger.WithCollectorEndpoint(jaeger.WithEndpoint(url)))
+func main() {
+	logging.Init(applicationID)
+
+	cfg, err := loadConfig()
 	if err != nil {
-		return nil, err
+		panic(err)
 	}
 
-	otel.SetTextMapPropagator(jaegerPropogator.Jaeger{})
-
-	tp := tracesdk.NewTracerProvider(
-		// Always be sure to batch in production.
-		tracesdk.WithBatcher(exp),
-		// Record information about this application in a Resource.
-		tracesdk.WithResource(resource.NewWithAttributes(
-			semconv.SchemaURL,
-			semconv.ServiceNameKey.String(applicationID),
-		)),
-	)
-
-	otel.GetTracerProvider()
-
-	return tp, nil
-}
-
-func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	tp, err := tracerProvider("http://localhost:14268/api/traces")
+	tp, err := telemetry.Init(ctx, applicationID, cfg.Telemetry)
 	if err != nil {
 		panic(err)
 	}
 	defer func(ctx context.Context) {
-		// Do not make the application hang when it is shutdown.
-		ctx, cancel = context.WithTimeout(ctx, time.Second*5)
-		defer cancel()
-		if err := tp.Shutdown(ctx); err != nil {
-			log.Fatal(err)
+		if err := telemetry.Shutdown(ctx, tp); err != nil {
+			slog.ErrorCtx(ctx, "shutdown tracer provider failed", slog.Any("error", err))
+			os.Exit(1)
 		}
 	}(ctx)
 
@@ -61,7 +41,14 @@ func main() {
 	ctx, span := tr.Start(ctx, "main")
 	defer span.End()
 
-	a, err := newAuth(ctx, tr, "127.0.0.1:50051")
+	creds, err := cfg.TLS.ClientCredentials()
+	if err != nil {
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		panic(err)
+	}
+
+	a, err := newAuth(ctx, tr, creds, cfg.Keepalive, cfg.ServiceKey, cfg.AuthAddr, cfg.Retry, cfg.JWTKey, cfg.RevokedPoll)
 	if err != nil {
 		span.SetAttributes(attribute.Bool("error", true))
 		span.RecordError(err)
@@ -71,10 +58,7 @@ func main() {
 
 	span.AddEvent("auth client initialized")
 
-	s, err := initStorages(ctx, tr,
-		"localhost:5302", // cache
-		"localhost:5300", // database
-	)
+	s, err := initStorages(ctx, tr, creds, cfg.Keepalive, cfg.ServiceKey, cfg.Storages, cfg.Retry)
 	if err != nil {
 		span.SetAttributes(attribute.Bool("error", true))
 		span.RecordError(err)
@@ -82,12 +66,106 @@ func main() {
 	}
 	defer s.Close()
 
-	h, err := newHandlers(ctx, tr, a, s)
+	var topic *clickTopic
+	if cfg.Clicks.Topic != "" {
+		topic, err = newClickTopic(ctx, tr, cfg.Clicks.YdbDSN, cfg.Clicks.Topic)
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+			panic(err)
+		}
+		span.AddEvent("click topic writer started")
+	}
+
+	an, err := newAnalytics(ctx, tr, creds, cfg.Keepalive, cfg.ServiceKey, cfg.AnalyticsAddr, topic)
+	if err != nil {
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		panic(err)
+	}
+	defer an.Close()
+
+	codes, err := newCodeGenerator(cfg.CodeGenerator, cfg.CodeLength, newReservedWords(cfg.ReservedCodes))
+	if err != nil {
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		panic(err)
+	}
+
+	var oidc *oidcProvider
+	if cfg.OIDC.Issuer != "" {
+		// auth LoginExternal trusts identities vouched by the service key only
+		if cfg.ServiceKey == "" {
+			err = errors.New("OIDC_ISSUER requires SERVICE_KEY")
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+			panic(err)
+		}
+		oidc, err = newOIDC(ctx, tr, cfg.OIDC)
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+			panic(err)
+		}
+		span.AddEvent("OIDC provider discovered")
+	}
+
+	cookies, err := newSessionCookies(cfg.Session, cfg.HTTPS.enabled())
+	if err != nil {
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		panic(err)
+	}
+
+	screening, err := newURLScreening(cfg.Screen)
+	if err != nil {
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		panic(err)
+	}
+
+	dests, err := newDestinations(cfg.Destinations)
+	if err != nil {
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		panic(err)
+	}
+
+	metadata := newMetadataFetcher(ctx, tr, s, cfg.Metadata)
+
+	ns, err := newNamespaces(cfg.Domains)
+	if err != nil {
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		panic(err)
+	}
+
+	h, err := newHandlers(ctx, handlersDeps{
+		Tracer:       tr,
+		Auth:         a,
+		Storage:      s,
+		Analytics:    an,
+		Codes:        codes,
+		Cookies:      cookies,
+		Protection:   newCSRFProtection(cfg.CSRFKey, cookies),
+		Namespaces:   ns,
+		OIDC:         oidc,
+		Screening:    screening,
+		Destinations: dests,
+		Metadata:     metadata,
+	}, handlersConfig{
+		MaxURLLength:  cfg.MaxURLLength,
+		ReadySentinel: cfg.ReadySentinel,
+		TraceIDHeader: cfg.TraceIDHeader,
+		RateLimit:     cfg.RateLimit,
+		Compression:   cfg.Compression,
+		AccessLog:     cfg.AccessLog,
+	})
 	if err != nil {
 		span.SetAttributes(attribute.Bool("error", true))
 		span.RecordError(err)
 		panic(err)
 	}
 
-	h.run(ctx, 8080)
+	h.run(ctx, cfg.Port, cfg.HTTPS)
 }
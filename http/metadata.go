@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slog"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	// maxTitleLength is length of stored title in runes, longer titles are cut
+	maxTitleLength = 200
+	// maxFaviconURLLength is length of stored favicon url in bytes, longer
+	// urls are not stored
+	maxFaviconURLLength = 2048
+	// maxMetadataRedirects is number of redirects followed by fetch of
+	// destination page
+	maxMetadataRedirects = 5
+)
+
+// errPrivateAddress means destination resolves to address the service must
+// not connect to on behalf of users
+var errPrivateAddress = errors.New("address is not public")
+
+// privatePrefixes are ranges of special-purpose addresses (RFC 6890) the
+// fetcher never connects to: they reach the network of the service, its
+// host or nothing public. IPv6 ranges embedding IPv4 addresses are denied as
+// a whole, as they may translate to any of the IPv4 ranges.
+var privatePrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // this network
+	netip.MustParsePrefix("10.0.0.0/8"),      // private
+	netip.MustParsePrefix("100.64.0.0/10"),   // carrier-grade NAT
+	netip.MustParsePrefix("127.0.0.0/8"),     // loopback
+	netip.MustParsePrefix("169.254.0.0/16"),  // link-local, cloud metadata
+	netip.MustParsePrefix("172.16.0.0/12"),   // private
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // documentation
+	netip.MustParsePrefix("192.88.99.0/24"),  // 6to4 relay anycast
+	netip.MustParsePrefix("192.168.0.0/16"),  // private
+	netip.MustParsePrefix("198.18.0.0/15"),   // benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // documentation
+	netip.MustParsePrefix("203.0.113.0/24"),  // documentation
+	netip.MustParsePrefix("224.0.0.0/4"),     // multicast
+	netip.MustParsePrefix("240.0.0.0/4"),     // reserved and broadcast
+	netip.MustParsePrefix("::/128"),          // unspecified
+	netip.MustParsePrefix("::1/128"),         // loopback
+	netip.MustParsePrefix("::ffff:0:0/96"),   // IPv4-mapped, unmapped before check
+	netip.MustParsePrefix("64:ff9b::/96"),    // NAT64
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // local-use NAT64
+	netip.MustParsePrefix("100::/64"),        // discard
+	netip.MustParsePrefix("2001::/32"),       // Teredo
+	netip.MustParsePrefix("2001:db8::/32"),   // documentation
+	netip.MustParsePrefix("2002::/16"),       // 6to4
+	netip.MustParsePrefix("fc00::/7"),        // unique local
+	netip.MustParsePrefix("fe80::/10"),       // link-local
+	netip.MustParsePrefix("ff00::/8"),        // multicast
+}
+
+// MetadataConfig enables fetching of titles and favicons of destinations
+type MetadataConfig struct {
+	Workers      int           `yaml:"workers" usage:"number of workers fetching title and favicon of destination after link is stored, 0 disables fetching"`
+	Queue        int           `yaml:"queue" usage:"links waiting for fetch, links stored while queue is full are left without title and favicon"`
+	Timeout      time.Duration `yaml:"timeout" usage:"deadline of fetching one destination page with its redirects"`
+	MaxBytes     int64         `yaml:"max_bytes" usage:"bytes of destination page read to find title and favicon"`
+	AllowPrivate bool          `yaml:"allow_private" usage:"fetch destinations on loopback, private and link-local addresses, e.g. in intranet deployments"`
+}
+
+var fetchedMetadata = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "http_metadata_fetches_total",
+	Help: "Total number of destinations queued for metadata fetch by result: fetched, failed or dropped.",
+}, []string{"result"})
+
+type metadataJob struct {
+	ctx  context.Context
+	hash string
+	url  string
+}
+
+// metadataFetcher fetches destination pages of stored links in background and
+// stores their titles and favicons. Pages are fetched only from public
+// addresses, so links cannot make the service probe its own network.
+type metadataFetcher struct {
+	tr       trace.Tracer
+	storage  Storage
+	client   *http.Client
+	timeout  time.Duration
+	maxBytes int64
+	queue    chan metadataJob
+}
+
+// newMetadataFetcher returns nil if no workers are configured. Workers stop
+// when ctx is done.
+func newMetadataFetcher(ctx context.Context, tr trace.Tracer, s Storage, cfg MetadataConfig) *metadataFetcher {
+	if cfg.Workers <= 0 {
+		return nil
+	}
+	dialer := &net.Dialer{
+		Timeout: cfg.Timeout,
+	}
+	if !cfg.AllowPrivate {
+		// address is checked after resolution, so host names resolving to
+		// private addresses are refused too
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			return checkPublicAddress(address)
+		}
+	}
+	f := &metadataFetcher{
+		tr:      tr,
+		storage: s,
+		client: &http.Client{
+			Timeout: cfg.Timeout,
+			// transport without proxy, proxy would connect on behalf of fetcher
+			// to addresses dialer never sees
+			Transport: &http.Transport{
+				DialContext:           dialer.DialContext,
+				TLSHandshakeTimeout:   cfg.Timeout,
+				ResponseHeaderTimeout: cfg.Timeout,
+				MaxIdleConns:          cfg.Workers,
+				IdleConnTimeout:       time.Minute,
+			},
+			CheckRedirect: func(r *http.Request, via []*http.Request) error {
+				if len(via) >= maxMetadataRedirects {
+					return fmt.Errorf("stopped after %d redirects", maxMetadataRedirects)
+				}
+				if r.URL.Scheme != "http" && r.URL.Scheme != "https" {
+					return fmt.Errorf("redirect to scheme '%s'", r.URL.Scheme)
+				}
+				return nil
+			},
+		},
+		timeout:  cfg.Timeout,
+		maxBytes: cfg.MaxBytes,
+		queue:    make(chan metadataJob, cfg.Queue),
+	}
+	for i := 0; i < cfg.Workers; i++ {
+		go f.work(ctx)
+	}
+	return f
+}
+
+// checkPublicAddress fails with errPrivateAddress for addresses of
+// privatePrefixes. IPv4-mapped IPv6 addresses are checked as IPv4 ones.
+func checkPublicAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return fmt.Errorf("%w: '%s'", errPrivateAddress, host)
+	}
+	ip = ip.WithZone("").Unmap()
+	for _, prefix := range privatePrefixes {
+		if prefix.Contains(ip) {
+			return fmt.Errorf("%w: '%s' is in %s", errPrivateAddress, host, prefix)
+		}
+	}
+	return nil
+}
+
+// Enqueue queues link for fetch without waiting: link stored while queue is
+// full is left without metadata. Nil fetcher drops every link.
+func (f *metadataFetcher) Enqueue(ctx context.Context, hash, url string) {
+	if f == nil {
+		return
+	}
+	select {
+	case f.queue <- metadataJob{ctx: detach(ctx), hash: hash, url: url}:
+	default:
+		fetchedMetadata.WithLabelValues("dropped").Inc()
+		trace.SpanFromContext(ctx).AddEvent("metadata fetch dropped", trace.WithAttributes(
+			attribute.String("hash", hash),
+		))
+	}
+}
+
+func (f *metadataFetcher) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-f.queue:
+			if err := f.process(job); err != nil {
+				fetchedMetadata.WithLabelValues("failed").Inc()
+				slog.WarnCtx(job.ctx, "fetch metadata failed", slog.String("hash", job.hash), slog.Any("error", err))
+			} else {
+				fetchedMetadata.WithLabelValues("fetched").Inc()
+			}
+		}
+	}
+}
+
+// process fetches metadata of destination of job and stores it
+func (f *metadataFetcher) process(job metadataJob) (err error) {
+	ctx, span := f.tr.Start(job.ctx, "fetch metadata", trace.WithAttributes(
+		attribute.String("hash", job.hash),
+		attribute.String("url", job.url),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	m, err := f.fetch(ctx, job.url)
+	if err != nil {
+		return err
+	}
+	span.AddEvent("metadata fetched", trace.WithAttributes(
+		attribute.String("title", m.Title),
+		attribute.String("favicon", m.Favicon),
+	))
+	return f.storage.SetMetadata(ctx, job.hash, job.url, m)
+}
+
+// fetch reads head of destination page for its title and favicon. Page
+// without favicon link gets /favicon.ico of its host.
+func (f *metadataFetcher) fetch(ctx context.Context, rawURL string) (m LinkMetadata, err error) {
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return m, err
+	}
+	request.Header.Set("Accept", "text/html")
+	response, err := f.client.Do(request)
+	if err != nil {
+		return m, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return m, fmt.Errorf("destination answered %s", response.Status)
+	}
+	contentType := response.Header.Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil ||
+		mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return m, fmt.Errorf("destination is not html page but '%s'", contentType)
+	}
+	body, err := charset.NewReader(io.LimitReader(response.Body, f.maxBytes), contentType)
+	if err != nil {
+		return m, err
+	}
+
+	// url after redirects is the base of relative favicon links
+	page := response.Request.URL
+	title, icon := parseHead(body)
+	m.Title = cutTitle(title)
+	favicon, err := page.Parse(icon)
+	if icon == "" || err != nil || favicon.Scheme != "http" && favicon.Scheme != "https" {
+		favicon = &url.URL{Scheme: page.Scheme, Host: page.Host, Path: "/favicon.ico"}
+	}
+	if s := favicon.String(); len(s) <= maxFaviconURLLength {
+		m.Favicon = s
+	}
+	return m, nil
+}
+
+// parseHead returns text of the first title element and href of the first
+// link to icon, it stops reading at body of page
+func parseHead(r io.Reader) (title, icon string) {
+	z := html.NewTokenizer(r)
+	inTitle, titleDone := false, false
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return title, icon
+		case html.TextToken:
+			if inTitle {
+				title += string(z.Text())
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "title":
+				if inTitle {
+					inTitle, titleDone = false, true
+				}
+			case "head":
+				return title, icon
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch string(name) {
+			case "title":
+				inTitle = !titleDone
+			case "link":
+				if icon != "" || !hasAttr {
+					continue
+				}
+				var rel, href string
+				for more := true; more; {
+					var key, val []byte
+					key, val, more = z.TagAttr()
+					switch string(key) {
+					case "rel":
+						rel = string(val)
+					case "href":
+						href = string(val)
+					}
+				}
+				for _, kind := range strings.Fields(strings.ToLower(rel)) {
+					if kind == "icon" {
+						icon = strings.TrimSpace(href)
+						break
+					}
+				}
+			case "body":
+				return title, icon
+			}
+		}
+	}
+}
+
+// cutTitle collapses whitespace of title and cuts it to maxTitleLength runes
+func cutTitle(title string) string {
+	title = strings.Join(strings.Fields(title), " ")
+	if utf8.RuneCountInString(title) <= maxTitleLength {
+		return title
+	}
+	runes := []rune(title)
+	return string(runes[:maxTitleLength]) + "..."
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCheckPublicAddress(t *testing.T) {
+	for _, tt := range []struct {
+		address string
+		public  bool
+	}{
+		{address: "93.184.216.34:443", public: true},
+		{address: "8.8.8.8:80", public: true},
+		{address: "[2606:2800:220:1:248:1893:25c8:1946]:443", public: true},
+		{address: "[::ffff:93.184.216.34]:443", public: true},
+		{address: "0.0.0.0:80"},
+		{address: "0.1.2.3:80"},
+		{address: "10.0.0.1:80"},
+		{address: "100.64.0.1:80"},
+		{address: "100.127.255.254:80"},
+		{address: "127.0.0.1:80"},
+		{address: "169.254.169.254:80"},
+		{address: "172.16.0.1:80"},
+		{address: "172.31.255.255:80"},
+		{address: "192.0.0.8:80"},
+		{address: "192.168.1.1:80"},
+		{address: "198.18.0.1:80"},
+		{address: "198.19.255.255:80"},
+		{address: "224.0.0.1:80"},
+		{address: "240.0.0.1:80"},
+		{address: "255.255.255.255:80"},
+		{address: "[::]:80"},
+		{address: "[::1]:80"},
+		{address: "[::ffff:127.0.0.1]:80"},
+		{address: "[::ffff:10.0.0.1]:80"},
+		{address: "[64:ff9b::7f00:1]:80"},
+		{address: "[64:ff9b::a00:1]:80"},
+		{address: "[fc00::1]:80"},
+		{address: "[fd12:3456::1]:80"},
+		{address: "[fe80::1]:80"},
+		{address: "[fe80::1%eth0]:80"},
+		{address: "[ff02::1]:80"},
+		{address: "[2002:7f00:1::1]:80"},
+		{address: "localhost:80"},
+	} {
+		t.Run(tt.address, func(t *testing.T) {
+			err := checkPublicAddress(tt.address)
+			if tt.public && err != nil {
+				t.Errorf("checkPublicAddress(%q) = %v, want nil", tt.address, err)
+			}
+			if !tt.public && !errors.Is(err, errPrivateAddress) {
+				t.Errorf("checkPublicAddress(%q) = %v, want %v", tt.address, err, errPrivateAddress)
+			}
+		})
+	}
+}
+
+func TestCheckPublicAddressWithoutPort(t *testing.T) {
+	err := checkPublicAddress("93.184.216.34")
+	if err == nil || errors.Is(err, errPrivateAddress) {
+		t.Errorf("checkPublicAddress without port = %v, want split error", err)
+	}
+}
+
+func TestParseHead(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		page  string
+		title string
+		icon  string
+	}{
+		{
+			name: "empty page",
+		},
+		{
+			name:  "title and icon",
+			page:  `<html><head><title>Example</title><link rel="icon" href="/favicon.png"></head></html>`,
+			title: "Example",
+			icon:  "/favicon.png",
+		},
+		{
+			name:  "shortcut icon",
+			page:  `<head><link rel="Shortcut Icon" href=" /favicon.ico "><title>Example</title></head>`,
+			title: "Example",
+			icon:  "/favicon.ico",
+		},
+		{
+			name:  "first icon wins",
+			page:  `<head><link rel="stylesheet" href="/a.css"><link rel="icon" href="/a.png"><link rel="icon" href="/b.png"></head>`,
+			title: "",
+			icon:  "/a.png",
+		},
+		{
+			name:  "first title wins",
+			page:  `<head><title>First</title><title>Second</title></head>`,
+			title: "First",
+		},
+		{
+			name: "self-closing link",
+			page: `<head><link rel="icon" href="/favicon.svg"/></head>`,
+			icon: "/favicon.svg",
+		},
+		{
+			name:  "entities in title",
+			page:  `<head><title>Tom &amp; Jerry</title></head>`,
+			title: "Tom & Jerry",
+		},
+		{
+			name:  "stops at body",
+			page:  `<html><body><title>Body</title><link rel="icon" href="/body.png"></body></html>`,
+			title: "",
+		},
+		{
+			name:  "stops at end of head",
+			page:  `<head><title>Head</title></head><link rel="icon" href="/late.png">`,
+			title: "Head",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			title, icon := parseHead(strings.NewReader(tt.page))
+			if title != tt.title || icon != tt.icon {
+				t.Errorf("parseHead = %q, %q, want %q, %q", title, icon, tt.title, tt.icon)
+			}
+		})
+	}
+}
+
+func TestCutTitle(t *testing.T) {
+	long := strings.Repeat("я", maxTitleLength)
+	for _, tt := range []struct {
+		name  string
+		title string
+		want  string
+	}{
+		{name: "empty", title: "", want: ""},
+		{name: "short", title: "Example", want: "Example"},
+		{name: "whitespace", title: "\n  Example \t page\n", want: "Example page"},
+		{name: "max length", title: long, want: long},
+		{name: "too long", title: long + "я", want: long + "..."},
+		{name: "too long after collapse", title: strings.Repeat("a ", maxTitleLength), want: strings.Repeat("a ", maxTitleLength/2)[:maxTitleLength] + "..."},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cutTitle(tt.title); got != tt.want {
+				t.Errorf("cutTitle(%q) = %q, want %q", tt.title, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// namespaceSeparator separates namespace from code in storage keys. Codes are
+// alphanumeric, so keys of namespaces never collide with global ones.
+const namespaceSeparator = "/"
+
+var namespaceName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// namespaces maps host names to namespaces of links, so teams run branded
+// short domains off the same storages. Links shortened and resolved on
+// a mapped host are stored under namespace/code keys, other hosts use global
+// namespace.
+type namespaces map[string]string
+
+// newNamespaces parses host=namespace pairs, several hosts may share
+// a namespace
+func newNamespaces(domains []string) (namespaces, error) {
+	n := make(namespaces, len(domains))
+	for _, domain := range domains {
+		domain = strings.TrimSpace(domain)
+		if domain == "" {
+			continue
+		}
+		host, namespace, ok := strings.Cut(domain, "=")
+		host = strings.TrimSuffix(strings.ToLower(asciiDomain(strings.TrimSpace(host))), ".")
+		namespace = strings.TrimSpace(namespace)
+		if !ok || host == "" {
+			return nil, fmt.Errorf("domain '%s' is not host=namespace", domain)
+		}
+		if !namespaceName.MatchString(namespace) {
+			return nil, fmt.Errorf("namespace '%s' of domain '%s' must be up to 32 lowercase letters, digits and hyphens", namespace, host)
+		}
+		if _, dup := n[host]; dup {
+			return nil, fmt.Errorf("domain '%s' is mapped twice", host)
+		}
+		n[host] = namespace
+	}
+	return n, nil
+}
+
+// of returns namespace of host request is sent to, empty for global namespace
+func (n namespaces) of(r *http.Request) string {
+	if len(n) == 0 {
+		return ""
+	}
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return n[strings.TrimSuffix(strings.ToLower(host), ".")]
+}
+
+// namespace returns namespace of request and records it in span of ctx
+func (h *handlers) namespace(ctx context.Context, r *http.Request) string {
+	namespace := h.namespaces.of(r)
+	if namespace != "" {
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("namespace", namespace))
+	}
+	return namespace
+}
+
+// namespaceKey returns storage key of code in namespace
+func namespaceKey(namespace, hash string) string {
+	if namespace == "" {
+		return hash
+	}
+	return namespace + namespaceSeparator + hash
+}
+
+// namespaceHash returns code of storage key, ok is false if key belongs to
+// another namespace
+func namespaceHash(namespace, key string) (hash string, ok bool) {
+	if namespace == "" {
+		return key, !strings.Contains(key, namespaceSeparator)
+	}
+	prefix := namespace + namespaceSeparator
+	if !strings.HasPrefix(key, prefix) {
+		return "", false
+	}
+	return key[len(prefix):], true
+}
+
+// namespacePage keeps links of namespace in page listed by storage and strips
+// namespace from their codes, so page may hold fewer links than asked while
+// next page token is not empty. Listing of namespace starts at its first key
+// and ends at the first key past it.
+func namespacePage(namespace string, links []Link, nextPageToken string) ([]Link, string) {
+	kept := links[:0]
+	for _, l := range links {
+		hash, ok := namespaceHash(namespace, l.Hash)
+		if !ok {
+			if namespace != "" && l.Hash > namespace+namespaceSeparator {
+				return kept, ""
+			}
+			continue
+		}
+		l.Hash = hash
+		kept = append(kept, l)
+	}
+	return kept, nextPageToken
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNamespacePage(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		namespace string
+		links     []Link
+		next      string
+		want      []Link
+		wantNext  string
+	}{
+		{
+			name: "empty page",
+			want: []Link{},
+		},
+		{
+			name: "global namespace skips keys of namespaces",
+			links: []Link{
+				{Hash: "abc", URL: "https://a"},
+				{Hash: "acme/abc", URL: "https://b"},
+				{Hash: "xyz", URL: "https://c"},
+			},
+			next: "xyz",
+			want: []Link{
+				{Hash: "abc", URL: "https://a"},
+				{Hash: "xyz", URL: "https://c"},
+			},
+			wantNext: "xyz",
+		},
+		{
+			name:      "namespace strips its prefix",
+			namespace: "acme",
+			links: []Link{
+				{Hash: "acme/abc", URL: "https://a", Title: "A"},
+				{Hash: "acme/xyz", URL: "https://b"},
+			},
+			next: "acme/xyz",
+			want: []Link{
+				{Hash: "abc", URL: "https://a", Title: "A"},
+				{Hash: "xyz", URL: "https://b"},
+			},
+			wantNext: "acme/xyz",
+		},
+		{
+			name:      "namespace skips keys before it",
+			namespace: "acme",
+			links: []Link{
+				{Hash: "abc", URL: "https://a"},
+				{Hash: "ac/abc", URL: "https://b"},
+				{Hash: "acme/abc", URL: "https://c"},
+			},
+			next: "acme/abc",
+			want: []Link{
+				{Hash: "abc", URL: "https://c"},
+			},
+			wantNext: "acme/abc",
+		},
+		{
+			name:      "listing ends at first key past namespace",
+			namespace: "acme",
+			links: []Link{
+				{Hash: "acme/abc", URL: "https://a"},
+				{Hash: "acmf", URL: "https://b"},
+				{Hash: "acmf/abc", URL: "https://c"},
+			},
+			next: "acmf/abc",
+			want: []Link{
+				{Hash: "abc", URL: "https://a"},
+			},
+			wantNext: "",
+		},
+		{
+			name:      "page past namespace",
+			namespace: "acme",
+			links: []Link{
+				{Hash: "zzz", URL: "https://a"},
+			},
+			next:     "zzz",
+			want:     []Link{},
+			wantNext: "",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, next := namespacePage(tt.namespace, tt.links, tt.next)
+			if len(got) == 0 && len(tt.want) == 0 {
+				got = []Link{}
+			}
+			if !reflect.DeepEqual(got, tt.want) || next != tt.wantNext {
+				t.Errorf("namespacePage = %v, %q, want %v, %q", got, next, tt.want, tt.wantNext)
+			}
+		})
+	}
+}
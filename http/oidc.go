@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// oidcFlowCookie keeps state, PKCE verifier and nonce of login in progress
+	oidcFlowCookie = "oidc_flow"
+	oidcFlowTTL    = 10 * time.Minute
+	oidcTimeout    = 10 * time.Second
+)
+
+// OIDCConfig enables login with OpenID Connect identity provider alongside
+// password login
+type OIDCConfig struct {
+	Issuer       string   `yaml:"issuer" usage:"OpenID Connect issuer URL, empty disables SSO login"`
+	ClientID     string   `yaml:"client_id" usage:"OAuth2 client ID registered at identity provider"`
+	ClientSecret string   `yaml:"client_secret" usage:"OAuth2 client secret, empty for public clients relying on PKCE only"`
+	RedirectURL  string   `yaml:"redirect_url" usage:"URL of /oidc/callback as registered at identity provider"`
+	Scopes       []string `yaml:"scopes" usage:"comma-separated scopes requested besides openid"`
+}
+
+// oidcProvider runs authorization code flow with PKCE against identity
+// provider and verifies its ID tokens with keys published by it
+type oidcProvider struct {
+	tr     trace.Tracer
+	cfg    OIDCConfig
+	client *http.Client
+
+	authEndpoint  string
+	tokenEndpoint string
+	jwksURI       string
+
+	mu   sync.Mutex
+	keys map[string]*rsa.PublicKey
+}
+
+// newOIDC fetches discovery document of issuer
+func newOIDC(ctx context.Context, tr trace.Tracer, cfg OIDCConfig) (_ *oidcProvider, err error) {
+	ctx, span := tr.Start(ctx, "newOIDC", trace.WithAttributes(
+		attribute.String("issuer", cfg.Issuer),
+	))
+	defer span.End()
+
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		}
+	}()
+
+	if cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, errors.New("OIDC client ID and redirect URL are required")
+	}
+	p := &oidcProvider{
+		tr:     tr,
+		cfg:    cfg,
+		client: &http.Client{Timeout: oidcTimeout},
+		keys:   make(map[string]*rsa.PublicKey),
+	}
+	var discovery struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	if err = p.getJSON(ctx, strings.TrimSuffix(cfg.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("discovery failed: %w", err)
+	}
+	if discovery.Issuer != cfg.Issuer {
+		return nil, fmt.Errorf("discovery issuer %q does not match %q", discovery.Issuer, cfg.Issuer)
+	}
+	p.authEndpoint = discovery.AuthorizationEndpoint
+	p.tokenEndpoint = discovery.TokenEndpoint
+	p.jwksURI = discovery.JWKSURI
+	return p, nil
+}
+
+func (p *oidcProvider) getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	return p.do(req, v)
+}
+
+func (p *oidcProvider) do(req *http.Request, v interface{}) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s", req.Method, req.URL.Redacted(), resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// oidcFlow is state of login kept in cookie between redirect to identity
+// provider and callback
+type oidcFlow struct {
+	state    string
+	verifier string
+	nonce    string
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func newOIDCFlow() (flow oidcFlow, err error) {
+	if flow.state, err = randomString(); err != nil {
+		return flow, err
+	}
+	if flow.verifier, err = randomString(); err != nil {
+		return flow, err
+	}
+	flow.nonce, err = randomString()
+	return flow, err
+}
+
+func (f oidcFlow) cookie() *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcFlowCookie,
+		Value:    strings.Join([]string{f.state, f.verifier, f.nonce}, "."),
+		Path:     "/oidc",
+		MaxAge:   int(oidcFlowTTL.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func parseOIDCFlow(r *http.Request) (oidcFlow, error) {
+	c, err := r.Cookie(oidcFlowCookie)
+	if err != nil {
+		return oidcFlow{}, errors.New("login flow cookie expected")
+	}
+	parts := strings.Split(c.Value, ".")
+	if len(parts) != 3 {
+		return oidcFlow{}, errors.New("malformed login flow cookie")
+	}
+	return oidcFlow{state: parts[0], verifier: parts[1], nonce: parts[2]}, nil
+}
+
+// authURL returns URL of identity provider login page for flow
+func (p *oidcProvider) authURL(flow oidcFlow) string {
+	challenge := sha256.Sum256([]byte(flow.verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, p.cfg.Scopes...), " ")},
+		"state":                 {flow.state},
+		"nonce":                 {flow.nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.authEndpoint, "?") {
+		sep = "&"
+	}
+	return p.authEndpoint + sep + q.Encode()
+}
+
+// exchange redeems authorization code and returns subject of verified ID
+// token
+func (p *oidcProvider) exchange(ctx context.Context, code string, flow oidcFlow) (subject string, err error) {
+	ctx, span := p.tr.Start(ctx, "oidcExchange")
+	defer span.End()
+
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		}
+	}()
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"client_id":     {p.cfg.ClientID},
+		"code_verifier": {flow.verifier},
+	}
+	if p.cfg.ClientSecret != "" {
+		form.Set("client_secret", p.cfg.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err = p.do(req, &tokens); err != nil {
+		return "", fmt.Errorf("code exchange failed: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return "", errors.New("identity provider returned no ID token")
+	}
+	span.AddEvent("code exchanged")
+
+	return p.verify(ctx, tokens.IDToken, flow.nonce)
+}
+
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce string `json:"nonce"`
+}
+
+// verify checks signature, issuer, audience, expiry and nonce of ID token
+func (p *oidcProvider) verify(ctx context.Context, idToken, nonce string) (subject string, err error) {
+	var claims idTokenClaims
+	_, err = jwt.ParseWithClaims(idToken, &claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, kid)
+	})
+	switch {
+	case err != nil:
+		return "", err
+	case claims.Issuer != p.cfg.Issuer:
+		return "", fmt.Errorf("ID token of unexpected issuer %q", claims.Issuer)
+	case !claims.VerifyAudience(p.cfg.ClientID, true):
+		return "", errors.New("ID token is issued to another client")
+	case claims.Nonce != nonce:
+		return "", errors.New("ID token nonce does not match")
+	case claims.Subject == "":
+		return "", errors.New("ID token has no subject")
+	}
+	return claims.Subject, nil
+}
+
+// key returns public key of identity provider by ID. Keys are fetched again
+// on unknown ID, so rotated keys are picked up.
+func (p *oidcProvider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	ctx, span := p.tr.Start(ctx, "oidcFetchKeys")
+	defer span.End()
+
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.jwksURI, &jwks); err != nil {
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return nil, err
+	}
+	keys := make(map[string]*rsa.PublicKey, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	span.SetAttributes(attribute.Int("keys", len(keys)))
+	p.keys = keys
+
+	key, ok := keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return key, nil
+}
@@ -0,0 +1,141 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v3.21.4
+// source: analytics.proto
+
+package __
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// AnalyticsClient is the client API for Analytics service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AnalyticsClient interface {
+	Click(ctx context.Context, in *ClickRequest, opts ...grpc.CallOption) (*ClickResponse, error)
+	Stats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*StatsResponse, error)
+}
+
+type analyticsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAnalyticsClient(cc grpc.ClientConnInterface) AnalyticsClient {
+	return &analyticsClient{cc}
+}
+
+func (c *analyticsClient) Click(ctx context.Context, in *ClickRequest, opts ...grpc.CallOption) (*ClickResponse, error) {
+	out := new(ClickResponse)
+	err := c.cc.Invoke(ctx, "/analytics.Analytics/Click", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *analyticsClient) Stats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*StatsResponse, error) {
+	out := new(StatsResponse)
+	err := c.cc.Invoke(ctx, "/analytics.Analytics/Stats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AnalyticsServer is the server API for Analytics service.
+// All implementations must embed UnimplementedAnalyticsServer
+// for forward compatibility
+type AnalyticsServer interface {
+	Click(context.Context, *ClickRequest) (*ClickResponse, error)
+	Stats(context.Context, *StatsRequest) (*StatsResponse, error)
+	mustEmbedUnimplementedAnalyticsServer()
+}
+
+// UnimplementedAnalyticsServer must be embedded to have forward compatible implementations.
+type UnimplementedAnalyticsServer struct {
+}
+
+func (UnimplementedAnalyticsServer) Click(context.Context, *ClickRequest) (*ClickResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Click not implemented")
+}
+func (UnimplementedAnalyticsServer) Stats(context.Context, *StatsRequest) (*StatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stats not implemented")
+}
+func (UnimplementedAnalyticsServer) mustEmbedUnimplementedAnalyticsServer() {}
+
+// UnsafeAnalyticsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AnalyticsServer will
+// result in compilation errors.
+type UnsafeAnalyticsServer interface {
+	mustEmbedUnimplementedAnalyticsServer()
+}
+
+func RegisterAnalyticsServer(s grpc.ServiceRegistrar, srv AnalyticsServer) {
+	s.RegisterService(&Analytics_ServiceDesc, srv)
+}
+
+func _Analytics_Click_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClickRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AnalyticsServer).Click(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/analytics.Analytics/Click",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AnalyticsServer).Click(ctx, req.(*ClickRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Analytics_Stats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AnalyticsServer).Stats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/analytics.Analytics/Stats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AnalyticsServer).Stats(ctx, req.(*StatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Analytics_ServiceDesc is the grpc.ServiceDesc for Analytics service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Analytics_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "analytics.Analytics",
+	HandlerType: (*AnalyticsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Click",
+			Handler:    _Analytics_Click_Handler,
+		},
+		{
+			MethodName: "Stats",
+			Handler:    _Analytics_Stats_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "analytics.proto",
+}
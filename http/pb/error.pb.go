@@ -0,0 +1,269 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.28.1
+// 	protoc        v3.21.4
+// source: error.proto
+
+package __
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ErrorReason int32
+
+const (
+	ErrorReason_ERROR_REASON_UNSPECIFIED ErrorReason = 0
+	// there is no link for hash or url
+	ErrorReason_ERROR_REASON_NOT_FOUND ErrorReason = 1
+	// request misses hash or url
+	ErrorReason_ERROR_REASON_INVALID_REQUEST ErrorReason = 2
+	// hash already points to another url
+	ErrorReason_ERROR_REASON_HASH_COLLISION ErrorReason = 3
+	// link is owned by another user
+	ErrorReason_ERROR_REASON_NOT_OWNER ErrorReason = 4
+	// user already has as many links as allowed
+	ErrorReason_ERROR_REASON_QUOTA_EXCEEDED ErrorReason = 5
+	// database does not answer
+	ErrorReason_ERROR_REASON_UNAVAILABLE ErrorReason = 6
+	// call did not finish in time
+	ErrorReason_ERROR_REASON_TIMEOUT ErrorReason = 7
+	// watcher fell behind and changes were lost
+	ErrorReason_ERROR_REASON_CHANGES_LOST ErrorReason = 8
+	// url points to domain banned by admin
+	ErrorReason_ERROR_REASON_DOMAIN_BANNED ErrorReason = 9
+)
+
+// Enum value maps for ErrorReason.
+var (
+	ErrorReason_name = map[int32]string{
+		0: "ERROR_REASON_UNSPECIFIED",
+		1: "ERROR_REASON_NOT_FOUND",
+		2: "ERROR_REASON_INVALID_REQUEST",
+		3: "ERROR_REASON_HASH_COLLISION",
+		4: "ERROR_REASON_NOT_OWNER",
+		5: "ERROR_REASON_QUOTA_EXCEEDED",
+		6: "ERROR_REASON_UNAVAILABLE",
+		7: "ERROR_REASON_TIMEOUT",
+		8: "ERROR_REASON_CHANGES_LOST",
+		9: "ERROR_REASON_DOMAIN_BANNED",
+	}
+	ErrorReason_value = map[string]int32{
+		"ERROR_REASON_UNSPECIFIED":     0,
+		"ERROR_REASON_NOT_FOUND":       1,
+		"ERROR_REASON_INVALID_REQUEST": 2,
+		"ERROR_REASON_HASH_COLLISION":  3,
+		"ERROR_REASON_NOT_OWNER":       4,
+		"ERROR_REASON_QUOTA_EXCEEDED":  5,
+		"ERROR_REASON_UNAVAILABLE":     6,
+		"ERROR_REASON_TIMEOUT":         7,
+		"ERROR_REASON_CHANGES_LOST":    8,
+		"ERROR_REASON_DOMAIN_BANNED":   9,
+	}
+)
+
+func (x ErrorReason) Enum() *ErrorReason {
+	p := new(ErrorReason)
+	*p = x
+	return p
+}
+
+func (x ErrorReason) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ErrorReason) Descriptor() protoreflect.EnumDescriptor {
+	return file_error_proto_enumTypes[0].Descriptor()
+}
+
+func (ErrorReason) Type() protoreflect.EnumType {
+	return &file_error_proto_enumTypes[0]
+}
+
+func (x ErrorReason) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ErrorReason.Descriptor instead.
+func (ErrorReason) EnumDescriptor() ([]byte, []int) {
+	return file_error_proto_rawDescGZIP(), []int{0}
+}
+
+// ErrorDetail is attached to status details of failed calls, so clients act
+// on cause of failure without parsing status messages
+type ErrorDetail struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Reason ErrorReason `protobuf:"varint,1,opt,name=reason,proto3,enum=error.ErrorReason" json:"reason,omitempty"`
+	// retryable tells whether the same call may succeed later
+	Retryable bool `protobuf:"varint,2,opt,name=retryable,proto3" json:"retryable,omitempty"`
+	// message explains failure to a human and reveals no internals
+	Message string `protobuf:"bytes,3,opt,name=message,proto3" json:"message,omitempty"`
+}
+
+func (x *ErrorDetail) Reset() {
+	*x = ErrorDetail{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_error_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ErrorDetail) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ErrorDetail) ProtoMessage() {}
+
+func (x *ErrorDetail) ProtoReflect() protoreflect.Message {
+	mi := &file_error_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ErrorDetail.ProtoReflect.Descriptor instead.
+func (*ErrorDetail) Descriptor() ([]byte, []int) {
+	return file_error_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ErrorDetail) GetReason() ErrorReason {
+	if x != nil {
+		return x.Reason
+	}
+	return ErrorReason_ERROR_REASON_UNSPECIFIED
+}
+
+func (x *ErrorDetail) GetRetryable() bool {
+	if x != nil {
+		return x.Retryable
+	}
+	return false
+}
+
+func (x *ErrorDetail) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+var File_error_proto protoreflect.FileDescriptor
+
+var file_error_proto_rawDesc = []byte{
+	0x0a, 0x0b, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x05, 0x65,
+	0x72, 0x72, 0x6f, 0x72, 0x22, 0x71, 0x0a, 0x0b, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x44, 0x65, 0x74,
+	0x61, 0x69, 0x6c, 0x12, 0x2a, 0x0a, 0x06, 0x72, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x0e, 0x32, 0x12, 0x2e, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x2e, 0x45, 0x72, 0x72, 0x6f,
+	0x72, 0x52, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x52, 0x06, 0x72, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x12,
+	0x1c, 0x0a, 0x09, 0x72, 0x65, 0x74, 0x72, 0x79, 0x61, 0x62, 0x6c, 0x65, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x08, 0x52, 0x09, 0x72, 0x65, 0x74, 0x72, 0x79, 0x61, 0x62, 0x6c, 0x65, 0x12, 0x18, 0x0a,
+	0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07,
+	0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x2a, 0xbe, 0x02, 0x0a, 0x0b, 0x45, 0x72, 0x72, 0x6f,
+	0x72, 0x52, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x12, 0x1c, 0x0a, 0x18, 0x45, 0x52, 0x52, 0x4f, 0x52,
+	0x5f, 0x52, 0x45, 0x41, 0x53, 0x4f, 0x4e, 0x5f, 0x55, 0x4e, 0x53, 0x50, 0x45, 0x43, 0x49, 0x46,
+	0x49, 0x45, 0x44, 0x10, 0x00, 0x12, 0x1a, 0x0a, 0x16, 0x45, 0x52, 0x52, 0x4f, 0x52, 0x5f, 0x52,
+	0x45, 0x41, 0x53, 0x4f, 0x4e, 0x5f, 0x4e, 0x4f, 0x54, 0x5f, 0x46, 0x4f, 0x55, 0x4e, 0x44, 0x10,
+	0x01, 0x12, 0x20, 0x0a, 0x1c, 0x45, 0x52, 0x52, 0x4f, 0x52, 0x5f, 0x52, 0x45, 0x41, 0x53, 0x4f,
+	0x4e, 0x5f, 0x49, 0x4e, 0x56, 0x41, 0x4c, 0x49, 0x44, 0x5f, 0x52, 0x45, 0x51, 0x55, 0x45, 0x53,
+	0x54, 0x10, 0x02, 0x12, 0x1f, 0x0a, 0x1b, 0x45, 0x52, 0x52, 0x4f, 0x52, 0x5f, 0x52, 0x45, 0x41,
+	0x53, 0x4f, 0x4e, 0x5f, 0x48, 0x41, 0x53, 0x48, 0x5f, 0x43, 0x4f, 0x4c, 0x4c, 0x49, 0x53, 0x49,
+	0x4f, 0x4e, 0x10, 0x03, 0x12, 0x1a, 0x0a, 0x16, 0x45, 0x52, 0x52, 0x4f, 0x52, 0x5f, 0x52, 0x45,
+	0x41, 0x53, 0x4f, 0x4e, 0x5f, 0x4e, 0x4f, 0x54, 0x5f, 0x4f, 0x57, 0x4e, 0x45, 0x52, 0x10, 0x04,
+	0x12, 0x1f, 0x0a, 0x1b, 0x45, 0x52, 0x52, 0x4f, 0x52, 0x5f, 0x52, 0x45, 0x41, 0x53, 0x4f, 0x4e,
+	0x5f, 0x51, 0x55, 0x4f, 0x54, 0x41, 0x5f, 0x45, 0x58, 0x43, 0x45, 0x45, 0x44, 0x45, 0x44, 0x10,
+	0x05, 0x12, 0x1c, 0x0a, 0x18, 0x45, 0x52, 0x52, 0x4f, 0x52, 0x5f, 0x52, 0x45, 0x41, 0x53, 0x4f,
+	0x4e, 0x5f, 0x55, 0x4e, 0x41, 0x56, 0x41, 0x49, 0x4c, 0x41, 0x42, 0x4c, 0x45, 0x10, 0x06, 0x12,
+	0x18, 0x0a, 0x14, 0x45, 0x52, 0x52, 0x4f, 0x52, 0x5f, 0x52, 0x45, 0x41, 0x53, 0x4f, 0x4e, 0x5f,
+	0x54, 0x49, 0x4d, 0x45, 0x4f, 0x55, 0x54, 0x10, 0x07, 0x12, 0x1d, 0x0a, 0x19, 0x45, 0x52, 0x52,
+	0x4f, 0x52, 0x5f, 0x52, 0x45, 0x41, 0x53, 0x4f, 0x4e, 0x5f, 0x43, 0x48, 0x41, 0x4e, 0x47, 0x45,
+	0x53, 0x5f, 0x4c, 0x4f, 0x53, 0x54, 0x10, 0x08, 0x12, 0x1e, 0x0a, 0x1a, 0x45, 0x52, 0x52, 0x4f,
+	0x52, 0x5f, 0x52, 0x45, 0x41, 0x53, 0x4f, 0x4e, 0x5f, 0x44, 0x4f, 0x4d, 0x41, 0x49, 0x4e, 0x5f,
+	0x42, 0x41, 0x4e, 0x4e, 0x45, 0x44, 0x10, 0x09, 0x42, 0x04, 0x5a, 0x02, 0x2e, 0x2f, 0x62, 0x06,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_error_proto_rawDescOnce sync.Once
+	file_error_proto_rawDescData = file_error_proto_rawDesc
+)
+
+func file_error_proto_rawDescGZIP() []byte {
+	file_error_proto_rawDescOnce.Do(func() {
+		file_error_proto_rawDescData = protoimpl.X.CompressGZIP(file_error_proto_rawDescData)
+	})
+	return file_error_proto_rawDescData
+}
+
+var file_error_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_error_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_error_proto_goTypes = []interface{}{
+	(ErrorReason)(0),    // 0: error.ErrorReason
+	(*ErrorDetail)(nil), // 1: error.ErrorDetail
+}
+var file_error_proto_depIdxs = []int32{
+	0, // 0: error.ErrorDetail.reason:type_name -> error.ErrorReason
+	1, // [1:1] is the sub-list for method output_type
+	1, // [1:1] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_error_proto_init() }
+func file_error_proto_init() {
+	if File_error_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_error_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ErrorDetail); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_error_proto_rawDesc,
+			NumEnums:      1,
+			NumMessages:   1,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_error_proto_goTypes,
+		DependencyIndexes: file_error_proto_depIdxs,
+		EnumInfos:         file_error_proto_enumTypes,
+		MessageInfos:      file_error_proto_msgTypes,
+	}.Build()
+	File_error_proto = out.File
+	file_error_proto_rawDesc = nil
+	file_error_proto_goTypes = nil
+	file_error_proto_depIdxs = nil
+}
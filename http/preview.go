@@ -0,0 +1,110 @@
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// previewTimeLayout formats creation and expiration times on preview page
+const previewTimeLayout = "2 Jan 2006 15:04 MST"
+
+//go:embed templates/preview.html
+var previewPage string
+
+// previewTemplate renders destination, creator and creation date of link
+var previewTemplate = template.Must(template.New("preview").Funcs(templateFuncs).Parse(previewPage))
+
+type previewData struct {
+	Hash string
+	// URL is destination with host in Unicode form
+	URL   string
+	Owner string
+	// Title and Favicon of destination page are empty until they are fetched
+	Title   string
+	Favicon string
+	// CreatedAt and ExpiresAt are empty if unknown or link never expires
+	CreatedAt string
+	ExpiresAt string
+}
+
+func formatPreviewTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(previewTimeLayout)
+}
+
+// handlePreview serves GET /{hash}+, which is not in OpenAPI spec as path
+// templates cannot have suffix after parameter
+func (h *handlers) handlePreview(w http.ResponseWriter, r *http.Request) {
+	h.preview(w, r, mux.Vars(r)["hash"])
+}
+
+// preview shows where link leads instead of redirecting, for users who do
+// not trust blind short links. Preview is not counted as click.
+func (h *handlers) preview(w http.ResponseWriter, r *http.Request, hash string) {
+	ctx, span := h.tr.Start(r.Context(), "preview", trace.WithAttributes(
+		attribute.String("hash", hash),
+	))
+	defer span.End()
+
+	if !isShortCorrect(hash) {
+		err := fmt.Errorf(invalidHashError, hash)
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	details, err := h.storage.Details(ctx, namespaceKey(h.namespace(ctx, r), hash))
+	if errors.Is(err, errExpired) {
+		writeResponse(w, http.StatusGone, err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+	if errors.Is(err, errNotFound) {
+		writeResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeResponse(w, lookupStatus(err), err.Error())
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		return
+	}
+
+	// preview does not show destinations which redirect would refuse
+	if h.screening.Redirects() {
+		if err = h.screening.Check(ctx, details.URL); err != nil {
+			writeResponse(w, http.StatusForbidden, err.Error())
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusOK)
+	err = previewTemplate.Execute(w, previewData{
+		Hash:      hash,
+		URL:       displayURL(details.URL),
+		Owner:     details.Owner,
+		Title:     details.Title,
+		Favicon:   details.Favicon,
+		CreatedAt: formatPreviewTime(details.CreatedAt),
+		ExpiresAt: formatPreviewTime(details.ExpiresAt),
+	})
+	if err != nil {
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+
+	"github.com/asmyasnikov/webinar-jaeger/server/api"
+)
+
+// idleBucketTTL is how long bucket of silent client is kept. Bucket idle for
+// so long is full again, so dropping it does not change limits.
+const idleBucketTTL = 10 * time.Minute
+
+// rateLimitedRoutes are expensive or brute-forceable routes
+var rateLimitedRoutes = map[string]bool{
+	"/login":             true,
+	"/refresh":           true,
+	"/register":          true,
+	"/shorten":           true,
+	"/api/shorten/batch": true,
+}
+
+var rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "http_rate_limited_total",
+	Help: "Total number of HTTP requests rejected by rate limiter.",
+}, []string{"route", "limit"})
+
+// buckets keeps token bucket per key
+type buckets struct {
+	limit rate.Limit
+	burst int
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	limiter *rate.Limiter
+	seen    time.Time
+}
+
+func newBuckets(limit float64, burst int) *buckets {
+	return &buckets{
+		limit:   rate.Limit(limit),
+		burst:   burst,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// allow takes token from bucket of key. If bucket is empty it returns time
+// after which request will be allowed.
+func (b *buckets) allow(key string) (ok bool, retryAfter time.Duration) {
+	now := time.Now()
+
+	b.mu.Lock()
+	bb, has := b.buckets[key]
+	if !has {
+		bb = &bucket{limiter: rate.NewLimiter(b.limit, b.burst)}
+		b.buckets[key] = bb
+	}
+	bb.seen = now
+	b.mu.Unlock()
+
+	r := bb.limiter.ReserveN(now, 1)
+	if !r.OK() {
+		return false, time.Second
+	}
+	if d := r.DelayFrom(now); d > 0 {
+		r.CancelAt(now)
+		return false, d
+	}
+	return true, 0
+}
+
+func (b *buckets) evictIdle() {
+	deadline := time.Now().Add(-idleBucketTTL)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for key, bb := range b.buckets {
+		if bb.seen.Before(deadline) {
+			delete(b.buckets, key)
+		}
+	}
+}
+
+type rateLimiter struct {
+	cfg      RateLimitConfig
+	ip       *buckets
+	sessions *buckets
+}
+
+// newRateLimiter returns middleware which limits requests to rateLimitedRoutes
+// by client IP and by session token. Zero rate disables the limit.
+func newRateLimiter(tr trace.Tracer, cfg RateLimitConfig) api.MiddlewareFunc {
+	l := &rateLimiter{
+		cfg: cfg,
+	}
+	if cfg.IPRate > 0 {
+		l.ip = newBuckets(cfg.IPRate, cfg.IPBurst)
+	}
+	if cfg.SessionRate > 0 {
+		l.sessions = newBuckets(cfg.SessionRate, cfg.SessionBurst)
+	}
+	go l.evictIdle()
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			route := routeTemplate(r)
+			if !rateLimitedRoutes[route] {
+				next(w, r)
+				return
+			}
+			limit, retryAfter := l.check(r)
+			if limit == "" {
+				next(w, r)
+				return
+			}
+
+			_, span := tr.Start(r.Context(), "rate limit", trace.WithAttributes(
+				attribute.String("client_ip", l.clientIP(r)),
+				attribute.String("rate_limit", limit),
+				attribute.Int64("retry_after_ms", retryAfter.Milliseconds()),
+			))
+			span.SetAttributes(attribute.Bool("error", true))
+			span.End()
+
+			rateLimited.WithLabelValues(route, limit).Inc()
+
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			writeResponse(w, http.StatusTooManyRequests, "rate limit exceeded, retry after "+retryAfter.Round(time.Second).String())
+		}
+	}
+}
+
+// check returns name of exceeded limit or empty string if request is allowed
+func (l *rateLimiter) check(r *http.Request) (limit string, retryAfter time.Duration) {
+	if l.ip != nil {
+		if ok, d := l.ip.allow(l.clientIP(r)); !ok {
+			return "ip", d
+		}
+	}
+	if l.sessions != nil {
+		if token := sessionFromContext(r.Context()); token != "" {
+			if ok, d := l.sessions.allow(token); !ok {
+				return "session", d
+			}
+		}
+	}
+	return "", 0
+}
+
+func (l *rateLimiter) clientIP(r *http.Request) string {
+	return clientIP(r, l.cfg.TrustForwardedFor)
+}
+
+// clientIP returns address of client, behind reverse proxy the last
+// address of X-Forwarded-For if it is trusted
+func clientIP(r *http.Request, trustForwardedFor bool) string {
+	if trustForwardedFor {
+		// the last address is appended by the trusted proxy, addresses before
+		// it are sent by client and may be anything
+		if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+			addrs := strings.Split(values[len(values)-1], ",")
+			if ip := strings.TrimSpace(addrs[len(addrs)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (l *rateLimiter) evictIdle() {
+	for range time.Tick(idleBucketTTL) {
+		for _, b := range []*buckets{l.ip, l.sessions} {
+			if b != nil {
+				b.evictIdle()
+			}
+		}
+	}
+}
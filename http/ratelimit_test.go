@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	for _, tt := range []struct {
+		name              string
+		remoteAddr        string
+		forwardedFor      []string
+		trustForwardedFor bool
+		want              string
+	}{
+		{
+			name:       "remote address",
+			remoteAddr: "203.0.113.1:51234",
+			want:       "203.0.113.1",
+		},
+		{
+			name:       "remote ipv6 address",
+			remoteAddr: "[2001:db8::1]:51234",
+			want:       "2001:db8::1",
+		},
+		{
+			name:       "remote address without port",
+			remoteAddr: "203.0.113.1",
+			want:       "203.0.113.1",
+		},
+		{
+			name:         "forwarded for is ignored if not trusted",
+			remoteAddr:   "10.0.0.1:51234",
+			forwardedFor: []string{"203.0.113.1"},
+			want:         "10.0.0.1",
+		},
+		{
+			name:              "forwarded for",
+			remoteAddr:        "10.0.0.1:51234",
+			forwardedFor:      []string{"203.0.113.1"},
+			trustForwardedFor: true,
+			want:              "203.0.113.1",
+		},
+		{
+			name:              "address appended by proxy wins over addresses sent by client",
+			remoteAddr:        "10.0.0.1:51234",
+			forwardedFor:      []string{"198.51.100.7, 192.0.2.9,203.0.113.1"},
+			trustForwardedFor: true,
+			want:              "203.0.113.1",
+		},
+		{
+			name:              "last of several headers",
+			remoteAddr:        "10.0.0.1:51234",
+			forwardedFor:      []string{"198.51.100.7", "203.0.113.1"},
+			trustForwardedFor: true,
+			want:              "203.0.113.1",
+		},
+		{
+			name:              "empty forwarded for",
+			remoteAddr:        "10.0.0.1:51234",
+			forwardedFor:      []string{"198.51.100.7, "},
+			trustForwardedFor: true,
+			want:              "10.0.0.1",
+		},
+		{
+			name:              "no forwarded for",
+			remoteAddr:        "10.0.0.1:51234",
+			trustForwardedFor: true,
+			want:              "10.0.0.1",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwardedFor {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := clientIP(r, tt.trustForwardedFor); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// ringReplicas is number of points of every cache on ring. More points
+// spread links over caches more evenly.
+const ringReplicas = 100
+
+// ring shards links over caches by consistent hashing of their hashes, so
+// every link is cached once and memory of caches adds up. Adding or removing
+// a cache moves only links of its share to other caches.
+type ring struct {
+	points []uint32
+	// owners[i] is cache of points[i]
+	owners []*storage
+}
+
+type ringPoint struct {
+	point uint32
+	owner *storage
+}
+
+// ringHash spreads similar keys like short codes evenly, unlike FNV
+func ringHash(key string) uint32 {
+	return crc32.ChecksumIEEE([]byte(key))
+}
+
+func newRing(caches []*storage) *ring {
+	points := make([]ringPoint, 0, len(caches)*ringReplicas)
+	for _, s := range caches {
+		for i := 0; i < ringReplicas; i++ {
+			points = append(points, ringPoint{
+				point: ringHash(s.addr + "#" + strconv.Itoa(i)),
+				owner: s,
+			})
+		}
+	}
+	// ties are broken by address, so every frontend builds the same ring
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].point != points[j].point {
+			return points[i].point < points[j].point
+		}
+		return points[i].owner.addr < points[j].owner.addr
+	})
+	r := &ring{
+		points: make([]uint32, 0, len(points)),
+		owners: make([]*storage, 0, len(points)),
+	}
+	for _, p := range points {
+		r.points = append(r.points, p.point)
+		r.owners = append(r.owners, p.owner)
+	}
+	return r
+}
+
+// shard returns cache of link of hash, nil if there are no caches
+func (r *ring) shard(hash string) *storage {
+	if len(r.points) == 0 {
+		return nil
+	}
+	point := ringHash(hash)
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i] >= point
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[i]
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func ringCaches(addrs ...string) []*storage {
+	caches := make([]*storage, 0, len(addrs))
+	for _, addr := range addrs {
+		caches = append(caches, &storage{addr: addr})
+	}
+	return caches
+}
+
+func ringHashes(n int) []string {
+	hashes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		hashes = append(hashes, "h"+strconv.Itoa(i))
+	}
+	return hashes
+}
+
+func TestRingShard(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		addrs []string
+	}{
+		{name: "no caches"},
+		{name: "one cache", addrs: []string{"cache-1:8080"}},
+		{name: "three caches", addrs: []string{"cache-1:8080", "cache-2:8080", "cache-3:8080"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRing(ringCaches(tt.addrs...))
+			if got := len(r.points); got != len(tt.addrs)*ringReplicas {
+				t.Fatalf("ring has %d points, want %d", got, len(tt.addrs)*ringReplicas)
+			}
+			hashes := ringHashes(3000)
+			shares := make(map[string]int, len(tt.addrs))
+			for _, hash := range hashes {
+				s := r.shard(hash)
+				if len(tt.addrs) == 0 {
+					if s != nil {
+						t.Fatalf("shard(%q) = %q, want nil", hash, s.addr)
+					}
+					continue
+				}
+				shares[s.addr]++
+			}
+			// every cache takes at least half of even share
+			for _, addr := range tt.addrs {
+				if min := len(hashes) / len(tt.addrs) / 2; shares[addr] < min {
+					t.Errorf("cache %q got %d of %d links, want at least %d", addr, shares[addr], len(hashes), min)
+				}
+			}
+		})
+	}
+}
+
+func TestRingPlacementDoesNotDependOnOrder(t *testing.T) {
+	a := newRing(ringCaches("cache-1:8080", "cache-2:8080", "cache-3:8080"))
+	b := newRing(ringCaches("cache-3:8080", "cache-1:8080", "cache-2:8080"))
+	for _, hash := range ringHashes(1000) {
+		if a.shard(hash).addr != b.shard(hash).addr {
+			t.Fatalf("shard(%q) is %q and %q on rings of the same caches", hash, a.shard(hash).addr, b.shard(hash).addr)
+		}
+	}
+}
+
+func TestRingMovesOnlyLinksOfChangedCache(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		before []string
+		after  []string
+		// changed is cache added or removed, links of other caches stay
+		changed string
+	}{
+		{
+			name:    "cache added",
+			before:  []string{"cache-1:8080", "cache-2:8080"},
+			after:   []string{"cache-1:8080", "cache-2:8080", "cache-3:8080"},
+			changed: "cache-3:8080",
+		},
+		{
+			name:    "cache removed",
+			before:  []string{"cache-1:8080", "cache-2:8080", "cache-3:8080"},
+			after:   []string{"cache-1:8080", "cache-3:8080"},
+			changed: "cache-2:8080",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			before, after := newRing(ringCaches(tt.before...)), newRing(ringCaches(tt.after...))
+			moved := 0
+			for _, hash := range ringHashes(3000) {
+				from, to := before.shard(hash).addr, after.shard(hash).addr
+				if from == to {
+					continue
+				}
+				moved++
+				if from != tt.changed && to != tt.changed {
+					t.Errorf("link %q moved from %q to %q", hash, from, to)
+				}
+			}
+			if moved == 0 {
+				t.Errorf("no links moved")
+			}
+		})
+	}
+}
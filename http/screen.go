@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	// screenCacheSize bounds verdicts kept by urlScreening, the cache is
+	// dropped as a whole when it is full
+	screenCacheSize = 10000
+
+	safeBrowsingClientID = "webinar-jaeger"
+)
+
+// errMalicious means url screening found destination malicious
+var errMalicious = errors.New("url is flagged as malicious")
+
+// ScreenConfig enables screening of destinations by blocklist and Google
+// Safe Browsing
+type ScreenConfig struct {
+	Screeners       []string      `yaml:"screeners" usage:"comma-separated url screeners asked in order: blocklist, safebrowsing; empty disables screening"`
+	Action          string        `yaml:"action" usage:"what to do with malicious url: reject it or flag it in traces, logs and metrics"`
+	Redirects       bool          `yaml:"redirects" usage:"screen destinations on redirect too, verdicts are cached for cache_ttl"`
+	FailClosed      bool          `yaml:"fail_closed" usage:"treat url as malicious if a screener fails, otherwise it passes"`
+	Timeout         time.Duration `yaml:"timeout" usage:"deadline of one screener call"`
+	CacheTTL        time.Duration `yaml:"cache_ttl" usage:"time verdict of url is reused, 0 disables cache"`
+	BlocklistFile   string        `yaml:"blocklist_file" usage:"file of blocked hosts (with subdomains) and url prefixes, one per line"`
+	SafeBrowsingKey string        `yaml:"safe_browsing_key" usage:"API key of Google Safe Browsing"`
+	SafeBrowsingURL string        `yaml:"safe_browsing_url" usage:"threatMatches:find endpoint of Safe Browsing Lookup API"`
+}
+
+var screenedURLs = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "http_screened_urls_total",
+	Help: "Total number of urls screened by verdict: clean, malicious or failed.",
+}, []string{"screener", "verdict"})
+
+// urlScreener checks destination of link before it is shortened
+type urlScreener interface {
+	// Screen returns threat url is known for, empty if none
+	Screen(ctx context.Context, rawURL string) (threat string, err error)
+}
+
+// screenerConstructors are keyed by ScreenConfig.Screeners values. New
+// screener needs only a constructor registered here.
+var screenerConstructors = map[string]func(cfg ScreenConfig) (urlScreener, error){
+	"blocklist":    newBlocklist,
+	"safebrowsing": newSafeBrowsing,
+}
+
+type namedScreener struct {
+	name string
+	urlScreener
+}
+
+type verdict struct {
+	threat   string
+	screener string
+	expireAt time.Time
+}
+
+// urlScreening asks screeners in order until one knows threat of url, and
+// rejects or only flags malicious urls
+type urlScreening struct {
+	screeners  []namedScreener
+	reject     bool
+	redirects  bool
+	failClosed bool
+	timeout    time.Duration
+	cacheTTL   time.Duration
+
+	mu    sync.Mutex
+	cache map[string]verdict
+}
+
+// newURLScreening returns nil if no screeners are configured
+func newURLScreening(cfg ScreenConfig) (*urlScreening, error) {
+	if len(cfg.Screeners) == 0 {
+		return nil, nil
+	}
+	if cfg.Action != "reject" && cfg.Action != "flag" {
+		return nil, fmt.Errorf("unknown screening action '%s'", cfg.Action)
+	}
+	s := &urlScreening{
+		reject:     cfg.Action == "reject",
+		redirects:  cfg.Redirects,
+		failClosed: cfg.FailClosed,
+		timeout:    cfg.Timeout,
+		cacheTTL:   cfg.CacheTTL,
+		cache:      make(map[string]verdict),
+	}
+	for _, name := range cfg.Screeners {
+		constructor, ok := screenerConstructors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown url screener '%s'", name)
+		}
+		screener, err := constructor(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("url screener '%s': %w", name, err)
+		}
+		s.screeners = append(s.screeners, namedScreener{name: name, urlScreener: screener})
+	}
+	return s, nil
+}
+
+// Check screens url and fails with errMalicious if it is malicious and
+// action is reject. Flagged urls pass with event in span and warning in log.
+// Nil screening passes every url.
+func (s *urlScreening) Check(ctx context.Context, rawURL string) error {
+	if s == nil {
+		return nil
+	}
+	v, err := s.verdict(ctx, rawURL)
+	if err != nil {
+		return err
+	}
+	if v.threat == "" {
+		return nil
+	}
+	trace.SpanFromContext(ctx).AddEvent("malicious url", trace.WithAttributes(
+		attribute.String("url", rawURL),
+		attribute.String("threat", v.threat),
+		attribute.String("screener", v.screener),
+		attribute.Bool("rejected", s.reject),
+	))
+	if !s.reject {
+		slog.WarnCtx(ctx, "malicious url flagged", slog.String("url", rawURL),
+			slog.String("threat", v.threat), slog.String("screener", v.screener))
+		return nil
+	}
+	return fmt.Errorf("%w: %s", errMalicious, v.threat)
+}
+
+// Redirects tells whether destinations are screened on redirect too
+func (s *urlScreening) Redirects() bool {
+	return s != nil && s.redirects
+}
+
+func (s *urlScreening) verdict(ctx context.Context, rawURL string) (verdict, error) {
+	now := time.Now()
+	if s.cacheTTL > 0 {
+		s.mu.Lock()
+		v, ok := s.cache[rawURL]
+		s.mu.Unlock()
+		if ok && now.Before(v.expireAt) {
+			return v, nil
+		}
+	}
+	var v verdict
+	for _, screener := range s.screeners {
+		threat, err := s.screen(ctx, screener, rawURL)
+		if err != nil {
+			screenedURLs.WithLabelValues(screener.name, "failed").Inc()
+			trace.SpanFromContext(ctx).RecordError(err)
+			slog.WarnCtx(ctx, "url screening failed", slog.String("screener", screener.name), slog.Any("error", err))
+			if s.failClosed {
+				// failure is shown to users, so it does not reveal its cause
+				return verdict{}, fmt.Errorf("%w: screener '%s' failed", errMalicious, screener.name)
+			}
+			// failed screening is not cached, so the url is screened again
+			return verdict{}, nil
+		}
+		if threat != "" {
+			screenedURLs.WithLabelValues(screener.name, "malicious").Inc()
+			v = verdict{threat: threat, screener: screener.name}
+			break
+		}
+		screenedURLs.WithLabelValues(screener.name, "clean").Inc()
+	}
+	if s.cacheTTL > 0 {
+		v.expireAt = now.Add(s.cacheTTL)
+		s.mu.Lock()
+		if len(s.cache) >= screenCacheSize {
+			s.cache = make(map[string]verdict)
+		}
+		s.cache[rawURL] = v
+		s.mu.Unlock()
+	}
+	return v, nil
+}
+
+func (s *urlScreening) screen(ctx context.Context, screener namedScreener, rawURL string) (string, error) {
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	return screener.Screen(ctx, rawURL)
+}
+
+// blocklist knows hosts and url prefixes listed in local file. Host blocks
+// its subdomains too.
+type blocklist struct {
+	hosts    map[string]struct{}
+	prefixes []string
+}
+
+func newBlocklist(cfg ScreenConfig) (urlScreener, error) {
+	if cfg.BlocklistFile == "" {
+		return nil, errors.New("blocklist file is required")
+	}
+	f, err := os.Open(cfg.BlocklistFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b := &blocklist{
+		hosts: make(map[string]struct{}),
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.Contains(line, "://"):
+			b.prefixes = append(b.prefixes, line)
+		default:
+			b.hosts[strings.TrimSuffix(strings.ToLower(line), ".")] = struct{}{}
+		}
+	}
+	return b, scanner.Err()
+}
+
+func (b *blocklist) Screen(_ context.Context, rawURL string) (string, error) {
+	for _, prefix := range b.prefixes {
+		if strings.HasPrefix(rawURL, prefix) {
+			return "BLOCKLISTED", nil
+		}
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", nil
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	for host != "" {
+		if _, ok := b.hosts[host]; ok {
+			return "BLOCKLISTED", nil
+		}
+		_, host, _ = strings.Cut(host, ".")
+	}
+	return "", nil
+}
+
+// safeBrowsing asks Lookup API of Google Safe Browsing v4
+type safeBrowsing struct {
+	endpoint string
+	client   *http.Client
+}
+
+func newSafeBrowsing(cfg ScreenConfig) (urlScreener, error) {
+	if cfg.SafeBrowsingKey == "" {
+		return nil, errors.New("Safe Browsing API key is required")
+	}
+	endpoint, err := url.Parse(cfg.SafeBrowsingURL)
+	if err != nil {
+		return nil, err
+	}
+	q := endpoint.Query()
+	q.Set("key", cfg.SafeBrowsingKey)
+	endpoint.RawQuery = q.Encode()
+	return &safeBrowsing{
+		endpoint: endpoint.String(),
+		client:   &http.Client{},
+	}, nil
+}
+
+type safeBrowsingEntry struct {
+	URL string `json:"url"`
+}
+
+type safeBrowsingRequest struct {
+	Client struct {
+		ClientID      string `json:"clientId"`
+		ClientVersion string `json:"clientVersion"`
+	} `json:"client"`
+	ThreatInfo struct {
+		ThreatTypes      []string            `json:"threatTypes"`
+		PlatformTypes    []string            `json:"platformTypes"`
+		ThreatEntryTypes []string            `json:"threatEntryTypes"`
+		ThreatEntries    []safeBrowsingEntry `json:"threatEntries"`
+	} `json:"threatInfo"`
+}
+
+type safeBrowsingResponse struct {
+	Matches []struct {
+		ThreatType string `json:"threatType"`
+	} `json:"matches"`
+}
+
+func (s *safeBrowsing) Screen(ctx context.Context, rawURL string) (string, error) {
+	var request safeBrowsingRequest
+	request.Client.ClientID = safeBrowsingClientID
+	request.Client.ClientVersion = "1.0.0"
+	request.ThreatInfo.ThreatTypes = []string{"MALWARE", "SOCIAL_ENGINEERING", "UNWANTED_SOFTWARE", "POTENTIALLY_HARMFUL_APPLICATION"}
+	request.ThreatInfo.PlatformTypes = []string{"ANY_PLATFORM"}
+	request.ThreatInfo.ThreatEntryTypes = []string{"URL"}
+	request.ThreatInfo.ThreatEntries = []safeBrowsingEntry{{URL: rawURL}}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		// error of client names request url, which holds API key
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return "", fmt.Errorf("safe browsing lookup failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("safe browsing lookup failed: %s", resp.Status)
+	}
+	var response safeBrowsingResponse
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", err
+	}
+	if len(response.Matches) == 0 {
+		return "", nil
+	}
+	return response.Matches[0].ThreatType, nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slog"
+
+	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
+)
+
+// sessionTouchInterval is how often last request time of active session is
+// written to its cookie, so not every response sets cookie
+const sessionTouchInterval = time.Minute
+
+// SessionConfig sets attributes and keys of session and refresh cookies
+type SessionConfig struct {
+	HashKey     string        `yaml:"hash_key" usage:"key signing session cookies, the same for every http instance; random if empty, so sessions end on restart"`
+	BlockKey    string        `yaml:"block_key" usage:"AES key of 16, 24 or 32 bytes encrypting session cookies; random if empty"`
+	Domain      string        `yaml:"domain" usage:"domain of session cookies, empty for host of request only"`
+	Path        string        `yaml:"path" usage:"path of session cookie"`
+	Secure      bool          `yaml:"secure" usage:"send session cookies over HTTPS only, always on when HTTPS is enabled"`
+	SameSite    string        `yaml:"same_site" usage:"SameSite attribute of session cookies: lax, strict or none"`
+	IdleTimeout time.Duration `yaml:"idle_timeout" usage:"session ends after this time without requests, 0 disables idle timeout"`
+}
+
+var sameSiteModes = map[string]http.SameSite{
+	"lax":    http.SameSiteLaxMode,
+	"strict": http.SameSiteStrictMode,
+	"none":   http.SameSiteNoneMode,
+}
+
+// sessionState is value of session cookie
+type sessionState struct {
+	Token    string `json:"token"`
+	ExpireAt int64  `json:"expire_at"`
+	// LastSeen is unix time of the last request of session
+	LastSeen int64 `json:"last_seen"`
+}
+
+type sessionKey struct{}
+
+// sessionCookies keeps tokens in encrypted and signed cookies, so clients
+// neither read tokens nor forge cookies, and ends sessions idle for too long
+type sessionCookies struct {
+	cfg      SessionConfig
+	sameSite http.SameSite
+	codec    *securecookie.SecureCookie
+}
+
+func newSessionCookies(cfg SessionConfig, https bool) (*sessionCookies, error) {
+	sameSite, ok := sameSiteModes[strings.ToLower(cfg.SameSite)]
+	if !ok {
+		return nil, fmt.Errorf("unknown SameSite mode '%s'", cfg.SameSite)
+	}
+	if sameSite == http.SameSiteNoneMode && !cfg.Secure && !https {
+		return nil, errors.New("SameSite none requires secure cookies")
+	}
+	hashKey, blockKey := []byte(cfg.HashKey), []byte(cfg.BlockKey)
+	if len(hashKey) == 0 || len(blockKey) == 0 {
+		slog.Warn("session keys are not set, sessions are signed and encrypted with random keys")
+	}
+	if len(hashKey) == 0 {
+		hashKey = securecookie.GenerateRandomKey(64)
+	}
+	if len(blockKey) == 0 {
+		blockKey = securecookie.GenerateRandomKey(32)
+	}
+	if _, err := aes.NewCipher(blockKey); err != nil {
+		return nil, fmt.Errorf("invalid session block key: %w", err)
+	}
+	codec := securecookie.New(hashKey, blockKey)
+	codec.SetSerializer(securecookie.JSONEncoder{})
+	cfg.Secure = cfg.Secure || https
+	return &sessionCookies{
+		cfg:      cfg,
+		sameSite: sameSite,
+		codec:    codec,
+	}, nil
+}
+
+func (s *sessionCookies) cookie(name, value, path string, expireAt time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   s.cfg.Domain,
+		Expires:  expireAt,
+		HttpOnly: true,
+		Secure:   s.cfg.Secure,
+		SameSite: s.sameSite,
+	}
+}
+
+// set sets session and refresh cookies. Refresh cookie is sent only to
+// /refresh, other requests do not expose it. Session cookie lives as long as
+// refresh token, so /refresh knows whether the session is idle.
+func (s *sessionCookies) set(w http.ResponseWriter, tokens *pb.LoginResponse) error {
+	expireAt := tokens.GetExpireAt().AsTime()
+	if tokens.GetRefreshToken() != "" {
+		expireAt = tokens.GetRefreshExpireAt().AsTime()
+		value, err := s.codec.Encode(refreshToken, tokens.GetRefreshToken())
+		if err != nil {
+			return err
+		}
+		http.SetCookie(w, s.cookie(refreshToken, value, "/refresh", expireAt))
+	}
+	return s.setSession(w, sessionState{
+		Token:    tokens.GetToken(),
+		ExpireAt: expireAt.Unix(),
+		LastSeen: time.Now().Unix(),
+	})
+}
+
+func (s *sessionCookies) setSession(w http.ResponseWriter, state sessionState) error {
+	value, err := s.codec.Encode(sessionToken, state)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, s.cookie(sessionToken, value, s.cfg.Path, time.Unix(state.ExpireAt, 0)))
+	return nil
+}
+
+// clear removes session and refresh cookies
+func (s *sessionCookies) clear(w http.ResponseWriter) {
+	for name, path := range map[string]string{sessionToken: s.cfg.Path, refreshToken: "/refresh"} {
+		c := s.cookie(name, "", path, time.Time{})
+		c.MaxAge = -1
+		http.SetCookie(w, c)
+	}
+}
+
+// refreshToken returns refresh token from cookie of request
+func (s *sessionCookies) refreshToken(r *http.Request) (string, error) {
+	c, err := r.Cookie(refreshToken)
+	if err != nil {
+		return "", errors.New("refresh token expected")
+	}
+	var token string
+	if err = s.codec.Decode(refreshToken, c.Value, &token); err != nil {
+		return "", fmt.Errorf("invalid refresh token cookie: %w", err)
+	}
+	return token, nil
+}
+
+// Middleware decodes session cookie and puts its token into request context.
+// Sessions idle for longer than idle timeout are ended and their cookies
+// cleared, cookies of active sessions are touched.
+func (s *sessionCookies) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie(sessionToken)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		span := trace.SpanFromContext(r.Context())
+		var state sessionState
+		if err = s.codec.Decode(sessionToken, c.Value, &state); err != nil {
+			span.AddEvent("invalid session cookie")
+			next.ServeHTTP(w, r)
+			return
+		}
+		now := time.Now()
+		idle := now.Sub(time.Unix(state.LastSeen, 0))
+		if s.cfg.IdleTimeout > 0 && idle > s.cfg.IdleTimeout {
+			span.AddEvent("session idle timeout")
+			s.clear(w)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if idle >= sessionTouchInterval {
+			state.LastSeen = now.Unix()
+			if err = s.setSession(w, state); err != nil {
+				span.RecordError(err)
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionKey{}, state.Token)))
+	})
+}
+
+// sessionFromContext returns session token put by Middleware or empty string
+func sessionFromContext(ctx context.Context) string {
+	token, _ := ctx.Value(sessionKey{}).(string)
+	return token
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
+)
+
+// coalescedStorage makes concurrent lookups of the same hash share one
+// downstream call, so a hot link does not multiply RPCs to storages
+type coalescedStorage struct {
+	Storage
+
+	group singleflight.Group
+}
+
+func newCoalescedStorage(s Storage) *coalescedStorage {
+	return &coalescedStorage{
+		Storage: s,
+	}
+}
+
+type getResult struct {
+	url string
+	err error
+}
+
+// Get joins in-flight lookup of hash or starts a new one. The lookup runs
+// without caller cancellation, so a caller gone early does not fail others.
+func (s *coalescedStorage) Get(ctx context.Context, hash string) (string, error) {
+	ch := s.group.DoChan(hash, func() (interface{}, error) {
+		url, err := s.Storage.Get(detach(ctx), hash)
+		// error is kept in result, so it is not reported as singleflight failure
+		return getResult{url: url, err: err}, nil
+	})
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case r := <-ch:
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("coalesced", r.Shared))
+		res := r.Val.(getResult)
+		return res.url, res.err
+	}
+}
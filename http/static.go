@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// staticPrefix is path of static/ tree, relative like other links of pages
+	staticPrefix = "static/"
+	// fingerprintLength is number of hex digits of content hash in asset urls
+	fingerprintLength = 12
+	// fingerprintParam carries fingerprint in asset urls
+	fingerprintParam = "v"
+)
+
+//go:embed static
+var staticFiles embed.FS
+
+// staticAssets serves static/ tree, templates link assets by asset function
+var staticAssets = mustAssets(newAssets(staticFiles, "static"))
+
+// templateFuncs are functions of page templates
+var templateFuncs = map[string]interface{}{
+	"asset": staticAssets.Path,
+}
+
+type asset struct {
+	content     []byte
+	fingerprint string
+}
+
+// assets keeps files of static tree in memory with fingerprints of their
+// contents. Fingerprinted urls never change content, so browsers cache them
+// forever, while a new build changes urls of changed files.
+type assets struct {
+	files map[string]asset
+}
+
+// newAssets reads files under dir of fsys
+func newAssets(fsys fs.FS, dir string) (*assets, error) {
+	a := &assets{
+		files: make(map[string]asset),
+	}
+	err := fs.WalkDir(fsys, dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(content)
+		a.files[strings.TrimPrefix(name, dir+"/")] = asset{
+			content:     content,
+			fingerprint: hex.EncodeToString(sum[:])[:fingerprintLength],
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func mustAssets(a *assets, err error) *assets {
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// Path returns fingerprinted url of file of static tree, e.g.
+// static/css/index.css?v=0123456789ab. Unknown file gets url without
+// fingerprint, which answers 404.
+func (a *assets) Path(name string) string {
+	f, ok := a.files[name]
+	if !ok {
+		return staticPrefix + name
+	}
+	return staticPrefix + name + "?" + fingerprintParam + "=" + f.fingerprint
+}
+
+// ServeHTTP serves GET /static/{path}. Requests with current fingerprint are
+// cacheable forever, others revalidate by ETag, so stale fingerprints of old
+// pages get current file without pinning it in caches.
+func (a *assets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean(mux.Vars(r)["path"])
+	f, ok := a.files[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("ETag", `"`+f.fingerprint+`"`)
+	if r.URL.Query().Get(fingerprintParam) == f.fingerprint {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	// content type by extension, If-None-Match and ranges are handled by
+	// ServeContent; embedded files have no modification time, so ETag is the
+	// only validator
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(f.content))
+}
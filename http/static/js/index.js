@@ -0,0 +1,64 @@
+// state-changing requests carry CSRF token issued with the page
+const csrfHeaders = {"X-CSRF-Token": document.querySelector('meta[name="csrf-token"]').content};
+
+(function (){
+    const loginForm = document.getElementById("login-form");
+    const loginButton = document.getElementById("login-form-submit");
+    const errorMsg = document.getElementById("error-msg");
+    const errorMsgHolder = document.getElementById("error-msg-holder");
+    const sourceHolder = document.getElementById("source-holder");
+    const shortenHolder = document.getElementById("shorten-holder");
+
+    loginButton.addEventListener("click", async (e) => {
+        e.preventDefault();
+        let response = await fetch("login", {
+            method: 'post',
+            headers: csrfHeaders,
+            body: JSON.stringify({
+                username: loginForm.username.value,
+                password: loginForm.password.value
+            }),
+        });
+        if (response.ok) {
+            errorMsgHolder.setAttribute("class", [...new Set(errorMsgHolder.className.split(" ").concat("hide"))].join(" "));
+            sourceHolder.setAttribute("class", sourceHolder.className.split(" ").filter(c => c != "hide").join(" "));
+            sourceHolder.setAttribute("class", sourceHolder.className.split(" ").filter(c => c != "hide").join(" "));
+            shortenHolder.setAttribute("class", shortenHolder.className.split(" ").filter(c => c != "hide").join(" "));
+            loginForm.setAttribute("class", loginForm.className.split(" ").concat("hide").join(" "));
+        } else {
+            errorMsgHolder.setAttribute("class", errorMsgHolder.className.split(" ").filter(c => c != "hide").join(" "));
+            errorMsg.innerText = await response.text();
+        }
+    })
+})()
+
+(function (){
+    const source = document.getElementById("source");
+    const shorten = document.getElementById("shorten");
+    const errorMsg = document.getElementById("error-msg");
+    const errorMsgHolder = document.getElementById("error-msg-holder");
+
+    source.oninput = async function(e) {
+        e.preventDefault();
+
+        const shortenSource = () => fetch("shorten", {
+            method: 'post',
+            headers: csrfHeaders,
+            body: source.value,
+        });
+        let response = await shortenSource();
+        if (response.status == 401 && (await fetch("refresh", {method: 'post', headers: csrfHeaders})).ok) {
+            // session token expired, refresh cookie renewed it
+            response = await shortenSource();
+        }
+        if (response.ok) {
+            errorMsgHolder.setAttribute("class", [...new Set(errorMsgHolder.className.split(" ").concat("hide"))].join(" "));
+            let hash = await response.text();
+            shorten.innerText = window.location.protocol + '//' + window.location.host + window.location.pathname + hash;
+            shorten.setAttribute("href", window.location.protocol + '//' + window.location.host + window.location.pathname + hash);
+        } else {
+            errorMsgHolder.setAttribute("class", errorMsgHolder.className.split(" ").filter(c => c != "hide").join(" "));
+            errorMsg.innerText = await response.text();
+        }
+    };
+})()
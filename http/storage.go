@@ -2,97 +2,788 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slog"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
+	"github.com/asmyasnikov/webinar-jaeger/internal/identity"
+	"github.com/asmyasnikov/webinar-jaeger/internal/keepalive"
+	"github.com/asmyasnikov/webinar-jaeger/internal/requestid"
+	"github.com/asmyasnikov/webinar-jaeger/internal/retry"
+	"github.com/asmyasnikov/webinar-jaeger/internal/serviceauth"
 	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
 )
 
+const (
+	// backfillTimeout bounds background put of link into caches
+	backfillTimeout = time.Second
+	// rollbackTimeout bounds removal of links from durable storages which
+	// stored them before another durable storage rejected them
+	rollbackTimeout = 5 * time.Second
+)
+
+// readConsistencies are values of StoragesConfig.ReadConsistency, empty
+// value leaves choice to storage deployment
+var readConsistencies = map[string]pb.Consistency{
+	"":       pb.Consistency_CONSISTENCY_UNSPECIFIED,
+	"strong": pb.Consistency_CONSISTENCY_STRONG,
+	"stale":  pb.Consistency_CONSISTENCY_STALE,
+}
+
+var (
+	errExpired   = errors.New("link expired")
+	errCollision = errors.New("hash already used by another url")
+	errNotFound  = errors.New("link not found")
+	// errForbidden means link is owned by another user
+	errForbidden = errors.New("link owned by another user")
+	// errQuotaExceeded means user already has as many links as allowed
+	errQuotaExceeded = errors.New("link quota exceeded")
+	// errDomainBanned means url points to domain banned by admin
+	errDomainBanned = errors.New("domain is banned")
+	// errInvalidRequest means storage rejected request as malformed
+	errInvalidRequest = errors.New("invalid request")
+	// errUnavailable means storage could not reach its database or no
+	// storage answered
+	errUnavailable = errors.New("storage unavailable")
+)
+
+type Link struct {
+	Hash string `json:"hash"`
+	URL  string `json:"url"`
+	// Title and Favicon of destination page are empty until they are fetched
+	Title   string `json:"title,omitempty"`
+	Favicon string `json:"favicon,omitempty"`
+}
+
+// AdminLink is link with its owner, empty for links stored on behalf of
+// nobody
+type AdminLink struct {
+	Hash  string `json:"hash"`
+	URL   string `json:"url"`
+	Owner string `json:"owner,omitempty"`
+}
+
+// LinkMetadata is title and favicon url of destination page of link
+type LinkMetadata struct {
+	Title   string
+	Favicon string
+}
+
+// LinkDetails is link with its owner, creation time and metadata of
+// destination, shown on preview page
+type LinkDetails struct {
+	URL   string
+	Owner string
+	// Title and Favicon of destination page are empty until they are fetched
+	Title   string
+	Favicon string
+	// CreatedAt is zero for links stored before creation times were kept
+	CreatedAt time.Time
+	// ExpiresAt is zero for links which never expire
+	ExpiresAt time.Time
+}
+
+// LinkFilter selects links listed by admins, empty fields match every link
+type LinkFilter struct {
+	Owner string
+	// Domain matches links to the domain and its subdomains
+	Domain string
+	// URL matches links with url containing it
+	URL string
+}
+
+// StorageStats is capacity overview of one storage service
+type StorageStats struct {
+	Address string `json:"address"`
+	// Tier is cache or durable
+	Tier      string          `json:"tier"`
+	Backend   string          `json:"backend,omitempty"`
+	RowCount  uint64          `json:"row_count"`
+	SizeBytes uint64          `json:"size_bytes"`
+	Latencies []MethodLatency `json:"latencies"`
+	// Error is set if storage did not answer, other fields are empty then
+	Error string `json:"error,omitempty"`
+}
+
+// CacheStats is content and effectiveness of one cache service since its start
+type CacheStats struct {
+	Address     string            `json:"address"`
+	Mode        string            `json:"mode,omitempty"`
+	Policy      string            `json:"policy,omitempty"`
+	Links       uint64            `json:"links"`
+	Hits        uint64            `json:"hits"`
+	Misses      uint64            `json:"misses"`
+	HitRatio    float64           `json:"hit_ratio"`
+	Evictions   map[string]uint64 `json:"evictions"`
+	Bytes       uint64            `json:"bytes"`
+	MemoryLimit uint64            `json:"memory_limit"`
+	// Error is set if cache did not answer, other fields are empty then
+	Error string `json:"error,omitempty"`
+}
+
+type MethodLatency struct {
+	Method string  `json:"method"`
+	Count  uint64  `json:"count"`
+	P50Ms  float64 `json:"p50_ms"`
+	P90Ms  float64 `json:"p90_ms"`
+	P99Ms  float64 `json:"p99_ms"`
+}
+
 type Storage interface {
 	Close() error
 	Get(ctx context.Context, hash string) (url string, err error)
-	Put(ctx context.Context, url, hash string) (err error)
+	// GetByURL returns not expired link to url, errNotFound if there is none
+	GetByURL(ctx context.Context, url string) (hash string, expiresAt time.Time, err error)
+	// Put stores link, zero expiresAt means link never expires
+	Put(ctx context.Context, url, hash string, expiresAt time.Time) (err error)
+	// BatchPut stores links at once. Result i is true if hash of links[i]
+	// already used by another url and the link was not stored.
+	BatchPut(ctx context.Context, links []Link, expiresAt time.Time) (collisions []bool, err error)
+	Delete(ctx context.Context, hash string) (err error)
+	// Owner returns user who stored link of hash according to durable tier,
+	// empty for links stored on behalf of nobody, errNotFound if there is no
+	// link
+	Owner(ctx context.Context, hash string) (owner string, err error)
+	// Details returns link of hash with its owner and creation time according
+	// to durable tier, errExpired if link expired, errNotFound if there is
+	// no link
+	Details(ctx context.Context, hash string) (details LinkDetails, err error)
+	List(ctx context.Context, pageSize int, pageToken string) (links []Link, nextPageToken string, err error)
+	// PutAlias claims alias of link of hash for user of ctx on every durable
+	// storage, errForbidden if link is owned by another user, errNotFound if
+	// there is no link
+	PutAlias(ctx context.Context, alias, hash string) error
+	// GetByAlias returns link of alias claimed by user according to durable
+	// tier, errExpired if link expired, errNotFound if there is no alias
+	GetByAlias(ctx context.Context, user, alias string) (hash, url string, err error)
+	// SetMetadata stores metadata of destination of link on every durable
+	// storage, link which points to another url by then is left as is
+	SetMetadata(ctx context.Context, hash, url string, m LinkMetadata) error
+	// AdminList lists links of every user matching filter. Page may hold
+	// fewer links than asked while nextPageToken is not empty.
+	AdminList(ctx context.Context, filter LinkFilter, pageSize int, pageToken string) (links []AdminLink, nextPageToken string, err error)
+	// BanDomain bans domain, or lifts its ban, on every durable storage
+	BanDomain(ctx context.Context, domain string, banned bool) error
+	BannedDomains(ctx context.Context) (domains []string, err error)
+	// Check reports error if storage service is unreachable or not serving
+	Check(ctx context.Context) error
+	// Stats returns overview of every storage service, failure of one is
+	// reported in its StorageStats.Error
+	Stats(ctx context.Context) []StorageStats
+	// CacheStats returns stats of every cache, failure of one is reported in
+	// its CacheStats.Error
+	CacheStats(ctx context.Context) []CacheStats
 }
 
-type multiStorage []*storage
+// tieredStorage writes durable tier first and then populates caches, reads
+// caches first and then falls back to durable tier. Every link is cached by
+// one cache chosen by its hash.
+type tieredStorage struct {
+	caches []*storage
+	// shards maps hash of link to cache of link
+	shards  *ring
+	durable []*storage
+	// backfill puts links found in durable tier into caches which missed them
+	backfill bool
+	// hedgeDelay is how long Get waits for a storage before asking the next one
+	// in parallel, zero means the next storage is asked only after a failure
+	hedgeDelay time.Duration
+	// fanOut makes Get ask all storages at once
+	fanOut bool
+}
 
-func initStorages(ctx context.Context, tr trace.Tracer, addrs ...string) (Storage, error) {
-	if len(addrs) == 1 {
-		return newStorage(ctx, tr, addrs[0])
+func initStorages(ctx context.Context, tr trace.Tracer, creds credentials.TransportCredentials, ka keepalive.Config, serviceKey string, cfg StoragesConfig, retryCfg retry.Config) (_ Storage, err error) {
+	if len(cfg.StorageAddrs) == 0 {
+		return nil, errors.New("at least one durable storage address is required")
+	}
+	consistency, ok := readConsistencies[cfg.ReadConsistency]
+	if !ok {
+		return nil, fmt.Errorf("unknown read consistency '%s'", cfg.ReadConsistency)
+	}
+	// single storage has nobody to hedge or fan out with
+	if len(cfg.CacheAddrs) == 0 && len(cfg.StorageAddrs) == 1 {
+		s, err := newStorage(ctx, tr, creds, ka, serviceKey, cfg.StorageAddrs[0], cfg.StorageTimeout, consistency, retryCfg)
+		if err != nil {
+			return nil, err
+		}
+		return newCoalescedStorage(s), nil
+	}
+	ts := &tieredStorage{
+		backfill:   cfg.CacheBackfill,
+		hedgeDelay: cfg.HedgeDelay,
+		fanOut:     cfg.StorageFanOut,
+	}
+	defer func() {
+		if err != nil {
+			_ = ts.Close()
+		}
+	}()
+	for _, addr := range cfg.CacheAddrs {
+		s, err := newStorage(ctx, tr, creds, ka, serviceKey, addr, cfg.CacheTimeout, consistency, retryCfg)
+		if err != nil {
+			return nil, err
+		}
+		ts.caches = append(ts.caches, s)
 	}
-	ss := make([]*storage, 0, len(addrs))
-	for _, addr := range addrs {
-		s, err := newStorage(ctx, tr, addr)
+	ts.shards = newRing(ts.caches)
+	for _, addr := range cfg.StorageAddrs {
+		s, err := newStorage(ctx, tr, creds, ka, serviceKey, addr, cfg.StorageTimeout, consistency, retryCfg)
 		if err != nil {
 			return nil, err
 		}
-		ss = append(ss, s)
+		ts.durable = append(ts.durable, s)
+	}
+	return newCoalescedStorage(ts), nil
+}
+
+func (ts *tieredStorage) all() []*storage {
+	return append(append(make([]*storage, 0, len(ts.caches)+len(ts.durable)), ts.caches...), ts.durable...)
+}
+
+// shard returns cache of link of hash, none if there are no caches
+func (ts *tieredStorage) shard(hash string) []*storage {
+	if s := ts.shards.shard(hash); s != nil {
+		return []*storage{s}
 	}
-	return multiStorage(ss), nil
+	return nil
 }
 
-func (ss multiStorage) Close() error {
-	errs := make([]error, 0, len(ss))
-	for _, s := range ss {
+func (ts *tieredStorage) Close() error {
+	errs := make([]error, 0, len(ts.caches)+len(ts.durable))
+	for _, s := range ts.all() {
 		err := s.Close()
 		if err != nil {
 			errs = append(errs, err)
 		}
 	}
 	if len(errs) > 0 {
-		return fmt.Errorf("get failed: %v", errs)
+		return fmt.Errorf("close failed: %v", errs)
 	}
 	return nil
 }
 
-func (ss multiStorage) Get(ctx context.Context, hash string) (url string, err error) {
-	errs := make([]error, 0, len(ss))
-	for _, s := range ss {
-		url, err = s.Get(ctx, hash)
+// Get returns link from cache of its hash if it has the link, otherwise from
+// the first durable storage which answers. Link found in durable tier is put
+// into the cache in background, so the next lookup is served by cache.
+// Storage which answered is recorded as storage.winner span attribute.
+func (ts *tieredStorage) Get(ctx context.Context, hash string) (url string, err error) {
+	r, errs := ts.lookup(ctx, hash)
+	if r.storage == nil {
+		if unavailable(errs) {
+			return "", fmt.Errorf("%w: get failed: %v", errUnavailable, errs)
+		}
+		return "", fmt.Errorf("get failed: %v", errs)
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("storage.winner", r.storage.addr))
+	if r.err == nil && r.durable && ts.backfill && len(ts.caches) > 0 {
+		go ts.fill(detach(ctx), r.url, hash, r.expiresAt)
+	}
+	return r.url, r.err
+}
+
+type lookupResult struct {
+	storage   *storage
+	durable   bool
+	url       string
+	expiresAt time.Time
+	err       error
+}
+
+// serving returns storages which health service reports serving
+func serving(storages []*storage) []*storage {
+	res := make([]*storage, 0, len(storages))
+	for _, s := range storages {
+		if s.isServing() {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// lookup asks serving cache of hash and then serving durable storages and returns the first answer which
+// is found or expired link, miss of durable storage or rejected hash, with
+// errors of storages which failed before.
+// The next storage is asked after a failure or, if hedging is enabled, every
+// hedgeDelay while no answer is found. In fan-out mode all storages are asked
+// at once. Lookups still running on return are cancelled.
+func (ts *tieredStorage) lookup(ctx context.Context, hash string) (_ lookupResult, errs []error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	shard := ts.shard(hash)
+	for _, s := range shard {
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("cache.shard", s.addr))
+	}
+	caches, durable := serving(shard), serving(ts.durable)
+	if len(caches)+len(durable) == 0 {
+		// health may lag behind recovery, so asking anyway beats failing at once
+		caches, durable = shard, ts.durable
+	}
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.Int("storage.skipped", len(shard)+len(ts.durable)-len(caches)-len(durable)),
+	)
+	storages := append(append(make([]*storage, 0, len(caches)+len(durable)), caches...), durable...)
+	results := make(chan lookupResult, len(storages))
+	next, pending := 0, 0
+	ask := func() {
+		s, durable := storages[next], next >= len(caches)
+		next++
+		pending++
+		go func() {
+			url, expiresAt, err := s.get(ctx, hash)
+			results <- lookupResult{storage: s, durable: durable, url: url, expiresAt: expiresAt, err: err}
+		}()
+	}
+
+	var hedge <-chan time.Time
+	if ts.hedgeDelay > 0 {
+		ticker := time.NewTicker(ts.hedgeDelay)
+		defer ticker.Stop()
+		hedge = ticker.C
+	}
+
+	ask()
+	for ts.fanOut && next < len(storages) {
+		ask()
+	}
+	for pending > 0 {
+		if next == len(storages) {
+			hedge = nil
+		}
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil || errors.Is(r.err, errExpired) || errors.Is(r.err, errInvalidRequest) ||
+				r.durable && errors.Is(r.err, errNotFound) {
+				return r, errs
+			}
+			errs = append(errs, r.err)
+			if next < len(storages) {
+				ask()
+			}
+		case <-hedge:
+			ask()
+		}
+	}
+	return lookupResult{}, errs
+}
+
+// GetByURL asks caches and then durable tier. Links are sharded by hash, which
+// is not known yet, so every cache is asked. Caches hold part of links, so
+// their miss proves nothing and only durable tier reports errNotFound. Caches
+// do not know link owners, so lookups on behalf of user skip them.
+func (ts *tieredStorage) GetByURL(ctx context.Context, url string) (hash string, expiresAt time.Time, err error) {
+	caches := ts.caches
+	if identity.FromContext(ctx) != "" {
+		caches = nil
+	}
+	for _, s := range caches {
+		hash, expiresAt, err = s.GetByURL(ctx, url)
 		if err == nil {
-			return url, err
+			return hash, expiresAt, nil
+		}
+	}
+	errs := make([]error, 0, len(ts.durable))
+	for _, s := range ts.durable {
+		hash, expiresAt, err = s.GetByURL(ctx, url)
+		if err == nil || errors.Is(err, errNotFound) {
+			return hash, expiresAt, err
 		}
 		errs = append(errs, err)
 	}
-	return "", fmt.Errorf("get failed: %v", errs)
+	if unavailable(errs) {
+		return "", time.Time{}, fmt.Errorf("%w: get by url failed: %v", errUnavailable, errs)
+	}
+	return "", time.Time{}, fmt.Errorf("get by url failed: %v", errs)
+}
+
+// unavailable reports whether lookup failed only because storages were
+// unavailable, misses of caches aside
+func unavailable(errs []error) bool {
+	found := false
+	for _, err := range errs {
+		switch {
+		case errors.Is(err, errUnavailable):
+			found = true
+		case !errors.Is(err, errNotFound):
+			return false
+		}
+	}
+	return found
+}
+
+// fill puts link into its cache, failures are only logged
+func (ts *tieredStorage) fill(ctx context.Context, url, hash string, expiresAt time.Time) {
+	ctx, cancel := context.WithTimeout(ctx, backfillTimeout)
+	defer cancel()
+	for _, s := range ts.shard(hash) {
+		if err := s.Put(ctx, url, hash, expiresAt); err != nil {
+			slog.WarnCtx(ctx, "backfill cache failed", slog.String("address", s.addr), slog.Any("error", err))
+		}
+	}
+}
+
+// Put writes link to every durable storage and then to its cache, so the link
+// rejected by durable tier never reaches caches. Link rejected by one durable
+// storage is removed from those which created it before, otherwise code
+// regenerated after collision would leave orphan link behind. Other failures
+// keep link where it is stored, as putting it again is idempotent. Cache
+// failures only cost a cache miss later, so they do not fail the request.
+func (ts *tieredStorage) Put(ctx context.Context, url, hash string, expiresAt time.Time) (err error) {
+	errs := make([]error, 0, len(ts.durable))
+	created := make(map[*storage][]string, len(ts.durable))
+	for _, s := range ts.durable {
+		isNew, err := s.put(ctx, url, hash, expiresAt)
+		if errors.Is(err, errCollision) || errors.Is(err, errQuotaExceeded) || errors.Is(err, errDomainBanned) {
+			ts.rollback(ctx, created)
+			return err
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if isNew {
+			created[s] = []string{hash}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("put failed: %v", errs)
+	}
+	for _, s := range ts.shard(hash) {
+		if err = s.Put(ctx, url, hash, expiresAt); err != nil {
+			slog.WarnCtx(ctx, "populate cache failed", slog.String("address", s.addr), slog.Any("error", err))
+		}
+	}
+	return nil
+}
+
+// BatchPut writes links to durable tier and then puts accepted ones to their
+// caches, one batch per cache. Collisions are reported by the first durable
+// storage. Like Put, batch rejected by one durable storage is removed from
+// those which created its links before.
+func (ts *tieredStorage) BatchPut(ctx context.Context, links []Link, expiresAt time.Time) (collisions []bool, err error) {
+	errs := make([]error, 0, len(ts.durable))
+	created := make(map[*storage][]string, len(ts.durable))
+	accepted := links
+	for _, s := range ts.durable {
+		c, isNew, err := s.batchPut(ctx, accepted, expiresAt)
+		if errors.Is(err, errQuotaExceeded) || errors.Is(err, errDomainBanned) {
+			ts.rollback(ctx, created)
+			return nil, err
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for j := range accepted {
+			if isNew[j] {
+				created[s] = append(created[s], accepted[j].Hash)
+			}
+		}
+		if collisions == nil {
+			collisions = c
+			accepted = make([]Link, 0, len(links))
+			for j := range links {
+				if !collisions[j] {
+					accepted = append(accepted, links[j])
+				}
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("batch put failed: %v", errs)
+	}
+	shards := make(map[*storage][]Link, len(ts.caches))
+	for _, l := range accepted {
+		for _, s := range ts.shard(l.Hash) {
+			shards[s] = append(shards[s], l)
+		}
+	}
+	for _, s := range ts.caches {
+		if len(shards[s]) == 0 {
+			continue
+		}
+		if _, err = s.BatchPut(ctx, shards[s], expiresAt); err != nil {
+			slog.WarnCtx(ctx, "populate cache failed", slog.String("address", s.addr), slog.Any("error", err))
+		}
+	}
+	return collisions, nil
+}
+
+// rollback removes links from durable storages which created them before
+// another durable storage rejected them. Storages report links they already
+// had as not created, so links stored before the request are never removed.
+// Failures are only logged, as the request fails anyway.
+func (ts *tieredStorage) rollback(ctx context.Context, created map[*storage][]string) {
+	if len(created) == 0 {
+		return
+	}
+	// request may be canceled already
+	ctx, cancel := context.WithTimeout(detach(ctx), rollbackTimeout)
+	defer cancel()
+	for s, hashes := range created {
+		for _, hash := range hashes {
+			if err := s.Delete(ctx, hash); err != nil && !errors.Is(err, errNotFound) {
+				slog.WarnCtx(ctx, "roll back put failed", slog.String("address", s.addr), slog.String("hash", hash), slog.Any("error", err))
+			}
+		}
+	}
 }
 
-func (ss multiStorage) Put(ctx context.Context, url, hash string) (err error) {
-	errs := make([]error, 0, len(ss))
-	for _, s := range ss {
-		err = s.Put(ctx, url, hash)
+// Delete removes link from durable tier and then invalidates it in caches.
+// Unlike Put, cache failure fails the request: the cache would keep serving
+// the link. Every cache is asked, as link may be cached by another cache
+// before caches were added or removed.
+func (ts *tieredStorage) Delete(ctx context.Context, hash string) (err error) {
+	errs := make([]error, 0, len(ts.caches)+len(ts.durable))
+	for _, s := range ts.durable {
+		err = s.Delete(ctx, hash)
+		if errors.Is(err, errForbidden) {
+			// handlers check owner before deleting, so storages get here only
+			// if they disagree on owner; link of another user stays in caches too
+			return err
+		}
 		if err != nil {
 			errs = append(errs, err)
 		}
 	}
+	for _, s := range ts.caches {
+		if err = s.Invalidate(ctx, hash); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("delete failed: %v", errs)
+	}
+	return nil
+}
+
+// Owner asks durable storages in order, caches do not keep owners
+func (ts *tieredStorage) Owner(ctx context.Context, hash string) (owner string, err error) {
+	errs := make([]error, 0, len(ts.durable))
+	for _, s := range ts.durable {
+		owner, err = s.Owner(ctx, hash)
+		if err == nil || errors.Is(err, errNotFound) {
+			return owner, err
+		}
+		errs = append(errs, err)
+	}
+	if unavailable(errs) {
+		return "", fmt.Errorf("%w: owner lookup failed: %v", errUnavailable, errs)
+	}
+	return "", fmt.Errorf("owner lookup failed: %v", errs)
+}
+
+// Details asks durable storages in order, caches keep neither owners nor
+// creation times
+func (ts *tieredStorage) Details(ctx context.Context, hash string) (details LinkDetails, err error) {
+	errs := make([]error, 0, len(ts.durable))
+	for _, s := range ts.durable {
+		details, err = s.Details(ctx, hash)
+		if err == nil || errors.Is(err, errNotFound) || errors.Is(err, errExpired) {
+			return details, err
+		}
+		errs = append(errs, err)
+	}
+	if unavailable(errs) {
+		return details, fmt.Errorf("%w: details lookup failed: %v", errUnavailable, errs)
+	}
+	return details, fmt.Errorf("details lookup failed: %v", errs)
+}
+
+// PutAlias claims alias on every durable storage, as aliases are resolved
+// by durable tier only. Ownership and missing links are checked by the first
+// storage already.
+func (ts *tieredStorage) PutAlias(ctx context.Context, alias, hash string) error {
+	errs := make([]error, 0, len(ts.durable))
+	for _, s := range ts.durable {
+		if err := s.PutAlias(ctx, alias, hash); err != nil {
+			if errors.Is(err, errForbidden) || errors.Is(err, errNotFound) || errors.Is(err, errInvalidRequest) {
+				return err
+			}
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("put alias failed: %v", errs)
+	}
+	return nil
+}
+
+// GetByAlias asks durable storages in order, caches keep no aliases
+func (ts *tieredStorage) GetByAlias(ctx context.Context, user, alias string) (hash, url string, err error) {
+	errs := make([]error, 0, len(ts.durable))
+	for _, s := range ts.durable {
+		hash, url, err = s.GetByAlias(ctx, user, alias)
+		if err == nil || errors.Is(err, errNotFound) || errors.Is(err, errExpired) {
+			return hash, url, err
+		}
+		errs = append(errs, err)
+	}
+	if unavailable(errs) {
+		return "", "", fmt.Errorf("%w: alias lookup failed: %v", errUnavailable, errs)
+	}
+	return "", "", fmt.Errorf("alias lookup failed: %v", errs)
+}
+
+// Check requires every storage to be serving: cache outage would move
+// all reads to durable storage.
+func (ts *tieredStorage) Check(ctx context.Context) error {
+	errs := make([]error, 0, len(ts.caches)+len(ts.durable))
+	for _, s := range ts.all() {
+		if err := s.Check(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("check failed: %v", errs)
+	}
+	return nil
+}
+
+func (ts *tieredStorage) Stats(ctx context.Context) []StorageStats {
+	stats := make([]StorageStats, 0, len(ts.caches)+len(ts.durable))
+	for _, s := range ts.caches {
+		stats = append(stats, s.stats(ctx, "cache"))
+	}
+	for _, s := range ts.durable {
+		stats = append(stats, s.stats(ctx, "durable"))
+	}
+	return stats
+}
+
+func (ts *tieredStorage) CacheStats(ctx context.Context) []CacheStats {
+	stats := make([]CacheStats, 0, len(ts.caches))
+	for _, s := range ts.caches {
+		stats = append(stats, s.cacheStats(ctx))
+	}
+	return stats
+}
+
+// List returns page from the first durable storage which answers. Caches
+// hold only part of links, so they are never listed.
+func (ts *tieredStorage) List(ctx context.Context, pageSize int, pageToken string) (links []Link, nextPageToken string, err error) {
+	errs := make([]error, 0, len(ts.durable))
+	for _, s := range ts.durable {
+		links, nextPageToken, err = s.List(ctx, pageSize, pageToken)
+		if err == nil {
+			return links, nextPageToken, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, "", fmt.Errorf("list failed: %v", errs)
+}
+
+// AdminList returns page from the first durable storage which answers
+func (ts *tieredStorage) AdminList(ctx context.Context, filter LinkFilter, pageSize int, pageToken string) (links []AdminLink, nextPageToken string, err error) {
+	errs := make([]error, 0, len(ts.durable))
+	for _, s := range ts.durable {
+		links, nextPageToken, err = s.AdminList(ctx, filter, pageSize, pageToken)
+		if err == nil {
+			return links, nextPageToken, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, "", fmt.Errorf("admin list failed: %v", errs)
+}
+
+// BanDomain changes bans of every durable storage, each of them checks links
+// it stores. Caches store only links accepted by durable tier.
+func (ts *tieredStorage) BanDomain(ctx context.Context, domain string, banned bool) error {
+	errs := make([]error, 0, len(ts.durable))
+	for _, s := range ts.durable {
+		if err := s.BanDomain(ctx, domain, banned); err != nil {
+			if errors.Is(err, errInvalidRequest) {
+				return err
+			}
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("ban domain failed: %v", errs)
+	}
+	return nil
+}
+
+// SetMetadata stores metadata on every durable storage. Caches keep only urls
+// of links, so they are not told.
+func (ts *tieredStorage) SetMetadata(ctx context.Context, hash, url string, m LinkMetadata) error {
+	errs := make([]error, 0, len(ts.durable))
+	for _, s := range ts.durable {
+		if err := s.SetMetadata(ctx, hash, url, m); err != nil {
+			errs = append(errs, err)
+		}
+	}
 	if len(errs) > 0 {
-		return fmt.Errorf("get failed: %v", errs)
+		return fmt.Errorf("set metadata failed: %v", errs)
 	}
 	return nil
 }
 
+// BannedDomains returns bans of the first durable storage which answers
+func (ts *tieredStorage) BannedDomains(ctx context.Context) (domains []string, err error) {
+	errs := make([]error, 0, len(ts.durable))
+	for _, s := range ts.durable {
+		domains, err = s.BannedDomains(ctx)
+		if err == nil {
+			return domains, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, fmt.Errorf("list banned domains failed: %v", errs)
+}
+
 type storage struct {
 	tr     trace.Tracer
 	addr   string
 	conn   *grpc.ClientConn
 	client pb.StorageClient
+	// cache is served by cache tier only
+	cache pb.CacheClient
+	// admin is served by durable tier only
+	admin pb.AdminClient
+	// timeout bounds single get, put and delete, so one slow storage does not
+	// take the whole request budget. Batches and pages scale with size, so
+	// they are bounded by request context only.
+	timeout time.Duration
+	// consistency is requested by get, caches ignore it
+	consistency pb.Consistency
+	// serving is 1 while health service of storage reports SERVING
+	serving     uint32
+	stopWatches context.CancelFunc
 }
 
-func newStorage(ctx context.Context, tr trace.Tracer, addr string) (*storage, error) {
+func newStorage(ctx context.Context, tr trace.Tracer, creds credentials.TransportCredentials, ka keepalive.Config, serviceKey, addr string, timeout time.Duration, consistency pb.Consistency, retryCfg retry.Config) (*storage, error) {
 	_, span := tr.Start(ctx, "newStorage", trace.WithAttributes(
 		attribute.String("address", addr),
 	))
 	defer span.End()
 
-	conn, err := grpc.DialContext(ctx, addr,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor()),
-	)
+	target, opts := storageTarget(addr)
+	conn, err := grpc.DialContext(ctx, target, append(opts,
+		grpc.WithTransportCredentials(creds),
+		ka.DialOption(),
+		grpc.WithChainUnaryInterceptor(
+			retry.UnaryClientInterceptor(retryCfg,
+				"/"+pb.Storage_ServiceDesc.ServiceName+"/Get",
+				"/"+pb.Cache_ServiceDesc.ServiceName+"/Invalidate",
+			),
+			otelgrpc.UnaryClientInterceptor(),
+			requestid.UnaryClientInterceptor(),
+			identity.UnaryClientInterceptor(),
+			serviceauth.UnaryClientInterceptor(serviceKey),
+		),
+	)...)
 	if err != nil {
 		span.RecordError(err)
 		return nil, err
@@ -100,19 +791,51 @@ func newStorage(ctx context.Context, tr trace.Tracer, addr string) (*storage, er
 
 	span.AddEvent("connected")
 
-	return &storage{
-		tr:     tr,
-		addr:   addr,
-		conn:   conn,
-		client: pb.NewStorageClient(conn),
-	}, nil
+	watchCtx, stopWatches := context.WithCancel(context.Background())
+	s := &storage{
+		tr:          tr,
+		addr:        addr,
+		conn:        conn,
+		client:      pb.NewStorageClient(conn),
+		cache:       pb.NewCacheClient(conn),
+		admin:       pb.NewAdminClient(conn),
+		timeout:     timeout,
+		consistency: consistency,
+		serving:     1,
+		stopWatches: stopWatches,
+	}
+	go s.watchHealth(watchCtx)
+	go watchConnState(watchCtx, tr, "storage", addr, conn)
+
+	return s, nil
 }
 
 func (a *storage) Close() error {
+	a.stopWatches()
 	return a.conn.Close()
 }
 
+func (a *storage) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if a.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, a.timeout)
+}
+
+func (a *storage) Check(ctx context.Context) error {
+	if err := checkHealth(ctx, a.conn, pb.Storage_ServiceDesc.ServiceName); err != nil {
+		return fmt.Errorf("storage '%s': %w", a.addr, err)
+	}
+	return nil
+}
+
 func (a *storage) Get(ctx context.Context, hash string) (url string, err error) {
+	url, _, err = a.get(ctx, hash)
+	return url, err
+}
+
+// get returns link with its expiration time, zero if link never expires
+func (a *storage) get(ctx context.Context, hash string) (url string, expiresAt time.Time, err error) {
 	ctx, span := a.tr.Start(ctx, "get", trace.WithAttributes(
 		attribute.String("address", a.addr),
 	))
@@ -128,17 +851,68 @@ func (a *storage) Get(ctx context.Context, hash string) (url string, err error)
 		span.End()
 	}()
 
+	ctx, cancel := a.withTimeout(ctx)
+	defer cancel()
+
 	response, err := a.client.Get(ctx, &pb.GetRequest{
-		Hash: hash,
+		Hash:        hash,
+		Consistency: a.consistency,
+	})
+	if err != nil {
+		return url, expiresAt, storageError(err)
+	}
+
+	if response.GetExpiresAt() != nil {
+		expiresAt = response.GetExpiresAt().AsTime()
+		if expiresAt.Before(time.Now()) {
+			return "", expiresAt, errExpired
+		}
+	}
+
+	return response.GetUrl(), expiresAt, nil
+}
+
+func (a *storage) GetByURL(ctx context.Context, url string) (hash string, expiresAt time.Time, err error) {
+	ctx, span := a.tr.Start(ctx, "get by url", trace.WithAttributes(
+		attribute.String("address", a.addr),
+		attribute.String("url", url),
+	))
+	defer func() {
+		if err != nil && !errors.Is(err, errNotFound) {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("get by url done", trace.WithAttributes(
+				attribute.String("hash", hash),
+			))
+		}
+		span.End()
+	}()
+
+	ctx, cancel := a.withTimeout(ctx)
+	defer cancel()
+
+	response, err := a.client.GetByURL(ctx, &pb.GetByURLRequest{
+		Url: url,
 	})
 	if err != nil {
-		return url, err
+		return "", expiresAt, storageError(err)
+	}
+
+	if response.GetExpiresAt() != nil {
+		expiresAt = response.GetExpiresAt().AsTime()
 	}
 
-	return response.GetUrl(), nil
+	return response.GetHash(), expiresAt, nil
+}
+
+func (a *storage) Put(ctx context.Context, url, hash string, expiresAt time.Time) error {
+	_, err := a.put(ctx, url, hash, expiresAt)
+	return err
 }
 
-func (a *storage) Put(ctx context.Context, url, hash string) (err error) {
+// put stores link, created is false if storage already had the same link
+func (a *storage) put(ctx context.Context, url, hash string, expiresAt time.Time) (created bool, err error) {
 	ctx, span := a.tr.Start(ctx, "get", trace.WithAttributes(
 		attribute.String("address", a.addr),
 	))
@@ -154,10 +928,587 @@ func (a *storage) Put(ctx context.Context, url, hash string) (err error) {
 		span.End()
 	}()
 
-	_, err = a.client.Put(ctx, &pb.PutRequest{
+	request := &pb.PutRequest{
 		Url:  url,
 		Hash: hash,
-	})
+	}
+	if !expiresAt.IsZero() {
+		request.ExpiresAt = timestamppb.New(expiresAt)
+	}
+
+	ctx, cancel := a.withTimeout(ctx)
+	defer cancel()
+
+	response, err := a.client.Put(ctx, request)
+	if err != nil {
+		return false, storageError(err)
+	}
+	return response.GetCreated(), nil
+}
+
+// reasonErrors are errors of ErrorDetail reasons sent by storage
+var reasonErrors = map[pb.ErrorReason]error{
+	pb.ErrorReason_ERROR_REASON_NOT_FOUND:       errNotFound,
+	pb.ErrorReason_ERROR_REASON_INVALID_REQUEST: errInvalidRequest,
+	pb.ErrorReason_ERROR_REASON_HASH_COLLISION:  errCollision,
+	pb.ErrorReason_ERROR_REASON_NOT_OWNER:       errForbidden,
+	pb.ErrorReason_ERROR_REASON_QUOTA_EXCEEDED:  errQuotaExceeded,
+	pb.ErrorReason_ERROR_REASON_DOMAIN_BANNED:   errDomainBanned,
+	pb.ErrorReason_ERROR_REASON_UNAVAILABLE:     errUnavailable,
+	pb.ErrorReason_ERROR_REASON_TIMEOUT:         errUnavailable,
+}
+
+// codeErrors are errors of status codes of storage which sends no ErrorDetail
+var codeErrors = map[codes.Code]error{
+	codes.NotFound:          errNotFound,
+	codes.InvalidArgument:   errInvalidRequest,
+	codes.AlreadyExists:     errCollision,
+	codes.PermissionDenied:  errForbidden,
+	codes.ResourceExhausted: errQuotaExceeded,
+	codes.Unavailable:       errUnavailable,
+}
+
+// detailError is failure of storage explained by ErrorDetail. It renders as
+// message of the detail, which reveals no internals of storage, and matches
+// error of the reason with errors.Is.
+type detailError struct {
+	err     error
+	message string
+}
 
+func (e *detailError) Error() string {
+	return e.message
+}
+
+func (e *detailError) Unwrap() error {
+	return e.err
+}
+
+// storageError turns status error of storage into one of errors above by
+// reason of attached ErrorDetail, or by status code if there is none
+func storageError(err error) error {
+	if detail := errorDetail(err); detail != nil {
+		if e, ok := reasonErrors[detail.GetReason()]; ok {
+			return &detailError{err: e, message: detail.GetMessage()}
+		}
+	}
+	if e, ok := codeErrors[status.Code(err)]; ok {
+		return fmt.Errorf("%w: %v", e, err)
+	}
 	return err
 }
+
+// errorDetail returns ErrorDetail attached to status error, nil if there is
+// none
+func errorDetail(err error) *pb.ErrorDetail {
+	for _, d := range status.Convert(err).Details() {
+		if detail, ok := d.(*pb.ErrorDetail); ok {
+			return detail
+		}
+	}
+	return nil
+}
+
+func (a *storage) BatchPut(ctx context.Context, links []Link, expiresAt time.Time) (collisions []bool, err error) {
+	collisions, _, err = a.batchPut(ctx, links, expiresAt)
+	return collisions, err
+}
+
+// batchPut stores links, created[i] is false if storage already had links[i]
+// or it collided
+func (a *storage) batchPut(ctx context.Context, links []Link, expiresAt time.Time) (collisions, created []bool, err error) {
+	ctx, span := a.tr.Start(ctx, "batch put", trace.WithAttributes(
+		attribute.String("address", a.addr),
+		attribute.Int("count", len(links)),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("batch put successful")
+		}
+		span.End()
+	}()
+
+	request := &pb.BatchPutRequest{
+		Links: make([]*pb.PutRequest, 0, len(links)),
+	}
+	for _, l := range links {
+		link := &pb.PutRequest{
+			Url:  l.URL,
+			Hash: l.Hash,
+		}
+		if !expiresAt.IsZero() {
+			link.ExpiresAt = timestamppb.New(expiresAt)
+		}
+		request.Links = append(request.Links, link)
+	}
+
+	response, err := a.client.BatchPut(ctx, request)
+	if err != nil {
+		return nil, nil, storageError(err)
+	}
+
+	if len(response.GetResults()) != len(links) {
+		return nil, nil, fmt.Errorf("unexpected batch put results count %d, expected %d", len(response.GetResults()), len(links))
+	}
+
+	collisions = make([]bool, 0, len(links))
+	created = make([]bool, 0, len(links))
+	for _, r := range response.GetResults() {
+		collisions = append(collisions, r.GetCollision())
+		created = append(created, r.GetCreated())
+	}
+
+	return collisions, created, nil
+}
+
+func (a *storage) Delete(ctx context.Context, hash string) (err error) {
+	ctx, span := a.tr.Start(ctx, "delete", trace.WithAttributes(
+		attribute.String("address", a.addr),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("delete successful", trace.WithAttributes(
+				attribute.String("hash", hash),
+			))
+		}
+		span.End()
+	}()
+
+	ctx, cancel := a.withTimeout(ctx)
+	defer cancel()
+
+	_, err = a.client.Delete(ctx, &pb.DeleteRequest{
+		Hash: hash,
+	})
+
+	return storageError(err)
+}
+
+// Owner reads link with strong consistency, so links stored a moment ago by
+// another user are not taken for missing
+func (a *storage) Owner(ctx context.Context, hash string) (owner string, err error) {
+	ctx, span := a.tr.Start(ctx, "owner", trace.WithAttributes(
+		attribute.String("address", a.addr),
+		attribute.String("hash", hash),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("owner found", trace.WithAttributes(
+				attribute.String("owner", owner),
+			))
+		}
+		span.End()
+	}()
+
+	ctx, cancel := a.withTimeout(ctx)
+	defer cancel()
+
+	response, err := a.client.Get(ctx, &pb.GetRequest{
+		Hash:        hash,
+		Consistency: pb.Consistency_CONSISTENCY_STRONG,
+	})
+	if err != nil {
+		return "", storageError(err)
+	}
+	return response.GetOwner(), nil
+}
+
+func (a *storage) Details(ctx context.Context, hash string) (details LinkDetails, err error) {
+	ctx, span := a.tr.Start(ctx, "details", trace.WithAttributes(
+		attribute.String("address", a.addr),
+		attribute.String("hash", hash),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("details found", trace.WithAttributes(
+				attribute.String("url", details.URL),
+				attribute.String("owner", details.Owner),
+			))
+		}
+		span.End()
+	}()
+
+	ctx, cancel := a.withTimeout(ctx)
+	defer cancel()
+
+	response, err := a.client.Get(ctx, &pb.GetRequest{
+		Hash:        hash,
+		Consistency: a.consistency,
+	})
+	if err != nil {
+		return details, storageError(err)
+	}
+
+	details = LinkDetails{
+		URL:     response.GetUrl(),
+		Owner:   response.GetOwner(),
+		Title:   response.GetMetadata().GetTitle(),
+		Favicon: response.GetMetadata().GetFaviconUrl(),
+	}
+	if response.GetCreatedAt() != nil {
+		details.CreatedAt = response.GetCreatedAt().AsTime()
+	}
+	if response.GetExpiresAt() != nil {
+		details.ExpiresAt = response.GetExpiresAt().AsTime()
+		if details.ExpiresAt.Before(time.Now()) {
+			return LinkDetails{}, errExpired
+		}
+	}
+	return details, nil
+}
+
+// Invalidate drops cached copy of link of hash from cache. Cache which does
+// not serve Cache service yet is asked to delete the link instead.
+func (a *storage) Invalidate(ctx context.Context, hash string) (err error) {
+	ctx, span := a.tr.Start(ctx, "invalidate", trace.WithAttributes(
+		attribute.String("address", a.addr),
+		attribute.String("hash", hash),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	ctx, cancel := a.withTimeout(ctx)
+	defer cancel()
+
+	response, err := a.cache.Invalidate(ctx, &pb.InvalidateRequest{
+		Hashes: []string{hash},
+	})
+	if status.Code(err) == codes.Unimplemented {
+		span.AddEvent("invalidate unimplemented, delete instead")
+		_, err = a.client.Delete(ctx, &pb.DeleteRequest{
+			Hash: hash,
+		})
+		return storageError(err)
+	}
+	if err != nil {
+		return storageError(err)
+	}
+	span.AddEvent("invalidate successful", trace.WithAttributes(
+		attribute.Int64("invalidated", int64(response.GetInvalidated())),
+	))
+	return nil
+}
+
+func (a *storage) List(ctx context.Context, pageSize int, pageToken string) (links []Link, nextPageToken string, err error) {
+	ctx, span := a.tr.Start(ctx, "list", trace.WithAttributes(
+		attribute.String("address", a.addr),
+		attribute.Int("page_size", pageSize),
+		attribute.String("page_token", pageToken),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("list successful", trace.WithAttributes(
+				attribute.Int("count", len(links)),
+			))
+		}
+		span.End()
+	}()
+
+	response, err := a.client.List(ctx, &pb.ListRequest{
+		PageSize:  uint32(pageSize),
+		PageToken: pageToken,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	links = make([]Link, 0, len(response.GetLinks()))
+	for _, l := range response.GetLinks() {
+		links = append(links, Link{
+			Hash:    l.GetHash(),
+			URL:     l.GetUrl(),
+			Title:   l.GetMetadata().GetTitle(),
+			Favicon: l.GetMetadata().GetFaviconUrl(),
+		})
+	}
+
+	return links, response.GetNextPageToken(), nil
+}
+
+func (a *storage) PutAlias(ctx context.Context, alias, hash string) (err error) {
+	ctx, span := a.tr.Start(ctx, "put alias", trace.WithAttributes(
+		attribute.String("address", a.addr),
+		attribute.String("alias", alias),
+		attribute.String("hash", hash),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	ctx, cancel := a.withTimeout(ctx)
+	defer cancel()
+
+	_, err = a.client.PutAlias(ctx, &pb.PutAliasRequest{
+		Alias: alias,
+		Hash:  hash,
+	})
+	return storageError(err)
+}
+
+func (a *storage) GetByAlias(ctx context.Context, user, alias string) (hash, url string, err error) {
+	ctx, span := a.tr.Start(ctx, "get by alias", trace.WithAttributes(
+		attribute.String("address", a.addr),
+		attribute.String("user", user),
+		attribute.String("alias", alias),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("alias found", trace.WithAttributes(
+				attribute.String("hash", hash),
+				attribute.String("url", url),
+			))
+		}
+		span.End()
+	}()
+
+	ctx, cancel := a.withTimeout(ctx)
+	defer cancel()
+
+	response, err := a.client.GetByAlias(ctx, &pb.GetByAliasRequest{
+		User:  user,
+		Alias: alias,
+	})
+	if err != nil {
+		return "", "", storageError(err)
+	}
+	if response.GetExpiresAt() != nil && response.GetExpiresAt().AsTime().Before(time.Now()) {
+		return "", "", errExpired
+	}
+	return response.GetHash(), response.GetUrl(), nil
+}
+
+func (a *storage) SetMetadata(ctx context.Context, hash, url string, m LinkMetadata) (err error) {
+	ctx, span := a.tr.Start(ctx, "set metadata", trace.WithAttributes(
+		attribute.String("address", a.addr),
+		attribute.String("hash", hash),
+		attribute.String("url", url),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	ctx, cancel := a.withTimeout(ctx)
+	defer cancel()
+
+	_, err = a.client.SetMetadata(ctx, &pb.SetMetadataRequest{
+		Hash: hash,
+		Url:  url,
+		Metadata: &pb.LinkMetadata{
+			Title:      m.Title,
+			FaviconUrl: m.Favicon,
+		},
+	})
+	return storageError(err)
+}
+
+func (a *storage) AdminList(ctx context.Context, filter LinkFilter, pageSize int, pageToken string) (links []AdminLink, nextPageToken string, err error) {
+	ctx, span := a.tr.Start(ctx, "admin list", trace.WithAttributes(
+		attribute.String("address", a.addr),
+		attribute.Int("page_size", pageSize),
+		attribute.String("page_token", pageToken),
+		attribute.String("owner", filter.Owner),
+		attribute.String("domain", filter.Domain),
+		attribute.String("url", filter.URL),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("admin list successful", trace.WithAttributes(
+				attribute.Int("count", len(links)),
+			))
+		}
+		span.End()
+	}()
+
+	response, err := a.admin.ListLinks(ctx, &pb.AdminListLinksRequest{
+		PageSize:    uint32(pageSize),
+		PageToken:   pageToken,
+		Owner:       filter.Owner,
+		Domain:      filter.Domain,
+		UrlContains: filter.URL,
+	})
+	if err != nil {
+		return nil, "", storageError(err)
+	}
+
+	links = make([]AdminLink, 0, len(response.GetLinks()))
+	for _, l := range response.GetLinks() {
+		links = append(links, AdminLink{
+			Hash:  l.GetHash(),
+			URL:   l.GetUrl(),
+			Owner: l.GetOwner(),
+		})
+	}
+
+	return links, response.GetNextPageToken(), nil
+}
+
+func (a *storage) BanDomain(ctx context.Context, domain string, banned bool) (err error) {
+	ctx, span := a.tr.Start(ctx, "ban domain", trace.WithAttributes(
+		attribute.String("address", a.addr),
+		attribute.String("domain", domain),
+		attribute.Bool("banned", banned),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	ctx, cancel := a.withTimeout(ctx)
+	defer cancel()
+
+	if banned {
+		_, err = a.admin.BanDomain(ctx, &pb.BanDomainRequest{Domain: domain})
+	} else {
+		_, err = a.admin.UnbanDomain(ctx, &pb.UnbanDomainRequest{Domain: domain})
+	}
+	return storageError(err)
+}
+
+func (a *storage) BannedDomains(ctx context.Context) (domains []string, err error) {
+	ctx, span := a.tr.Start(ctx, "banned domains", trace.WithAttributes(
+		attribute.String("address", a.addr),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	ctx, cancel := a.withTimeout(ctx)
+	defer cancel()
+
+	response, err := a.admin.ListBannedDomains(ctx, &pb.ListBannedDomainsRequest{})
+	if err != nil {
+		return nil, storageError(err)
+	}
+	return response.GetDomains(), nil
+}
+
+func (a *storage) Stats(ctx context.Context) []StorageStats {
+	return []StorageStats{a.stats(ctx, "durable")}
+}
+
+// CacheStats of single durable storage are empty, it is not a cache
+func (a *storage) CacheStats(context.Context) []CacheStats {
+	return []CacheStats{}
+}
+
+func (a *storage) cacheStats(ctx context.Context) (stats CacheStats) {
+	ctx, span := a.tr.Start(ctx, "cache stats", trace.WithAttributes(
+		attribute.String("address", a.addr),
+	))
+	defer span.End()
+
+	stats = CacheStats{
+		Address:   a.addr,
+		Evictions: map[string]uint64{},
+	}
+
+	ctx, cancel := a.withTimeout(ctx)
+	defer cancel()
+
+	response, err := a.cache.Stats(ctx, &pb.CacheStatsRequest{})
+	if err != nil {
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		stats.Error = err.Error()
+		return stats
+	}
+
+	stats.Mode = response.GetMode()
+	stats.Policy = response.GetPolicy()
+	stats.Links = response.GetLinks()
+	stats.Hits = response.GetHits()
+	stats.Misses = response.GetMisses()
+	stats.HitRatio = response.GetHitRatio()
+	for reason, n := range response.GetEvictions() {
+		stats.Evictions[reason] = n
+	}
+	stats.Bytes = response.GetBytes()
+	stats.MemoryLimit = response.GetMemoryLimit()
+	span.AddEvent("cache stats successful", trace.WithAttributes(
+		attribute.Int64("links", int64(stats.Links)),
+	))
+	return stats
+}
+
+func (a *storage) stats(ctx context.Context, tier string) (stats StorageStats) {
+	ctx, span := a.tr.Start(ctx, "stats", trace.WithAttributes(
+		attribute.String("address", a.addr),
+		attribute.String("tier", tier),
+	))
+	defer span.End()
+
+	stats = StorageStats{
+		Address:   a.addr,
+		Tier:      tier,
+		Latencies: []MethodLatency{},
+	}
+
+	ctx, cancel := a.withTimeout(ctx)
+	defer cancel()
+
+	response, err := a.client.Stats(ctx, &pb.StorageStatsRequest{})
+	if err != nil {
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(err)
+		stats.Error = err.Error()
+		return stats
+	}
+
+	stats.Backend = response.GetBackend()
+	stats.RowCount = response.GetRowCount()
+	stats.SizeBytes = response.GetSizeBytes()
+	for _, l := range response.GetLatencies() {
+		stats.Latencies = append(stats.Latencies, MethodLatency{
+			Method: l.GetMethod(),
+			Count:  l.GetCount(),
+			P50Ms:  l.GetP50Ms(),
+			P90Ms:  l.GetP90Ms(),
+			P99Ms:  l.GetP99Ms(),
+		})
+	}
+	span.AddEvent("stats successful", trace.WithAttributes(
+		attribute.Int64("row_count", int64(stats.RowCount)),
+	))
+	return stats
+}
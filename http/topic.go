@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/ydb-platform/ydb-go-sdk/v3"
+	"github.com/ydb-platform/ydb-go-sdk/v3/topic/topicwriter"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
+)
+
+// clickTopic publishes clicks to YDB topic instead of calling analytics
+// service. Trace context travels inside the message, so consumer span is
+// linked to the redirect which published the click.
+type clickTopic struct {
+	tr     trace.Tracer
+	path   string
+	db     ydb.Connection
+	writer *topicwriter.Writer
+}
+
+// newClickTopic starts writer to topic, relative topic path is resolved
+// against database
+func newClickTopic(ctx context.Context, tr trace.Tracer, dsn, topic string) (_ *clickTopic, err error) {
+	ctx, span := tr.Start(ctx, "newClickTopic", trace.WithAttributes(
+		attribute.String("topic", topic),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	db, err := ydb.Open(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(topic, "/") {
+		topic = path.Join(db.Name(), topic)
+	}
+
+	hostname, _ := os.Hostname()
+	// writer connects in background, first Write waits for it
+	writer, err := db.Topic().StartWriter(fmt.Sprintf("http-%s-%d", hostname, os.Getpid()), topic)
+	if err != nil {
+		_ = db.Close(ctx)
+		return nil, err
+	}
+
+	return &clickTopic{
+		tr:     tr,
+		path:   topic,
+		db:     db,
+		writer: writer,
+	}, nil
+}
+
+func (t *clickTopic) Close(ctx context.Context) error {
+	_ = t.writer.Close(ctx)
+	return t.db.Close(ctx)
+}
+
+func (t *clickTopic) Publish(ctx context.Context, click Click) (err error) {
+	ctx, span := t.tr.Start(ctx, "publish click", trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(
+		attribute.String("topic", t.path),
+		attribute.String("hash", click.Hash),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("click published")
+		}
+		span.End()
+	}()
+
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+
+	data, err := proto.Marshal(&pb.ClickEvent{
+		Click: &pb.ClickRequest{
+			Hash:      click.Hash,
+			Timestamp: timestamppb.New(click.Timestamp),
+			UserAgent: click.UserAgent,
+			Referrer:  click.Referrer,
+		},
+		TraceContext: carrier,
+	})
+	if err != nil {
+		return err
+	}
+
+	return t.writer.Write(ctx, topicwriter.Message{
+		CreatedAt: click.Timestamp,
+		Data:      bytes.NewReader(data),
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	traceIDHeader = "X-Trace-ID"
+	// debugHeader asks for X-Trace-ID in "debug" mode, its value is ignored
+	debugHeader = "X-Debug"
+)
+
+// newTraceMiddleware returns router middleware which starts server span of
+// request, so handler spans become its children and metrics get exemplars.
+// Depending on mode trace ID of the span is returned in X-Trace-ID header
+// always, only to requests with X-Debug header or never.
+func newTraceMiddleware(tr trace.Tracer, mode string) (mux.MiddlewareFunc, error) {
+	switch mode {
+	case "always", "debug", "never":
+	default:
+		return nil, fmt.Errorf("unknown trace id header mode '%s'", mode)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := routeTemplate(r)
+			// continue trace of client or proxy which sent traceparent or uber-trace-id
+			ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx, span := tr.Start(ctx, r.Method+" "+route,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					attribute.String("http.method", r.Method),
+					attribute.String("http.route", route),
+					attribute.String("http.target", r.URL.RequestURI()),
+				),
+			)
+			defer span.End()
+
+			if mode == "always" || (mode == "debug" && r.Header.Get(debugHeader) != "") {
+				// header must be set before handler writes status
+				w.Header().Set(traceIDHeader, span.SpanContext().TraceID().String())
+			}
+
+			rw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+			next.ServeHTTP(rw, r.WithContext(ctx))
+
+			span.SetAttributes(attribute.Int("http.status_code", rw.code))
+			if rw.code >= http.StatusInternalServerError {
+				span.SetAttributes(attribute.Bool("error", true))
+			}
+		})
+	}, nil
+}
+
+// statusWriter records status and number of body bytes of response
+type statusWriter struct {
+	http.ResponseWriter
+	code  int
+	bytes int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += n
+	return n, err
+}
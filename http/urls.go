@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+const (
+	// maxQuotedURL is length of url quoted in validation errors, longer urls
+	// are cut
+	maxQuotedURL = 100
+
+	maxHostLength  = 253
+	maxLabelLength = 63
+)
+
+// urlValidator checks destinations of links with net/url and returns them
+// normalized, so the same destination is stored and looked up the same way
+type urlValidator struct {
+	maxLength int
+}
+
+// normalize returns url with lowercase host and escaped path, or error
+// telling the user what is wrong with it. Unicode host names are stored in
+// punycode, so destination lists, bans and screeners see one form of host.
+func (v urlValidator) normalize(raw string) (string, error) {
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf(invalidURLError, quoteURL(raw), fmt.Sprintf(format, args...))
+	}
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", invalid("url is empty")
+	}
+	if v.maxLength > 0 && len(raw) > v.maxLength {
+		return "", invalid("url is %d bytes long, at most %d allowed", len(raw), v.maxLength)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		// error of url.Parse quotes the whole url again
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return "", invalid("%v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", invalid("scheme must be http or https")
+	}
+	if u.Opaque != "" || u.Host == "" {
+		return "", invalid("host is missing")
+	}
+	if u.User != nil {
+		// user info makes http://trusted.example@evil.example look trusted
+		return "", invalid("credentials in url are not allowed")
+	}
+	if port := u.Port(); port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return "", invalid("port %s is out of range", port)
+		}
+	}
+	host, err := asciiHost(u.Hostname())
+	if err != nil {
+		return "", invalid("%v", err)
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port := u.Port(); port != "" {
+		host += ":" + port
+	}
+	u.Host = host
+	return u.String(), nil
+}
+
+// asciiHost validates host and converts Unicode host name to lowercase
+// punycode, ASCII host names are only lowercased
+func asciiHost(host string) (string, error) {
+	if isASCII(host) {
+		if err := validateHost(host); err != nil {
+			return "", err
+		}
+		return strings.ToLower(host), nil
+	}
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err == nil {
+		err = validateHost(ascii)
+	}
+	if err != nil {
+		return "", fmt.Errorf("host '%s' is not a valid internationalized domain name", host)
+	}
+	return ascii, nil
+}
+
+// displayURL returns url with host in Unicode form for listings, url which
+// cannot be converted is returned as is
+func displayURL(rawURL string) string {
+	if !strings.Contains(rawURL, "xn--") {
+		return rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	host, err := idna.Display.ToUnicode(u.Hostname())
+	if err != nil {
+		return rawURL
+	}
+	if port := u.Port(); port != "" {
+		host += ":" + port
+	}
+	// String of url would escape Unicode host, so only host is replaced
+	prefix := u.Scheme + "://" + u.Host
+	if !strings.HasPrefix(rawURL, prefix) {
+		return rawURL
+	}
+	return u.Scheme + "://" + host + rawURL[len(prefix):]
+}
+
+// asciiDomain converts Unicode domain given by admin to punycode, domain
+// which cannot be converted is returned as is for storage to reject it
+func asciiDomain(domain string) string {
+	if isASCII(domain) {
+		return domain
+	}
+	ascii, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return domain
+	}
+	return ascii
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// validateHost accepts IP addresses and domain names of letters, digits,
+// hyphens and underscores
+func validateHost(host string) error {
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	name := strings.TrimSuffix(host, ".")
+	if name == "" || len(name) > maxHostLength {
+		return fmt.Errorf("host '%s' is not a valid domain name", host)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > maxLabelLength ||
+			strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return fmt.Errorf("host '%s' is not a valid domain name", host)
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return fmt.Errorf("host '%s' has invalid character %q", host, c)
+			}
+		}
+	}
+	return nil
+}
+
+// quoteURL cuts long url for error message
+func quoteURL(raw string) string {
+	if len(raw) <= maxQuotedURL {
+		return raw
+	}
+	cut := maxQuotedURL
+	for cut > 0 && !utf8.RuneStart(raw[cut]) {
+		cut--
+	}
+	return raw[:cut] + "..."
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/asmyasnikov/webinar-jaeger/server/api"
+)
+
+// newValidator returns middleware which rejects requests not matching OpenAPI spec.
+// Session is checked by handlers, so the validator does not authenticate requests.
+func newValidator(tr trace.Tracer) (api.MiddlewareFunc, error) {
+	spec, err := api.GetSwagger()
+	if err != nil {
+		return nil, err
+	}
+	// requests are matched by path only, whatever host serves them
+	spec.Servers = openapi3.Servers{{URL: "/"}}
+
+	router, err := gorillamux.NewRouter(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			_, span := tr.Start(r.Context(), "validate")
+			if err := validateRequest(router, r); err != nil {
+				span.SetAttributes(attribute.Bool("error", true))
+				span.RecordError(err)
+				span.End()
+				writeResponse(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			span.End()
+			next(w, r)
+		}
+	}, nil
+}
+
+func validateRequest(router routers.Router, r *http.Request) error {
+	route, pathParams, err := router.FindRoute(r)
+	if err != nil {
+		return err
+	}
+	return openapi3filter.ValidateRequest(r.Context(), &openapi3filter.RequestValidationInput{
+		Request:    r,
+		PathParams: pathParams,
+		Route:      route,
+		Options: &openapi3filter.Options{
+			AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+		},
+	})
+}
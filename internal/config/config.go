@@ -0,0 +1,219 @@
+// Package config loads service settings from (in increasing priority) the
+// defaults already stored in a config struct, an optional YAML file,
+// environment variables and command-line flags.
+//
+// Every exported struct field is addressed by its yaml tag. Nested structs
+// join keys with "_" for environment variables and with "-" for flags:
+// field `yaml:"jaeger_endpoint"` is read from JAEGER_ENDPOINT and -jaeger-endpoint.
+// Fields of struct tagged `yaml:",inline"` are addressed as fields of the outer struct.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	fileFlag = "config"
+	fileEnv  = "CONFIG_FILE"
+)
+
+type field struct {
+	env   string
+	flag  string
+	usage string
+	value reflect.Value
+}
+
+// Load fills cfg (pointer to struct) using os.Args.
+func Load(cfg interface{}) error {
+	return LoadArgs(cfg, os.Args[0], os.Args[1:])
+}
+
+// LoadArgs fills cfg (pointer to struct) using the given program name and arguments.
+func LoadArgs(cfg interface{}, name string, args []string) error {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config: expected pointer to struct, got %T", cfg)
+	}
+
+	fields := collect(v.Elem(), "", "")
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	file := fs.String(fileFlag, os.Getenv(fileEnv), "path to optional YAML config file (env "+fileEnv+")")
+	flags := make(map[string]*flagValue, len(fields))
+	for _, f := range fields {
+		fv := &flagValue{
+			typ:    f.value.Type(),
+			def:    format(f.value),
+			isBool: f.value.Kind() == reflect.Bool,
+		}
+		flags[f.flag] = fv
+		fs.Var(fv, f.flag, fmt.Sprintf("%s (env %s)", f.usage, f.env))
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *file != "" {
+		data, err := os.ReadFile(*file)
+		if err != nil {
+			return fmt.Errorf("config: read file '%s' failed: %w", *file, err)
+		}
+		if err = yaml.Unmarshal(data, cfg); err != nil {
+			return fmt.Errorf("config: parse file '%s' failed: %w", *file, err)
+		}
+	}
+
+	for _, f := range fields {
+		if s, ok := os.LookupEnv(f.env); ok {
+			if err := set(f.value, s); err != nil {
+				return fmt.Errorf("config: invalid value of %s: %w", f.env, err)
+			}
+		}
+	}
+
+	for _, f := range fields {
+		if fv := flags[f.flag]; fv.set {
+			if err := set(f.value, fv.raw); err != nil {
+				return fmt.Errorf("config: invalid value of -%s: %w", f.flag, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func collect(v reflect.Value, envPrefix, flagPrefix string) (fields []field) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		tag := strings.Split(sf.Tag.Get("yaml"), ",")
+		// inline struct shares keys with its parent
+		if len(tag) > 1 && tag[1] == "inline" && sf.Type.Kind() == reflect.Struct {
+			fields = append(fields, collect(v.Field(i), envPrefix, flagPrefix)...)
+			continue
+		}
+		key := tag[0]
+		if key == "-" {
+			continue
+		}
+		if key == "" {
+			key = strings.ToLower(sf.Name)
+		}
+		env := envPrefix + strings.ToUpper(key)
+		flg := flagPrefix + strings.ReplaceAll(key, "_", "-")
+		if sf.Type.Kind() == reflect.Struct && sf.Type != reflect.TypeOf(time.Time{}) {
+			fields = append(fields, collect(v.Field(i), env+"_", flg+"-")...)
+			continue
+		}
+		fields = append(fields, field{
+			env:   env,
+			flag:  flg,
+			usage: sf.Tag.Get("usage"),
+			value: v.Field(i),
+		})
+	}
+	return fields
+}
+
+func set(v reflect.Value, s string) error {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported slice type %s", v.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items).Convert(v.Type()))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func format(v reflect.Value) string {
+	if v.Kind() == reflect.Slice {
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = fmt.Sprint(v.Index(i).Interface())
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+type flagValue struct {
+	typ    reflect.Type
+	def    string
+	isBool bool
+	set    bool
+	raw    string
+}
+
+func (f *flagValue) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.def
+}
+
+func (f *flagValue) Set(s string) error {
+	if err := set(reflect.New(f.typ).Elem(), s); err != nil {
+		return err
+	}
+	f.set, f.raw = true, s
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.isBool
+}
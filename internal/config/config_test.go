@@ -0,0 +1,256 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testStorage struct {
+	Addrs   []string      `yaml:"addrs" usage:"storage addresses"`
+	Timeout time.Duration `yaml:"timeout" usage:"storage timeout"`
+}
+
+type testConfig struct {
+	Name    string      `yaml:"cfgtest_name" usage:"name"`
+	Port    int         `yaml:"cfgtest_port" usage:"port"`
+	Debug   bool        `yaml:"cfgtest_debug" usage:"debug"`
+	Ratio   float64     `yaml:"cfgtest_ratio" usage:"ratio"`
+	Storage testStorage `yaml:"cfgtest_storage"`
+	Skipped string      `yaml:"-"`
+	hidden  string
+}
+
+func defaultTestConfig() *testConfig {
+	return &testConfig{
+		Name: "default",
+		Port: 8080,
+		Storage: testStorage{
+			Addrs:   []string{"localhost:5300"},
+			Timeout: time.Second,
+		},
+	}
+}
+
+func writeFile(t *testing.T, data string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadArgs(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+		want func(cfg *testConfig)
+	}{
+		{
+			name: "defaults",
+			want: func(cfg *testConfig) {},
+		},
+		{
+			name: "file",
+			file: "cfgtest_name: file\ncfgtest_storage:\n  timeout: 2s\n",
+			want: func(cfg *testConfig) {
+				cfg.Name = "file"
+				cfg.Storage.Timeout = 2 * time.Second
+			},
+		},
+		{
+			name: "env overrides file",
+			file: "cfgtest_name: file\ncfgtest_port: 9000\n",
+			env:  map[string]string{"CFGTEST_NAME": "env"},
+			want: func(cfg *testConfig) {
+				cfg.Name = "env"
+				cfg.Port = 9000
+			},
+		},
+		{
+			name: "flag overrides env",
+			env:  map[string]string{"CFGTEST_NAME": "env", "CFGTEST_PORT": "9000"},
+			args: []string{"-cfgtest-name", "flag"},
+			want: func(cfg *testConfig) {
+				cfg.Name = "flag"
+				cfg.Port = 9000
+			},
+		},
+		{
+			name: "nested struct joins keys",
+			env:  map[string]string{"CFGTEST_STORAGE_TIMEOUT": "250ms"},
+			args: []string{"-cfgtest-storage-addrs", "a:1, b:2,,"},
+			want: func(cfg *testConfig) {
+				cfg.Storage.Timeout = 250 * time.Millisecond
+				cfg.Storage.Addrs = []string{"a:1", "b:2"}
+			},
+		},
+		{
+			name: "bool flag without value",
+			args: []string{"-cfgtest-debug"},
+			want: func(cfg *testConfig) {
+				cfg.Debug = true
+			},
+		},
+		{
+			name: "float env",
+			env:  map[string]string{"CFGTEST_RATIO": "0.25"},
+			want: func(cfg *testConfig) {
+				cfg.Ratio = 0.25
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeFile(t, tt.file)}, args...)
+			}
+			cfg := defaultTestConfig()
+			if err := LoadArgs(cfg, "test", args); err != nil {
+				t.Fatalf("LoadArgs failed: %v", err)
+			}
+			want := defaultTestConfig()
+			tt.want(want)
+			if !reflect.DeepEqual(cfg, want) {
+				t.Errorf("LoadArgs = %+v, want %+v", cfg, want)
+			}
+		})
+	}
+}
+
+func TestLoadArgsFileFromEnv(t *testing.T) {
+	t.Setenv("CONFIG_FILE", writeFile(t, "cfgtest_port: 9000\n"))
+	cfg := defaultTestConfig()
+	if err := LoadArgs(cfg, "test", nil); err != nil {
+		t.Fatalf("LoadArgs failed: %v", err)
+	}
+	if cfg.Port != 9000 {
+		t.Errorf("port = %d, want 9000", cfg.Port)
+	}
+}
+
+func TestLoadArgsErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		cfg  interface{}
+		file string
+		env  map[string]string
+		want string
+	}{
+		{
+			name: "not pointer",
+			cfg:  testConfig{},
+			want: "expected pointer to struct",
+		},
+		{
+			name: "pointer to not struct",
+			cfg:  new(int),
+			want: "expected pointer to struct",
+		},
+		{
+			name: "invalid int",
+			cfg:  defaultTestConfig(),
+			env:  map[string]string{"CFGTEST_PORT": "port"},
+			want: "invalid value of CFGTEST_PORT",
+		},
+		{
+			name: "invalid duration",
+			cfg:  defaultTestConfig(),
+			env:  map[string]string{"CFGTEST_STORAGE_TIMEOUT": "5"},
+			want: "invalid value of CFGTEST_STORAGE_TIMEOUT",
+		},
+		{
+			name: "invalid file",
+			cfg:  defaultTestConfig(),
+			file: "cfgtest_port: [",
+			want: "parse file",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			var args []string
+			if tt.file != "" {
+				args = []string{"-config", writeFile(t, tt.file)}
+			}
+			err := LoadArgs(tt.cfg, "test", args)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadArgs = %v, want error with %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadArgsSkipsIgnoredFields(t *testing.T) {
+	t.Setenv("SKIPPED", "env")
+	t.Setenv("HIDDEN", "env")
+	cfg := defaultTestConfig()
+	if err := LoadArgs(cfg, "test", nil); err != nil {
+		t.Fatalf("LoadArgs failed: %v", err)
+	}
+	if cfg.Skipped != "" || cfg.hidden != "" {
+		t.Errorf("ignored fields are set: %q, %q", cfg.Skipped, cfg.hidden)
+	}
+}
+
+type testTelemetry struct {
+	Endpoint string  `yaml:"cfgtest_endpoint" usage:"endpoint"`
+	Ratio    float64 `yaml:"cfgtest_sample_ratio" usage:"ratio"`
+}
+
+type testInlineConfig struct {
+	Telemetry testTelemetry `yaml:",inline"`
+	Port      int           `yaml:"cfgtest_port" usage:"port"`
+}
+
+func TestLoadArgsInline(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+		want testInlineConfig
+	}{
+		{
+			name: "file",
+			file: "cfgtest_endpoint: file:4318\ncfgtest_port: 9000\n",
+			want: testInlineConfig{Telemetry: testTelemetry{Endpoint: "file:4318", Ratio: 1}, Port: 9000},
+		},
+		{
+			name: "env",
+			env:  map[string]string{"CFGTEST_ENDPOINT": "env:4318", "CFGTEST_SAMPLE_RATIO": "0.5"},
+			want: testInlineConfig{Telemetry: testTelemetry{Endpoint: "env:4318", Ratio: 0.5}, Port: 8080},
+		},
+		{
+			name: "flag",
+			args: []string{"-cfgtest-endpoint", "flag:4318", "-cfgtest-sample-ratio", "0.1"},
+			want: testInlineConfig{Telemetry: testTelemetry{Endpoint: "flag:4318", Ratio: 0.1}, Port: 8080},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeFile(t, tt.file)}, args...)
+			}
+			cfg := &testInlineConfig{Telemetry: testTelemetry{Ratio: 1}, Port: 8080}
+			if err := LoadArgs(cfg, "test", args); err != nil {
+				t.Fatalf("LoadArgs failed: %v", err)
+			}
+			if *cfg != tt.want {
+				t.Errorf("LoadArgs = %+v, want %+v", *cfg, tt.want)
+			}
+		})
+	}
+}
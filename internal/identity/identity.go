@@ -0,0 +1,164 @@
+// Package identity passes the acting user from HTTP frontend to every
+// downstream service in OpenTelemetry baggage, so spans of storage and auth
+// show on whose behalf they were made. Services which authorize by user take
+// it from gRPC metadata set by client interceptors instead, so the user does
+// not depend on propagators configured for tracing.
+package identity
+
+import (
+	"context"
+	"net/url"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// Attribute is span attribute with user name
+	Attribute = "user"
+
+	baggageKey  = "user"
+	metadataKey = "x-user"
+)
+
+type (
+	ctxKey    struct{}
+	callerKey struct{}
+)
+
+// NewContext returns context which carries user in baggage. Baggage values
+// are limited to printable ASCII, so the user is percent-encoded there.
+func NewContext(ctx context.Context, user string) context.Context {
+	return withBaggage(context.WithValue(ctx, ctxKey{}, user), user)
+}
+
+// NewAdminContext returns context which carries user in baggage only. Calls
+// made with it are on behalf of nobody, so services grant access to links of
+// everyone, while spans still show the acting user. It is only for users
+// whose admin role is checked.
+func NewAdminContext(ctx context.Context, user string) context.Context {
+	return withBaggage(ctx, user)
+}
+
+func withBaggage(ctx context.Context, user string) context.Context {
+	m, err := baggage.NewMember(baggageKey, url.QueryEscape(user))
+	if err != nil {
+		return ctx
+	}
+	b, err := baggage.FromContext(ctx).SetMember(m)
+	if err != nil {
+		return ctx
+	}
+	return baggage.ContextWithBaggage(ctx, b)
+}
+
+// FromContext returns user or empty string. Baggage received from another
+// service is already decoded by propagator.
+func FromContext(ctx context.Context) string {
+	if user, ok := ctx.Value(ctxKey{}).(string); ok {
+		return user
+	}
+	return baggage.FromContext(ctx).Member(baggageKey).Value()
+}
+
+// Authenticated returns user put into context by NewContext, whose calls are
+// scoped to links of the user, or empty string for calls made on behalf of
+// nobody. Unlike FromContext it ignores baggage.
+func Authenticated(ctx context.Context) string {
+	user, _ := ctx.Value(ctxKey{}).(string)
+	return user
+}
+
+// UnaryClientInterceptor sends user from context in call metadata.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(outgoing(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor sends user from context in stream metadata.
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(outgoing(ctx), desc, cc, method, opts...)
+	}
+}
+
+// Caller returns user who made the gRPC call or empty string if the call was
+// made on behalf of nobody. Unlike FromContext it never trusts baggage, which
+// any client can set, and is empty outside of calls passed through server
+// interceptors.
+func Caller(ctx context.Context) string {
+	user, _ := ctx.Value(callerKey{}).(string)
+	return user
+}
+
+// UnaryServerInterceptor puts user from call metadata into context for
+// Caller. It must follow tracing interceptor.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(incoming(ctx), req)
+	}
+}
+
+// StreamServerInterceptor puts user from stream metadata into context for
+// Caller. It must follow tracing interceptor.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &serverStream{
+			ServerStream: ss,
+			ctx:          incoming(ss.Context()),
+		})
+	}
+}
+
+// outgoing sends only user authenticated by this process, baggage of
+// incoming request may be forged by client
+func outgoing(ctx context.Context) context.Context {
+	if user := Authenticated(ctx); user != "" {
+		return metadata.AppendToOutgoingContext(ctx, metadataKey, url.QueryEscape(user))
+	}
+	return ctx
+}
+
+func incoming(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var user string
+	if users := md.Get(metadataKey); len(users) > 0 {
+		user, _ = url.QueryUnescape(users[0])
+	}
+	if user == "" {
+		return ctx
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String(Attribute, user))
+	return context.WithValue(ctx, callerKey{}, user)
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// SpanProcessor sets user attribute on every span started on behalf of user.
+func SpanProcessor() tracesdk.SpanProcessor {
+	return spanProcessor{}
+}
+
+type spanProcessor struct{}
+
+func (spanProcessor) OnStart(parent context.Context, s tracesdk.ReadWriteSpan) {
+	if user := FromContext(parent); user != "" {
+		s.SetAttributes(attribute.String(Attribute, user))
+	}
+}
+
+func (spanProcessor) OnEnd(tracesdk.ReadOnlySpan)      {}
+func (spanProcessor) Shutdown(context.Context) error   { return nil }
+func (spanProcessor) ForceFlush(context.Context) error { return nil }
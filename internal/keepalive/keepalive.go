@@ -0,0 +1,62 @@
+// Package keepalive sets HTTP/2 pings of gRPC connections, so an idle
+// connection dropped by a load balancer or NAT is noticed and redialed
+// before the next call rather than by its failure.
+package keepalive
+
+import (
+	"time"
+
+	"google.golang.org/grpc"
+	grpckeepalive "google.golang.org/grpc/keepalive"
+)
+
+// minPingInterval is the most frequent pings servers accept from clients.
+// gRPC clients never ping more often than every 10 seconds.
+const minPingInterval = 5 * time.Second
+
+// Config is shared by clients and servers of a service.
+type Config struct {
+	Time                time.Duration `yaml:"time" usage:"interval of pings on idle gRPC connection, 0 disables pings"`
+	Timeout             time.Duration `yaml:"timeout" usage:"time to wait for ping ack before gRPC connection is closed"`
+	PermitWithoutStream bool          `yaml:"permit_without_stream" usage:"ping gRPC connections without active calls"`
+}
+
+// DefaultConfig returns pings every 30 seconds even without active calls.
+func DefaultConfig() Config {
+	return Config{
+		Time:                30 * time.Second,
+		Timeout:             10 * time.Second,
+		PermitWithoutStream: true,
+	}
+}
+
+// DialOption returns client keepalive, no-op if Time is zero.
+func (c Config) DialOption() grpc.DialOption {
+	if c.Time <= 0 {
+		return grpc.EmptyDialOption{}
+	}
+	return grpc.WithKeepaliveParams(grpckeepalive.ClientParameters{
+		Time:                c.Time,
+		Timeout:             c.Timeout,
+		PermitWithoutStream: c.PermitWithoutStream,
+	})
+}
+
+// ServerOptions returns server keepalive and policy which accepts pings of
+// clients configured the same way. Server accepts pings even if its own are
+// disabled, otherwise it would close connections of pinging clients.
+func (c Config) ServerOptions() []grpc.ServerOption {
+	opts := []grpc.ServerOption{
+		grpc.KeepaliveEnforcementPolicy(grpckeepalive.EnforcementPolicy{
+			MinTime:             minPingInterval,
+			PermitWithoutStream: true,
+		}),
+	}
+	if c.Time > 0 {
+		opts = append(opts, grpc.KeepaliveParams(grpckeepalive.ServerParameters{
+			Time:    c.Time,
+			Timeout: c.Timeout,
+		}))
+	}
+	return opts
+}
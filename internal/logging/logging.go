@@ -0,0 +1,59 @@
+// Package logging builds structured logger which joins log lines with traces:
+// every record logged with context of a recording span gets trace_id and
+// span_id attributes, the same ids Jaeger shows. Request ID from context is
+// logged as request_id.
+package logging
+
+import (
+	"context"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slog"
+
+	"github.com/asmyasnikov/webinar-jaeger/internal/requestid"
+)
+
+// New returns JSON logger writing to stdout. Every line carries service name.
+func New(service string) *slog.Logger {
+	return slog.New(traceHandler{
+		Handler: slog.NewJSONHandler(os.Stdout),
+	}).With(slog.String("service", service))
+}
+
+// Init makes logger for service the default one for slog package functions
+// and for errors of OpenTelemetry SDK (e.g. failed span export).
+func Init(service string) *slog.Logger {
+	l := New(service)
+	slog.SetDefault(l)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		l.Error("opentelemetry failed", slog.Any("error", err))
+	}))
+	return l
+}
+
+type traceHandler struct {
+	slog.Handler
+}
+
+func (h traceHandler) Handle(ctx context.Context, r slog.Record) error {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(
+			slog.String("trace_id", sc.TraceID().String()),
+			slog.String("span_id", sc.SpanID().String()),
+		)
+	}
+	if id := requestid.FromContext(ctx); id != "" {
+		r.AddAttrs(slog.String(requestid.Attribute, id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h traceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h traceHandler) WithGroup(name string) slog.Handler {
+	return traceHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,142 @@
+// Package metrics collects RED (rate, errors, duration) metrics of HTTP
+// handlers and gRPC methods and states of gRPC client connections in
+// Prometheus format.
+//
+// Duration observations carry trace_id exemplar of the current span, so a slow
+// bucket on a dashboard leads straight to the trace in Jaeger.
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	grpcHandled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_server_handled_total",
+		Help: "Total number of RPCs completed on the server.",
+	}, []string{"grpc_service", "grpc_method", "grpc_code"})
+	grpcDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grpc_server_handling_seconds",
+		Help:    "Duration of RPCs handled by the server.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"grpc_service", "grpc_method"})
+
+	grpcConnState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "grpc_client_connection_state",
+		Help: "1 for the current connectivity state of client connection, 0 for others.",
+	}, []string{"peer", "target", "state"})
+
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Total number of HTTP requests completed on the server.",
+	}, []string{"route", "method", "code"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Duration of HTTP requests handled by the server.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+)
+
+// Handler serves metrics in OpenMetrics format, which is required for exemplars.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
+		EnableOpenMetrics: true,
+	})
+}
+
+// SetConnState records current connectivity state of client connection to
+// target. Peer names the service, as several services may share one target.
+func SetConnState(peer, target string, state connectivity.State) {
+	for s := connectivity.Idle; s <= connectivity.Shutdown; s++ {
+		v := 0.0
+		if s == state {
+			v = 1
+		}
+		grpcConnState.WithLabelValues(peer, target, s.String()).Set(v)
+	}
+}
+
+// UnaryServerInterceptor observes unary RPCs.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		observeRPC(ctx, info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor observes streaming RPCs, duration covers the whole stream.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		observeRPC(ss.Context(), info.FullMethod, start, err)
+		return err
+	}
+}
+
+// Middleware observes HTTP requests. Route must return request pattern rather
+// than path, otherwise every short code becomes a separate series.
+func Middleware(route func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+			next.ServeHTTP(rw, r)
+			name := route(r)
+			httpRequests.WithLabelValues(name, r.Method, strconv.Itoa(rw.code)).Inc()
+			observe(r.Context(), httpDuration.WithLabelValues(name, r.Method), start)
+		})
+	}
+}
+
+func observeRPC(ctx context.Context, fullMethod string, start time.Time, err error) {
+	service, method := splitMethod(fullMethod)
+	grpcHandled.WithLabelValues(service, method, status.Code(err).String()).Inc()
+	observe(ctx, grpcDuration.WithLabelValues(service, method), start)
+}
+
+func observe(ctx context.Context, o prometheus.Observer, start time.Time) {
+	d := time.Since(start).Seconds()
+	if sc := trace.SpanContextFromContext(ctx); sc.IsSampled() {
+		if eo, ok := o.(prometheus.ExemplarObserver); ok {
+			eo.ObserveWithExemplar(d, prometheus.Labels{
+				"trace_id": sc.TraceID().String(),
+			})
+			return
+		}
+	}
+	o.Observe(d)
+}
+
+// splitMethod splits "/package.Service/Method"
+func splitMethod(fullMethod string) (service, method string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if i := strings.Index(fullMethod, "/"); i >= 0 {
+		return fullMethod[:i], fullMethod[i+1:]
+	}
+	return "unknown", fullMethod
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
@@ -0,0 +1,88 @@
+// Package mtls builds TLS settings for mutual authentication between services.
+//
+// Every service presents its own certificate and verifies the peer certificate
+// against the shared CA, so both servers and clients use the same Config.
+package mtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Config holds paths to PEM files. Empty Cert disables TLS.
+type Config struct {
+	Cert string `yaml:"cert" usage:"path to PEM certificate of this service, empty disables mTLS"`
+	Key  string `yaml:"key" usage:"path to PEM private key of this service"`
+	CA   string `yaml:"ca" usage:"path to PEM certificate of CA which signs peer certificates"`
+}
+
+// Enabled reports whether certificate is configured.
+func (c Config) Enabled() bool {
+	return c.Cert != ""
+}
+
+// ServerConfig returns TLS settings which require and verify client certificate.
+func (c Config) ServerConfig() (*tls.Config, error) {
+	cert, pool, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// ClientConfig returns TLS settings which present client certificate and
+// verify server certificate. Server name is taken from dial address.
+func (c Config) ClientConfig() (*tls.Config, error) {
+	cert, pool, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// ClientCredentials returns gRPC credentials of ClientConfig or insecure ones
+// if certificate is not configured.
+func (c Config) ClientCredentials() (credentials.TransportCredentials, error) {
+	if !c.Enabled() {
+		return insecure.NewCredentials(), nil
+	}
+	tlsConfig, err := c.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+func (c Config) load() (cert tls.Certificate, pool *x509.CertPool, err error) {
+	if c.Key == "" || c.CA == "" {
+		return cert, nil, errors.New("mtls: certificate, key and CA must be set together")
+	}
+	cert, err = tls.LoadX509KeyPair(c.Cert, c.Key)
+	if err != nil {
+		return cert, nil, fmt.Errorf("mtls: load key pair failed: %w", err)
+	}
+	ca, err := os.ReadFile(c.CA)
+	if err != nil {
+		return cert, nil, fmt.Errorf("mtls: read CA '%s' failed: %w", c.CA, err)
+	}
+	pool = x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return cert, nil, fmt.Errorf("mtls: no certificates found in CA '%s'", c.CA)
+	}
+	return cert, pool, nil
+}
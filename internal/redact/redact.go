@@ -0,0 +1,172 @@
+// Package redact hides secrets in span attributes before spans are exported.
+//
+// Tokens are replaced with a short hash, so spans of one session can still be
+// matched with each other, passwords and secrets are dropped, and values of
+// sensitive query parameters are cut out of URLs.
+package redact
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Redacted replaces values of sensitive query parameters
+const Redacted = "REDACTED"
+
+var (
+	// hashedKeys are attribute names (or their "_suffix") with tokens
+	hashedKeys = []string{"token"}
+	// droppedKeys are attribute names (or their "_suffix") which must never be exported
+	droppedKeys = []string{"password", "secret"}
+)
+
+type processor struct {
+	next   tracesdk.SpanProcessor
+	params map[string]bool
+}
+
+// NewSpanProcessor returns processor which passes to next spans with redacted
+// attributes. Params are names of URL query parameters to hide, case is ignored.
+func NewSpanProcessor(next tracesdk.SpanProcessor, params []string) tracesdk.SpanProcessor {
+	p := &processor{
+		next:   next,
+		params: make(map[string]bool, len(params)),
+	}
+	for _, param := range params {
+		p.params[strings.ToLower(param)] = true
+	}
+	return p
+}
+
+func (p *processor) OnStart(parent context.Context, s tracesdk.ReadWriteSpan) {
+	p.next.OnStart(parent, s)
+}
+
+func (p *processor) OnEnd(s tracesdk.ReadOnlySpan) {
+	attrs, changed := p.redactAll(s.Attributes())
+
+	events := s.Events()
+	redactedEvents := make([]tracesdk.Event, len(events))
+	for i, e := range events {
+		var ok bool
+		e.Attributes, ok = p.redactAll(e.Attributes)
+		changed = changed || ok
+		redactedEvents[i] = e
+	}
+
+	if !changed {
+		p.next.OnEnd(s)
+		return
+	}
+	p.next.OnEnd(span{ReadOnlySpan: s, attrs: attrs, events: redactedEvents})
+}
+
+func (p *processor) Shutdown(ctx context.Context) error {
+	return p.next.Shutdown(ctx)
+}
+
+func (p *processor) ForceFlush(ctx context.Context) error {
+	return p.next.ForceFlush(ctx)
+}
+
+// redactAll returns redacted copy of attributes and reports whether any of
+// them was changed
+func (p *processor) redactAll(attrs []attribute.KeyValue) ([]attribute.KeyValue, bool) {
+	redacted := make([]attribute.KeyValue, 0, len(attrs))
+	changed := false
+	for _, a := range attrs {
+		r, keep, ok := p.redact(a)
+		changed = changed || ok
+		if keep {
+			redacted = append(redacted, r)
+		}
+	}
+	return redacted, changed
+}
+
+// redact returns attribute with hidden value and reports whether attribute
+// must be kept and whether it was changed
+func (p *processor) redact(a attribute.KeyValue) (_ attribute.KeyValue, keep, changed bool) {
+	key := strings.ToLower(string(a.Key))
+	switch {
+	case matches(key, droppedKeys):
+		return a, false, true
+	case matches(key, hashedKeys):
+		return attribute.String(string(a.Key), Hash(a.Value.Emit())), true, true
+	case a.Value.Type() == attribute.STRING && len(p.params) > 0:
+		if v, ok := p.redactURL(a.Value.AsString()); ok {
+			return attribute.String(string(a.Key), v), true, true
+		}
+	}
+	return a, true, false
+}
+
+// redactURL hides values of sensitive query parameters and password of user
+// info. It reports false if value is not URL or has nothing to hide.
+func (p *processor) redactURL(value string) (string, bool) {
+	if !strings.ContainsAny(value, "?@") {
+		return value, false
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return value, false
+	}
+	changed := false
+	if _, has := u.User.Password(); has {
+		u.User = url.UserPassword(u.User.Username(), Redacted)
+		changed = true
+	}
+	if u.RawQuery != "" {
+		q := u.Query()
+		for name := range q {
+			if p.params[strings.ToLower(name)] {
+				q.Set(name, Redacted)
+				changed = true
+			}
+		}
+		if changed {
+			u.RawQuery = q.Encode()
+		}
+	}
+	if !changed {
+		return value, false
+	}
+	return u.String(), true
+}
+
+// Hash returns short stable digest of secret, which identifies it in traces
+// without revealing it.
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+func matches(key string, names []string) bool {
+	for _, name := range names {
+		if key == name || strings.HasSuffix(key, "_"+name) || strings.HasSuffix(key, "."+name) {
+			return true
+		}
+	}
+	return false
+}
+
+// span overrides attributes and events of exported span
+type span struct {
+	tracesdk.ReadOnlySpan
+	attrs  []attribute.KeyValue
+	events []tracesdk.Event
+}
+
+func (s span) Attributes() []attribute.KeyValue {
+	return s.attrs
+}
+
+func (s span) Events() []tracesdk.Event {
+	return s.events
+}
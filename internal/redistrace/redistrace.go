@@ -0,0 +1,65 @@
+// Package redistrace makes a client span for every Redis command or
+// pipeline, so time spent in Redis shows up in traces of requests.
+package redistrace
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type hook struct {
+	tr trace.Tracer
+}
+
+// Hook returns hook of redis client which traces commands by tr. Missing
+// keys (redis.Nil) are not errors.
+func Hook(tr trace.Tracer) redis.Hook {
+	return hook{tr: tr}
+}
+
+func (h hook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h hook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		ctx, span := h.tr.Start(ctx, cmd.FullName(), trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+			semconv.DBSystemRedis,
+			semconv.DBOperationKey.String(cmd.Name()),
+		))
+		defer span.End()
+		err := next(ctx, cmd)
+		if err != nil && !errors.Is(err, redis.Nil) {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		}
+		return err
+	}
+}
+
+func (h hook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		names := make([]string, 0, len(cmds))
+		for _, cmd := range cmds {
+			names = append(names, cmd.Name())
+		}
+		ctx, span := h.tr.Start(ctx, "pipeline", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+			semconv.DBSystemRedis,
+			semconv.DBOperationKey.String(strings.Join(names, " ")),
+			attribute.Int("count", len(cmds)),
+		))
+		defer span.End()
+		err := next(ctx, cmds)
+		if err != nil && !errors.Is(err, redis.Nil) {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		}
+		return err
+	}
+}
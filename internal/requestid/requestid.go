@@ -0,0 +1,134 @@
+// Package requestid passes request ID from HTTP frontend through gRPC calls,
+// so an ID quoted by user in bug report leads to logs and spans of every service.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// Header is HTTP header with request ID
+	Header = "X-Request-ID"
+	// Attribute is span attribute with request ID
+	Attribute = "request_id"
+
+	metadataKey = "x-request-id"
+	maxLength   = 128
+)
+
+type ctxKey struct{}
+
+// NewContext returns context which carries request ID.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext returns request ID or empty string.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+// New generates random request ID.
+func New() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Middleware accepts request ID from client or generates new one and returns
+// it in response header.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if id == "" || len(id) > maxLength {
+			id = New()
+		}
+		w.Header().Set(Header, id)
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+	})
+}
+
+// UnaryClientInterceptor sends request ID from context in call metadata.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(outgoing(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor sends request ID from context in stream metadata.
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(outgoing(ctx), desc, cc, method, opts...)
+	}
+}
+
+// UnaryServerInterceptor puts request ID from call metadata into context and
+// span of the call. It must follow tracing interceptor.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(incoming(ctx), req)
+	}
+}
+
+// StreamServerInterceptor puts request ID from stream metadata into context
+// and span of the stream. It must follow tracing interceptor.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &serverStream{
+			ServerStream: ss,
+			ctx:          incoming(ss.Context()),
+		})
+	}
+}
+
+// SpanProcessor sets request ID attribute on every span started within request.
+func SpanProcessor() tracesdk.SpanProcessor {
+	return spanProcessor{}
+}
+
+func outgoing(ctx context.Context) context.Context {
+	if id := FromContext(ctx); id != "" {
+		return metadata.AppendToOutgoingContext(ctx, metadataKey, id)
+	}
+	return ctx
+}
+
+func incoming(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if ids := md.Get(metadataKey); len(ids) > 0 && ids[0] != "" {
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String(Attribute, ids[0]))
+		return NewContext(ctx, ids[0])
+	}
+	return ctx
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+type spanProcessor struct{}
+
+func (spanProcessor) OnStart(parent context.Context, s tracesdk.ReadWriteSpan) {
+	if id := FromContext(parent); id != "" {
+		s.SetAttributes(attribute.String(Attribute, id))
+	}
+}
+
+func (spanProcessor) OnEnd(tracesdk.ReadOnlySpan)      {}
+func (spanProcessor) Shutdown(context.Context) error   { return nil }
+func (spanProcessor) ForceFlush(context.Context) error { return nil }
@@ -0,0 +1,102 @@
+// Package retry repeats idempotent gRPC calls which failed with transient
+// errors, so a restarting backend does not turn into user-visible failures.
+package retry
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Config sets how many times and how often a call is attempted.
+type Config struct {
+	MaxAttempts    int           `yaml:"max_attempts" usage:"attempts of idempotent gRPC call including the first one, 1 disables retries"`
+	InitialBackoff time.Duration `yaml:"initial_backoff" usage:"upper bound of pause before the first retry, doubled for every next retry"`
+	MaxBackoff     time.Duration `yaml:"max_backoff" usage:"upper bound of pause before any retry"`
+}
+
+// DefaultConfig returns three attempts with backoff from 10ms up to 200ms.
+func DefaultConfig() Config {
+	return Config{
+		MaxAttempts:    3,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     200 * time.Millisecond,
+	}
+}
+
+// UnaryClientInterceptor retries calls of listed full methods (like
+// "/storage.Storage/Get") on Unavailable and DeadlineExceeded codes, or as
+// status detail tells, while caller context is alive. Other methods may be not idempotent and are
+// never retried. Retries are recorded as events of the caller span, so the
+// interceptor must precede tracing interceptor to get a client span for
+// every attempt.
+func UnaryClientInterceptor(cfg Config, methods ...string) grpc.UnaryClientInterceptor {
+	idempotent := make(map[string]bool, len(methods))
+	for _, m := range methods {
+		idempotent[m] = true
+	}
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if !idempotent[method] || cfg.MaxAttempts <= 1 {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		span := trace.SpanFromContext(ctx)
+		for attempt := 1; ; attempt++ {
+			err := invoker(ctx, method, req, reply, cc, opts...)
+			if err == nil || attempt >= cfg.MaxAttempts || !retryable(err) {
+				if attempt > 1 {
+					span.SetAttributes(attribute.Int("retry.attempts", attempt))
+				}
+				return err
+			}
+			pause := backoff(cfg, attempt)
+			span.AddEvent("retry", trace.WithAttributes(
+				attribute.String("method", method),
+				attribute.Int("attempt", attempt),
+				attribute.String("code", status.Code(err).String()),
+				attribute.Int64("backoff_ms", pause.Milliseconds()),
+			))
+			timer := time.NewTimer(pause)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+		}
+	}
+}
+
+// retryable follows retryable flag of status detail when server attached
+// one (ErrorDetail of storage and cache), otherwise status code
+func retryable(err error) bool {
+	for _, d := range status.Convert(err).Details() {
+		if detail, ok := d.(interface{ GetRetryable() bool }); ok {
+			return detail.GetRetryable()
+		}
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
+
+// backoff returns random pause up to exponentially growing bound ("full
+// jitter"), so clients failed at once do not retry at once.
+func backoff(cfg Config, attempt int) time.Duration {
+	bound := cfg.InitialBackoff << (attempt - 1)
+	if bound > cfg.MaxBackoff || bound <= 0 {
+		bound = cfg.MaxBackoff
+	}
+	if bound <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(bound)))
+}
@@ -0,0 +1,204 @@
+// Package sampling decides which traces are exported after they are finished.
+//
+// Head sampling drops a trace before anything is known about it, so a rare
+// failure is as likely to be lost as a healthy request. Tail processor buffers
+// spans of a trace until its local root ends and then exports the whole trace
+// if any span failed, or a fraction of successful traces chosen by trace ID.
+// The choice by trace ID is the same in every service, so a successful trace
+// is either kept or dropped entirely.
+package sampling
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// traceTimeout bounds how long spans wait for the local root. Long-lived
+	// roots like "main" would keep their children in memory forever otherwise.
+	traceTimeout = 30 * time.Second
+	// maxPendingTraces bounds memory under heavy load, traces over the limit
+	// are decided at once with the spans ended so far
+	maxPendingTraces = 10000
+)
+
+type pending struct {
+	spans   []tracesdk.ReadOnlySpan
+	failed  bool
+	started time.Time
+}
+
+type decision struct {
+	keep    bool
+	decided time.Time
+}
+
+type tailProcessor struct {
+	next  tracesdk.SpanProcessor
+	ratio float64
+
+	mu      sync.Mutex
+	pending map[trace.TraceID]*pending
+	// decided remembers recent decisions for spans ending after local root,
+	// e.g. spans of background goroutines
+	decided map[trace.TraceID]decision
+
+	done chan struct{}
+	once sync.Once
+}
+
+// NewTailProcessor returns processor which passes to next all spans of failed
+// traces and ratio of successful ones. Ratio 1 or more keeps everything.
+func NewTailProcessor(next tracesdk.SpanProcessor, ratio float64) tracesdk.SpanProcessor {
+	if ratio >= 1 {
+		return next
+	}
+	p := &tailProcessor{
+		next:    next,
+		ratio:   ratio,
+		pending: make(map[trace.TraceID]*pending),
+		decided: make(map[trace.TraceID]decision),
+		done:    make(chan struct{}),
+	}
+	go p.evictExpired()
+	return p
+}
+
+func (p *tailProcessor) OnStart(parent context.Context, s tracesdk.ReadWriteSpan) {
+	p.next.OnStart(parent, s)
+}
+
+func (p *tailProcessor) OnEnd(s tracesdk.ReadOnlySpan) {
+	traceID := s.SpanContext().TraceID()
+	failed := isFailed(s)
+
+	p.mu.Lock()
+	if d, has := p.decided[traceID]; has {
+		p.mu.Unlock()
+		// late failure of dropped trace is still worth seeing alone
+		if d.keep || failed {
+			p.next.OnEnd(s)
+		}
+		return
+	}
+
+	t, has := p.pending[traceID]
+	if !has {
+		t = &pending{started: time.Now()}
+		p.pending[traceID] = t
+	}
+	t.spans = append(t.spans, s)
+	t.failed = t.failed || failed
+
+	var export []tracesdk.ReadOnlySpan
+	if isLocalRoot(s) || len(p.pending) > maxPendingTraces {
+		export = p.decide(traceID, t)
+	}
+	p.mu.Unlock()
+
+	for _, s := range export {
+		p.next.OnEnd(s)
+	}
+}
+
+// decide must be called with p.mu held. It returns spans to export.
+func (p *tailProcessor) decide(traceID trace.TraceID, t *pending) []tracesdk.ReadOnlySpan {
+	delete(p.pending, traceID)
+	keep := t.failed || p.sampled(traceID)
+	p.decided[traceID] = decision{keep: keep, decided: time.Now()}
+	if !keep {
+		return nil
+	}
+	return t.spans
+}
+
+// sampled compares the upper half of trace ID with ratio the same way as
+// tracesdk.TraceIDRatioBased, so services agree on the decision
+func (p *tailProcessor) sampled(traceID trace.TraceID) bool {
+	x := binary.BigEndian.Uint64(traceID[0:8]) >> 1
+	return x < uint64(p.ratio*(1<<63))
+}
+
+func (p *tailProcessor) evictExpired() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+		deadline := time.Now().Add(-traceTimeout)
+
+		var export []tracesdk.ReadOnlySpan
+		p.mu.Lock()
+		for traceID, t := range p.pending {
+			if t.started.Before(deadline) {
+				export = append(export, p.decide(traceID, t)...)
+			}
+		}
+		for traceID, d := range p.decided {
+			if d.decided.Before(deadline) {
+				delete(p.decided, traceID)
+			}
+		}
+		p.mu.Unlock()
+
+		for _, s := range export {
+			p.next.OnEnd(s)
+		}
+	}
+}
+
+// flush decides all pending traces
+func (p *tailProcessor) flush() {
+	var export []tracesdk.ReadOnlySpan
+	p.mu.Lock()
+	for traceID, t := range p.pending {
+		export = append(export, p.decide(traceID, t)...)
+	}
+	p.mu.Unlock()
+
+	for _, s := range export {
+		p.next.OnEnd(s)
+	}
+}
+
+func (p *tailProcessor) Shutdown(ctx context.Context) error {
+	p.once.Do(func() {
+		close(p.done)
+	})
+	p.flush()
+	return p.next.Shutdown(ctx)
+}
+
+func (p *tailProcessor) ForceFlush(ctx context.Context) error {
+	p.flush()
+	return p.next.ForceFlush(ctx)
+}
+
+// isFailed reports whether span has error status or "error" attribute set by
+// handlers of this repository
+func isFailed(s tracesdk.ReadOnlySpan) bool {
+	if s.Status().Code == codes.Error {
+		return true
+	}
+	for _, a := range s.Attributes() {
+		if a.Key == "error" && a.Value.AsBool() {
+			return true
+		}
+	}
+	return false
+}
+
+// isLocalRoot reports whether span has no parent in this process
+func isLocalRoot(s tracesdk.ReadOnlySpan) bool {
+	parent := s.Parent()
+	return !parent.IsValid() || parent.IsRemote()
+}
@@ -0,0 +1,162 @@
+package sampling
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// traceID returns trace ID with the given upper half, which decides sampling
+func traceID(high uint64) trace.TraceID {
+	var id trace.TraceID
+	binary.BigEndian.PutUint64(id[:8], high)
+	id[15] = 1
+	return id
+}
+
+// remoteParent returns context of span of another service
+func remoteParent(id trace.TraceID) context.Context {
+	return trace.ContextWithRemoteSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    id,
+		SpanID:     trace.SpanID{1},
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	}))
+}
+
+func newTestProvider(ratio float64) (*tracesdk.TracerProvider, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := tracesdk.NewTracerProvider(
+		tracesdk.WithSampler(tracesdk.AlwaysSample()),
+		tracesdk.WithSpanProcessor(NewTailProcessor(recorder, ratio)),
+	)
+	return provider, recorder
+}
+
+func spanNames(spans []tracesdk.ReadOnlySpan) []string {
+	names := make([]string, 0, len(spans))
+	for _, s := range spans {
+		names = append(names, s.Name())
+	}
+	return names
+}
+
+func TestTailProcessorKeepsEverythingWithRatioOne(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	if p := NewTailProcessor(recorder, 1); p != tracesdk.SpanProcessor(recorder) {
+		t.Errorf("NewTailProcessor with ratio 1 = %T, want next processor", p)
+	}
+}
+
+func TestTailProcessor(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		ratio float64
+		high  uint64
+		// fail marks child span as failed: "status" or "attribute"
+		fail string
+		want []string
+	}{
+		{name: "successful trace is dropped", ratio: 0, high: 0},
+		{name: "failed by status trace is kept", ratio: 0, fail: "status", want: []string{"child", "root"}},
+		{name: "failed by attribute trace is kept", ratio: 0, fail: "attribute", want: []string{"child", "root"}},
+		{name: "sampled successful trace is kept", ratio: 0.5, high: 1 << 61, want: []string{"child", "root"}},
+		{name: "not sampled successful trace is dropped", ratio: 0.5, high: 1<<64 - 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, recorder := newTestProvider(tt.ratio)
+			defer provider.Shutdown(context.Background())
+			tracer := provider.Tracer("test")
+
+			ctx, root := tracer.Start(remoteParent(traceID(tt.high)), "root")
+			_, child := tracer.Start(ctx, "child")
+			switch tt.fail {
+			case "status":
+				child.SetStatus(codes.Error, "failed")
+			case "attribute":
+				child.SetAttributes(attribute.Bool("error", true))
+			}
+			child.End()
+			if got := len(recorder.Ended()); got != 0 {
+				t.Fatalf("%d spans exported before local root ended", got)
+			}
+			root.End()
+
+			got := spanNames(recorder.Ended())
+			if len(got) != len(tt.want) {
+				t.Fatalf("exported %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("exported %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestTailProcessorAgreesWithTraceIDRatioBased(t *testing.T) {
+	const ratio = 0.3
+	p := NewTailProcessor(tracetest.NewSpanRecorder(), ratio).(*tailProcessor)
+	defer p.Shutdown(context.Background())
+	sampler := tracesdk.TraceIDRatioBased(ratio)
+	kept := 0
+	for i := uint64(0); i < 1000; i++ {
+		id := traceID(i * 0x9e3779b97f4a7c15)
+		want := sampler.ShouldSample(tracesdk.SamplingParameters{TraceID: id}).Decision == tracesdk.RecordAndSample
+		if got := p.sampled(id); got != want {
+			t.Fatalf("sampled(%s) = %v, TraceIDRatioBased decided %v", id, got, want)
+		}
+		if want {
+			kept++
+		}
+	}
+	if kept < 200 || kept > 400 {
+		t.Errorf("kept %d of 1000 traces with ratio %v", kept, ratio)
+	}
+}
+
+func TestTailProcessorLateSpans(t *testing.T) {
+	provider, recorder := newTestProvider(0)
+	defer provider.Shutdown(context.Background())
+	tracer := provider.Tracer("test")
+
+	ctx, root := tracer.Start(remoteParent(traceID(0)), "root")
+	_, healthy := tracer.Start(ctx, "late healthy")
+	_, failed := tracer.Start(ctx, "late failed")
+	root.End()
+	healthy.End()
+	failed.SetStatus(codes.Error, "failed")
+	failed.End()
+
+	// dropped trace is not exported, but its late failure is still seen alone
+	got := spanNames(recorder.Ended())
+	if len(got) != 1 || got[0] != "late failed" {
+		t.Errorf("exported %v, want [late failed]", got)
+	}
+}
+
+func TestTailProcessorShutdownFlushesPendingTraces(t *testing.T) {
+	provider, recorder := newTestProvider(0)
+	tracer := provider.Tracer("test")
+
+	ctx, root := tracer.Start(remoteParent(traceID(0)), "root")
+	_, child := tracer.Start(ctx, "child")
+	child.SetStatus(codes.Error, "failed")
+	child.End()
+	if err := provider.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := spanNames(recorder.Ended())
+	if len(got) != 1 || got[0] != "child" {
+		t.Errorf("exported %v, want [child]", got)
+	}
+	root.End()
+}
@@ -0,0 +1,166 @@
+// Package serviceauth lets storage and cache accept calls of other services
+// only. Caller proves itself by shared key sent in gRPC metadata or by client
+// certificate of allowed peer when mTLS is enabled. Without key and peers
+// every call is accepted, as before.
+package serviceauth
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/x509"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// Header is HTTP header with service key for REST gateways
+	Header = "X-Service-Key"
+
+	metadataKey = "x-service-key"
+	// healthPrefix is method prefix of health service, probes carry no key
+	healthPrefix = "/grpc.health.v1.Health/"
+)
+
+// UnaryClientInterceptor sends key in call metadata, empty key sends nothing.
+func UnaryClientInterceptor(key string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(outgoing(ctx, key), method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor sends key in stream metadata, empty key sends nothing.
+func StreamClientInterceptor(key string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(outgoing(ctx, key), desc, cc, method, opts...)
+	}
+}
+
+// UnaryServerInterceptor rejects calls with neither key nor client
+// certificate of one of peers with Unauthenticated. Peers are matched by
+// common name or DNS name of verified certificate.
+func UnaryServerInterceptor(key string, peers []string) grpc.UnaryServerInterceptor {
+	a := newAuthenticator(key, peers)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := a.authenticate(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor rejects streams the same way as
+// UnaryServerInterceptor rejects calls.
+func StreamServerInterceptor(key string, peers []string) grpc.StreamServerInterceptor {
+	a := newAuthenticator(key, peers)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := a.authenticate(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// Middleware rejects HTTP requests without key in Header with 401. Peers do
+// not apply, as REST gateways are served without TLS, so services trusting
+// peers must not serve gateways without key.
+func Middleware(key string, next http.Handler) http.Handler {
+	if key == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !equal(r.Header.Get(Header), key) {
+			trace.SpanFromContext(r.Context()).AddEvent("service authentication failed")
+			http.Error(w, "service key required", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type authenticator struct {
+	key   string
+	peers map[string]bool
+}
+
+func newAuthenticator(key string, peers []string) *authenticator {
+	a := &authenticator{
+		key:   key,
+		peers: make(map[string]bool, len(peers)),
+	}
+	for _, p := range peers {
+		a.peers[p] = true
+	}
+	return a
+}
+
+func (a *authenticator) authenticate(ctx context.Context, method string) error {
+	if a.key == "" && len(a.peers) == 0 || strings.HasPrefix(method, healthPrefix) {
+		return nil
+	}
+	if a.key != "" {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if keys := md.Get(metadataKey); len(keys) > 0 && equal(keys[0], a.key) {
+			return nil
+		}
+	}
+	if a.allowedPeer(ctx) {
+		return nil
+	}
+	trace.SpanFromContext(ctx).AddEvent("service authentication failed")
+	return status.Error(codes.Unauthenticated, "service key or client certificate of allowed peer required")
+}
+
+// allowedPeer reports whether caller presented verified certificate of one
+// of peers
+func (a *authenticator) allowedPeer(ctx context.Context) bool {
+	if len(a.peers) == 0 {
+		return false
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return false
+	}
+	for _, chain := range info.State.VerifiedChains {
+		if len(chain) > 0 && a.allowedCert(chain[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *authenticator) allowedCert(cert *x509.Certificate) bool {
+	if a.peers[cert.Subject.CommonName] {
+		return true
+	}
+	for _, name := range cert.DNSNames {
+		if a.peers[name] {
+			return true
+		}
+	}
+	return false
+}
+
+func outgoing(ctx context.Context, key string) context.Context {
+	if key != "" {
+		return metadata.AppendToOutgoingContext(ctx, metadataKey, key)
+	}
+	return ctx
+}
+
+// equal compares keys in constant time, so response time does not reveal
+// how much of key was guessed
+func equal(got, want string) bool {
+	return subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
@@ -0,0 +1,134 @@
+// Package telemetry sets up tracing of a service: span exporter, export
+// policy (sampling and redaction), resource attributes and context propagation.
+//
+// Every service calls Init once at start and Shutdown on exit, so spans of
+// all services are exported and linked in the same way.
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	jaegerPropogator "go.opentelemetry.io/contrib/propagators/jaeger"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/jaeger"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+
+	"github.com/asmyasnikov/webinar-jaeger/internal/identity"
+	"github.com/asmyasnikov/webinar-jaeger/internal/redact"
+	"github.com/asmyasnikov/webinar-jaeger/internal/requestid"
+	"github.com/asmyasnikov/webinar-jaeger/internal/sampling"
+)
+
+// shutdownTimeout bounds flushing of spans on exit, so the application does
+// not hang when collector is unreachable
+const shutdownTimeout = 5 * time.Second
+
+// Config is embedded into service config with `yaml:",inline"`, so its keys
+// stay top-level (TRACE_EXPORTER, -trace-exporter).
+type Config struct {
+	TraceExporter  string   `yaml:"trace_exporter" usage:"trace exporter: jaeger, otlp-grpc or otlp-http"`
+	JaegerEndpoint string   `yaml:"jaeger_endpoint" usage:"Jaeger collector endpoint"`
+	OtlpEndpoint   string   `yaml:"otlp_endpoint" usage:"OTLP collector host:port, OTEL_EXPORTER_OTLP_ENDPOINT is used if empty"`
+	OtlpInsecure   bool     `yaml:"otlp_insecure" usage:"disable TLS for OTLP exporter"`
+	SampleRatio    float64  `yaml:"sample_ratio" usage:"fraction of successful traces exported, failed traces are always exported"`
+	RedactParams   []string `yaml:"redact_params" usage:"comma-separated URL query parameters hidden in span attributes"`
+}
+
+// DefaultConfig exports every trace to local Jaeger.
+func DefaultConfig() Config {
+	return Config{
+		TraceExporter:  "jaeger",
+		JaegerEndpoint: "http://localhost:14268/api/traces",
+		OtlpInsecure:   true,
+		SampleRatio:    1,
+		RedactParams:   []string{"token", "access_token", "api_key", "key", "password", "secret", "signature", "sig"},
+	}
+}
+
+func spanExporter(ctx context.Context, cfg Config) (tracesdk.SpanExporter, error) {
+	switch cfg.TraceExporter {
+	case "jaeger":
+		return jaeger.New(jaeger.WithCollectorEndpoint(jaeger.WithEndpoint(cfg.JaegerEndpoint)))
+	case "otlp-grpc":
+		var opts []otlptracegrpc.Option
+		if cfg.OtlpEndpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(cfg.OtlpEndpoint))
+		}
+		if cfg.OtlpInsecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		return otlptracegrpc.New(ctx, opts...)
+	case "otlp-http":
+		var opts []otlptracehttp.Option
+		if cfg.OtlpEndpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.OtlpEndpoint))
+		}
+		if cfg.OtlpInsecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter '%s'", cfg.TraceExporter)
+	}
+}
+
+// Init creates tracer provider of service and installs it and the propagator
+// globally, so instrumentation libraries (otelgrpc, YDB) use them too.
+func Init(ctx context.Context, service string, cfg Config) (*tracesdk.TracerProvider, error) {
+	exp, err := spanExporter(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// OTEL_RESOURCE_ATTRIBUTES may add e.g. deployment.environment
+	res, err := resource.New(ctx,
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithAttributes(semconv.ServiceNameKey.String(service)),
+		resource.WithFromEnv(),
+		resource.WithHost(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	tp := tracesdk.NewTracerProvider(
+		// Always be sure to batch in production.
+		// Failed traces are always exported, successful ones by SampleRatio.
+		// Secrets are hidden before spans leave the process.
+		tracesdk.WithSpanProcessor(sampling.NewTailProcessor(
+			redact.NewSpanProcessor(tracesdk.NewBatchSpanProcessor(exp), cfg.RedactParams),
+			cfg.SampleRatio,
+		)),
+		tracesdk.WithSpanProcessor(requestid.SpanProcessor()),
+		tracesdk.WithSpanProcessor(identity.SpanProcessor()),
+		tracesdk.WithResource(res),
+		// every span is recorded, the tail processor decides what to export
+		tracesdk.WithSampler(tracesdk.AlwaysSample()),
+	)
+
+	// Jaeger headers link services of this repository with older clients,
+	// traceparent and baggage link them with proxies and W3C-only clients
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		jaegerPropogator.Jaeger{},
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	otel.SetTracerProvider(tp)
+
+	return tp, nil
+}
+
+// Shutdown flushes spans which are not exported yet.
+func Shutdown(ctx context.Context, tp *tracesdk.TracerProvider) error {
+	ctx, cancel := context.WithTimeout(ctx, shutdownTimeout)
+	defer cancel()
+	return tp.Shutdown(ctx)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/asmyasnikov/webinar-jaeger/internal/identity"
+	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
+)
+
+// adminScanLimit bounds links scanned by one ListLinks call, so rare matches
+// of filters do not make it read the whole table at once
+const adminScanLimit = 10000
+
+// admin moderates links of every user through storage calls made on behalf
+// of nobody, so links deleted by it are published to watchers as usual
+type admin struct {
+	pb.UnimplementedAdminServer
+
+	storage pb.StorageServer
+	bans    *bans
+}
+
+func newAdmin(s pb.StorageServer, b *bans) *admin {
+	return &admin{
+		storage: s,
+		bans:    b,
+	}
+}
+
+// authorize rejects calls made on behalf of user: http service checks admin
+// role of session and calls admin service on behalf of nobody
+func authorize(ctx context.Context) error {
+	if user := identity.Caller(ctx); user != "" {
+		return status.Errorf(codes.PermissionDenied, "user '%s' may not call admin service", user)
+	}
+	return nil
+}
+
+func (a *admin) ListLinks(ctx context.Context, request *pb.AdminListLinksRequest) (response *pb.AdminListLinksResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "AdminListLinks", trace.WithAttributes(
+		attribute.Int("page_size", int(request.GetPageSize())),
+		attribute.String("page_token", request.GetPageToken()),
+		attribute.String("owner", request.GetOwner()),
+		attribute.String("domain", request.GetDomain()),
+		attribute.String("url_contains", request.GetUrlContains()),
+	))
+	scanned := 0
+	defer func() {
+		span.SetAttributes(attribute.Int("scanned", scanned))
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("admin list done", trace.WithAttributes(
+				attribute.Int("count", len(response.GetLinks())),
+				attribute.String("next_page_token", response.GetNextPageToken()),
+			))
+		}
+		span.End()
+	}()
+
+	if err = authorize(ctx); err != nil {
+		return nil, err
+	}
+	var domain string
+	if request.GetDomain() != "" {
+		if domain, err = normalizeDomain(request.GetDomain()); err != nil {
+			return nil, invalidRequest(err.Error())
+		}
+	}
+	pageSize := int(request.GetPageSize())
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	} else if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	// links of one user are listed the way the user lists them
+	listCtx := ctx
+	if request.GetOwner() != "" {
+		listCtx = withOwner(ctx, request.GetOwner())
+	}
+	domains := map[string]struct{}{domain: {}}
+
+	response = &pb.AdminListLinksResponse{}
+	token := request.GetPageToken()
+	for {
+		page, err := a.storage.List(listCtx, &pb.ListRequest{
+			PageSize:  maxPageSize,
+			PageToken: token,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range page.GetLinks() {
+			token = l.GetHash()
+			scanned++
+			if domain != "" && matchDomain(l.GetUrl(), domains) == "" ||
+				!strings.Contains(l.GetUrl(), request.GetUrlContains()) {
+				continue
+			}
+			link := &pb.AdminLink{
+				Hash:  l.GetHash(),
+				Url:   l.GetUrl(),
+				Owner: request.GetOwner(),
+			}
+			if link.Owner == "" {
+				found, err := a.storage.Get(ctx, &pb.GetRequest{
+					Hash:        l.GetHash(),
+					Consistency: pb.Consistency_CONSISTENCY_STRONG,
+				})
+				if status.Code(err) == codes.NotFound {
+					// deleted since it was listed
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+				link.Owner = found.GetOwner()
+			}
+			response.Links = append(response.Links, link)
+			if len(response.Links) == pageSize {
+				response.NextPageToken = token
+				return response, nil
+			}
+		}
+		if page.GetNextPageToken() == "" {
+			return response, nil
+		}
+		if scanned >= adminScanLimit {
+			response.NextPageToken = token
+			return response, nil
+		}
+	}
+}
+
+func (a *admin) DeleteLink(ctx context.Context, request *pb.AdminDeleteLinkRequest) (_ *pb.AdminDeleteLinkResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "AdminDeleteLink", trace.WithAttributes(
+		attribute.String("hash", request.GetHash()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("admin delete done")
+		}
+		span.End()
+	}()
+
+	if err = authorize(ctx); err != nil {
+		return nil, err
+	}
+	if _, err = a.storage.Delete(ctx, &pb.DeleteRequest{Hash: request.GetHash()}); err != nil {
+		return nil, err
+	}
+	return &pb.AdminDeleteLinkResponse{}, nil
+}
+
+func (a *admin) BanDomain(ctx context.Context, request *pb.BanDomainRequest) (_ *pb.BanDomainResponse, err error) {
+	if err = a.setBan(ctx, "BanDomain", request.GetDomain(), true); err != nil {
+		return nil, err
+	}
+	return &pb.BanDomainResponse{}, nil
+}
+
+func (a *admin) UnbanDomain(ctx context.Context, request *pb.UnbanDomainRequest) (_ *pb.UnbanDomainResponse, err error) {
+	if err = a.setBan(ctx, "UnbanDomain", request.GetDomain(), false); err != nil {
+		return nil, err
+	}
+	return &pb.UnbanDomainResponse{}, nil
+}
+
+func (a *admin) setBan(ctx context.Context, name, domain string, banned bool) (err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, name, trace.WithAttributes(
+		attribute.String("domain", domain),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	if err = authorize(ctx); err != nil {
+		return err
+	}
+	if domain, err = normalizeDomain(domain); err != nil {
+		return invalidRequest(err.Error())
+	}
+	if err = a.bans.set(domain, banned); err != nil {
+		return status.Errorf(codes.Internal, "save banned domains failed: %v", err)
+	}
+	span.AddEvent("bans changed", trace.WithAttributes(
+		attribute.Bool("banned", banned),
+	))
+	return nil
+}
+
+func (a *admin) ListBannedDomains(ctx context.Context, _ *pb.ListBannedDomainsRequest) (*pb.ListBannedDomainsResponse, error) {
+	if err := authorize(ctx); err != nil {
+		return nil, err
+	}
+	return &pb.ListBannedDomainsResponse{
+		Domains: a.bans.list(),
+	}, nil
+}
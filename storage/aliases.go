@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// maxAliasLength is max length of alias in bytes
+const maxAliasLength = 64
+
+// aliasName is what users may claim as alias: human-readable and safe in url
+// path without escaping
+var aliasName = regexp.MustCompile(fmt.Sprintf(`^[a-zA-Z0-9][a-zA-Z0-9_-]{0,%d}$`, maxAliasLength-1))
+
+// aliasNotFound is error of missing alias, or alias of deleted link
+func aliasNotFound(user, alias string) error {
+	return fmt.Errorf("alias '%s' of user '%s': %w", alias, user, errNotFound)
+}
+
+// checkAliasOwner fails unless link owned by linkOwner belongs to user, who
+// claims alias for it. Unlike deletes, calls without user are not allowed.
+func checkAliasOwner(hash, user, linkOwner string) error {
+	if user == "" || user != linkOwner {
+		return fmt.Errorf("link '%s': %w", hash, errNotOwner)
+	}
+	return nil
+}
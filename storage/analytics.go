@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ydb-platform/ydb-go-sdk/v3"
+	"github.com/ydb-platform/ydb-go-sdk/v3/retry"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
+)
+
+type analytics struct {
+	pb.UnimplementedAnalyticsServer
+
+	db     *sql.DB
+	prefix string
+}
+
+func (a *analytics) Click(ctx context.Context, request *pb.ClickRequest) (response *pb.ClickResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "Click", trace.WithAttributes(
+		attribute.String("hash", request.GetHash()),
+		attribute.String("user_agent", request.GetUserAgent()),
+		attribute.String("referrer", request.GetReferrer()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("click done")
+		}
+		span.End()
+	}()
+	clickedAt := time.Now()
+	if request.GetTimestamp() != nil {
+		clickedAt = request.GetTimestamp().AsTime()
+	}
+	id := uuid.NewString()
+	err = retry.DoTx(ctx, a.db, func(ctx context.Context, tx *sql.Tx) (err error) {
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(`
+			PRAGMA TablePathPrefix("%s");
+
+			DECLARE $hash AS Text;
+			DECLARE $clicked_at AS Timestamp;
+			DECLARE $id AS Text;
+			DECLARE $user_agent AS Text;
+			DECLARE $referrer AS Text;
+
+			UPSERT INTO clicks (hash, clicked_at, id, user_agent, referrer)
+			VALUES ($hash, $clicked_at, $id, $user_agent, $referrer);
+		`, a.prefix),
+			sql.Named("hash", request.GetHash()),
+			sql.Named("clicked_at", clickedAt),
+			sql.Named("id", id),
+			sql.Named("user_agent", request.GetUserAgent()),
+			sql.Named("referrer", request.GetReferrer()),
+		)
+		return err
+	}, retry.WithDoTxRetryOptions(retry.WithIdempotent(true)))
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ClickResponse{}, nil
+}
+
+func (a *analytics) Stats(ctx context.Context, request *pb.StatsRequest) (response *pb.StatsResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "Stats", trace.WithAttributes(
+		attribute.String("hash", request.GetHash()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("stats done", trace.WithAttributes(
+				attribute.Int64("total_clicks", int64(response.GetTotalClicks())),
+			))
+		}
+		span.End()
+	}()
+	err = retry.DoTx(ctx, a.db, func(ctx context.Context, tx *sql.Tx) error {
+		row := tx.QueryRowContext(ctx, fmt.Sprintf(`
+			PRAGMA TablePathPrefix("%s");
+
+			DECLARE $hash AS Text;
+
+			SELECT COUNT(*), MAX(clicked_at) FROM clicks WHERE hash = $hash;
+		`, a.prefix), sql.Named("hash", request.GetHash()))
+		var (
+			total      uint64
+			lastAccess sql.NullTime
+		)
+		if err := row.Scan(&total, &lastAccess); err != nil {
+			return err
+		}
+		response = &pb.StatsResponse{
+			TotalClicks: total,
+		}
+		if lastAccess.Valid {
+			response.LastAccess = timestamppb.New(lastAccess.Time)
+		}
+		return row.Err()
+	}, retry.WithDoTxRetryOptions(retry.WithIdempotent(true)))
+	return response, err
+}
+
+// topClicked returns hashes of the most clicked links, the most clicked first.
+// Clicks are counted over the whole clicks table, so it is meant for rare
+// calls like warm-up of caches. Zero limit returns all clicked hashes.
+func (a *analytics) topClicked(ctx context.Context, limit uint64) (hashes []string, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "topClicked", trace.WithAttributes(
+		attribute.Int64("limit", int64(limit)),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("top clicked done", trace.WithAttributes(
+				attribute.Int("count", len(hashes)),
+			))
+		}
+		span.End()
+	}()
+	if limit == 0 {
+		limit = math.MaxUint64
+	}
+	err = retry.DoTx(ctx, a.db, func(ctx context.Context, tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, fmt.Sprintf(`
+			PRAGMA TablePathPrefix("%s");
+
+			DECLARE $limit AS Uint64;
+
+			SELECT hash, COUNT(*) AS clicks FROM clicks GROUP BY hash ORDER BY clicks DESC LIMIT $limit;
+		`, a.prefix), sql.Named("limit", limit))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		hashes = hashes[:0]
+		for rows.Next() {
+			var (
+				hash   string
+				clicks uint64
+			)
+			if err = rows.Scan(&hash, &clicks); err != nil {
+				return err
+			}
+			hashes = append(hashes, hash)
+		}
+		return rows.Err()
+	}, retry.WithDoTxRetryOptions(retry.WithIdempotent(true)))
+	return hashes, err
+}
+
+func initAnalyticsSchema(ctx context.Context, db *sql.DB, prefix string) (err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "initAnalyticsSchema")
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("schema prepared")
+		}
+		span.End()
+	}()
+	return retry.Do(ctx, db, func(ctx context.Context, cc *sql.Conn) error {
+		db, err := ydb.Unwrap(cc)
+		if err != nil {
+			return err
+		}
+
+		s, err := db.Table().CreateSession(ctx)
+		if err != nil {
+			return err
+		}
+		defer s.Close(ctx)
+
+		_, err = s.DescribeTable(ctx, path.Join(prefix, "clicks"))
+		if err == nil {
+			return nil
+		}
+
+		_, err = cc.ExecContext(
+			ydb.WithQueryMode(ctx, ydb.SchemeQueryMode),
+			fmt.Sprintf(`
+				PRAGMA TablePathPrefix("%s");
+
+				CREATE TABLE clicks (
+					hash Text,
+					clicked_at Timestamp,
+					id Text,
+					user_agent Text,
+					referrer Text,
+					PRIMARY KEY (
+						hash, clicked_at, id
+					)
+				) WITH (
+					AUTO_PARTITIONING_BY_LOAD = ENABLED
+				);
+			`, prefix),
+		)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "create clicks table failed: %v", err)
+			return err
+		}
+		return nil
+	}, retry.WithDoRetryOptions(retry.WithIdempotent(true)))
+}
+
+func newAnalytics(ctx context.Context, db *sql.DB, prefix string) (_ *analytics, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "newAnalytics")
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	if err = initAnalyticsSchema(ctx, db, prefix); err != nil {
+		return nil, err
+	}
+
+	return &analytics{
+		db:     db,
+		prefix: prefix,
+	}, nil
+}
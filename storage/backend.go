@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	ydbOtel "github.com/ydb-platform/ydb-go-sdk-otel"
+	"github.com/ydb-platform/ydb-go-sdk/v3"
+	"github.com/ydb-platform/ydb-go-sdk/v3/balancers"
+	ydbTrace "github.com/ydb-platform/ydb-go-sdk/v3/trace"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
+)
+
+// backend is a set of gRPC services served on top of one database
+type backend struct {
+	storage pb.StorageServer
+	// analytics is nil if backend does not support it
+	analytics pb.AnalyticsServer
+	// ping checks that database is reachable, nil means always reachable
+	ping func(ctx context.Context) error
+	// size estimates stored links for Stats, nil if backend can not
+	size sizeFunc
+	// purge hard-deletes soft deleted links, nil if backend keeps no such links
+	purge removeFunc
+	// cleanup hard-deletes expired links, nil if database removes them itself
+	cleanup removeFunc
+	// topClicked orders links of Export by clicks, nil if backend does not
+	// count clicks
+	topClicked topClickedFunc
+	close      func(ctx context.Context) error
+	// latencies are recorded by interceptor of gRPC server and reported by Stats
+	latencies *latencies
+	// bans are domains links may not point to, managed by admin service
+	bans *bans
+}
+
+type backendConstructor func(ctx context.Context, cfg *Config) (*backend, error)
+
+// backends are keyed by Config.Backend value. New backend needs only
+// a constructor registered here.
+var backends = map[string]backendConstructor{
+	"ydb":        newYdbBackend,
+	"ydb-native": newYdbNativeBackend,
+	"redis":      newRedisBackend,
+	"postgres":   newPostgresBackend,
+	"memory":     newMemoryBackend,
+}
+
+func newBackend(ctx context.Context, cfg *Config) (_ *backend, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "newBackend", trace.WithAttributes(
+		attribute.String("backend", cfg.Backend),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	constructor, ok := backends[cfg.Backend]
+	if !ok {
+		return nil, fmt.Errorf("unknown storage backend '%s'", cfg.Backend)
+	}
+
+	bans, err := loadBans(cfg.BannedDomainsFile)
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("banned_domains", len(bans.domains)))
+
+	b, err := constructor(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	b.bans = bans
+	// bans go first, so every way of storing links is checked
+	b.storage = newBannedStorage(b.storage, b.bans)
+	b.storage = newExportStorage(b.storage, b.topClicked)
+	// changes are published by any backend, so caches can watch them
+	b.storage = newWatchedStorage(b.storage)
+	b.latencies = newLatencies()
+	b.storage = newStatsStorage(b.storage, cfg.Backend, b.size, b.latencies)
+	b.storage = newStatusStorage(b.storage)
+
+	return b, nil
+}
+
+// ydbBalancers are values of Config.YdbPool.Balancer
+var ydbBalancers = map[string]func() ydb.Option{
+	"single": func() ydb.Option {
+		return ydb.WithBalancer(balancers.SingleConn())
+	},
+	"random_choice": func() ydb.Option {
+		return ydb.WithBalancer(balancers.RandomChoice())
+	},
+	// nodes of other data centers are used only if local one has none
+	"prefer_local_dc": func() ydb.Option {
+		return ydb.WithBalancer(balancers.PreferLocalDCWithFallBack(balancers.RandomChoice()))
+	},
+}
+
+func newYdbBackend(ctx context.Context, cfg *Config) (*backend, error) {
+	return openYdbBackend(ctx, cfg, false)
+}
+
+// newYdbNativeBackend serves links with table client of ydb-go-sdk instead of
+// database/sql. Schema and analytics still use database/sql.
+func newYdbNativeBackend(ctx context.Context, cfg *Config) (*backend, error) {
+	return openYdbBackend(ctx, cfg, true)
+}
+
+func openYdbBackend(ctx context.Context, cfg *Config, native bool) (_ *backend, err error) {
+	consistency, ok := consistencies[cfg.ReadConsistency]
+	if !ok {
+		return nil, fmt.Errorf("unknown read consistency '%s'", cfg.ReadConsistency)
+	}
+
+	balancer, ok := ydbBalancers[cfg.YdbPool.Balancer]
+	if !ok {
+		return nil, fmt.Errorf("unknown YDB balancer '%s'", cfg.YdbPool.Balancer)
+	}
+
+	credentials, err := ydbCredentials(cfg.YdbAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	// waits for a session of exhausted pool are spans of ydbOtel traces
+	db, err := ydb.Open(ctx, cfg.YdbDSN,
+		balancer(),
+		credentials,
+		ydb.WithSessionPoolSizeLimit(cfg.YdbPool.SizeLimit),
+		ydb.WithSessionPoolIdleThreshold(cfg.YdbPool.IdleThreshold),
+		ydb.WithConnectionTTL(cfg.YdbPool.ConnectionTTL),
+		ydbOtel.WithTraces(nil, ydbTrace.DetailsAll),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = db.Close(ctx)
+		}
+	}()
+
+	connector, err := ydb.Connector(db)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = connector.Close()
+		}
+	}()
+
+	// connector creates sessions bypassing session pool, so database/sql pool
+	// is limited the same way
+	sqlDB := sql.OpenDB(connector)
+	sqlDB.SetMaxOpenConns(cfg.YdbPool.SizeLimit)
+	sqlDB.SetMaxIdleConns(cfg.YdbPool.SizeLimit)
+	sqlDB.SetConnMaxIdleTime(cfg.YdbPool.IdleThreshold)
+
+	links := ydbTable{
+		prefix: ydbPrefix(db.Name(), cfg.YdbPrefix),
+		name:   cfg.YdbTable,
+	}
+
+	// newStorage prepares schema for native storage too
+	var s pb.StorageServer
+	s, err = newStorage(ctx, sqlDB, links, cfg.YdbTTL, cfg.RecreateSchema, consistency, cfg.UserQuota)
+	if err != nil {
+		return nil, err
+	}
+	if native {
+		s, err = newNativeStorage(ctx, db, links, consistency, cfg.UserQuota)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	a, err := newAnalytics(ctx, sqlDB, links.prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var clicks *clickConsumer
+	if cfg.ClickTopic != "" {
+		clicks, err = startClickConsumer(ctx, db, sqlDB, links.prefix, cfg.ClickTopic, cfg.ClickConsumer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &backend{
+		storage:    s,
+		analytics:  a,
+		topClicked: a.topClicked,
+		ping:       sqlDB.PingContext,
+		size: func(ctx context.Context) (uint64, uint64, error) {
+			return tableSize(ctx, sqlDB, links)
+		},
+		purge: func(ctx context.Context, before time.Time) (uint64, error) {
+			return purgeDeleted(ctx, sqlDB, newQueries(links), before)
+		},
+		close: func(ctx context.Context) error {
+			if clicks != nil {
+				_ = clicks.Close(ctx)
+			}
+			_ = connector.Close()
+			return db.Close(ctx)
+		},
+	}, nil
+}
+
+func newRedisBackend(ctx context.Context, cfg *Config) (*backend, error) {
+	s, err := newRedisStorage(ctx, cfg.RedisAddr, cfg.UserQuota)
+	if err != nil {
+		return nil, err
+	}
+
+	return &backend{
+		storage: s,
+		ping: func(ctx context.Context) error {
+			return s.client.Ping(ctx).Err()
+		},
+		size:    s.size,
+		purge:   s.purge,
+		cleanup: s.cleanup,
+		close: func(context.Context) error {
+			return s.Close()
+		},
+	}, nil
+}
+
+func newPostgresBackend(ctx context.Context, cfg *Config) (*backend, error) {
+	s, err := newPostgresStorage(ctx, cfg.PostgresDSN, cfg.UserQuota)
+	if err != nil {
+		return nil, err
+	}
+
+	return &backend{
+		storage: s,
+		ping:    s.db.PingContext,
+		size:    s.size,
+		purge:   s.purge,
+		cleanup: s.cleanup,
+		close: func(context.Context) error {
+			return s.Close()
+		},
+	}, nil
+}
+
+func newMemoryBackend(ctx context.Context, cfg *Config) (*backend, error) {
+	s, err := newMemoryStorage(ctx, cfg.UserQuota)
+	if err != nil {
+		return nil, err
+	}
+
+	return &backend{
+		storage: s,
+		size:    s.size,
+		purge:   s.purge,
+		cleanup: s.cleanup,
+		close: func(context.Context) error {
+			return nil
+		},
+	}, nil
+}
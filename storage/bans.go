@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+
+	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
+)
+
+// bans are destination domains banned by admins. Ban of domain covers its
+// subdomains too. Bans are kept in file, if one is set, so they survive
+// restarts; every storage instance keeps its own bans.
+type bans struct {
+	mu      sync.RWMutex
+	domains map[string]struct{}
+	file    string
+}
+
+// loadBans reads domains banned before from file, one per line. Missing
+// file means no bans yet.
+func loadBans(file string) (*bans, error) {
+	b := &bans{
+		domains: make(map[string]struct{}),
+		file:    file,
+	}
+	if file == "" {
+		return b, nil
+	}
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domain, err := normalizeDomain(line)
+		if err != nil {
+			return nil, fmt.Errorf("banned domains file '%s': %w", file, err)
+		}
+		b.domains[domain] = struct{}{}
+	}
+	return b, scanner.Err()
+}
+
+// normalizeDomain lowercases domain and rejects values which are not host
+// names, like urls or host:port pairs
+func normalizeDomain(domain string) (string, error) {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if domain == "" || strings.ContainsAny(domain, "/:@?# ") {
+		return "", fmt.Errorf("invalid domain '%s'", domain)
+	}
+	return domain, nil
+}
+
+// list returns banned domains in alphabetical order
+func (b *bans) list() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	domains := make([]string, 0, len(b.domains))
+	for domain := range b.domains {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+// set bans domain or lifts its ban and saves bans to file. Ban is kept in
+// memory even if file could not be written.
+func (b *bans) set(domain string, banned bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if banned {
+		b.domains[domain] = struct{}{}
+	} else {
+		delete(b.domains, domain)
+	}
+	return b.save()
+}
+
+// save rewrites file through temporary one, so failure leaves the old file
+func (b *bans) save() error {
+	if b.file == "" {
+		return nil
+	}
+	domains := make([]string, 0, len(b.domains))
+	for domain := range b.domains {
+		domains = append(domains, domain+"\n")
+	}
+	sort.Strings(domains)
+	tmp := b.file + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(domains, "")), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.file)
+}
+
+// banned returns banned domain url points to, empty if there is none
+func (b *bans) banned(rawURL string) string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if len(b.domains) == 0 {
+		return ""
+	}
+	return matchDomain(rawURL, b.domains)
+}
+
+// matchDomain returns domain of domains which host of url equals or is
+// a subdomain of, empty if there is none. Urls which do not parse match
+// nothing and are left to validation of callers.
+func matchDomain(rawURL string, domains map[string]struct{}) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	for host != "" {
+		if _, ok := domains[host]; ok {
+			return host
+		}
+		_, host, _ = strings.Cut(host, ".")
+	}
+	return ""
+}
+
+// check fails with PermissionDenied if url points to banned domain
+func (b *bans) check(ctx context.Context, rawURL string) error {
+	domain := b.banned(rawURL)
+	if domain == "" {
+		return nil
+	}
+	trace.SpanFromContext(ctx).AddEvent("domain banned", trace.WithAttributes(
+		attribute.String("domain", domain),
+	))
+	message := fmt.Sprintf("domain '%s' is banned", domain)
+	return detailedError(codes.PermissionDenied, pb.ErrorReason_ERROR_REASON_DOMAIN_BANNED, message,
+		fmt.Sprintf("url '%s': %s", rawURL, message))
+}
+
+// bannedStorage rejects links to banned domains before wrapped storage
+// stores them. Batch with a banned link is rejected as a whole.
+type bannedStorage struct {
+	pb.StorageServer
+
+	bans *bans
+}
+
+func newBannedStorage(s pb.StorageServer, b *bans) *bannedStorage {
+	return &bannedStorage{
+		StorageServer: s,
+		bans:          b,
+	}
+}
+
+func (s *bannedStorage) Put(ctx context.Context, request *pb.PutRequest) (*pb.PutResponse, error) {
+	if err := s.bans.check(ctx, request.GetUrl()); err != nil {
+		return nil, err
+	}
+	return s.StorageServer.Put(ctx, request)
+}
+
+func (s *bannedStorage) BatchPut(ctx context.Context, request *pb.BatchPutRequest) (*pb.BatchPutResponse, error) {
+	for _, l := range request.GetLinks() {
+		if err := s.bans.check(ctx, l.GetUrl()); err != nil {
+			return nil, err
+		}
+	}
+	return s.StorageServer.BatchPut(ctx, request)
+}
+
+// PutStream stores batches through BatchPut of this wrapper, so they are checked
+func (s *bannedStorage) PutStream(stream pb.Storage_PutStreamServer) error {
+	return putStream(stream, s.BatchPut)
+}
+
+func (s *bannedStorage) Import(stream pb.Storage_ImportServer) error {
+	return s.StorageServer.Import(&bannedImportStream{
+		Storage_ImportServer: stream,
+		bans:                 s.bans,
+	})
+}
+
+// bannedImportStream fails import on the first link to banned domain. Batches
+// imported before are not rolled back.
+type bannedImportStream struct {
+	pb.Storage_ImportServer
+
+	bans *bans
+}
+
+func (s *bannedImportStream) Recv() (*pb.PutRequest, error) {
+	link, err := s.Storage_ImportServer.Recv()
+	if err != nil {
+		return nil, err
+	}
+	if err = s.bans.check(s.Context(), link.GetUrl()); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
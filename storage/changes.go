@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc/codes"
+
+	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
+)
+
+// watcherBuffer is number of changes waiting for slow watcher. Watcher which
+// falls behind further is disconnected, it must drop its state on reconnect.
+const watcherBuffer = 1024
+
+// changes broadcasts changes of links to watchers
+type changes struct {
+	mu       sync.Mutex
+	watchers map[chan *pb.Change]struct{}
+}
+
+func newChanges() *changes {
+	return &changes{
+		watchers: make(map[chan *pb.Change]struct{}),
+	}
+}
+
+func (c *changes) publish(change *pb.Change) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for ch := range c.watchers {
+		select {
+		case ch <- change:
+		default:
+			delete(c.watchers, ch)
+			close(ch)
+		}
+	}
+}
+
+// watch returns channel of changes which is closed if watcher falls behind
+func (c *changes) watch() (_ <-chan *pb.Change, cancel func()) {
+	ch := make(chan *pb.Change, watcherBuffer)
+	c.mu.Lock()
+	c.watchers[ch] = struct{}{}
+	c.mu.Unlock()
+	return ch, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if _, ok := c.watchers[ch]; ok {
+			delete(c.watchers, ch)
+			close(ch)
+		}
+	}
+}
+
+// watchedStorage publishes changes made through wrapped storage
+type watchedStorage struct {
+	pb.StorageServer
+
+	changes *changes
+}
+
+func newWatchedStorage(s pb.StorageServer) *watchedStorage {
+	return &watchedStorage{
+		StorageServer: s,
+		changes:       newChanges(),
+	}
+}
+
+func (s *watchedStorage) Put(ctx context.Context, request *pb.PutRequest) (*pb.PutResponse, error) {
+	response, err := s.StorageServer.Put(ctx, request)
+	if err == nil {
+		s.changes.publish(&pb.Change{
+			Hash:      request.GetHash(),
+			Url:       request.GetUrl(),
+			ExpiresAt: request.GetExpiresAt(),
+		})
+	}
+	return response, err
+}
+
+func (s *watchedStorage) BatchPut(ctx context.Context, request *pb.BatchPutRequest) (*pb.BatchPutResponse, error) {
+	response, err := s.StorageServer.BatchPut(ctx, request)
+	if err != nil {
+		return response, err
+	}
+	for i, result := range response.GetResults() {
+		if result.GetCollision() || i >= len(request.GetLinks()) {
+			continue
+		}
+		l := request.GetLinks()[i]
+		s.changes.publish(&pb.Change{
+			Hash:      l.GetHash(),
+			Url:       l.GetUrl(),
+			ExpiresAt: l.GetExpiresAt(),
+		})
+	}
+	return response, nil
+}
+
+// PutStream stores batches through BatchPut of this wrapper, so they are published
+func (s *watchedStorage) PutStream(stream pb.Storage_PutStreamServer) error {
+	return putStream(stream, s.BatchPut)
+}
+
+func (s *watchedStorage) Delete(ctx context.Context, request *pb.DeleteRequest) (*pb.DeleteResponse, error) {
+	response, err := s.StorageServer.Delete(ctx, request)
+	if err == nil {
+		s.changes.publish(&pb.Change{
+			Hash:    request.GetHash(),
+			Deleted: true,
+		})
+	}
+	return response, err
+}
+
+func (s *watchedStorage) WatchChanges(_ *pb.WatchChangesRequest, stream pb.Storage_WatchChangesServer) (err error) {
+	_, span := otel.GetTracerProvider().Tracer(applicationID).Start(stream.Context(), "WatchChanges")
+	sent := 0
+	defer func() {
+		span.SetAttributes(attribute.Int("sent", sent))
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	ch, cancel := s.changes.watch()
+	defer cancel()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case change, ok := <-ch:
+			if !ok {
+				return detailedError(codes.ResourceExhausted, pb.ErrorReason_ERROR_REASON_CHANGES_LOST,
+					"watcher fell behind, changes were lost", "watcher fell behind, changes were lost")
+			}
+			if err = stream.Send(change); err != nil {
+				return err
+			}
+			sent++
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ydb-platform/ydb-go-sdk/v3"
+	"github.com/ydb-platform/ydb-go-sdk/v3/retry"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table/types"
+	"github.com/ydb-platform/ydb-go-sdk/v3/topic/topicoptions"
+	"github.com/ydb-platform/ydb-go-sdk/v3/topic/topicreader"
+	"github.com/ydb-platform/ydb-go-sdk/v3/topic/topicsugar"
+	"github.com/ydb-platform/ydb-go-sdk/v3/topic/topictypes"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slog"
+
+	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
+)
+
+// clickConsumer reads clicks published by http service to YDB topic and
+// writes them into clicks table by batches. Every batch is traced by its own
+// root span linked to spans of redirects which published the clicks.
+type clickConsumer struct {
+	db     *sql.DB
+	prefix string
+	topic  string
+	reader *topicreader.Reader
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// startClickConsumer creates topic with consumer if it is missing and starts
+// reading it in background, relative topic path is resolved against database
+func startClickConsumer(ctx context.Context, db ydb.Connection, sqlDB *sql.DB, prefix, topic, consumer string) (_ *clickConsumer, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "startClickConsumer", trace.WithAttributes(
+		attribute.String("topic", topic),
+		attribute.String("consumer", consumer),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	if !strings.HasPrefix(topic, "/") {
+		topic = path.Join(db.Name(), topic)
+	}
+
+	desc, err := db.Topic().Describe(ctx, topic)
+	if err != nil {
+		err = db.Topic().Create(ctx, topic, topicoptions.CreateWithConsumer(topictypes.Consumer{
+			Name: consumer,
+		}))
+		if err != nil {
+			return nil, err
+		}
+		span.AddEvent("topic created")
+	} else if !hasConsumer(desc, consumer) {
+		err = db.Topic().Alter(ctx, topic, topicoptions.AlterWithAddConsumers(topictypes.Consumer{
+			Name: consumer,
+		}))
+		if err != nil {
+			return nil, err
+		}
+		span.AddEvent("consumer added")
+	}
+
+	reader, err := db.Topic().StartReader(consumer, topicoptions.ReadTopic(topic))
+	if err != nil {
+		return nil, err
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	c := &clickConsumer{
+		db:     sqlDB,
+		prefix: prefix,
+		topic:  topic,
+		reader: reader,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go c.run(runCtx)
+
+	return c, nil
+}
+
+func hasConsumer(desc topictypes.TopicDescription, consumer string) bool {
+	for _, c := range desc.Consumers {
+		if c.Name == consumer {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *clickConsumer) run(ctx context.Context) {
+	defer close(c.done)
+	for {
+		batch, err := c.reader.ReadMessageBatch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			slog.ErrorCtx(ctx, "read clicks failed", slog.Any("error", err))
+			time.Sleep(time.Second)
+			continue
+		}
+		if err = c.consume(ctx, batch); err != nil {
+			slog.ErrorCtx(ctx, "consume clicks failed", slog.Any("error", err))
+		}
+	}
+}
+
+// consume writes clicks of batch and commits it. Click id is made of message
+// partition and offset, so batch read again after failed commit overwrites
+// the same rows.
+func (c *clickConsumer) consume(ctx context.Context, batch *topicreader.Batch) (err error) {
+	var (
+		links []trace.Link
+		rows  = make([]types.Value, 0, len(batch.Messages))
+	)
+	for _, msg := range batch.Messages {
+		var event pb.ClickEvent
+		if err = topicsugar.ProtoUnmarshal(msg, &event); err != nil {
+			return err
+		}
+		producer := otel.GetTextMapPropagator().Extract(context.Background(), propagation.MapCarrier(event.GetTraceContext()))
+		if sc := trace.SpanContextFromContext(producer); sc.IsValid() {
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+		click := event.GetClick()
+		clickedAt := msg.CreatedAt
+		if click.GetTimestamp() != nil {
+			clickedAt = click.GetTimestamp().AsTime()
+		}
+		rows = append(rows, types.StructValue(
+			types.StructFieldValue("hash", types.TextValue(click.GetHash())),
+			types.StructFieldValue("clicked_at", types.TimestampValueFromTime(clickedAt)),
+			types.StructFieldValue("id", types.TextValue(fmt.Sprintf("%d:%d", msg.PartitionID(), msg.Offset))),
+			types.StructFieldValue("user_agent", types.TextValue(click.GetUserAgent())),
+			types.StructFieldValue("referrer", types.TextValue(click.GetReferrer())),
+		))
+	}
+
+	// batch has no single parent: it is a root span linked to every producer
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "consume clicks",
+		trace.WithNewRoot(),
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			attribute.String("topic", c.topic),
+			attribute.Int("count", len(rows)),
+		),
+	)
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("clicks committed")
+		}
+		span.End()
+	}()
+
+	if len(rows) > 0 {
+		err = retry.DoTx(ctx, c.db, func(ctx context.Context, tx *sql.Tx) (err error) {
+			_, err = tx.ExecContext(ctx, fmt.Sprintf(`
+				PRAGMA TablePathPrefix("%s");
+
+				DECLARE $clicks AS List<Struct<
+					hash: Text,
+					clicked_at: Timestamp,
+					id: Text,
+					user_agent: Text,
+					referrer: Text
+				>>;
+
+				UPSERT INTO clicks SELECT * FROM AS_TABLE($clicks);
+			`, c.prefix), sql.Named("clicks", types.ListValue(rows...)))
+			return err
+		}, retry.WithDoTxRetryOptions(retry.WithIdempotent(true)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.reader.Commit(ctx, batch)
+}
+
+func (c *clickConsumer) Close(ctx context.Context) error {
+	c.cancel()
+	<-c.done
+	return c.reader.Close(ctx)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/ydb-platform/ydb-go-sdk/v3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// metadataTokenURL is token of service account attached to cloud VM
+	metadataTokenURL = "http://169.254.169.254/computeMetadata/v1/instance/service-accounts/default/token"
+	// tokenRefreshMargin is how long before expiration IAM token is renewed
+	tokenRefreshMargin = 5 * time.Minute
+	// jwtTTL is lifetime of JWT exchanged for IAM token
+	jwtTTL = time.Hour
+)
+
+// ydbCredentials returns credentials option of ydb.Open for configured
+// auth, anonymous access if none is configured
+func ydbCredentials(cfg ydbAuthConfig) (ydb.Option, error) {
+	configured := 0
+	for _, set := range []bool{cfg.Token != "", cfg.ServiceAccountKeyFile != "", cfg.Metadata} {
+		if set {
+			configured++
+		}
+	}
+	if configured > 1 {
+		return nil, errors.New("only one of YDB token, service account key file and metadata credentials can be set")
+	}
+
+	switch {
+	case cfg.Token != "":
+		return ydb.WithAccessTokenCredentials(cfg.Token), nil
+	case cfg.ServiceAccountKeyFile != "":
+		key, err := readServiceAccountKey(cfg.ServiceAccountKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return ydb.WithCredentials(&iamToken{
+			source: "service account key",
+			fetch: func(ctx context.Context) (string, time.Time, error) {
+				return exchangeServiceAccountKey(ctx, cfg.IAMEndpoint, key)
+			},
+		}), nil
+	case cfg.Metadata:
+		return ydb.WithCredentials(&iamToken{
+			source: "metadata",
+			fetch:  fetchMetadataToken,
+		}), nil
+	default:
+		return ydb.WithAnonymousCredentials(), nil
+	}
+}
+
+// iamToken caches short-lived IAM token and fetches a new one shortly
+// before it expires
+type iamToken struct {
+	source string
+	fetch  func(ctx context.Context) (token string, expiresAt time.Time, err error)
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+func (t *iamToken) Token(ctx context.Context) (_ string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.token != "" && time.Until(t.expiresAt) > tokenRefreshMargin {
+		return t.token, nil
+	}
+
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "fetch YDB token", trace.WithAttributes(
+		attribute.String("source", t.source),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	token, expiresAt, err := t.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	t.token, t.expiresAt = token, expiresAt
+	span.AddEvent("token fetched", trace.WithAttributes(
+		attribute.String("expires_at", expiresAt.Format(time.RFC3339)),
+	))
+
+	return token, nil
+}
+
+// serviceAccountKey is authorized key file created by
+// `yc iam key create --service-account-name <name> -o key.json`
+type serviceAccountKey struct {
+	ID               string `json:"id"`
+	ServiceAccountID string `json:"service_account_id"`
+	PrivateKey       string `json:"private_key"`
+}
+
+func readServiceAccountKey(path string) (*serviceAccountKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var key serviceAccountKey
+	if err = json.Unmarshal(data, &key); err != nil {
+		return nil, fmt.Errorf("parse service account key '%s': %w", path, err)
+	}
+	if key.ID == "" || key.ServiceAccountID == "" || key.PrivateKey == "" {
+		return nil, fmt.Errorf("service account key '%s' misses id, service_account_id or private_key", path)
+	}
+	return &key, nil
+}
+
+// exchangeServiceAccountKey signs JWT with service account key and
+// exchanges it for IAM token
+func exchangeServiceAccountKey(ctx context.Context, endpoint string, key *serviceAccountKey) (string, time.Time, error) {
+	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(key.PrivateKey))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now()
+	claims := jwt.RegisteredClaims{
+		Issuer:    key.ServiceAccountID,
+		Audience:  jwt.ClaimStrings{endpoint},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(jwtTTL)),
+	}
+	signer := jwt.NewWithClaims(jwt.SigningMethodPS256, claims)
+	signer.Header["kid"] = key.ID
+	signed, err := signer.SignedString(privateKey)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	body, err := json.Marshal(map[string]string{"jwt": signed})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var response struct {
+		IamToken  string    `json:"iamToken"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	}
+	if err = doJSON(req, &response); err != nil {
+		return "", time.Time{}, err
+	}
+	return response.IamToken, response.ExpiresAt, nil
+}
+
+// fetchMetadataToken gets IAM token of service account attached to VM
+func fetchMetadataToken(ctx context.Context) (string, time.Time, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataTokenURL, nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	var response struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err = doJSON(req, &response); err != nil {
+		return "", time.Time{}, err
+	}
+	return response.AccessToken, time.Now().Add(time.Duration(response.ExpiresIn) * time.Second), nil
+}
+
+func doJSON(req *http.Request, v interface{}) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s", req.Method, req.URL, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
+)
+
+// topClickedFunc returns hashes of the most clicked links, zero limit
+// returns all of them
+type topClickedFunc func(ctx context.Context, limit uint64) (hashes []string, err error)
+
+// exportStorage serves Export by List and Get of wrapped storage, so every
+// backend exports links without queries of its own. Get tells expiration of
+// listed links and skips links deleted meanwhile.
+type exportStorage struct {
+	pb.StorageServer
+
+	// topClicked is nil if backend does not count clicks
+	topClicked topClickedFunc
+}
+
+func newExportStorage(s pb.StorageServer, topClicked topClickedFunc) *exportStorage {
+	return &exportStorage{
+		StorageServer: s,
+		topClicked:    topClicked,
+	}
+}
+
+func (s *exportStorage) Export(request *pb.ExportRequest, stream pb.Storage_ExportServer) (err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(stream.Context(), "Export", trace.WithAttributes(
+		attribute.Int64("limit", int64(request.GetLimit())),
+		attribute.String("order", request.GetOrder().String()),
+	))
+	var sent uint32
+	defer func() {
+		span.SetAttributes(attribute.Int64("count", int64(sent)))
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("export done")
+		}
+		span.End()
+	}()
+
+	// send streams link of hash unless it is gone or expired and reports
+	// whether export is complete
+	send := func(hash string) (bool, error) {
+		response, err := s.Get(ctx, &pb.GetRequest{
+			Hash: hash,
+		})
+		if errors.Is(err, errNotFound) || status.Code(err) == codes.NotFound {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if response.GetExpiresAt() != nil && response.GetExpiresAt().AsTime().Before(time.Now()) {
+			return false, nil
+		}
+		if err = stream.Send(&pb.PutRequest{
+			Url:       response.GetUrl(),
+			Hash:      hash,
+			ExpiresAt: response.GetExpiresAt(),
+		}); err != nil {
+			return false, err
+		}
+		sent++
+		return sent == request.GetLimit(), nil
+	}
+
+	switch request.GetOrder() {
+	case pb.ExportOrder_EXPORT_ORDER_MOST_CLICKED:
+		if s.topClicked == nil {
+			return status.Error(codes.FailedPrecondition, "backend does not count clicks")
+		}
+		// gone and expired links are skipped, so fewer links may be sent
+		hashes, err := s.topClicked(ctx, uint64(request.GetLimit()))
+		if err != nil {
+			return err
+		}
+		for _, hash := range hashes {
+			if done, err := send(hash); done || err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		var token string
+		for {
+			page, err := s.List(ctx, &pb.ListRequest{
+				PageSize:  maxPageSize,
+				PageToken: token,
+			})
+			if err != nil {
+				return err
+			}
+			for _, l := range page.GetLinks() {
+				if done, err := send(l.GetHash()); done || err != nil {
+					return err
+				}
+			}
+			if page.GetNextPageToken() == "" {
+				return nil
+			}
+			token = page.GetNextPageToken()
+		}
+	}
+}
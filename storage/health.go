@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const pingTimeout = time.Second
+
+// watchHealth pings backend every interval and reports serving status of
+// services (empty name means the whole server) until ctx is done
+func watchHealth(ctx context.Context, h *health.Server, b *backend, interval time.Duration, services ...string) {
+	services = append(services, "")
+	status := healthpb.HealthCheckResponse_UNKNOWN
+	for {
+		next := healthpb.HealthCheckResponse_SERVING
+		if err := ping(ctx, b); err != nil {
+			next = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		if next != status {
+			status = next
+			for _, service := range services {
+				h.SetServingStatus(service, status)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func ping(ctx context.Context, b *backend) error {
+	if b.ping == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	return b.ping(ctx)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/ydb-platform/ydb-go-sdk/v3"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table/types"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
+)
+
+// importBatchSize is number of links written by one BulkUpsert of Import
+const importBatchSize = 10000
+
+// bulkImport receives links from client and writes them into links table by
+// BulkUpsert of importBatchSize rows. BulkUpsert takes no transaction and no
+// locks, so it loads millions of links much faster than PutStream, but skips
+// collision checks. Batches stored before a failure are not rolled back.
+func bulkImport(stream pb.Storage_ImportServer, db ydb.Connection, links ydbTable) (err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(stream.Context(), "Import")
+	var (
+		rows     = make([]types.Value, 0, importBatchSize)
+		progress = &pb.ImportProgress{}
+	)
+	defer func() {
+		span.SetAttributes(
+			attribute.Int64("count", int64(progress.GetImported())+int64(len(rows))),
+			attribute.Int64("batches", int64(progress.GetBatches())),
+		)
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("import done")
+		}
+		span.End()
+	}()
+
+	tablePath := links.path()
+	flush := func() error {
+		if len(rows) == 0 {
+			return nil
+		}
+		err := db.Table().Do(ctx, func(ctx context.Context, s table.Session) error {
+			return s.BulkUpsert(ctx, tablePath, types.ListValue(rows...))
+		}, table.WithIdempotent())
+		if err != nil {
+			return err
+		}
+		progress = &pb.ImportProgress{
+			Imported: progress.GetImported() + uint64(len(rows)),
+			Batches:  progress.GetBatches() + 1,
+		}
+		rows = make([]types.Value, 0, importBatchSize)
+		span.AddEvent("batch imported", trace.WithAttributes(
+			attribute.Int64("imported", int64(progress.GetImported())),
+		))
+		return stream.Send(progress)
+	}
+
+	for {
+		link, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var expiresAt *time.Time
+		if link.GetExpiresAt() != nil {
+			t := link.GetExpiresAt().AsTime()
+			expiresAt = &t
+		}
+		rows = append(rows, types.StructValue(
+			types.StructFieldValue("hash", types.TextValue(link.GetHash())),
+			types.StructFieldValue("url", types.TextValue(link.GetUrl())),
+			types.StructFieldValue("expires_at", types.NullableTimestampValueFromTime(expiresAt)),
+			// imported link revives soft deleted one with the same hash
+			types.StructFieldValue("deleted_at", types.NullValue(types.TypeTimestamp)),
+			// and drops metadata of destination of the link it replaces
+			types.StructFieldValue("title", types.NullValue(types.TypeText)),
+			types.StructFieldValue("favicon_url", types.NullValue(types.TypeText)),
+		))
+		if len(rows) == importBatchSize {
+			if err = flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
@@ -2,142 +2,281 @@ package main
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
-	"github.com/ydb-platform/ydb-go-sdk/v3/trace"
-	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
-	"time"
+	"sync"
+	"syscall"
 
-	ydbOtel "github.com/ydb-platform/ydb-go-sdk-otel"
-	ydb "github.com/ydb-platform/ydb-go-sdk/v3"
-	"github.com/ydb-platform/ydb-go-sdk/v3/balancers"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
-	jaegerPropogator "go.opentelemetry.io/contrib/propagators/jaeger"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/exporters/jaeger"
-	"go.opentelemetry.io/otel/sdk/resource"
-	tracesdk "go.opentelemetry.io/otel/sdk/trace"
-	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/propagation"
+	"golang.org/x/exp/slog"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 
+	"github.com/asmyasnikov/webinar-jaeger/internal/identity"
+	"github.com/asmyasnikov/webinar-jaeger/internal/logging"
+	"github.com/asmyasnikov/webinar-jaeger/internal/metrics"
+	"github.com/asmyasnikov/webinar-jaeger/internal/requestid"
+	"github.com/asmyasnikov/webinar-jaeger/internal/serviceauth"
+	"github.com/asmyasnikov/webinar-jaeger/internal/telemetry"
 	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
 )
 
-const (
-	applicationID = "storage"
-	port          = 5300
-)
+const applicationID = "storage"
 
-func tracerProvider(url string) (*tracesdk.TracerProvider, error) {
-	exp, err := jaeger.New(jaeger.WithCollectorEndpoint(jaeger.WithEndpoint(url)))
-	if err != nil {
-		return nil, err
+// gracefulStop stops accepting new RPCs and waits for in-flight ones until ctx
+// is done, then closes remaining connections
+func gracefulStop(ctx context.Context, s *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.Stop()
+		<-done
 	}
+}
 
-	otel.SetTextMapPropagator(jaegerPropogator.Jaeger{})
-
-	tp := tracesdk.NewTracerProvider(
-		// Always be sure to batch in production.
-		tracesdk.WithBatcher(exp),
-		// Record information about this application in a Resource.
-		tracesdk.WithResource(resource.NewWithAttributes(
-			semconv.SchemaURL,
-			semconv.ServiceNameKey.String(applicationID),
-		)),
-		tracesdk.WithSampler(tracesdk.AlwaysSample()),
-	)
-
-	otel.SetTracerProvider(tp)
-
-	return tp, nil
+// extractTrace continues trace of REST client, the gateway calls storage
+// in-process, so gRPC propagation does not apply
+func extractTrace(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
 }
 
 func main() {
+	logging.Init(applicationID)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		slog.Error("load config failed", slog.Any("error", err))
+		return
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	tp, err := tracerProvider("http://localhost:14268/api/traces")
+	tp, err := telemetry.Init(ctx, applicationID, cfg.Telemetry)
 	if err != nil {
 		panic(err)
 	}
 	defer func(ctx context.Context) {
-		// Do not make the application hang when it is shutdown.
-		ctx, cancel = context.WithTimeout(ctx, time.Second*5)
-		defer cancel()
-		if err := tp.Shutdown(ctx); err != nil {
-			log.Fatal(err)
+		if err := telemetry.Shutdown(ctx, tp); err != nil {
+			slog.ErrorCtx(ctx, "shutdown tracer provider failed", slog.Any("error", err))
+			os.Exit(1)
 		}
 	}(ctx)
 
 	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "main")
 	defer span.End()
 
-	db, err := ydb.Open(ctx, "grpc://localhost:2136/local",
-		ydb.WithBalancer(balancers.SingleConn()),
-		ydbOtel.WithTraces(nil, trace.DetailsAll),
-	)
+	b, err := newBackend(ctx, cfg)
 	if err != nil {
 		span.SetAttributes(attribute.Bool("error", true))
 		span.RecordError(err)
-		fmt.Println(err)
+		slog.ErrorCtx(ctx, "init backend failed", slog.Any("error", err))
 		return
 	}
-	defer db.Close(ctx)
+	defer b.close(ctx)
 
-	connector, err := ydb.Connector(db)
-	if err != nil {
-		span.SetAttributes(attribute.Bool("error", true))
-		span.RecordError(err)
-		fmt.Println(err)
-		return
-	}
-	defer connector.Close()
+	span.SetAttributes(attribute.String("backend", cfg.Backend))
 
-	s, err := newStorage(ctx, sql.OpenDB(connector), db.Name())
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.Port))
 	if err != nil {
 		span.SetAttributes(attribute.Bool("error", true))
 		span.RecordError(err)
-		fmt.Println(err)
+		slog.ErrorCtx(ctx, "listen failed", slog.Any("error", err))
 		return
 	}
 
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
-	if err != nil {
-		span.SetAttributes(attribute.Bool("error", true))
-		span.RecordError(err)
-		fmt.Println(err)
-		return
+	opts := []grpc.ServerOption{
+		// tracing goes first, so others see span of the call
+		grpc.ChainUnaryInterceptor(
+			otelgrpc.UnaryServerInterceptor(),
+			requestid.UnaryServerInterceptor(),
+			identity.UnaryServerInterceptor(),
+			metrics.UnaryServerInterceptor(),
+			serviceauth.UnaryServerInterceptor(cfg.ServiceKey, cfg.ServicePeers),
+			b.latencies.UnaryServerInterceptor(),
+		),
+		grpc.ChainStreamInterceptor(
+			otelgrpc.StreamServerInterceptor(),
+			requestid.StreamServerInterceptor(),
+			identity.StreamServerInterceptor(),
+			metrics.StreamServerInterceptor(),
+			serviceauth.StreamServerInterceptor(cfg.ServiceKey, cfg.ServicePeers),
+		),
+	}
+	if cfg.TLS.Enabled() {
+		tlsConfig, err := cfg.TLS.ServerConfig()
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+			slog.ErrorCtx(ctx, "load TLS config failed", slog.Any("error", err))
+			return
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		span.AddEvent("mTLS enabled")
 	}
 
-	grpcServer := grpc.NewServer(
-		grpc.UnaryInterceptor(otelgrpc.UnaryServerInterceptor()),
-		grpc.StreamInterceptor(otelgrpc.StreamServerInterceptor()),
-	)
+	opts = append(opts, cfg.Keepalive.ServerOptions()...)
+	grpcServer := grpc.NewServer(opts...)
 
-	pb.RegisterStorageServer(grpcServer, s)
+	pb.RegisterStorageServer(grpcServer, b.storage)
 	span.AddEvent("storage server registered")
 
+	pb.RegisterAdminServer(grpcServer, newAdmin(b.storage, b.bans))
+	span.AddEvent("admin server registered")
+
+	services := []string{pb.Storage_ServiceDesc.ServiceName, pb.Admin_ServiceDesc.ServiceName}
+
+	if b.analytics != nil {
+		pb.RegisterAnalyticsServer(grpcServer, b.analytics)
+		span.AddEvent("analytics server registered")
+		services = append(services, pb.Analytics_ServiceDesc.ServiceName)
+	}
+
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	go watchHealth(ctx, healthServer, b, cfg.HealthInterval, services...)
+	span.AddEvent("health server registered")
+
+	// reflection lets grpcurl and evans call services without proto files
+	if cfg.GRPCReflection {
+		reflection.Register(grpcServer)
+		span.AddEvent("reflection registered")
+	}
+
+	if b.purge != nil && cfg.Purge.Interval > 0 {
+		go runMaintenance(ctx, maintenanceJob{
+			name:     "purge deleted links",
+			remove:   b.purge,
+			interval: cfg.Purge.Interval,
+			keep:     cfg.Purge.Retention,
+		})
+		span.AddEvent("purge of deleted links started")
+	}
+	if b.cleanup != nil && cfg.Cleanup.Interval > 0 {
+		go runMaintenance(ctx, maintenanceJob{
+			name:     "clean up expired links",
+			remove:   b.cleanup,
+			interval: cfg.Cleanup.Interval,
+			keep:     cfg.Cleanup.Grace,
+		})
+		span.AddEvent("cleanup of expired links started")
+	}
+
 	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, os.Interrupt)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(ch)
+
+	// gRPC server, REST gateway and metrics server stop service on failure,
+	// signals channel is never closed, signal arriving during shutdown would
+	// panic
+	done := make(chan struct{})
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			close(done)
+		})
+	}
 
 	go func() {
 		if err := grpcServer.Serve(listener); err != nil {
 			span.SetAttributes(attribute.Bool("error", true))
 			span.RecordError(err)
-			fmt.Println(err)
-			close(ch)
+			slog.ErrorCtx(ctx, "serve gRPC failed", slog.Any("error", err))
+			stop()
 		}
 	}()
 
-	fmt.Printf("Start storage service on port %d...\n", port)
+	slog.InfoCtx(ctx, "start storage service", slog.Int("port", cfg.Port))
+
+	var gatewayServer *http.Server
+	if cfg.GatewayPort != 0 {
+		gateway := runtime.NewServeMux()
+		if err = pb.RegisterStorageHandlerServer(ctx, gateway, b.storage); err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+			slog.ErrorCtx(ctx, "register REST gateway failed", slog.Any("error", err))
+			return
+		}
+		span.AddEvent("storage gateway registered")
+
+		gatewayServer = &http.Server{
+			Addr:    fmt.Sprintf(":%d", cfg.GatewayPort),
+			Handler: extractTrace(serviceauth.Middleware(cfg.ServiceKey, gateway)),
+		}
+
+		go func() {
+			if err := gatewayServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				span.SetAttributes(attribute.Bool("error", true))
+				span.RecordError(err)
+				slog.ErrorCtx(ctx, "serve REST gateway failed", slog.Any("error", err))
+				stop()
+			}
+		}()
 
-	for range ch {
-		fmt.Println("shutdown...")
+		slog.InfoCtx(ctx, "start storage REST gateway", slog.Int("port", cfg.GatewayPort))
+	}
+
+	if cfg.MetricsPort != 0 {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+
+		metricsServer := &http.Server{
+			Addr:    fmt.Sprintf(":%d", cfg.MetricsPort),
+			Handler: mux,
+		}
+		defer metricsServer.Close()
+
+		go func() {
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				span.SetAttributes(attribute.Bool("error", true))
+				span.RecordError(err)
+				slog.ErrorCtx(ctx, "serve metrics failed", slog.Any("error", err))
+				stop()
+			}
+		}()
+
+		slog.InfoCtx(ctx, "start metrics", slog.Int("port", cfg.MetricsPort))
+	}
+
+	select {
+	case <-ch:
 		span.AddEvent("received interrupt signal")
-		return
+	case <-done:
 	}
+	slog.InfoCtx(ctx, "shutdown")
+
+	// let clients route around this instance while it drains
+	healthServer.Shutdown()
+
+	stopCtx, stopCancel := context.WithTimeout(ctx, cfg.ShutdownTimeout)
+	defer stopCancel()
+
+	// gateway calls storage in-process, so it is drained first
+	if gatewayServer != nil {
+		if err := gatewayServer.Shutdown(stopCtx); err != nil {
+			_ = gatewayServer.Close()
+		}
+		span.AddEvent("gateway stopped")
+	}
+
+	gracefulStop(stopCtx, grpcServer)
+	span.AddEvent("grpc server stopped")
 }
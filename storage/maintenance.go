@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slog"
+)
+
+// maintenanceBatchSize is max number of links removed by one transaction or
+// statement of maintenance job
+const maintenanceBatchSize = 1000
+
+// removeFunc hard-deletes links which were soft deleted (purge) or expired
+// (cleanup) before given time and returns their number
+type removeFunc func(ctx context.Context, before time.Time) (removed uint64, err error)
+
+// maintenanceJob removes links every interval, keeping links deleted or
+// expired within the last keep
+type maintenanceJob struct {
+	// name is name of root span of every run
+	name     string
+	remove   removeFunc
+	interval time.Duration
+	keep     time.Duration
+}
+
+// runMaintenance runs job every interval until ctx is done
+func runMaintenance(ctx context.Context, job maintenanceJob) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(job.interval):
+		}
+		if err := job.run(ctx); err != nil && ctx.Err() == nil {
+			slog.ErrorCtx(ctx, job.name+" failed", slog.Any("error", err))
+		}
+	}
+}
+
+// run removes links once, traced by its own root span instead of span of the
+// whole service. Backends add event to the span for every removed batch.
+func (job maintenanceJob) run(ctx context.Context) (err error) {
+	before := time.Now().Add(-job.keep)
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, job.name,
+		trace.WithNewRoot(),
+		trace.WithAttributes(
+			attribute.String("before", before.Format(time.RFC3339)),
+		),
+	)
+	var removed uint64
+	defer func() {
+		span.SetAttributes(attribute.Int64("removed", int64(removed)))
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	removed, err = job.remove(ctx, before)
+	if removed > 0 {
+		slog.InfoCtx(ctx, job.name+" done", slog.Uint64("removed", removed))
+	}
+	return err
+}
+
+// batchRemoved marks batch of n removed links on span of maintenance job
+func batchRemoved(ctx context.Context, n int) {
+	trace.SpanFromContext(ctx).AddEvent("batch removed", trace.WithAttributes(
+		attribute.Int("count", n),
+	))
+}
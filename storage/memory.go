@@ -0,0 +1,487 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
+)
+
+// memoryStorage keeps links in process memory, so links are lost on restart.
+// It is intended for local walkthrough without YDB or Docker.
+type memoryStorage struct {
+	pb.UnimplementedStorageServer
+
+	mu    sync.RWMutex
+	links map[string]memoryLink
+	// byURL points url to hash of its latest link of every owner. Entry left
+	// by deleted or replaced link is detected on lookup, as link of hash has
+	// another url or owner.
+	byURL map[string]map[string]string
+	// aliases map aliases of every user to hashes of links
+	aliases map[string]map[string]string
+	// quota is max number of not expired links of one user, 0 is unlimited
+	quota int
+}
+
+type memoryLink struct {
+	url       string
+	expiresAt *timestamppb.Timestamp
+	owner     string
+	createdAt time.Time
+	// metadata of destination is nil until it is fetched, link stored again
+	// drops it
+	metadata *pb.LinkMetadata
+	// deletedAt is set for soft deleted link until it is purged
+	deletedAt time.Time
+}
+
+func (l memoryLink) expired() bool {
+	return l.expiresAt != nil && l.expiresAt.AsTime().Before(time.Now())
+}
+
+func (l memoryLink) deleted() bool {
+	return !l.deletedAt.IsZero()
+}
+
+// link returns not deleted link of hash. Caller must hold mu.
+func (s *memoryStorage) link(hash string) (memoryLink, bool) {
+	link, ok := s.links[hash]
+	if !ok || link.deleted() {
+		return memoryLink{}, false
+	}
+	return link, true
+}
+
+// free reports whether hash can be used for url of owner and whether the
+// same link is already stored. Caller must hold mu.
+func (s *memoryStorage) free(hash, url, owner string) (free, stored bool) {
+	link, ok := s.link(hash)
+	switch {
+	case !ok:
+		return true, false
+	case link.expired():
+		// expired link releases its hash
+		return true, false
+	case link.url == url && link.owner == owner:
+		return true, true
+	default:
+		return false, false
+	}
+}
+
+// count returns number of not expired links of owner. Caller must hold mu.
+func (s *memoryStorage) count(owner string) (n int) {
+	for _, l := range s.links {
+		if l.owner == owner && !l.expired() && !l.deleted() {
+			n++
+		}
+	}
+	return n
+}
+
+// store puts link, the same link stored again keeps its creation time.
+// Caller must hold mu.
+func (s *memoryStorage) store(request *pb.PutRequest, owner string) {
+	createdAt := time.Now()
+	if _, stored := s.free(request.GetHash(), request.GetUrl(), owner); stored {
+		createdAt = s.links[request.GetHash()].createdAt
+	}
+	s.links[request.GetHash()] = memoryLink{
+		url:       request.GetUrl(),
+		expiresAt: request.GetExpiresAt(),
+		owner:     owner,
+		createdAt: createdAt,
+	}
+	hashes, ok := s.byURL[request.GetUrl()]
+	if !ok {
+		hashes = make(map[string]string, 1)
+		s.byURL[request.GetUrl()] = hashes
+	}
+	hashes[owner] = request.GetHash()
+}
+
+func (s *memoryStorage) Put(ctx context.Context, request *pb.PutRequest) (response *pb.PutResponse, err error) {
+	_, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "Put", trace.WithAttributes(
+		attribute.String("url", request.GetUrl()),
+		attribute.String("hash", request.GetHash()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("put done")
+		}
+		span.End()
+	}()
+	user := owner(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	free, stored := s.free(request.GetHash(), request.GetUrl(), user)
+	if !free {
+		err = fmt.Errorf("hash '%s' already used by url '%s': %w", request.GetHash(), s.links[request.GetHash()].url, errCollision)
+		return nil, collisionStatus(err)
+	}
+	if !stored {
+		if err = checkQuota(ctx, user, s.quota, s.count(user), 1); err != nil {
+			return nil, ownerStatus(err)
+		}
+	}
+	s.store(request, user)
+	return &pb.PutResponse{Created: !stored}, nil
+}
+
+func (s *memoryStorage) BatchPut(ctx context.Context, request *pb.BatchPutRequest) (response *pb.BatchPutResponse, err error) {
+	_, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "BatchPut", trace.WithAttributes(
+		attribute.Int("count", len(request.GetLinks())),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("batch put done")
+		}
+		span.End()
+	}()
+	user := owner(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	response = &pb.BatchPutResponse{
+		Results: make([]*pb.PutResult, 0, len(request.GetLinks())),
+	}
+	links := make([]*pb.PutRequest, 0, len(request.GetLinks()))
+	// added are urls of new links met earlier in this batch by their hashes
+	added := make(map[string]string, len(request.GetLinks()))
+	for _, l := range request.GetLinks() {
+		free, stored := s.free(l.GetHash(), l.GetUrl(), user)
+		if url, ok := added[l.GetHash()]; ok {
+			free, stored = url == l.GetUrl(), true
+		}
+		if !free {
+			response.Results = append(response.Results, &pb.PutResult{
+				Hash:      l.GetHash(),
+				Collision: true,
+			})
+			continue
+		}
+		response.Results = append(response.Results, &pb.PutResult{
+			Hash:    l.GetHash(),
+			Created: !stored,
+		})
+		if !stored {
+			added[l.GetHash()] = l.GetUrl()
+			links = append(links, l)
+		}
+	}
+	// whole batch is rejected if its new links do not fit into quota
+	if err = checkQuota(ctx, user, s.quota, s.count(user), len(links)); err != nil {
+		return nil, ownerStatus(err)
+	}
+	for _, l := range links {
+		s.store(l, user)
+	}
+	return response, nil
+}
+
+// PutStream stores streamed links by batches of BatchPut
+func (s *memoryStorage) PutStream(stream pb.Storage_PutStreamServer) error {
+	return putStream(stream, s.BatchPut)
+}
+
+func (s *memoryStorage) Get(ctx context.Context, request *pb.GetRequest) (response *pb.GetResponse, err error) {
+	_, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "Get", trace.WithAttributes(
+		attribute.String("hash", request.GetHash()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("get done", trace.WithAttributes(
+				attribute.String("url", response.GetUrl()),
+			))
+		}
+		span.End()
+	}()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	link, ok := s.link(request.GetHash())
+	if !ok {
+		return nil, fmt.Errorf("url for hash '%s': %w", request.GetHash(), errNotFound)
+	}
+	return &pb.GetResponse{
+		Url:       link.url,
+		ExpiresAt: link.expiresAt,
+		Owner:     link.owner,
+		CreatedAt: timestamppb.New(link.createdAt),
+		Metadata:  link.metadata,
+	}, nil
+}
+
+func (s *memoryStorage) GetByURL(ctx context.Context, request *pb.GetByURLRequest) (response *pb.GetByURLResponse, err error) {
+	_, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "GetByURL", trace.WithAttributes(
+		attribute.String("url", request.GetUrl()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("get by url done", trace.WithAttributes(
+				attribute.String("hash", response.GetHash()),
+			))
+		}
+		span.End()
+	}()
+	user := owner(ctx)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for linkOwner, hash := range s.byURL[request.GetUrl()] {
+		if user != "" && linkOwner != user {
+			continue
+		}
+		link, ok := s.link(hash)
+		if !ok || link.url != request.GetUrl() || link.owner != linkOwner || link.expired() {
+			continue
+		}
+		return &pb.GetByURLResponse{
+			Hash:      hash,
+			ExpiresAt: link.expiresAt,
+		}, nil
+	}
+	return nil, fmt.Errorf("link to url '%s': %w", request.GetUrl(), errNotFound)
+}
+
+func (s *memoryStorage) Delete(ctx context.Context, request *pb.DeleteRequest) (response *pb.DeleteResponse, err error) {
+	_, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "Delete", trace.WithAttributes(
+		attribute.String("hash", request.GetHash()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("delete done")
+		}
+		span.End()
+	}()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	link, ok := s.link(request.GetHash())
+	if !ok {
+		return &pb.DeleteResponse{}, nil
+	}
+	if err = checkOwner(request.GetHash(), owner(ctx), link.owner); err != nil {
+		return nil, ownerStatus(err)
+	}
+	link.deletedAt = time.Now()
+	s.links[request.GetHash()] = link
+	return &pb.DeleteResponse{}, nil
+}
+
+// SetMetadata updates link only if it still points to url, link replaced or
+// deleted since metadata was fetched is left as is
+func (s *memoryStorage) SetMetadata(ctx context.Context, request *pb.SetMetadataRequest) (response *pb.SetMetadataResponse, err error) {
+	_, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "SetMetadata", trace.WithAttributes(
+		attribute.String("hash", request.GetHash()),
+		attribute.String("url", request.GetUrl()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("set metadata done")
+		}
+		span.End()
+	}()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	link, ok := s.link(request.GetHash())
+	if ok && link.url == request.GetUrl() {
+		link.metadata = &pb.LinkMetadata{
+			Title:      request.GetMetadata().GetTitle(),
+			FaviconUrl: request.GetMetadata().GetFaviconUrl(),
+		}
+		s.links[request.GetHash()] = link
+	}
+	return &pb.SetMetadataResponse{}, nil
+}
+
+func (s *memoryStorage) PutAlias(ctx context.Context, request *pb.PutAliasRequest) (response *pb.PutAliasResponse, err error) {
+	_, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "PutAlias", trace.WithAttributes(
+		attribute.String("alias", request.GetAlias()),
+		attribute.String("hash", request.GetHash()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("put alias done")
+		}
+		span.End()
+	}()
+	user := owner(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	link, ok := s.link(request.GetHash())
+	if !ok {
+		return nil, fmt.Errorf("url for hash '%s': %w", request.GetHash(), errNotFound)
+	}
+	if err = checkAliasOwner(request.GetHash(), user, link.owner); err != nil {
+		return nil, ownerStatus(err)
+	}
+	aliases, ok := s.aliases[user]
+	if !ok {
+		aliases = make(map[string]string, 1)
+		s.aliases[user] = aliases
+	}
+	aliases[request.GetAlias()] = request.GetHash()
+	return &pb.PutAliasResponse{}, nil
+}
+
+func (s *memoryStorage) GetByAlias(ctx context.Context, request *pb.GetByAliasRequest) (response *pb.GetByAliasResponse, err error) {
+	_, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "GetByAlias", trace.WithAttributes(
+		attribute.String("user", request.GetUser()),
+		attribute.String("alias", request.GetAlias()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("get by alias done", trace.WithAttributes(
+				attribute.String("hash", response.GetHash()),
+				attribute.String("url", response.GetUrl()),
+			))
+		}
+		span.End()
+	}()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hash, ok := s.aliases[request.GetUser()][request.GetAlias()]
+	if !ok {
+		return nil, aliasNotFound(request.GetUser(), request.GetAlias())
+	}
+	link, ok := s.link(hash)
+	// link of hash reused by another user does not take alias over
+	if !ok || link.owner != request.GetUser() {
+		return nil, aliasNotFound(request.GetUser(), request.GetAlias())
+	}
+	return &pb.GetByAliasResponse{
+		Hash:      hash,
+		Url:       link.url,
+		ExpiresAt: link.expiresAt,
+	}, nil
+}
+
+// purge hard-deletes links soft deleted before given time
+func (s *memoryStorage) purge(_ context.Context, before time.Time) (n uint64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, l := range s.links {
+		if l.deleted() && l.deletedAt.Before(before) {
+			delete(s.links, hash)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// cleanup hard-deletes links expired before given time
+func (s *memoryStorage) cleanup(_ context.Context, before time.Time) (n uint64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, l := range s.links {
+		if l.expiresAt != nil && l.expiresAt.AsTime().Before(before) {
+			delete(s.links, hash)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// size counts links and bytes of their hashes and urls
+func (s *memoryStorage) size(context.Context) (rows, bytes uint64, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for hash, l := range s.links {
+		bytes += uint64(len(hash) + len(l.url))
+	}
+	return uint64(len(s.links)), bytes, nil
+}
+
+func (s *memoryStorage) List(ctx context.Context, request *pb.ListRequest) (response *pb.ListResponse, err error) {
+	_, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "List", trace.WithAttributes(
+		attribute.Int64("page_size", int64(request.GetPageSize())),
+		attribute.String("page_token", request.GetPageToken()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("list done", trace.WithAttributes(
+				attribute.Int("count", len(response.GetLinks())),
+				attribute.String("next_page_token", response.GetNextPageToken()),
+			))
+		}
+		span.End()
+	}()
+	pageSize := int(request.GetPageSize())
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	} else if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	user := owner(ctx)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hashes := make([]string, 0, len(s.links))
+	for hash, l := range s.links {
+		if hash > request.GetPageToken() && !l.deleted() && (user == "" || l.owner == user) {
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Strings(hashes)
+	if len(hashes) > pageSize {
+		hashes = hashes[:pageSize]
+	}
+	response = &pb.ListResponse{
+		Links: make([]*pb.Link, 0, len(hashes)),
+	}
+	for _, hash := range hashes {
+		response.Links = append(response.Links, &pb.Link{
+			Hash:     hash,
+			Url:      s.links[hash].url,
+			Metadata: s.links[hash].metadata,
+		})
+	}
+	if len(hashes) == pageSize {
+		response.NextPageToken = hashes[len(hashes)-1]
+	}
+	return response, nil
+}
+
+func newMemoryStorage(ctx context.Context, quota int) (_ *memoryStorage, err error) {
+	_, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "newMemoryStorage")
+	defer span.End()
+
+	return &memoryStorage{
+		links:   make(map[string]memoryLink),
+		byURL:   make(map[string]map[string]string),
+		aliases: make(map[string]map[string]string),
+		quota:   quota,
+	}, nil
+}
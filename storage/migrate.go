@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ydb-platform/ydb-go-sdk/v3"
+	"github.com/ydb-platform/ydb-go-sdk/v3/retry"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table/options"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// migrationFiles are YQL scheme queries named <version>_<name>.sql. Every file
+// holds one statement, links table is referenced as {table}.
+// Migrations are up-only: applied file must never change, next change of
+// schema is a new file with the next version.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type migration struct {
+	version uint64
+	name    string
+	query   string
+}
+
+func loadMigrations() ([]migration, error) {
+	files, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	migrations := make([]migration, 0, len(files))
+	for _, f := range files {
+		name := strings.TrimSuffix(f.Name(), ".sql")
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.ParseUint(prefix, 10, 64)
+		if err != nil || version == 0 {
+			return nil, fmt.Errorf("migration '%s' has no version prefix", f.Name())
+		}
+		query, err := migrationFiles.ReadFile(path.Join("migrations", f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{
+			version: version,
+			name:    name,
+			query:   string(query),
+		})
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].version == migrations[i-1].version {
+			return nil, fmt.Errorf("migrations '%s' and '%s' have the same version",
+				migrations[i-1].name, migrations[i].name)
+		}
+	}
+	return migrations, nil
+}
+
+// initSchema applies migrations which are not recorded in schema version
+// table yet. Restart applies nothing and keeps stored links, unless recreate
+// asks to drop all tables and all stored links with them first.
+func initSchema(ctx context.Context, db *sql.DB, table ydbTable, recreate bool) (err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "initSchema", trace.WithAttributes(
+		attribute.Bool("recreate", recreate),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("schema prepared")
+		}
+		span.End()
+	}()
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	if recreate {
+		if err = dropSchema(ctx, db, table); err != nil {
+			return err
+		}
+		span.AddEvent("schema dropped")
+	}
+	// every attempt starts from recorded version, so retry after partial
+	// failure continues with the first not applied migration
+	return retry.Do(ctx, db, func(ctx context.Context, cc *sql.Conn) error {
+		version, err := schemaVersion(ctx, cc, table)
+		if err != nil {
+			return err
+		}
+		span.SetAttributes(attribute.Int64("schema.version", int64(version)))
+		for _, m := range migrations {
+			if m.version <= version {
+				continue
+			}
+			_, err = cc.ExecContext(
+				ydb.WithQueryMode(ctx, ydb.SchemeQueryMode),
+				table.bind(m.query),
+			)
+			if err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "migration %s failed: %v", m.name, err)
+				return err
+			}
+			if err = recordMigration(ctx, cc, table, m); err != nil {
+				return err
+			}
+			span.AddEvent("migration applied", trace.WithAttributes(
+				attribute.String("migration", m.name),
+			))
+		}
+		return nil
+	}, retry.WithDoRetryOptions(retry.WithIdempotent(true)))
+}
+
+// dropSchema drops tables created by migrations, missing tables are skipped
+func dropSchema(ctx context.Context, db *sql.DB, table ydbTable) error {
+	return retry.Do(ctx, db, func(ctx context.Context, cc *sql.Conn) error {
+		db, err := ydb.Unwrap(cc)
+		if err != nil {
+			return err
+		}
+
+		s, err := db.Table().CreateSession(ctx)
+		if err != nil {
+			return err
+		}
+		defer s.Close(ctx)
+
+		for _, tablePath := range []string{table.path(), table.aliasesPath(), table.versionPath()} {
+			if _, err = s.DescribeTable(ctx, tablePath); err != nil {
+				continue
+			}
+			if err = s.DropTable(ctx, tablePath); err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "drop %s table failed: %v", tablePath, err)
+				return err
+			}
+		}
+		return nil
+	}, retry.WithDoRetryOptions(retry.WithIdempotent(true)))
+}
+
+// schemaVersion returns version of the last applied migration. It creates
+// schema version table on the first start and records migrations applied by
+// versions without it.
+func schemaVersion(ctx context.Context, cc *sql.Conn, table ydbTable) (uint64, error) {
+	db, err := ydb.Unwrap(cc)
+	if err != nil {
+		return 0, err
+	}
+
+	s, err := db.Table().CreateSession(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer s.Close(ctx)
+
+	if _, err = s.DescribeTable(ctx, table.versionPath()); err != nil {
+		_, err = cc.ExecContext(
+			ydb.WithQueryMode(ctx, ydb.SchemeQueryMode),
+			fmt.Sprintf(`
+				PRAGMA TablePathPrefix("%s");
+
+				CREATE TABLE %s (
+					version Uint64,
+					name Text,
+					applied_at Timestamp,
+					PRIMARY KEY (
+						version
+					)
+				);
+			`, table.prefix, table.versionName()),
+		)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "create %s table failed: %v", table.versionName(), err)
+			return 0, err
+		}
+	}
+
+	var version uint64
+	err = cc.QueryRowContext(ctx, fmt.Sprintf(`
+		PRAGMA TablePathPrefix("%s");
+
+		SELECT COALESCE(MAX(version), 0ul) FROM %s;
+	`, table.prefix, table.versionName())).Scan(&version)
+	if err != nil || version > 0 {
+		return version, err
+	}
+
+	desc, err := s.DescribeTable(ctx, table.path())
+	if err != nil {
+		// empty database
+		return 0, nil
+	}
+	return baselineSchema(ctx, cc, table, desc)
+}
+
+// baselineSchema records migrations already applied to urls table created
+// before schema version table existed
+func baselineSchema(ctx context.Context, cc *sql.Conn, table ydbTable, desc options.Description) (uint64, error) {
+	version := uint64(1)
+	for _, c := range desc.Columns {
+		if c.Name == "expires_at" {
+			version = 2
+		}
+	}
+	for _, idx := range desc.Indexes {
+		if idx.Name == urlIndex && version == 2 {
+			version = 3
+		}
+	}
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+	for _, m := range migrations {
+		if m.version > version {
+			break
+		}
+		if err = recordMigration(ctx, cc, table, m); err != nil {
+			return 0, err
+		}
+	}
+	return version, nil
+}
+
+func recordMigration(ctx context.Context, cc *sql.Conn, table ydbTable, m migration) error {
+	_, err := cc.ExecContext(ctx, fmt.Sprintf(`
+			PRAGMA TablePathPrefix("%s");
+
+			DECLARE $version AS Uint64;
+			DECLARE $name AS Text;
+
+			UPSERT INTO %s (version, name, applied_at)
+			VALUES ($version, $name, CurrentUtcTimestamp());
+		`, table.prefix, table.versionName()),
+		sql.Named("version", m.version),
+		sql.Named("name", m.name),
+	)
+	return err
+}
@@ -0,0 +1,10 @@
+-- links table maps short code (hash) to original url
+CREATE TABLE {table} (
+	hash Text,
+	url Text,
+	PRIMARY KEY (
+		hash
+	)
+) WITH (
+	AUTO_PARTITIONING_BY_LOAD = ENABLED
+);
@@ -0,0 +1,2 @@
+-- links without expiration keep NULL expires_at
+ALTER TABLE {table} ADD COLUMN expires_at Timestamp;
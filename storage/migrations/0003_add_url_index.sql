@@ -0,0 +1,2 @@
+-- lookup by url for GetByURL, index is built in background
+ALTER TABLE {table} ADD INDEX url_index GLOBAL ON (url);
@@ -0,0 +1,2 @@
+-- owner of link, NULL for links stored on behalf of nobody
+ALTER TABLE {table} ADD COLUMN user_id Text;
@@ -0,0 +1,2 @@
+-- per-user listing and quota, index is built in background
+ALTER TABLE {table} ADD INDEX user_index GLOBAL ON (user_id);
@@ -0,0 +1,2 @@
+-- soft deleted links keep deletion time until purge, NULL for live links
+ALTER TABLE {table} ADD COLUMN deleted_at Timestamp;
@@ -0,0 +1,2 @@
+-- time link was stored, NULL for links stored before it was added and imported links
+ALTER TABLE {table} ADD COLUMN created_at Timestamp;
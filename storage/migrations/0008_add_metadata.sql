@@ -0,0 +1,2 @@
+-- metadata of destination page, NULL until it is fetched after link is stored
+ALTER TABLE {table} ADD COLUMN title Text, ADD COLUMN favicon_url Text;
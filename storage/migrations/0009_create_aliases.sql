@@ -0,0 +1,7 @@
+-- aliases users claim for their links, resolved under /u/{user}/{alias}
+CREATE TABLE {table}_aliases (
+	user_id Text,
+	alias Text,
+	hash Text,
+	PRIMARY KEY (user_id, alias)
+);
@@ -0,0 +1,702 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ydb-platform/ydb-go-sdk/v3"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table/result/named"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table/types"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
+)
+
+// nativeStorage is the same storage as storage, but it talks to YDB with
+// table client of ydb-go-sdk instead of database/sql. Reads are single
+// requests with online read-only transaction, so trace waterfall has no
+// begin and commit spans of database/sql transactions.
+type nativeStorage struct {
+	pb.UnimplementedStorageServer
+
+	db      ydb.Connection
+	table   ydbTable
+	queries queries
+	// consistency is applied to Get without explicit one
+	consistency pb.Consistency
+	// quota is max number of not expired links of one user, 0 is unlimited
+	quota int
+}
+
+// count returns number of not expired links of user if quota applies to
+// them, 0 otherwise
+func (s *nativeStorage) count(ctx context.Context, tx table.TransactionActor, user string) (int, error) {
+	if user == "" || s.quota <= 0 {
+		return 0, nil
+	}
+	res, err := tx.Execute(ctx, s.queries.countUser, table.NewQueryParameters(
+		table.ValueParam("$user_id", types.TextValue(user)),
+	))
+	if err != nil {
+		return 0, err
+	}
+	defer res.Close()
+	var n uint64
+	if res.NextResultSet(ctx) && res.NextRow() {
+		if err = res.ScanNamed(named.Required("links", &n)); err != nil {
+			return 0, err
+		}
+	}
+	return int(n), res.Err()
+}
+
+// readLink reads link with get query
+func (s *nativeStorage) readLink(ctx context.Context, tx table.TransactionActor, hash string) (link storedLink, err error) {
+	res, err := tx.Execute(ctx, s.queries.get, table.NewQueryParameters(
+		table.ValueParam("$hash", types.TextValue(hash)),
+	))
+	if err != nil {
+		return link, err
+	}
+	defer res.Close()
+	if res.NextResultSet(ctx) && res.NextRow() {
+		if err = res.ScanNamed(
+			named.Optional("url", &link.url),
+			named.Optional("expires_at", &link.deadline),
+			named.Optional("user_id", &link.user),
+			named.Optional("created_at", &link.createdAt),
+			named.Optional("title", &link.title),
+			named.Optional("favicon_url", &link.faviconURL),
+		); err != nil {
+			return storedLink{}, err
+		}
+	}
+	return link, res.Err()
+}
+
+func (s *nativeStorage) Put(ctx context.Context, request *pb.PutRequest) (response *pb.PutResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "Put", trace.WithAttributes(
+		attribute.String("url", request.GetUrl()),
+		attribute.String("hash", request.GetHash()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("put done")
+		}
+		span.End()
+	}()
+	var expiresAt *time.Time
+	if request.GetExpiresAt() != nil {
+		t := request.GetExpiresAt().AsTime()
+		expiresAt = &t
+		span.SetAttributes(attribute.String("expires_at", t.Format(time.RFC3339)))
+	}
+	user := owner(ctx)
+	created := false
+	err = s.db.Table().DoTx(ctx, func(ctx context.Context, tx table.TransactionActor) error {
+		link, err := s.readLink(ctx, tx, request.GetHash())
+		if err != nil {
+			return err
+		}
+		stored := false
+		switch {
+		case link.url == nil:
+		case link.deadline != nil && link.deadline.Before(time.Now()):
+			// expired link releases its hash
+		case *link.url != request.GetUrl() || ownerOf(link.user) != user:
+			// non-retryable error
+			return fmt.Errorf("hash '%s' already used by url '%s': %w", request.GetHash(), *link.url, errCollision)
+		default:
+			stored = true
+		}
+		created = !stored
+		if !stored {
+			n, err := s.count(ctx, tx, user)
+			if err != nil {
+				return err
+			}
+			if err = checkQuota(ctx, user, s.quota, n, 1); err != nil {
+				// non-retryable error
+				return err
+			}
+		}
+		createdAt := link.createdAt
+		if !stored {
+			now := time.Now()
+			createdAt = &now
+		}
+		_, err = tx.Execute(ctx, s.queries.put, table.NewQueryParameters(
+			table.ValueParam("$hash", types.TextValue(request.GetHash())),
+			table.ValueParam("$url", types.TextValue(request.GetUrl())),
+			table.ValueParam("$expires_at", types.NullableTimestampValueFromTime(expiresAt)),
+			table.ValueParam("$user_id", types.NullableTextValue(nullableOwner(user))),
+			// the same link stored again keeps its creation time
+			table.ValueParam("$created_at", types.NullableTimestampValueFromTime(createdAt)),
+		))
+		return err
+	}, table.WithIdempotent())
+	if errors.Is(err, errCollision) {
+		return nil, collisionStatus(err)
+	}
+	if err != nil {
+		return nil, ownerStatus(err)
+	}
+	return &pb.PutResponse{Created: created}, nil
+}
+
+// BatchPut stores all links in a single transaction. Links which hashes
+// already point to another url are skipped and reported as collisions, links
+// already stored get new expiration like on Put.
+func (s *nativeStorage) BatchPut(ctx context.Context, request *pb.BatchPutRequest) (response *pb.BatchPutResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "BatchPut", trace.WithAttributes(
+		attribute.Int("count", len(request.GetLinks())),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("batch put done")
+		}
+		span.End()
+	}()
+	if len(request.GetLinks()) == 0 {
+		return &pb.BatchPutResponse{}, nil
+	}
+	hashes := make([]types.Value, 0, len(request.GetLinks()))
+	for _, l := range request.GetLinks() {
+		hashes = append(hashes, types.TextValue(l.GetHash()))
+	}
+	user := owner(ctx)
+	err = s.db.Table().DoTx(ctx, func(ctx context.Context, tx table.TransactionActor) error {
+		res, err := tx.Execute(ctx, s.queries.getBatch, table.NewQueryParameters(
+			table.ValueParam("$hashes", types.ListValue(hashes...)),
+		))
+		if err != nil {
+			return err
+		}
+		defer res.Close()
+		// used are links which hold hashes, link of another owner collides
+		// with any url
+		used := make(map[string]string, len(hashes))
+		// stored are creation times of links of user already stored, the
+		// same link stored again keeps it
+		stored := make(map[string]*time.Time, len(hashes))
+		for res.NextResultSet(ctx) {
+			for res.NextRow() {
+				var (
+					hash, url, linkOwner *string
+					deadline, createdAt  *time.Time
+				)
+				if err = res.ScanNamed(
+					named.Optional("hash", &hash),
+					named.Optional("url", &url),
+					named.Optional("expires_at", &deadline),
+					named.Optional("user_id", &linkOwner),
+					named.Optional("created_at", &createdAt),
+				); err != nil {
+					return err
+				}
+				if hash == nil || url == nil {
+					continue
+				}
+				if deadline != nil && deadline.Before(time.Now()) {
+					// expired link releases its hash
+					continue
+				}
+				if ownerOf(linkOwner) != user {
+					used[*hash] = ""
+					continue
+				}
+				used[*hash] = *url
+				stored[*hash] = createdAt
+			}
+		}
+		if err = res.Err(); err != nil {
+			return err
+		}
+		results := make([]*pb.PutResult, 0, len(request.GetLinks()))
+		links := make([]types.Value, 0, len(request.GetLinks()))
+		added := 0
+		now := time.Now()
+		for _, l := range request.GetLinks() {
+			url, ok := used[l.GetHash()]
+			if ok && url != l.GetUrl() {
+				results = append(results, &pb.PutResult{
+					Hash:      l.GetHash(),
+					Collision: true,
+				})
+				continue
+			}
+			result := &pb.PutResult{
+				Hash: l.GetHash(),
+			}
+			results = append(results, result)
+			createdAt, isStored := stored[l.GetHash()]
+			if ok && !isStored {
+				// same link met earlier in this batch
+				continue
+			}
+			// link already stored is upserted once, with new expiration and
+			// its creation time
+			delete(stored, l.GetHash())
+			used[l.GetHash()] = l.GetUrl()
+			if !isStored {
+				createdAt = &now
+				added++
+				result.Created = true
+			}
+			var expiresAt *time.Time
+			if l.GetExpiresAt() != nil {
+				t := l.GetExpiresAt().AsTime()
+				expiresAt = &t
+			}
+			links = append(links, types.StructValue(
+				types.StructFieldValue("hash", types.TextValue(l.GetHash())),
+				types.StructFieldValue("url", types.TextValue(l.GetUrl())),
+				types.StructFieldValue("expires_at", types.NullableTimestampValueFromTime(expiresAt)),
+				types.StructFieldValue("user_id", types.NullableTextValue(nullableOwner(user))),
+				types.StructFieldValue("created_at", types.NullableTimestampValueFromTime(createdAt)),
+			))
+		}
+		n, err := s.count(ctx, tx, user)
+		if err != nil {
+			return err
+		}
+		// whole batch is rejected if its new links do not fit into quota
+		if err = checkQuota(ctx, user, s.quota, n, added); err != nil {
+			return err
+		}
+		if len(links) > 0 {
+			_, err = tx.Execute(ctx, s.queries.putBatch, table.NewQueryParameters(
+				table.ValueParam("$links", types.ListValue(links...)),
+			))
+			if err != nil {
+				return err
+			}
+		}
+		response = &pb.BatchPutResponse{
+			Results: results,
+		}
+		return nil
+	}, table.WithIdempotent())
+	if err != nil {
+		return nil, ownerStatus(err)
+	}
+	return response, nil
+}
+
+// PutStream stores streamed links by batches of BatchPut
+func (s *nativeStorage) PutStream(stream pb.Storage_PutStreamServer) error {
+	return putStream(stream, s.BatchPut)
+}
+
+// Import loads streamed links by BulkUpsert
+func (s *nativeStorage) Import(stream pb.Storage_ImportServer) error {
+	return bulkImport(stream, s.db, s.table)
+}
+
+func (s *nativeStorage) Get(ctx context.Context, request *pb.GetRequest) (response *pb.GetResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "Get", trace.WithAttributes(
+		attribute.String("hash", request.GetHash()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("get done", trace.WithAttributes(
+				attribute.String("url", response.GetUrl()),
+			))
+		}
+		span.End()
+	}()
+	txControl := table.OnlineReadOnlyTxControl()
+	if isStale(request.GetConsistency(), s.consistency) {
+		span.SetAttributes(attribute.Bool("stale", true))
+		txControl = table.StaleReadOnlyTxControl()
+	}
+	err = s.db.Table().Do(ctx, func(ctx context.Context, session table.Session) error {
+		_, res, err := session.Execute(ctx, txControl, s.queries.get, table.NewQueryParameters(
+			table.ValueParam("$hash", types.TextValue(request.GetHash())),
+		))
+		if err != nil {
+			return err
+		}
+		defer res.Close()
+		response = nil
+		if res.NextResultSet(ctx) && res.NextRow() {
+			var link storedLink
+			if err = res.ScanNamed(
+				named.Optional("url", &link.url),
+				named.Optional("expires_at", &link.deadline),
+				named.Optional("user_id", &link.user),
+				named.Optional("created_at", &link.createdAt),
+				named.Optional("title", &link.title),
+				named.Optional("favicon_url", &link.faviconURL),
+			); err != nil {
+				return err
+			}
+			if link.url != nil {
+				response = &pb.GetResponse{
+					Url:      *link.url,
+					Owner:    ownerOf(link.user),
+					Metadata: link.metadata(),
+				}
+				if link.deadline != nil {
+					response.ExpiresAt = timestamppb.New(*link.deadline)
+				}
+				if link.createdAt != nil {
+					response.CreatedAt = timestamppb.New(*link.createdAt)
+				}
+			}
+		}
+		return res.Err()
+	}, table.WithIdempotent())
+	if err != nil {
+		return nil, err
+	}
+	if response == nil {
+		return nil, fmt.Errorf("url for hash '%s': %w", request.GetHash(), errNotFound)
+	}
+	return response, nil
+}
+
+// GetByURL looks link up by url with secondary index. Url may be shortened
+// more than once, any not expired link of calling user is returned then.
+func (s *nativeStorage) GetByURL(ctx context.Context, request *pb.GetByURLRequest) (response *pb.GetByURLResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "GetByURL", trace.WithAttributes(
+		attribute.String("url", request.GetUrl()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("get by url done", trace.WithAttributes(
+				attribute.String("hash", response.GetHash()),
+			))
+		}
+		span.End()
+	}()
+	user := owner(ctx)
+	err = s.db.Table().Do(ctx, func(ctx context.Context, session table.Session) error {
+		_, res, err := session.Execute(ctx, table.OnlineReadOnlyTxControl(), s.queries.getByURL, table.NewQueryParameters(
+			table.ValueParam("$url", types.TextValue(request.GetUrl())),
+		))
+		if err != nil {
+			return err
+		}
+		defer res.Close()
+		response = nil
+		for response == nil && res.NextResultSet(ctx) {
+			for res.NextRow() {
+				var (
+					hash, linkOwner *string
+					expiresAt       *time.Time
+				)
+				if err = res.ScanNamed(
+					named.Optional("hash", &hash),
+					named.Optional("expires_at", &expiresAt),
+					named.Optional("user_id", &linkOwner),
+				); err != nil {
+					return err
+				}
+				if hash == nil || expiresAt != nil && expiresAt.Before(time.Now()) {
+					continue
+				}
+				if user != "" && ownerOf(linkOwner) != user {
+					continue
+				}
+				response = &pb.GetByURLResponse{
+					Hash: *hash,
+				}
+				if expiresAt != nil {
+					response.ExpiresAt = timestamppb.New(*expiresAt)
+				}
+				break
+			}
+		}
+		return res.Err()
+	}, table.WithIdempotent())
+	if err != nil {
+		return nil, err
+	}
+	if response == nil {
+		return nil, fmt.Errorf("link to url '%s': %w", request.GetUrl(), errNotFound)
+	}
+	return response, nil
+}
+
+func (s *nativeStorage) Delete(ctx context.Context, request *pb.DeleteRequest) (response *pb.DeleteResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "Delete", trace.WithAttributes(
+		attribute.String("hash", request.GetHash()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("delete done")
+		}
+		span.End()
+	}()
+	user := owner(ctx)
+	if user == "" {
+		// single request without interactive transaction
+		err = s.db.Table().Do(ctx, func(ctx context.Context, session table.Session) (err error) {
+			_, _, err = session.Execute(ctx, table.SerializableReadWriteTxControl(table.CommitTx()), s.queries.delete, table.NewQueryParameters(
+				table.ValueParam("$hash", types.TextValue(request.GetHash())),
+			))
+			return err
+		}, table.WithIdempotent())
+	} else {
+		err = s.db.Table().DoTx(ctx, func(ctx context.Context, tx table.TransactionActor) error {
+			link, err := s.readLink(ctx, tx, request.GetHash())
+			if err != nil || link.url == nil {
+				return err
+			}
+			if err = checkOwner(request.GetHash(), user, ownerOf(link.user)); err != nil {
+				// non-retryable error
+				return err
+			}
+			_, err = tx.Execute(ctx, s.queries.delete, table.NewQueryParameters(
+				table.ValueParam("$hash", types.TextValue(request.GetHash())),
+			))
+			return err
+		}, table.WithIdempotent())
+	}
+	if err != nil {
+		return nil, ownerStatus(err)
+	}
+	return &pb.DeleteResponse{}, nil
+}
+
+func (s *nativeStorage) List(ctx context.Context, request *pb.ListRequest) (response *pb.ListResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "List", trace.WithAttributes(
+		attribute.Int64("page_size", int64(request.GetPageSize())),
+		attribute.String("page_token", request.GetPageToken()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("list done", trace.WithAttributes(
+				attribute.Int("count", len(response.GetLinks())),
+				attribute.String("next_page_token", response.GetNextPageToken()),
+			))
+		}
+		span.End()
+	}()
+	pageSize := uint64(request.GetPageSize())
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	} else if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	query, params := s.queries.list, []table.ParameterOption{
+		table.ValueParam("$token", types.TextValue(request.GetPageToken())),
+		table.ValueParam("$limit", types.Uint64Value(pageSize)),
+	}
+	if user := owner(ctx); user != "" {
+		query, params = s.queries.listByUser, append(params, table.ValueParam("$user_id", types.TextValue(user)))
+	}
+	err = s.db.Table().Do(ctx, func(ctx context.Context, session table.Session) error {
+		_, res, err := session.Execute(ctx, table.OnlineReadOnlyTxControl(), query, table.NewQueryParameters(params...))
+		if err != nil {
+			return err
+		}
+		defer res.Close()
+		response = &pb.ListResponse{}
+		for res.NextResultSet(ctx) {
+			for res.NextRow() {
+				var hash, url, title, faviconURL *string
+				if err = res.ScanNamed(
+					named.Optional("hash", &hash),
+					named.Optional("url", &url),
+					named.Optional("title", &title),
+					named.Optional("favicon_url", &faviconURL),
+				); err != nil {
+					return err
+				}
+				if hash == nil || url == nil {
+					continue
+				}
+				response.Links = append(response.Links, &pb.Link{
+					Hash:     *hash,
+					Url:      *url,
+					Metadata: metadataOf(title, faviconURL),
+				})
+			}
+		}
+		if err = res.Err(); err != nil {
+			return err
+		}
+		if uint64(len(response.Links)) == pageSize {
+			response.NextPageToken = response.Links[len(response.Links)-1].GetHash()
+		}
+		return nil
+	}, table.WithIdempotent())
+	return response, err
+}
+
+// SetMetadata updates link only if it still points to url, link replaced or
+// deleted since metadata was fetched is left as is
+func (s *nativeStorage) SetMetadata(ctx context.Context, request *pb.SetMetadataRequest) (response *pb.SetMetadataResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "SetMetadata", trace.WithAttributes(
+		attribute.String("hash", request.GetHash()),
+		attribute.String("url", request.GetUrl()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("set metadata done")
+		}
+		span.End()
+	}()
+	err = s.db.Table().Do(ctx, func(ctx context.Context, session table.Session) (err error) {
+		_, _, err = session.Execute(ctx, table.SerializableReadWriteTxControl(table.CommitTx()), s.queries.setMetadata, table.NewQueryParameters(
+			table.ValueParam("$hash", types.TextValue(request.GetHash())),
+			table.ValueParam("$url", types.TextValue(request.GetUrl())),
+			table.ValueParam("$title", types.TextValue(request.GetMetadata().GetTitle())),
+			table.ValueParam("$favicon_url", types.TextValue(request.GetMetadata().GetFaviconUrl())),
+		))
+		return err
+	}, table.WithIdempotent())
+	if err != nil {
+		return nil, err
+	}
+	return &pb.SetMetadataResponse{}, nil
+}
+
+func (s *nativeStorage) PutAlias(ctx context.Context, request *pb.PutAliasRequest) (response *pb.PutAliasResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "PutAlias", trace.WithAttributes(
+		attribute.String("alias", request.GetAlias()),
+		attribute.String("hash", request.GetHash()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("put alias done")
+		}
+		span.End()
+	}()
+	user := owner(ctx)
+	err = s.db.Table().DoTx(ctx, func(ctx context.Context, tx table.TransactionActor) error {
+		link, err := s.readLink(ctx, tx, request.GetHash())
+		if err != nil {
+			return err
+		}
+		if link.url == nil {
+			// non-retryable error
+			return fmt.Errorf("url for hash '%s': %w", request.GetHash(), errNotFound)
+		}
+		if err = checkAliasOwner(request.GetHash(), user, ownerOf(link.user)); err != nil {
+			// non-retryable error
+			return err
+		}
+		_, err = tx.Execute(ctx, s.queries.putAlias, table.NewQueryParameters(
+			table.ValueParam("$user_id", types.TextValue(user)),
+			table.ValueParam("$alias", types.TextValue(request.GetAlias())),
+			table.ValueParam("$hash", types.TextValue(request.GetHash())),
+		))
+		return err
+	}, table.WithIdempotent())
+	if err != nil {
+		return nil, ownerStatus(err)
+	}
+	return &pb.PutAliasResponse{}, nil
+}
+
+func (s *nativeStorage) GetByAlias(ctx context.Context, request *pb.GetByAliasRequest) (response *pb.GetByAliasResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "GetByAlias", trace.WithAttributes(
+		attribute.String("user", request.GetUser()),
+		attribute.String("alias", request.GetAlias()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("get by alias done", trace.WithAttributes(
+				attribute.String("hash", response.GetHash()),
+				attribute.String("url", response.GetUrl()),
+			))
+		}
+		span.End()
+	}()
+	err = s.db.Table().Do(ctx, func(ctx context.Context, session table.Session) error {
+		_, res, err := session.Execute(ctx, table.OnlineReadOnlyTxControl(), s.queries.getByAlias, table.NewQueryParameters(
+			table.ValueParam("$user_id", types.TextValue(request.GetUser())),
+			table.ValueParam("$alias", types.TextValue(request.GetAlias())),
+		))
+		if err != nil {
+			return err
+		}
+		defer res.Close()
+		response = nil
+		if res.NextResultSet(ctx) && res.NextRow() {
+			var (
+				hash, url *string
+				expiresAt *time.Time
+			)
+			if err = res.ScanNamed(
+				named.Optional("hash", &hash),
+				named.Optional("url", &url),
+				named.Optional("expires_at", &expiresAt),
+			); err != nil {
+				return err
+			}
+			if hash != nil && url != nil {
+				response = &pb.GetByAliasResponse{
+					Hash: *hash,
+					Url:  *url,
+				}
+				if expiresAt != nil {
+					response.ExpiresAt = timestamppb.New(*expiresAt)
+				}
+			}
+		}
+		return res.Err()
+	}, table.WithIdempotent())
+	if err != nil {
+		return nil, err
+	}
+	if response == nil {
+		return nil, aliasNotFound(request.GetUser(), request.GetAlias())
+	}
+	return response, nil
+}
+
+func newNativeStorage(ctx context.Context, db ydb.Connection, links ydbTable, consistency pb.Consistency, quota int) (_ *nativeStorage, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "newNativeStorage")
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	return &nativeStorage{
+		db:          db,
+		table:       links,
+		queries:     newQueries(links),
+		consistency: consistency,
+		quota:       quota,
+	}, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+
+	"github.com/asmyasnikov/webinar-jaeger/internal/identity"
+	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
+)
+
+var (
+	// errQuota means user already has as many links as quota allows
+	errQuota = errors.New("link quota exceeded")
+	// errNotOwner means link is owned by another user
+	errNotOwner = errors.New("link owned by another user")
+)
+
+type ownerKey struct{}
+
+// withOwner makes calls with ctx act on behalf of user, so admin service
+// lists links of one user with the same calls the user makes
+func withOwner(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, ownerKey{}, user)
+}
+
+// owner returns user who stores or manages links in the call, empty for
+// calls made on behalf of nobody (REST gateway, imports, other services).
+// Links of user are listed, found by url and deleted only by the user, while
+// calls without user see and manage all links.
+func owner(ctx context.Context) string {
+	if user, ok := ctx.Value(ownerKey{}).(string); ok {
+		return user
+	}
+	return identity.Caller(ctx)
+}
+
+// checkQuota fails if user who has count not expired links may not store
+// n more, quota 0 is unlimited. Calls without user are not limited.
+func checkQuota(ctx context.Context, user string, quota, count, n int) error {
+	if user == "" || quota <= 0 || count+n <= quota {
+		return nil
+	}
+	trace.SpanFromContext(ctx).AddEvent("quota exceeded")
+	return fmt.Errorf("user '%s' would have %d links, %d allowed: %w", user, count+n, quota, errQuota)
+}
+
+// checkOwner fails if user may not delete link owned by linkOwner. Links
+// stored without user are managed by calls without user only.
+func checkOwner(hash, user, linkOwner string) error {
+	if user == "" || user == linkOwner {
+		return nil
+	}
+	return fmt.Errorf("link '%s': %w", hash, errNotOwner)
+}
+
+// ownerStatus turns quota and ownership errors into gRPC status errors
+func ownerStatus(err error) error {
+	switch {
+	case errors.Is(err, errQuota):
+		return detailedError(codes.ResourceExhausted, pb.ErrorReason_ERROR_REASON_QUOTA_EXCEEDED, err.Error(), err.Error())
+	case errors.Is(err, errNotOwner):
+		return detailedError(codes.PermissionDenied, pb.ErrorReason_ERROR_REASON_NOT_OWNER, err.Error(), err.Error())
+	default:
+		return err
+	}
+}
+
+// nullableOwner is user_id column value, NULL for links stored on behalf of
+// nobody
+func nullableOwner(user string) *string {
+	if user == "" {
+		return nil
+	}
+	return &user
+}
+
+// ownerOf is user who owns link by its user_id column value
+func ownerOf(userID *string) string {
+	if userID == nil {
+		return ""
+	}
+	return *userID
+}
@@ -0,0 +1,255 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v3.21.4
+// source: admin.proto
+
+package __
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// AdminClient is the client API for Admin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AdminClient interface {
+	ListLinks(ctx context.Context, in *AdminListLinksRequest, opts ...grpc.CallOption) (*AdminListLinksResponse, error)
+	// DeleteLink deletes link regardless of its owner
+	DeleteLink(ctx context.Context, in *AdminDeleteLinkRequest, opts ...grpc.CallOption) (*AdminDeleteLinkResponse, error)
+	// BanDomain rejects new links to domain and its subdomains, links stored
+	// before stay until deleted
+	BanDomain(ctx context.Context, in *BanDomainRequest, opts ...grpc.CallOption) (*BanDomainResponse, error)
+	UnbanDomain(ctx context.Context, in *UnbanDomainRequest, opts ...grpc.CallOption) (*UnbanDomainResponse, error)
+	ListBannedDomains(ctx context.Context, in *ListBannedDomainsRequest, opts ...grpc.CallOption) (*ListBannedDomainsResponse, error)
+}
+
+type adminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAdminClient(cc grpc.ClientConnInterface) AdminClient {
+	return &adminClient{cc}
+}
+
+func (c *adminClient) ListLinks(ctx context.Context, in *AdminListLinksRequest, opts ...grpc.CallOption) (*AdminListLinksResponse, error) {
+	out := new(AdminListLinksResponse)
+	err := c.cc.Invoke(ctx, "/admin.Admin/ListLinks", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) DeleteLink(ctx context.Context, in *AdminDeleteLinkRequest, opts ...grpc.CallOption) (*AdminDeleteLinkResponse, error) {
+	out := new(AdminDeleteLinkResponse)
+	err := c.cc.Invoke(ctx, "/admin.Admin/DeleteLink", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) BanDomain(ctx context.Context, in *BanDomainRequest, opts ...grpc.CallOption) (*BanDomainResponse, error) {
+	out := new(BanDomainResponse)
+	err := c.cc.Invoke(ctx, "/admin.Admin/BanDomain", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) UnbanDomain(ctx context.Context, in *UnbanDomainRequest, opts ...grpc.CallOption) (*UnbanDomainResponse, error) {
+	out := new(UnbanDomainResponse)
+	err := c.cc.Invoke(ctx, "/admin.Admin/UnbanDomain", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ListBannedDomains(ctx context.Context, in *ListBannedDomainsRequest, opts ...grpc.CallOption) (*ListBannedDomainsResponse, error) {
+	out := new(ListBannedDomainsResponse)
+	err := c.cc.Invoke(ctx, "/admin.Admin/ListBannedDomains", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AdminServer is the server API for Admin service.
+// All implementations must embed UnimplementedAdminServer
+// for forward compatibility
+type AdminServer interface {
+	ListLinks(context.Context, *AdminListLinksRequest) (*AdminListLinksResponse, error)
+	// DeleteLink deletes link regardless of its owner
+	DeleteLink(context.Context, *AdminDeleteLinkRequest) (*AdminDeleteLinkResponse, error)
+	// BanDomain rejects new links to domain and its subdomains, links stored
+	// before stay until deleted
+	BanDomain(context.Context, *BanDomainRequest) (*BanDomainResponse, error)
+	UnbanDomain(context.Context, *UnbanDomainRequest) (*UnbanDomainResponse, error)
+	ListBannedDomains(context.Context, *ListBannedDomainsRequest) (*ListBannedDomainsResponse, error)
+	mustEmbedUnimplementedAdminServer()
+}
+
+// UnimplementedAdminServer must be embedded to have forward compatible implementations.
+type UnimplementedAdminServer struct {
+}
+
+func (UnimplementedAdminServer) ListLinks(context.Context, *AdminListLinksRequest) (*AdminListLinksResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListLinks not implemented")
+}
+func (UnimplementedAdminServer) DeleteLink(context.Context, *AdminDeleteLinkRequest) (*AdminDeleteLinkResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteLink not implemented")
+}
+func (UnimplementedAdminServer) BanDomain(context.Context, *BanDomainRequest) (*BanDomainResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BanDomain not implemented")
+}
+func (UnimplementedAdminServer) UnbanDomain(context.Context, *UnbanDomainRequest) (*UnbanDomainResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UnbanDomain not implemented")
+}
+func (UnimplementedAdminServer) ListBannedDomains(context.Context, *ListBannedDomainsRequest) (*ListBannedDomainsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListBannedDomains not implemented")
+}
+func (UnimplementedAdminServer) mustEmbedUnimplementedAdminServer() {}
+
+// UnsafeAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AdminServer will
+// result in compilation errors.
+type UnsafeAdminServer interface {
+	mustEmbedUnimplementedAdminServer()
+}
+
+func RegisterAdminServer(s grpc.ServiceRegistrar, srv AdminServer) {
+	s.RegisterService(&Admin_ServiceDesc, srv)
+}
+
+func _Admin_ListLinks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AdminListLinksRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListLinks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/admin.Admin/ListLinks",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListLinks(ctx, req.(*AdminListLinksRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_DeleteLink_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AdminDeleteLinkRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).DeleteLink(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/admin.Admin/DeleteLink",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).DeleteLink(ctx, req.(*AdminDeleteLinkRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_BanDomain_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BanDomainRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).BanDomain(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/admin.Admin/BanDomain",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).BanDomain(ctx, req.(*BanDomainRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_UnbanDomain_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnbanDomainRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).UnbanDomain(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/admin.Admin/UnbanDomain",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).UnbanDomain(ctx, req.(*UnbanDomainRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ListBannedDomains_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListBannedDomainsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListBannedDomains(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/admin.Admin/ListBannedDomains",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListBannedDomains(ctx, req.(*ListBannedDomainsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Admin_ServiceDesc is the grpc.ServiceDesc for Admin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Admin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "admin.Admin",
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListLinks",
+			Handler:    _Admin_ListLinks_Handler,
+		},
+		{
+			MethodName: "DeleteLink",
+			Handler:    _Admin_DeleteLink_Handler,
+		},
+		{
+			MethodName: "BanDomain",
+			Handler:    _Admin_BanDomain_Handler,
+		},
+		{
+			MethodName: "UnbanDomain",
+			Handler:    _Admin_UnbanDomain_Handler,
+		},
+		{
+			MethodName: "ListBannedDomains",
+			Handler:    _Admin_ListBannedDomains_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "admin.proto",
+}
@@ -23,7 +23,39 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type StorageClient interface {
 	Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*PutResponse, error)
+	BatchPut(ctx context.Context, in *BatchPutRequest, opts ...grpc.CallOption) (*BatchPutResponse, error)
+	// PutStream stores links sent by client in batches, results are in the
+	// order links were sent
+	PutStream(ctx context.Context, opts ...grpc.CallOption) (Storage_PutStreamClient, error)
+	// Import loads links sent by client with YDB BulkUpsert: without
+	// transactions and collision checks, imported link overwrites link with
+	// the same hash. Progress is sent after every stored batch. Imported
+	// links are not published to WatchChanges.
+	Import(ctx context.Context, opts ...grpc.CallOption) (Storage_ImportClient, error)
 	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error)
+	// GetByURL returns hash of not expired link to url, NOT_FOUND if url was
+	// never shortened
+	GetByURL(ctx context.Context, in *GetByURLRequest, opts ...grpc.CallOption) (*GetByURLResponse, error)
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+	List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error)
+	// SetMetadata attaches metadata of destination page to link, if link of
+	// hash still points to url. Put and Import drop metadata of the link.
+	SetMetadata(ctx context.Context, in *SetMetadataRequest, opts ...grpc.CallOption) (*SetMetadataResponse, error)
+	// PutAlias claims alias of link for user of the call in namespace of the
+	// user, alias claimed before by the same user is pointed to the link.
+	// Link must be owned by the user.
+	PutAlias(ctx context.Context, in *PutAliasRequest, opts ...grpc.CallOption) (*PutAliasResponse, error)
+	// GetByAlias resolves alias claimed by user, NOT_FOUND if there is no
+	// alias or its link was deleted
+	GetByAlias(ctx context.Context, in *GetByAliasRequest, opts ...grpc.CallOption) (*GetByAliasResponse, error)
+	// Export streams not expired links in order asked, so caches preload
+	// links which are likely to be looked up
+	Export(ctx context.Context, in *ExportRequest, opts ...grpc.CallOption) (Storage_ExportClient, error)
+	// Stats reports size of stored links and latencies of this instance
+	Stats(ctx context.Context, in *StorageStatsRequest, opts ...grpc.CallOption) (*StorageStatsResponse, error)
+	// WatchChanges streams changes of links stored through this instance
+	// until client cancels the call
+	WatchChanges(ctx context.Context, in *WatchChangesRequest, opts ...grpc.CallOption) (Storage_WatchChangesClient, error)
 }
 
 type storageClient struct {
@@ -43,6 +75,80 @@ func (c *storageClient) Put(ctx context.Context, in *PutRequest, opts ...grpc.Ca
 	return out, nil
 }
 
+func (c *storageClient) BatchPut(ctx context.Context, in *BatchPutRequest, opts ...grpc.CallOption) (*BatchPutResponse, error) {
+	out := new(BatchPutResponse)
+	err := c.cc.Invoke(ctx, "/storage.Storage/BatchPut", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageClient) PutStream(ctx context.Context, opts ...grpc.CallOption) (Storage_PutStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Storage_ServiceDesc.Streams[0], "/storage.Storage/PutStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &storagePutStreamClient{stream}
+	return x, nil
+}
+
+type Storage_PutStreamClient interface {
+	Send(*PutRequest) error
+	CloseAndRecv() (*BatchPutResponse, error)
+	grpc.ClientStream
+}
+
+type storagePutStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *storagePutStreamClient) Send(m *PutRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *storagePutStreamClient) CloseAndRecv() (*BatchPutResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(BatchPutResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *storageClient) Import(ctx context.Context, opts ...grpc.CallOption) (Storage_ImportClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Storage_ServiceDesc.Streams[1], "/storage.Storage/Import", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &storageImportClient{stream}
+	return x, nil
+}
+
+type Storage_ImportClient interface {
+	Send(*PutRequest) error
+	Recv() (*ImportProgress, error)
+	grpc.ClientStream
+}
+
+type storageImportClient struct {
+	grpc.ClientStream
+}
+
+func (x *storageImportClient) Send(m *PutRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *storageImportClient) Recv() (*ImportProgress, error) {
+	m := new(ImportProgress)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *storageClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error) {
 	out := new(GetResponse)
 	err := c.cc.Invoke(ctx, "/storage.Storage/Get", in, out, opts...)
@@ -52,12 +158,171 @@ func (c *storageClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.Ca
 	return out, nil
 }
 
+func (c *storageClient) GetByURL(ctx context.Context, in *GetByURLRequest, opts ...grpc.CallOption) (*GetByURLResponse, error) {
+	out := new(GetByURLResponse)
+	err := c.cc.Invoke(ctx, "/storage.Storage/GetByURL", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, "/storage.Storage/Delete", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageClient) List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error) {
+	out := new(ListResponse)
+	err := c.cc.Invoke(ctx, "/storage.Storage/List", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageClient) SetMetadata(ctx context.Context, in *SetMetadataRequest, opts ...grpc.CallOption) (*SetMetadataResponse, error) {
+	out := new(SetMetadataResponse)
+	err := c.cc.Invoke(ctx, "/storage.Storage/SetMetadata", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageClient) PutAlias(ctx context.Context, in *PutAliasRequest, opts ...grpc.CallOption) (*PutAliasResponse, error) {
+	out := new(PutAliasResponse)
+	err := c.cc.Invoke(ctx, "/storage.Storage/PutAlias", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageClient) GetByAlias(ctx context.Context, in *GetByAliasRequest, opts ...grpc.CallOption) (*GetByAliasResponse, error) {
+	out := new(GetByAliasResponse)
+	err := c.cc.Invoke(ctx, "/storage.Storage/GetByAlias", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageClient) Export(ctx context.Context, in *ExportRequest, opts ...grpc.CallOption) (Storage_ExportClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Storage_ServiceDesc.Streams[2], "/storage.Storage/Export", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &storageExportClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Storage_ExportClient interface {
+	Recv() (*PutRequest, error)
+	grpc.ClientStream
+}
+
+type storageExportClient struct {
+	grpc.ClientStream
+}
+
+func (x *storageExportClient) Recv() (*PutRequest, error) {
+	m := new(PutRequest)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *storageClient) Stats(ctx context.Context, in *StorageStatsRequest, opts ...grpc.CallOption) (*StorageStatsResponse, error) {
+	out := new(StorageStatsResponse)
+	err := c.cc.Invoke(ctx, "/storage.Storage/Stats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageClient) WatchChanges(ctx context.Context, in *WatchChangesRequest, opts ...grpc.CallOption) (Storage_WatchChangesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Storage_ServiceDesc.Streams[3], "/storage.Storage/WatchChanges", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &storageWatchChangesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Storage_WatchChangesClient interface {
+	Recv() (*Change, error)
+	grpc.ClientStream
+}
+
+type storageWatchChangesClient struct {
+	grpc.ClientStream
+}
+
+func (x *storageWatchChangesClient) Recv() (*Change, error) {
+	m := new(Change)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // StorageServer is the server API for Storage service.
 // All implementations must embed UnimplementedStorageServer
 // for forward compatibility
 type StorageServer interface {
 	Put(context.Context, *PutRequest) (*PutResponse, error)
+	BatchPut(context.Context, *BatchPutRequest) (*BatchPutResponse, error)
+	// PutStream stores links sent by client in batches, results are in the
+	// order links were sent
+	PutStream(Storage_PutStreamServer) error
+	// Import loads links sent by client with YDB BulkUpsert: without
+	// transactions and collision checks, imported link overwrites link with
+	// the same hash. Progress is sent after every stored batch. Imported
+	// links are not published to WatchChanges.
+	Import(Storage_ImportServer) error
 	Get(context.Context, *GetRequest) (*GetResponse, error)
+	// GetByURL returns hash of not expired link to url, NOT_FOUND if url was
+	// never shortened
+	GetByURL(context.Context, *GetByURLRequest) (*GetByURLResponse, error)
+	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	List(context.Context, *ListRequest) (*ListResponse, error)
+	// SetMetadata attaches metadata of destination page to link, if link of
+	// hash still points to url. Put and Import drop metadata of the link.
+	SetMetadata(context.Context, *SetMetadataRequest) (*SetMetadataResponse, error)
+	// PutAlias claims alias of link for user of the call in namespace of the
+	// user, alias claimed before by the same user is pointed to the link.
+	// Link must be owned by the user.
+	PutAlias(context.Context, *PutAliasRequest) (*PutAliasResponse, error)
+	// GetByAlias resolves alias claimed by user, NOT_FOUND if there is no
+	// alias or its link was deleted
+	GetByAlias(context.Context, *GetByAliasRequest) (*GetByAliasResponse, error)
+	// Export streams not expired links in order asked, so caches preload
+	// links which are likely to be looked up
+	Export(*ExportRequest, Storage_ExportServer) error
+	// Stats reports size of stored links and latencies of this instance
+	Stats(context.Context, *StorageStatsRequest) (*StorageStatsResponse, error)
+	// WatchChanges streams changes of links stored through this instance
+	// until client cancels the call
+	WatchChanges(*WatchChangesRequest, Storage_WatchChangesServer) error
 	mustEmbedUnimplementedStorageServer()
 }
 
@@ -68,9 +333,45 @@ type UnimplementedStorageServer struct {
 func (UnimplementedStorageServer) Put(context.Context, *PutRequest) (*PutResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Put not implemented")
 }
+func (UnimplementedStorageServer) BatchPut(context.Context, *BatchPutRequest) (*BatchPutResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchPut not implemented")
+}
+func (UnimplementedStorageServer) PutStream(Storage_PutStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method PutStream not implemented")
+}
+func (UnimplementedStorageServer) Import(Storage_ImportServer) error {
+	return status.Errorf(codes.Unimplemented, "method Import not implemented")
+}
 func (UnimplementedStorageServer) Get(context.Context, *GetRequest) (*GetResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
 }
+func (UnimplementedStorageServer) GetByURL(context.Context, *GetByURLRequest) (*GetByURLResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetByURL not implemented")
+}
+func (UnimplementedStorageServer) Delete(context.Context, *DeleteRequest) (*DeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedStorageServer) List(context.Context, *ListRequest) (*ListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedStorageServer) SetMetadata(context.Context, *SetMetadataRequest) (*SetMetadataResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetMetadata not implemented")
+}
+func (UnimplementedStorageServer) PutAlias(context.Context, *PutAliasRequest) (*PutAliasResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PutAlias not implemented")
+}
+func (UnimplementedStorageServer) GetByAlias(context.Context, *GetByAliasRequest) (*GetByAliasResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetByAlias not implemented")
+}
+func (UnimplementedStorageServer) Export(*ExportRequest, Storage_ExportServer) error {
+	return status.Errorf(codes.Unimplemented, "method Export not implemented")
+}
+func (UnimplementedStorageServer) Stats(context.Context, *StorageStatsRequest) (*StorageStatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stats not implemented")
+}
+func (UnimplementedStorageServer) WatchChanges(*WatchChangesRequest, Storage_WatchChangesServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchChanges not implemented")
+}
 func (UnimplementedStorageServer) mustEmbedUnimplementedStorageServer() {}
 
 // UnsafeStorageServer may be embedded to opt out of forward compatibility for this service.
@@ -102,6 +403,76 @@ func _Storage_Put_Handler(srv interface{}, ctx context.Context, dec func(interfa
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Storage_BatchPut_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchPutRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServer).BatchPut(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/storage.Storage/BatchPut",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServer).BatchPut(ctx, req.(*BatchPutRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Storage_PutStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(StorageServer).PutStream(&storagePutStreamServer{stream})
+}
+
+type Storage_PutStreamServer interface {
+	SendAndClose(*BatchPutResponse) error
+	Recv() (*PutRequest, error)
+	grpc.ServerStream
+}
+
+type storagePutStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *storagePutStreamServer) SendAndClose(m *BatchPutResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *storagePutStreamServer) Recv() (*PutRequest, error) {
+	m := new(PutRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Storage_Import_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(StorageServer).Import(&storageImportServer{stream})
+}
+
+type Storage_ImportServer interface {
+	Send(*ImportProgress) error
+	Recv() (*PutRequest, error)
+	grpc.ServerStream
+}
+
+type storageImportServer struct {
+	grpc.ServerStream
+}
+
+func (x *storageImportServer) Send(m *ImportProgress) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *storageImportServer) Recv() (*PutRequest, error) {
+	m := new(PutRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func _Storage_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(GetRequest)
 	if err := dec(in); err != nil {
@@ -120,6 +491,174 @@ func _Storage_Get_Handler(srv interface{}, ctx context.Context, dec func(interfa
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Storage_GetByURL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetByURLRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServer).GetByURL(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/storage.Storage/GetByURL",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServer).GetByURL(ctx, req.(*GetByURLRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Storage_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/storage.Storage/Delete",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Storage_List_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServer).List(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/storage.Storage/List",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServer).List(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Storage_SetMetadata_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetMetadataRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServer).SetMetadata(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/storage.Storage/SetMetadata",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServer).SetMetadata(ctx, req.(*SetMetadataRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Storage_PutAlias_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PutAliasRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServer).PutAlias(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/storage.Storage/PutAlias",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServer).PutAlias(ctx, req.(*PutAliasRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Storage_GetByAlias_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetByAliasRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServer).GetByAlias(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/storage.Storage/GetByAlias",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServer).GetByAlias(ctx, req.(*GetByAliasRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Storage_Export_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExportRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(StorageServer).Export(m, &storageExportServer{stream})
+}
+
+type Storage_ExportServer interface {
+	Send(*PutRequest) error
+	grpc.ServerStream
+}
+
+type storageExportServer struct {
+	grpc.ServerStream
+}
+
+func (x *storageExportServer) Send(m *PutRequest) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Storage_Stats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StorageStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServer).Stats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/storage.Storage/Stats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServer).Stats(ctx, req.(*StorageStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Storage_WatchChanges_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchChangesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(StorageServer).WatchChanges(m, &storageWatchChangesServer{stream})
+}
+
+type Storage_WatchChangesServer interface {
+	Send(*Change) error
+	grpc.ServerStream
+}
+
+type storageWatchChangesServer struct {
+	grpc.ServerStream
+}
+
+func (x *storageWatchChangesServer) Send(m *Change) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // Storage_ServiceDesc is the grpc.ServiceDesc for Storage service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -131,11 +670,65 @@ var Storage_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Put",
 			Handler:    _Storage_Put_Handler,
 		},
+		{
+			MethodName: "BatchPut",
+			Handler:    _Storage_BatchPut_Handler,
+		},
 		{
 			MethodName: "Get",
 			Handler:    _Storage_Get_Handler,
 		},
+		{
+			MethodName: "GetByURL",
+			Handler:    _Storage_GetByURL_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _Storage_Delete_Handler,
+		},
+		{
+			MethodName: "List",
+			Handler:    _Storage_List_Handler,
+		},
+		{
+			MethodName: "SetMetadata",
+			Handler:    _Storage_SetMetadata_Handler,
+		},
+		{
+			MethodName: "PutAlias",
+			Handler:    _Storage_PutAlias_Handler,
+		},
+		{
+			MethodName: "GetByAlias",
+			Handler:    _Storage_GetByAlias_Handler,
+		},
+		{
+			MethodName: "Stats",
+			Handler:    _Storage_Stats_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "PutStream",
+			Handler:       _Storage_PutStream_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Import",
+			Handler:       _Storage_Import_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Export",
+			Handler:       _Storage_Export_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchChanges",
+			Handler:       _Storage_WatchChanges_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "storage.proto",
 }
@@ -0,0 +1,629 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
+)
+
+// upsertLinkQuery replaces link only if hash is free: it points to the same url
+// of the same owner or previous link expired or was deleted. Collided rows are
+// not returned. The same link stored again keeps its creation time, metadata
+// of destination is dropped as link may point to another url now. Rows which
+// got creation time of this transaction were created by it.
+const upsertLinkQuery = `
+	INSERT INTO urls (hash, url, expires_at, user_id, created_at)
+	SELECT h, u, e, NULLIF($4, ''), now() FROM unnest($1::text[], $2::text[], $3::timestamptz[]) AS l(h, u, e)
+	ON CONFLICT (hash) DO UPDATE SET url = EXCLUDED.url, expires_at = EXCLUDED.expires_at, user_id = EXCLUDED.user_id, deleted_at = NULL,
+		title = NULL, favicon_url = NULL, created_at = CASE WHEN urls.deleted_at IS NULL AND COALESCE(urls.expires_at >= now(), true)
+			THEN urls.created_at ELSE EXCLUDED.created_at END
+	WHERE urls.url = EXCLUDED.url AND urls.user_id IS NOT DISTINCT FROM EXCLUDED.user_id
+		OR urls.expires_at < now() OR urls.deleted_at IS NOT NULL
+	RETURNING hash, COALESCE(created_at = now(), false)
+`
+
+type postgresStorage struct {
+	pb.UnimplementedStorageServer
+
+	db *sql.DB
+	// quota is max number of not expired links of one user, 0 is unlimited
+	quota int
+}
+
+// put stores links of user in a transaction and returns hashes which were
+// accepted and those of them which were not stored before. If links do not
+// fit into quota of user, none is stored. Puts of the same user are
+// serialized by advisory lock, so concurrent ones do not exceed quota
+// together.
+func (s *postgresStorage) put(ctx context.Context, links []*pb.PutRequest) (accepted, created map[string]bool, err error) {
+	user := owner(ctx)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+	limited := user != "" && s.quota > 0
+	if limited {
+		if _, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, user); err != nil {
+			return nil, nil, err
+		}
+	}
+	if accepted, created, err = upsert(ctx, tx, links, user); err != nil {
+		return nil, nil, err
+	}
+	if limited {
+		var n int
+		err = tx.QueryRowContext(ctx,
+			`SELECT count(*) FROM urls WHERE user_id = $1 AND deleted_at IS NULL AND (expires_at IS NULL OR expires_at > now())`, user,
+		).Scan(&n)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err = checkQuota(ctx, user, s.quota, n, 0); err != nil {
+			return nil, nil, err
+		}
+	}
+	return accepted, created, tx.Commit()
+}
+
+// upsert stores links of user and returns hashes which were accepted and
+// created
+func upsert(ctx context.Context, tx *sql.Tx, links []*pb.PutRequest, user string) (accepted, created map[string]bool, err error) {
+	var (
+		hashes    = make([]string, 0, len(links))
+		urls      = make([]string, 0, len(links))
+		expiresAt = make([]*time.Time, 0, len(links))
+	)
+	for _, l := range links {
+		hashes = append(hashes, l.GetHash())
+		urls = append(urls, l.GetUrl())
+		if l.GetExpiresAt() != nil {
+			t := l.GetExpiresAt().AsTime()
+			expiresAt = append(expiresAt, &t)
+		} else {
+			expiresAt = append(expiresAt, nil)
+		}
+	}
+	rows, err := tx.QueryContext(ctx, upsertLinkQuery, hashes, urls, expiresAt, user)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	accepted = make(map[string]bool, len(links))
+	created = make(map[string]bool, len(links))
+	for rows.Next() {
+		var (
+			hash  string
+			isNew bool
+		)
+		if err = rows.Scan(&hash, &isNew); err != nil {
+			return nil, nil, err
+		}
+		accepted[hash] = true
+		created[hash] = isNew
+	}
+	return accepted, created, rows.Err()
+}
+
+func (s *postgresStorage) Put(ctx context.Context, request *pb.PutRequest) (response *pb.PutResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "Put", trace.WithAttributes(
+		attribute.String("url", request.GetUrl()),
+		attribute.String("hash", request.GetHash()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("put done")
+		}
+		span.End()
+	}()
+	accepted, created, err := s.put(ctx, []*pb.PutRequest{request})
+	if err != nil {
+		return nil, ownerStatus(err)
+	}
+	if !accepted[request.GetHash()] {
+		err = fmt.Errorf("hash '%s' already used by another url: %w", request.GetHash(), errCollision)
+		return nil, collisionStatus(err)
+	}
+	return &pb.PutResponse{Created: created[request.GetHash()]}, nil
+}
+
+// BatchPut stores all links with a single statement. Links which hashes
+// already point to another url are skipped and reported as collisions.
+func (s *postgresStorage) BatchPut(ctx context.Context, request *pb.BatchPutRequest) (response *pb.BatchPutResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "BatchPut", trace.WithAttributes(
+		attribute.Int("count", len(request.GetLinks())),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("batch put done")
+		}
+		span.End()
+	}()
+	// statement must not touch the same row twice, so duplicated hashes
+	// are resolved before: first link wins
+	first := make(map[string]string, len(request.GetLinks()))
+	links := make([]*pb.PutRequest, 0, len(request.GetLinks()))
+	for _, l := range request.GetLinks() {
+		if _, ok := first[l.GetHash()]; ok {
+			continue
+		}
+		first[l.GetHash()] = l.GetUrl()
+		links = append(links, l)
+	}
+	accepted, created := map[string]bool{}, map[string]bool{}
+	if len(links) > 0 {
+		accepted, created, err = s.put(ctx, links)
+		if err != nil {
+			return nil, ownerStatus(err)
+		}
+	}
+	response = &pb.BatchPutResponse{
+		Results: make([]*pb.PutResult, 0, len(request.GetLinks())),
+	}
+	for _, l := range request.GetLinks() {
+		collision := !accepted[l.GetHash()] || first[l.GetHash()] != l.GetUrl()
+		response.Results = append(response.Results, &pb.PutResult{
+			Hash:      l.GetHash(),
+			Collision: collision,
+			// only the first of duplicated links created it
+			Created: !collision && created[l.GetHash()],
+		})
+		delete(created, l.GetHash())
+	}
+	return response, nil
+}
+
+// PutStream stores streamed links by batches of BatchPut
+func (s *postgresStorage) PutStream(stream pb.Storage_PutStreamServer) error {
+	return putStream(stream, s.BatchPut)
+}
+
+func (s *postgresStorage) Get(ctx context.Context, request *pb.GetRequest) (response *pb.GetResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "Get", trace.WithAttributes(
+		attribute.String("hash", request.GetHash()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("get done", trace.WithAttributes(
+				attribute.String("url", response.GetUrl()),
+			))
+		}
+		span.End()
+	}()
+	var (
+		url                  string
+		expiresAt, createdAt sql.NullTime
+		userID               *string
+		title, faviconURL    *string
+	)
+	err = s.db.QueryRowContext(ctx,
+		`SELECT url, expires_at, user_id, created_at, title, favicon_url FROM urls WHERE hash = $1 AND deleted_at IS NULL`, request.GetHash(),
+	).Scan(&url, &expiresAt, &userID, &createdAt, &title, &faviconURL)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("url for hash '%s': %w", request.GetHash(), errNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	response = &pb.GetResponse{
+		Url:      url,
+		Owner:    ownerOf(userID),
+		Metadata: metadataOf(title, faviconURL),
+	}
+	if expiresAt.Valid {
+		response.ExpiresAt = timestamppb.New(expiresAt.Time)
+	}
+	if createdAt.Valid {
+		response.CreatedAt = timestamppb.New(createdAt.Time)
+	}
+	return response, nil
+}
+
+func (s *postgresStorage) GetByURL(ctx context.Context, request *pb.GetByURLRequest) (response *pb.GetByURLResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "GetByURL", trace.WithAttributes(
+		attribute.String("url", request.GetUrl()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("get by url done", trace.WithAttributes(
+				attribute.String("hash", response.GetHash()),
+			))
+		}
+		span.End()
+	}()
+	var (
+		hash      string
+		expiresAt sql.NullTime
+	)
+	err = s.db.QueryRowContext(ctx, `
+		SELECT hash, expires_at FROM urls
+		WHERE url = $1 AND deleted_at IS NULL AND (expires_at IS NULL OR expires_at > now()) AND ($2 = '' OR user_id = $2)
+		LIMIT 1
+	`, request.GetUrl(), owner(ctx)).Scan(&hash, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("link to url '%s': %w", request.GetUrl(), errNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	response = &pb.GetByURLResponse{
+		Hash: hash,
+	}
+	if expiresAt.Valid {
+		response.ExpiresAt = timestamppb.New(expiresAt.Time)
+	}
+	return response, nil
+}
+
+func (s *postgresStorage) Delete(ctx context.Context, request *pb.DeleteRequest) (response *pb.DeleteResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "Delete", trace.WithAttributes(
+		attribute.String("hash", request.GetHash()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("delete done")
+		}
+		span.End()
+	}()
+	user := owner(ctx)
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE urls SET deleted_at = now() WHERE hash = $1 AND deleted_at IS NULL AND ($2 = '' OR user_id = $2)
+	`, request.GetHash(), user)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 && user != "" {
+		// link of another user is kept
+		var exists bool
+		err = s.db.QueryRowContext(ctx,
+			`SELECT EXISTS (SELECT 1 FROM urls WHERE hash = $1 AND deleted_at IS NULL)`, request.GetHash(),
+		).Scan(&exists)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			return nil, ownerStatus(fmt.Errorf("link '%s': %w", request.GetHash(), errNotOwner))
+		}
+	}
+	return &pb.DeleteResponse{}, nil
+}
+
+func (s *postgresStorage) List(ctx context.Context, request *pb.ListRequest) (response *pb.ListResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "List", trace.WithAttributes(
+		attribute.Int64("page_size", int64(request.GetPageSize())),
+		attribute.String("page_token", request.GetPageToken()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("list done", trace.WithAttributes(
+				attribute.Int("count", len(response.GetLinks())),
+				attribute.String("next_page_token", response.GetNextPageToken()),
+			))
+		}
+		span.End()
+	}()
+	pageSize := int(request.GetPageSize())
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	} else if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT hash, url, title, favicon_url FROM urls WHERE hash > $1 AND deleted_at IS NULL AND ($3 = '' OR user_id = $3) ORDER BY hash LIMIT $2`,
+		request.GetPageToken(), pageSize, owner(ctx),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	response = &pb.ListResponse{}
+	for rows.Next() {
+		var (
+			hash, url         string
+			title, faviconURL *string
+		)
+		if err = rows.Scan(&hash, &url, &title, &faviconURL); err != nil {
+			return nil, err
+		}
+		response.Links = append(response.Links, &pb.Link{
+			Hash:     hash,
+			Url:      url,
+			Metadata: metadataOf(title, faviconURL),
+		})
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(response.Links) == pageSize {
+		response.NextPageToken = response.Links[len(response.Links)-1].GetHash()
+	}
+	return response, nil
+}
+
+// SetMetadata updates link only if it still points to url, link replaced or
+// deleted since metadata was fetched is left as is
+func (s *postgresStorage) SetMetadata(ctx context.Context, request *pb.SetMetadataRequest) (response *pb.SetMetadataResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "SetMetadata", trace.WithAttributes(
+		attribute.String("hash", request.GetHash()),
+		attribute.String("url", request.GetUrl()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("set metadata done")
+		}
+		span.End()
+	}()
+	_, err = s.db.ExecContext(ctx, `
+		UPDATE urls SET title = $3, favicon_url = $4 WHERE hash = $1 AND url = $2 AND deleted_at IS NULL
+	`, request.GetHash(), request.GetUrl(), request.GetMetadata().GetTitle(), request.GetMetadata().GetFaviconUrl())
+	if err != nil {
+		return nil, err
+	}
+	return &pb.SetMetadataResponse{}, nil
+}
+
+// PutAlias claims alias only for link of the user, alias claimed before is
+// pointed to the link
+func (s *postgresStorage) PutAlias(ctx context.Context, request *pb.PutAliasRequest) (response *pb.PutAliasResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "PutAlias", trace.WithAttributes(
+		attribute.String("alias", request.GetAlias()),
+		attribute.String("hash", request.GetHash()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("put alias done")
+		}
+		span.End()
+	}()
+	user := owner(ctx)
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO aliases (user_id, alias, hash)
+		SELECT $1, $2, hash FROM urls WHERE hash = $3 AND deleted_at IS NULL AND user_id = $1
+		ON CONFLICT (user_id, alias) DO UPDATE SET hash = EXCLUDED.hash
+	`, user, request.GetAlias(), request.GetHash())
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		// link is missing or owned by another user
+		var linkOwner *string
+		err = s.db.QueryRowContext(ctx,
+			`SELECT user_id FROM urls WHERE hash = $1 AND deleted_at IS NULL`, request.GetHash(),
+		).Scan(&linkOwner)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("url for hash '%s': %w", request.GetHash(), errNotFound)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return nil, ownerStatus(checkAliasOwner(request.GetHash(), user, ownerOf(linkOwner)))
+	}
+	return &pb.PutAliasResponse{}, nil
+}
+
+func (s *postgresStorage) GetByAlias(ctx context.Context, request *pb.GetByAliasRequest) (response *pb.GetByAliasResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "GetByAlias", trace.WithAttributes(
+		attribute.String("user", request.GetUser()),
+		attribute.String("alias", request.GetAlias()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("get by alias done", trace.WithAttributes(
+				attribute.String("hash", response.GetHash()),
+				attribute.String("url", response.GetUrl()),
+			))
+		}
+		span.End()
+	}()
+	var (
+		hash, url string
+		expiresAt sql.NullTime
+	)
+	// link of hash reused by another user does not take alias over
+	err = s.db.QueryRowContext(ctx, `
+		SELECT l.hash, l.url, l.expires_at FROM aliases a JOIN urls l ON l.hash = a.hash
+		WHERE a.user_id = $1 AND a.alias = $2 AND l.user_id = $1 AND l.deleted_at IS NULL
+	`, request.GetUser(), request.GetAlias()).Scan(&hash, &url, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, aliasNotFound(request.GetUser(), request.GetAlias())
+	}
+	if err != nil {
+		return nil, err
+	}
+	response = &pb.GetByAliasResponse{
+		Hash: hash,
+		Url:  url,
+	}
+	if expiresAt.Valid {
+		response.ExpiresAt = timestamppb.New(expiresAt.Time)
+	}
+	return response, nil
+}
+
+func initPostgresSchema(ctx context.Context, db *sql.DB) (err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "initPostgresSchema")
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("schema prepared")
+		}
+		span.End()
+	}()
+	_, err = db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS urls (
+			hash TEXT PRIMARY KEY,
+			url TEXT NOT NULL,
+			expires_at TIMESTAMPTZ
+		);
+		CREATE INDEX IF NOT EXISTS urls_url_idx ON urls (url);
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS user_id TEXT;
+		CREATE INDEX IF NOT EXISTS urls_user_idx ON urls (user_id, hash);
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+		CREATE INDEX IF NOT EXISTS urls_deleted_idx ON urls (deleted_at) WHERE deleted_at IS NOT NULL;
+		CREATE INDEX IF NOT EXISTS urls_expires_idx ON urls (expires_at) WHERE expires_at IS NOT NULL;
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ;
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS title TEXT;
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS favicon_url TEXT;
+		CREATE TABLE IF NOT EXISTS aliases (
+			user_id TEXT NOT NULL,
+			alias TEXT NOT NULL,
+			hash TEXT NOT NULL,
+			PRIMARY KEY (user_id, alias)
+		);
+	`)
+	return err
+}
+
+// pgxTracing makes span for every query
+type pgxTracing struct {
+	tr trace.Tracer
+}
+
+func (t pgxTracing) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, _ = t.tr.Start(ctx, "query", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.DBSystemPostgreSQL,
+		semconv.DBStatementKey.String(data.SQL),
+	))
+	return ctx
+}
+
+func (t pgxTracing) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	if data.Err != nil {
+		span.SetAttributes(attribute.Bool("error", true))
+		span.RecordError(data.Err)
+	} else {
+		span.SetAttributes(attribute.Int64("rows_affected", data.CommandTag.RowsAffected()))
+	}
+	span.End()
+}
+
+func newPostgresStorage(ctx context.Context, dsn string, quota int) (_ *postgresStorage, err error) {
+	tr := otel.GetTracerProvider().Tracer(applicationID)
+	ctx, span := tr.Start(ctx, "newPostgresStorage")
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	config, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	config.Tracer = pgxTracing{tr: tr}
+
+	db := stdlib.OpenDB(*config)
+
+	if err = initPostgresSchema(ctx, db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &postgresStorage{
+		db:    db,
+		quota: quota,
+	}, nil
+}
+
+// size returns planner estimate of rows and total size of urls table with
+// its indexes, estimate is refreshed by autovacuum
+func (s *postgresStorage) size(ctx context.Context) (rows, bytes uint64, err error) {
+	var estimate float64
+	err = s.db.QueryRowContext(ctx, `
+		SELECT reltuples, pg_total_relation_size(oid) FROM pg_class WHERE relname = 'urls'
+	`).Scan(&estimate, &bytes)
+	if err != nil {
+		return 0, 0, err
+	}
+	// -1 means table was never analyzed
+	if estimate > 0 {
+		rows = uint64(estimate)
+	}
+	return rows, bytes, nil
+}
+
+// purge hard-deletes links soft deleted before given time
+func (s *postgresStorage) purge(ctx context.Context, before time.Time) (uint64, error) {
+	return s.remove(ctx, `
+		DELETE FROM urls WHERE hash IN (SELECT hash FROM urls WHERE deleted_at < $1 LIMIT $2)
+	`, before)
+}
+
+// cleanup hard-deletes links expired before given time
+func (s *postgresStorage) cleanup(ctx context.Context, before time.Time) (uint64, error) {
+	return s.remove(ctx, `
+		DELETE FROM urls WHERE hash IN (SELECT hash FROM urls WHERE expires_at < $1 LIMIT $2)
+	`, before)
+}
+
+// remove runs statement deleting up to maintenanceBatchSize links until it
+// deletes less, so no statement locks too many rows
+func (s *postgresStorage) remove(ctx context.Context, query string, before time.Time) (removed uint64, err error) {
+	for {
+		res, err := s.db.ExecContext(ctx, query, before, maintenanceBatchSize)
+		if err != nil {
+			return removed, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return removed, err
+		}
+		removed += uint64(n)
+		batchRemoved(ctx, int(n))
+		if n < maintenanceBatchSize {
+			return removed, nil
+		}
+	}
+}
+
+func (s *postgresStorage) Close() error {
+	return s.db.Close()
+}
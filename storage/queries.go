@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
+)
+
+// tablePlaceholder is replaced by name of links table in queries and migrations
+const tablePlaceholder = "{table}"
+
+// ydbTable locates links table in YDB database. Its schema version table is
+// named after it, so instances with other table names in the same directory
+// migrate independently.
+type ydbTable struct {
+	prefix string
+	name   string
+}
+
+// ydbPrefix resolves directory of tables against database, empty dir means
+// database root
+func ydbPrefix(database, dir string) string {
+	if strings.HasPrefix(dir, "/") {
+		return dir
+	}
+	return path.Join(database, dir)
+}
+
+func (t ydbTable) path() string {
+	return path.Join(t.prefix, t.name)
+}
+
+func (t ydbTable) versionPath() string {
+	return path.Join(t.prefix, t.versionName())
+}
+
+func (t ydbTable) versionName() string {
+	return t.name + "_schema_version"
+}
+
+// aliasesPath is path of table of aliases claimed by users for links
+func (t ydbTable) aliasesPath() string {
+	return path.Join(t.prefix, t.name+"_aliases")
+}
+
+// bind makes query run against the table
+func (t ydbTable) bind(query string) string {
+	return fmt.Sprintf("PRAGMA TablePathPrefix(\"%s\");\n%s", t.prefix, strings.ReplaceAll(query, tablePlaceholder, t.name))
+}
+
+// storedLink is row selected by get query, fields are nil if there is no
+// link or its column is NULL
+type storedLink struct {
+	url        *string
+	deadline   *time.Time
+	user       *string
+	createdAt  *time.Time
+	title      *string
+	faviconURL *string
+}
+
+// metadata of destination page, nil until it is fetched
+func (l storedLink) metadata() *pb.LinkMetadata {
+	return metadataOf(l.title, l.faviconURL)
+}
+
+// metadataOf makes metadata of title and favicon_url columns, nil if both are
+// NULL
+func metadataOf(title, faviconURL *string) *pb.LinkMetadata {
+	if title == nil && faviconURL == nil {
+		return nil
+	}
+	metadata := &pb.LinkMetadata{}
+	if title != nil {
+		metadata.Title = *title
+	}
+	if faviconURL != nil {
+		metadata.FaviconUrl = *faviconURL
+	}
+	return metadata
+}
+
+// queries are YQL texts of storage bound to table once, so requests neither
+// format them nor make YDB compile a new text for every call
+type queries struct {
+	// get selects owner too, so puts and deletes check it, and creation time,
+	// which put of the same link keeps
+	get         string
+	getByURL    string
+	put         string
+	getBatch    string
+	putBatch    string
+	delete      string
+	list        string
+	listByUser  string
+	countUser   string
+	setMetadata string
+	putAlias    string
+	// getByAlias selects link of alias only if it is still owned by the user
+	// who claimed alias, so hash reused by another user does not take over
+	getByAlias string
+	// purge selects soft deleted links to hard delete by deleteBatch
+	purge       string
+	deleteBatch string
+}
+
+func newQueries(table ydbTable) queries {
+	bind := table.bind
+	return queries{
+		get: bind(`
+			DECLARE $hash AS Text;
+
+			SELECT url, expires_at, user_id, created_at, title, favicon_url FROM {table} WHERE hash = $hash AND deleted_at IS NULL;
+		`),
+		getByURL: bind(`
+			DECLARE $url AS Text;
+
+			SELECT hash, expires_at, user_id FROM {table} VIEW ` + urlIndex + `
+			WHERE url = $url AND deleted_at IS NULL;
+		`),
+		put: bind(`
+			DECLARE $hash AS Text;
+			DECLARE $url AS Text;
+			DECLARE $expires_at AS Optional<Timestamp>;
+			DECLARE $user_id AS Optional<Text>;
+			DECLARE $created_at AS Optional<Timestamp>;
+
+			UPSERT INTO {table} (hash, url, expires_at, user_id, created_at, deleted_at, title, favicon_url)
+			VALUES ($hash, $url, $expires_at, $user_id, $created_at, CAST(NULL AS Optional<Timestamp>),
+				CAST(NULL AS Optional<Text>), CAST(NULL AS Optional<Text>));
+		`),
+		getBatch: bind(`
+			DECLARE $hashes AS List<Text>;
+
+			SELECT hash, url, expires_at, user_id, created_at FROM {table} WHERE hash IN $hashes AND deleted_at IS NULL;
+		`),
+		putBatch: bind(`
+			DECLARE $links AS List<Struct<
+				hash: Text,
+				url: Text,
+				expires_at: Optional<Timestamp>,
+				user_id: Optional<Text>,
+				created_at: Optional<Timestamp>
+			>>;
+
+			UPSERT INTO {table}
+			SELECT hash, url, expires_at, user_id, created_at, CAST(NULL AS Optional<Timestamp>) AS deleted_at,
+				CAST(NULL AS Optional<Text>) AS title, CAST(NULL AS Optional<Text>) AS favicon_url
+			FROM AS_TABLE($links);
+		`),
+		delete: bind(`
+			DECLARE $hash AS Text;
+
+			UPDATE {table} SET deleted_at = CurrentUtcTimestamp() WHERE hash = $hash AND deleted_at IS NULL;
+		`),
+		list: bind(`
+			DECLARE $token AS Text;
+			DECLARE $limit AS Uint64;
+
+			SELECT hash, url, title, favicon_url FROM {table} WHERE hash > $token AND deleted_at IS NULL ORDER BY hash LIMIT $limit;
+		`),
+		listByUser: bind(`
+			DECLARE $user_id AS Text;
+			DECLARE $token AS Text;
+			DECLARE $limit AS Uint64;
+
+			SELECT hash, url, title, favicon_url FROM {table} VIEW ` + userIndex + `
+			WHERE user_id = $user_id AND hash > $token AND deleted_at IS NULL ORDER BY hash LIMIT $limit;
+		`),
+		countUser: bind(`
+			DECLARE $user_id AS Text;
+
+			SELECT COUNT(*) AS links FROM {table} VIEW ` + userIndex + `
+			WHERE user_id = $user_id AND deleted_at IS NULL
+				AND (expires_at IS NULL OR expires_at > CurrentUtcTimestamp());
+		`),
+		setMetadata: bind(`
+			DECLARE $hash AS Text;
+			DECLARE $url AS Text;
+			DECLARE $title AS Text;
+			DECLARE $favicon_url AS Text;
+
+			UPDATE {table} SET title = $title, favicon_url = $favicon_url
+			WHERE hash = $hash AND url = $url AND deleted_at IS NULL;
+		`),
+		putAlias: bind(`
+			DECLARE $user_id AS Text;
+			DECLARE $alias AS Text;
+			DECLARE $hash AS Text;
+
+			UPSERT INTO {table}_aliases (user_id, alias, hash) VALUES ($user_id, $alias, $hash);
+		`),
+		getByAlias: bind(`
+			DECLARE $user_id AS Text;
+			DECLARE $alias AS Text;
+
+			SELECT l.hash AS hash, l.url AS url, l.expires_at AS expires_at
+			FROM {table}_aliases AS a INNER JOIN {table} AS l ON a.hash = l.hash
+			WHERE a.user_id = $user_id AND a.alias = $alias AND l.user_id = $user_id AND l.deleted_at IS NULL;
+		`),
+		purge: bind(`
+			DECLARE $before AS Timestamp;
+			DECLARE $limit AS Uint64;
+
+			SELECT hash FROM {table} WHERE deleted_at < $before LIMIT $limit;
+		`),
+		deleteBatch: bind(`
+			DECLARE $hashes AS List<Text>;
+
+			DELETE FROM {table} WHERE hash IN $hashes;
+		`),
+	}
+}
@@ -0,0 +1,879 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/asmyasnikov/webinar-jaeger/internal/redistrace"
+	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
+)
+
+const (
+	// every link is stored as redis hash with url and optional expires_at,
+	// user_id, created_at, deleted_at, title and favicon_url fields
+	redisLinkPrefix = "link:"
+	// sorted set of all not deleted hashes, used for listing links in hash order
+	redisLinksKey = "links"
+	// sorted set of soft deleted hashes scored by deletion time, used by purge
+	redisDeletedKey = "deleted"
+	// sorted set of hashes of expiring links scored by expiration, used by
+	// cleanup. Links stored before it was introduced are not cleaned up.
+	redisExpiresKey = "expires"
+	// string with hash of the latest link to url. Key left by deleted or
+	// replaced link is detected on lookup, as link of hash has another url.
+	redisURLPrefix = "url:"
+	// keys of links owned by user are prefixed with escaped user name: sorted
+	// set of hashes for listing, sorted set of hashes scored by expiration for
+	// quota, latest link to url and strings with hashes of aliases
+	redisUserPrefix  = "user:"
+	redisAliasPrefix = "alias:"
+	// optimistic transaction is retried on concurrent modification of watched keys
+	redisTxAttempts = 10
+)
+
+type redisStorage struct {
+	pb.UnimplementedStorageServer
+
+	client *redis.Client
+	// quota is max number of not expired links of one user, 0 is unlimited
+	quota int
+}
+
+type redisLink struct {
+	url       string
+	expiresAt *time.Time
+	owner     string
+	// createdAt is zero for links stored before creation times were kept
+	createdAt time.Time
+	// title and faviconURL are nil until metadata of destination is fetched
+	title, faviconURL *string
+}
+
+func (l redisLink) expired() bool {
+	return l.expiresAt != nil && l.expiresAt.Before(time.Now())
+}
+
+func redisLinkKey(hash string) string {
+	return redisLinkPrefix + hash
+}
+
+func redisURLKey(url string) string {
+	return redisURLPrefix + url
+}
+
+func redisUserKey(user, key string) string {
+	return redisUserPrefix + url.QueryEscape(user) + ":" + key
+}
+
+func redisUserLinksKey(user string) string {
+	return redisUserKey(user, redisLinksKey)
+}
+
+func redisUserExpiresKey(user string) string {
+	return redisUserKey(user, "expires")
+}
+
+func redisUserURLKey(user, link string) string {
+	return redisUserKey(user, redisURLKey(link))
+}
+
+func redisUserAliasKey(user, alias string) string {
+	return redisUserKey(user, redisAliasPrefix+alias)
+}
+
+// redisExpiresScore scores link in expiration set of its owner
+func redisExpiresScore(request *pb.PutRequest) float64 {
+	if request.GetExpiresAt() == nil {
+		return math.Inf(1)
+	}
+	return float64(request.GetExpiresAt().AsTime().Unix())
+}
+
+// count returns number of not expired links of user if quota applies to
+// them, 0 otherwise. Caller watches expiration set of user.
+func (s *redisStorage) count(ctx context.Context, tx *redis.Tx, user string) (int, error) {
+	if user == "" || s.quota <= 0 {
+		return 0, nil
+	}
+	n, err := tx.ZCount(ctx, redisUserExpiresKey(user), "("+strconv.FormatInt(time.Now().Unix(), 10), "+inf").Result()
+	return int(n), err
+}
+
+// parseRedisLink reads link from fields of its hash, soft deleted link is
+// reported as missing
+func parseRedisLink(fields map[string]string) (link redisLink, ok bool, err error) {
+	url, ok := fields["url"]
+	if !ok {
+		return link, false, nil
+	}
+	if _, deleted := fields["deleted_at"]; deleted {
+		return link, false, nil
+	}
+	link.url = url
+	link.owner = fields["user_id"]
+	if v, has := fields["expires_at"]; has {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return link, false, err
+		}
+		link.expiresAt = &t
+	}
+	if v, has := fields["created_at"]; has {
+		if link.createdAt, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			return link, false, err
+		}
+	}
+	if v, has := fields["title"]; has {
+		link.title = &v
+	}
+	if v, has := fields["favicon_url"]; has {
+		link.faviconURL = &v
+	}
+	return link, true, nil
+}
+
+// redisString returns value of hash field read by HMGet, nil if field is missing
+func redisString(v interface{}) *string {
+	s, ok := v.(string)
+	if !ok {
+		return nil
+	}
+	return &s
+}
+
+// storeRedisLink queues commands which replace link of previous owner with
+// link of user created at given time, zero if unknown, in pipeline
+func storeRedisLink(ctx context.Context, p redis.Pipeliner, request *pb.PutRequest, user, previous string, createdAt time.Time) {
+	key := redisLinkKey(request.GetHash())
+	fields := []interface{}{"url", request.GetUrl()}
+	if request.GetExpiresAt() != nil {
+		fields = append(fields, "expires_at", request.GetExpiresAt().AsTime().Format(time.RFC3339Nano))
+	}
+	if user != "" {
+		fields = append(fields, "user_id", user)
+	}
+	if !createdAt.IsZero() {
+		fields = append(fields, "created_at", createdAt.Format(time.RFC3339Nano))
+	}
+	if previous != "" && previous != user {
+		unlinkRedisOwner(ctx, p, request.GetHash(), previous)
+	}
+	p.Del(ctx, key)
+	p.HSet(ctx, key, fields...)
+	p.ZAdd(ctx, redisLinksKey, redis.Z{Member: request.GetHash()})
+	p.ZRem(ctx, redisDeletedKey, request.GetHash())
+	if request.GetExpiresAt() != nil {
+		p.ZAdd(ctx, redisExpiresKey, redis.Z{Member: request.GetHash(), Score: redisExpiresScore(request)})
+	} else {
+		p.ZRem(ctx, redisExpiresKey, request.GetHash())
+	}
+	p.Set(ctx, redisURLKey(request.GetUrl()), request.GetHash(), 0)
+	if user != "" {
+		p.ZAdd(ctx, redisUserLinksKey(user), redis.Z{Member: request.GetHash()})
+		p.ZAdd(ctx, redisUserExpiresKey(user), redis.Z{Member: request.GetHash(), Score: redisExpiresScore(request)})
+		p.Set(ctx, redisUserURLKey(user, request.GetUrl()), request.GetHash(), 0)
+	}
+}
+
+// unlinkRedisOwner queues commands which remove hash from sets of its owner
+func unlinkRedisOwner(ctx context.Context, p redis.Pipeliner, hash, owner string) {
+	p.ZRem(ctx, redisUserLinksKey(owner), hash)
+	p.ZRem(ctx, redisUserExpiresKey(owner), hash)
+}
+
+// watch runs fn in optimistic transaction and retries it if watched keys were changed concurrently
+func (s *redisStorage) watch(ctx context.Context, fn func(tx *redis.Tx) error, keys ...string) (err error) {
+	for i := 0; i < redisTxAttempts; i++ {
+		err = s.client.Watch(ctx, fn, keys...)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return err
+}
+
+func (s *redisStorage) Put(ctx context.Context, request *pb.PutRequest) (response *pb.PutResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "Put", trace.WithAttributes(
+		attribute.String("url", request.GetUrl()),
+		attribute.String("hash", request.GetHash()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("put done")
+		}
+		span.End()
+	}()
+	user := owner(ctx)
+	key := redisLinkKey(request.GetHash())
+	created := false
+	err = s.watch(ctx, func(tx *redis.Tx) error {
+		fields, err := tx.HGetAll(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		link, ok, err := parseRedisLink(fields)
+		stored := false
+		switch {
+		case err != nil:
+			return err
+		case !ok:
+		case link.expired():
+			// expired link releases its hash
+		case link.url != request.GetUrl() || link.owner != user:
+			return fmt.Errorf("hash '%s' already used by url '%s': %w", request.GetHash(), link.url, errCollision)
+		default:
+			stored = true
+		}
+		created = !stored
+		if !stored {
+			n, err := s.count(ctx, tx, user)
+			if err != nil {
+				return err
+			}
+			if err = checkQuota(ctx, user, s.quota, n, 1); err != nil {
+				return err
+			}
+		}
+		createdAt := time.Now()
+		if stored {
+			// the same link stored again keeps its creation time
+			createdAt = link.createdAt
+		}
+		_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			storeRedisLink(ctx, p, request, user, link.owner, createdAt)
+			return nil
+		})
+		return err
+	}, key, redisUserExpiresKey(user))
+	if errors.Is(err, errCollision) {
+		return nil, collisionStatus(err)
+	}
+	if err != nil {
+		return nil, ownerStatus(err)
+	}
+	return &pb.PutResponse{Created: created}, nil
+}
+
+// BatchPut stores all links in a single MULTI/EXEC transaction. Links which hashes
+// already point to another url are skipped and reported as collisions.
+func (s *redisStorage) BatchPut(ctx context.Context, request *pb.BatchPutRequest) (response *pb.BatchPutResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "BatchPut", trace.WithAttributes(
+		attribute.Int("count", len(request.GetLinks())),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("batch put done")
+		}
+		span.End()
+	}()
+	if len(request.GetLinks()) == 0 {
+		return &pb.BatchPutResponse{}, nil
+	}
+	user := owner(ctx)
+	keys := make([]string, 0, len(request.GetLinks())+1)
+	for _, l := range request.GetLinks() {
+		keys = append(keys, redisLinkKey(l.GetHash()))
+	}
+	err = s.watch(ctx, func(tx *redis.Tx) error {
+		cmds := make([]*redis.MapStringStringCmd, 0, len(keys))
+		_, err := tx.Pipelined(ctx, func(p redis.Pipeliner) error {
+			for _, key := range keys {
+				cmds = append(cmds, p.HGetAll(ctx, key))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// used are links which hold hashes, link of another owner collides
+		// with any url
+		used := make(map[string]string, len(keys))
+		// previous are owners of expired links replaced by the batch
+		previous := make(map[string]string, len(keys))
+		for i, cmd := range cmds {
+			link, ok, err := parseRedisLink(cmd.Val())
+			if err != nil {
+				return err
+			}
+			hash := request.GetLinks()[i].GetHash()
+			switch {
+			case !ok:
+			case link.expired():
+				previous[hash] = link.owner
+			case link.owner != user:
+				used[hash] = ""
+			default:
+				used[hash] = link.url
+			}
+		}
+		results := make([]*pb.PutResult, 0, len(request.GetLinks()))
+		links := make([]*pb.PutRequest, 0, len(request.GetLinks()))
+		for _, l := range request.GetLinks() {
+			url, ok := used[l.GetHash()]
+			if ok && url != l.GetUrl() {
+				results = append(results, &pb.PutResult{
+					Hash:      l.GetHash(),
+					Collision: true,
+				})
+				continue
+			}
+			result := &pb.PutResult{
+				Hash: l.GetHash(),
+			}
+			results = append(results, result)
+			if ok {
+				// same link already stored or met earlier in this batch
+				continue
+			}
+			used[l.GetHash()] = l.GetUrl()
+			links = append(links, l)
+			result.Created = true
+		}
+		n, err := s.count(ctx, tx, user)
+		if err != nil {
+			return err
+		}
+		// whole batch is rejected if its new links do not fit into quota
+		if err = checkQuota(ctx, user, s.quota, n, len(links)); err != nil {
+			return err
+		}
+		now := time.Now()
+		_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			for _, l := range links {
+				storeRedisLink(ctx, p, l, user, previous[l.GetHash()], now)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		response = &pb.BatchPutResponse{
+			Results: results,
+		}
+		return nil
+	}, append(keys, redisUserExpiresKey(user))...)
+	if err != nil {
+		return nil, ownerStatus(err)
+	}
+	return response, nil
+}
+
+// PutStream stores streamed links by batches of BatchPut
+func (s *redisStorage) PutStream(stream pb.Storage_PutStreamServer) error {
+	return putStream(stream, s.BatchPut)
+}
+
+func (s *redisStorage) Get(ctx context.Context, request *pb.GetRequest) (response *pb.GetResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "Get", trace.WithAttributes(
+		attribute.String("hash", request.GetHash()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("get done", trace.WithAttributes(
+				attribute.String("url", response.GetUrl()),
+			))
+		}
+		span.End()
+	}()
+	fields, err := s.client.HGetAll(ctx, redisLinkKey(request.GetHash())).Result()
+	if err != nil {
+		return nil, err
+	}
+	link, ok, err := parseRedisLink(fields)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("url for hash '%s': %w", request.GetHash(), errNotFound)
+	}
+	response = &pb.GetResponse{
+		Url:      link.url,
+		Owner:    link.owner,
+		Metadata: metadataOf(link.title, link.faviconURL),
+	}
+	if link.expiresAt != nil {
+		response.ExpiresAt = timestamppb.New(*link.expiresAt)
+	}
+	if !link.createdAt.IsZero() {
+		response.CreatedAt = timestamppb.New(link.createdAt)
+	}
+	return response, nil
+}
+
+func (s *redisStorage) GetByURL(ctx context.Context, request *pb.GetByURLRequest) (response *pb.GetByURLResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "GetByURL", trace.WithAttributes(
+		attribute.String("url", request.GetUrl()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("get by url done", trace.WithAttributes(
+				attribute.String("hash", response.GetHash()),
+			))
+		}
+		span.End()
+	}()
+	notFound := fmt.Errorf("link to url '%s': %w", request.GetUrl(), errNotFound)
+	user := owner(ctx)
+	urlKey := redisURLKey(request.GetUrl())
+	if user != "" {
+		urlKey = redisUserURLKey(user, request.GetUrl())
+	}
+	hash, err := s.client.Get(ctx, urlKey).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, notFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	fields, err := s.client.HGetAll(ctx, redisLinkKey(hash)).Result()
+	if err != nil {
+		return nil, err
+	}
+	link, ok, err := parseRedisLink(fields)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || link.url != request.GetUrl() || link.expired() || user != "" && link.owner != user {
+		return nil, notFound
+	}
+	response = &pb.GetByURLResponse{
+		Hash: hash,
+	}
+	if link.expiresAt != nil {
+		response.ExpiresAt = timestamppb.New(*link.expiresAt)
+	}
+	return response, nil
+}
+
+func (s *redisStorage) Delete(ctx context.Context, request *pb.DeleteRequest) (response *pb.DeleteResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "Delete", trace.WithAttributes(
+		attribute.String("hash", request.GetHash()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("delete done")
+		}
+		span.End()
+	}()
+	user := owner(ctx)
+	key := redisLinkKey(request.GetHash())
+	err = s.watch(ctx, func(tx *redis.Tx) error {
+		fields, err := tx.HGetAll(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		link, ok, err := parseRedisLink(fields)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		if err = checkOwner(request.GetHash(), user, link.owner); err != nil {
+			return err
+		}
+		now := time.Now()
+		_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			p.HSet(ctx, key, "deleted_at", now.Format(time.RFC3339Nano))
+			p.ZRem(ctx, redisLinksKey, request.GetHash())
+			p.ZAdd(ctx, redisDeletedKey, redis.Z{Member: request.GetHash(), Score: float64(now.Unix())})
+			if link.owner != "" {
+				unlinkRedisOwner(ctx, p, request.GetHash(), link.owner)
+			}
+			return nil
+		})
+		return err
+	}, key)
+	if err != nil {
+		return nil, ownerStatus(err)
+	}
+	return &pb.DeleteResponse{}, nil
+}
+
+// SetMetadata updates link only if it still points to url, link replaced or
+// deleted since metadata was fetched is left as is
+func (s *redisStorage) SetMetadata(ctx context.Context, request *pb.SetMetadataRequest) (response *pb.SetMetadataResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "SetMetadata", trace.WithAttributes(
+		attribute.String("hash", request.GetHash()),
+		attribute.String("url", request.GetUrl()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("set metadata done")
+		}
+		span.End()
+	}()
+	key := redisLinkKey(request.GetHash())
+	err = s.watch(ctx, func(tx *redis.Tx) error {
+		fields, err := tx.HGetAll(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		link, ok, err := parseRedisLink(fields)
+		if err != nil {
+			return err
+		}
+		if !ok || link.url != request.GetUrl() {
+			return nil
+		}
+		_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			p.HSet(ctx, key,
+				"title", request.GetMetadata().GetTitle(),
+				"favicon_url", request.GetMetadata().GetFaviconUrl(),
+			)
+			return nil
+		})
+		return err
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.SetMetadataResponse{}, nil
+}
+
+func (s *redisStorage) PutAlias(ctx context.Context, request *pb.PutAliasRequest) (response *pb.PutAliasResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "PutAlias", trace.WithAttributes(
+		attribute.String("alias", request.GetAlias()),
+		attribute.String("hash", request.GetHash()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("put alias done")
+		}
+		span.End()
+	}()
+	user := owner(ctx)
+	key := redisLinkKey(request.GetHash())
+	err = s.watch(ctx, func(tx *redis.Tx) error {
+		fields, err := tx.HGetAll(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		link, ok, err := parseRedisLink(fields)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("url for hash '%s': %w", request.GetHash(), errNotFound)
+		}
+		if err = checkAliasOwner(request.GetHash(), user, link.owner); err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			p.Set(ctx, redisUserAliasKey(user, request.GetAlias()), request.GetHash(), 0)
+			return nil
+		})
+		return err
+	}, key)
+	if err != nil {
+		return nil, ownerStatus(err)
+	}
+	return &pb.PutAliasResponse{}, nil
+}
+
+func (s *redisStorage) GetByAlias(ctx context.Context, request *pb.GetByAliasRequest) (response *pb.GetByAliasResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "GetByAlias", trace.WithAttributes(
+		attribute.String("user", request.GetUser()),
+		attribute.String("alias", request.GetAlias()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("get by alias done", trace.WithAttributes(
+				attribute.String("hash", response.GetHash()),
+				attribute.String("url", response.GetUrl()),
+			))
+		}
+		span.End()
+	}()
+	hash, err := s.client.Get(ctx, redisUserAliasKey(request.GetUser(), request.GetAlias())).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, aliasNotFound(request.GetUser(), request.GetAlias())
+	}
+	if err != nil {
+		return nil, err
+	}
+	fields, err := s.client.HGetAll(ctx, redisLinkKey(hash)).Result()
+	if err != nil {
+		return nil, err
+	}
+	link, ok, err := parseRedisLink(fields)
+	if err != nil {
+		return nil, err
+	}
+	// link of hash reused by another user does not take alias over
+	if !ok || link.owner != request.GetUser() {
+		return nil, aliasNotFound(request.GetUser(), request.GetAlias())
+	}
+	response = &pb.GetByAliasResponse{
+		Hash: hash,
+		Url:  link.url,
+	}
+	if link.expiresAt != nil {
+		response.ExpiresAt = timestamppb.New(*link.expiresAt)
+	}
+	return response, nil
+}
+
+// purge hard-deletes links soft deleted before given time by batches of
+// maintenanceBatchSize. Link stored again since deletion is only dropped from
+// deleted set.
+func (s *redisStorage) purge(ctx context.Context, before time.Time) (purged uint64, err error) {
+	for {
+		hashes, err := s.client.ZRangeByScore(ctx, redisDeletedKey, &redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   "(" + strconv.FormatInt(before.Unix(), 10),
+			Count: maintenanceBatchSize,
+		}).Result()
+		if err != nil || len(hashes) == 0 {
+			return purged, err
+		}
+		keys := make([]string, 0, len(hashes))
+		for _, hash := range hashes {
+			keys = append(keys, redisLinkKey(hash))
+		}
+		var n uint64
+		err = s.watch(ctx, func(tx *redis.Tx) error {
+			cmds := make([]*redis.BoolCmd, 0, len(keys))
+			_, err := tx.Pipelined(ctx, func(p redis.Pipeliner) error {
+				for _, key := range keys {
+					cmds = append(cmds, p.HExists(ctx, key, "deleted_at"))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			n = 0
+			_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+				for i, hash := range hashes {
+					if cmds[i].Val() {
+						p.Del(ctx, keys[i])
+						p.ZRem(ctx, redisExpiresKey, hash)
+						n++
+					}
+					p.ZRem(ctx, redisDeletedKey, hash)
+				}
+				return nil
+			})
+			return err
+		}, keys...)
+		if err != nil {
+			return purged, err
+		}
+		purged += n
+		batchRemoved(ctx, int(n))
+		if len(hashes) < maintenanceBatchSize {
+			return purged, nil
+		}
+	}
+}
+
+func (s *redisStorage) List(ctx context.Context, request *pb.ListRequest) (response *pb.ListResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "List", trace.WithAttributes(
+		attribute.Int64("page_size", int64(request.GetPageSize())),
+		attribute.String("page_token", request.GetPageToken()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("list done", trace.WithAttributes(
+				attribute.Int("count", len(response.GetLinks())),
+				attribute.String("next_page_token", response.GetNextPageToken()),
+			))
+		}
+		span.End()
+	}()
+	pageSize := int64(request.GetPageSize())
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	} else if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	min := "-"
+	if request.GetPageToken() != "" {
+		min = "(" + request.GetPageToken()
+	}
+	linksKey := redisLinksKey
+	if user := owner(ctx); user != "" {
+		linksKey = redisUserLinksKey(user)
+	}
+	hashes, err := s.client.ZRangeByLex(ctx, linksKey, &redis.ZRangeBy{
+		Min:   min,
+		Max:   "+",
+		Count: pageSize,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	cmds := make([]*redis.SliceCmd, 0, len(hashes))
+	_, err = s.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, hash := range hashes {
+			cmds = append(cmds, p.HMGet(ctx, redisLinkKey(hash), "url", "title", "favicon_url"))
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	response = &pb.ListResponse{}
+	for i, cmd := range cmds {
+		var url string
+		if v := redisString(cmd.Val()[0]); v != nil {
+			url = *v
+		}
+		response.Links = append(response.Links, &pb.Link{
+			Hash:     hashes[i],
+			Url:      url,
+			Metadata: metadataOf(redisString(cmd.Val()[1]), redisString(cmd.Val()[2])),
+		})
+	}
+	if int64(len(hashes)) == pageSize {
+		response.NextPageToken = hashes[len(hashes)-1]
+	}
+	return response, nil
+}
+
+func newRedisStorage(ctx context.Context, addr string, quota int) (_ *redisStorage, err error) {
+	tr := otel.GetTracerProvider().Tracer(applicationID)
+	ctx, span := tr.Start(ctx, "newRedisStorage", trace.WithAttributes(
+		attribute.String("address", addr),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	client := redis.NewClient(&redis.Options{
+		Addr: addr,
+	})
+	client.AddHook(redistrace.Hook(tr))
+
+	if err = client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+
+	return &redisStorage{
+		client: client,
+		quota:  quota,
+	}, nil
+}
+
+// size counts links in sorted set of hashes, Redis does not tell memory taken
+// by subset of keys cheaply
+func (s *redisStorage) size(ctx context.Context) (rows, bytes uint64, err error) {
+	n, err := s.client.ZCard(ctx, redisLinksKey).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint64(n), 0, nil
+}
+
+// cleanup hard-deletes links expired before given time by batches of
+// maintenanceBatchSize. Link stored again with later expiration since it was
+// scanned is kept.
+func (s *redisStorage) cleanup(ctx context.Context, before time.Time) (removed uint64, err error) {
+	for {
+		hashes, err := s.client.ZRangeByScore(ctx, redisExpiresKey, &redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   "(" + strconv.FormatInt(before.Unix(), 10),
+			Count: maintenanceBatchSize,
+		}).Result()
+		if err != nil || len(hashes) == 0 {
+			return removed, err
+		}
+		keys := make([]string, 0, len(hashes))
+		for _, hash := range hashes {
+			keys = append(keys, redisLinkKey(hash))
+		}
+		var n uint64
+		err = s.watch(ctx, func(tx *redis.Tx) error {
+			cmds := make([]*redis.SliceCmd, 0, len(keys))
+			_, err := tx.Pipelined(ctx, func(p redis.Pipeliner) error {
+				for _, key := range keys {
+					cmds = append(cmds, p.HMGet(ctx, key, "expires_at", "user_id"))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			n = 0
+			_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+				for i, hash := range hashes {
+					fields := cmds[i].Val()
+					v, _ := fields[0].(string)
+					t, err := time.Parse(time.RFC3339Nano, v)
+					if err == nil && !t.Before(before) {
+						// stored again with later expiration
+						continue
+					}
+					if err == nil {
+						p.Del(ctx, keys[i])
+						p.ZRem(ctx, redisLinksKey, hash)
+						p.ZRem(ctx, redisDeletedKey, hash)
+						if user, ok := fields[1].(string); ok && user != "" {
+							unlinkRedisOwner(ctx, p, hash, user)
+						}
+						n++
+					}
+					p.ZRem(ctx, redisExpiresKey, hash)
+				}
+				return nil
+			})
+			return err
+		}, keys...)
+		if err != nil {
+			return removed, err
+		}
+		removed += n
+		batchRemoved(ctx, int(n))
+		if len(hashes) < maintenanceBatchSize {
+			return removed, nil
+		}
+	}
+}
+
+func (s *redisStorage) Close() error {
+	return s.client.Close()
+}
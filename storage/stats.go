@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+
+	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
+)
+
+// latencyWindow is number of recent calls of a method percentiles are
+// computed over
+const latencyWindow = 1024
+
+// sizeFunc estimates number of stored links and their size in bytes
+type sizeFunc func(ctx context.Context) (rows, bytes uint64, err error)
+
+// latencies keeps durations of recent unary calls of Storage service
+type latencies struct {
+	mu      sync.Mutex
+	methods map[string]*latencyRing
+}
+
+type latencyRing struct {
+	samples []time.Duration
+	next    int
+}
+
+func newLatencies() *latencies {
+	return &latencies{
+		methods: make(map[string]*latencyRing),
+	}
+}
+
+func (l *latencies) observe(method string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r, ok := l.methods[method]
+	if !ok {
+		r = &latencyRing{
+			samples: make([]time.Duration, 0, latencyWindow),
+		}
+		l.methods[method] = r
+	}
+	if len(r.samples) < latencyWindow {
+		r.samples = append(r.samples, d)
+		return
+	}
+	r.samples[r.next] = d
+	r.next = (r.next + 1) % latencyWindow
+}
+
+// UnaryServerInterceptor records latency of every Storage call, failed ones
+// included
+func (l *latencies) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	prefix := "/" + pb.Storage_ServiceDesc.ServiceName + "/"
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !strings.HasPrefix(info.FullMethod, prefix) {
+			return handler(ctx, req)
+		}
+		start := time.Now()
+		defer func() {
+			l.observe(strings.TrimPrefix(info.FullMethod, prefix), time.Since(start))
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// snapshot returns percentiles of every called method ordered by method name
+func (l *latencies) snapshot() []*pb.MethodLatency {
+	l.mu.Lock()
+	samples := make(map[string][]time.Duration, len(l.methods))
+	for method, r := range l.methods {
+		samples[method] = append([]time.Duration(nil), r.samples...)
+	}
+	l.mu.Unlock()
+
+	snapshot := make([]*pb.MethodLatency, 0, len(samples))
+	for method, s := range samples {
+		sort.Slice(s, func(i, j int) bool {
+			return s[i] < s[j]
+		})
+		snapshot = append(snapshot, &pb.MethodLatency{
+			Method: method,
+			Count:  uint64(len(s)),
+			P50Ms:  percentile(s, 0.5),
+			P90Ms:  percentile(s, 0.9),
+			P99Ms:  percentile(s, 0.99),
+		})
+	}
+	sort.Slice(snapshot, func(i, j int) bool {
+		return snapshot[i].GetMethod() < snapshot[j].GetMethod()
+	})
+	return snapshot
+}
+
+// percentile returns p-th percentile of sorted durations in milliseconds
+func percentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return float64(sorted[i]) / float64(time.Millisecond)
+}
+
+// statsStorage answers Stats with size estimate of backend and latencies
+// recorded by interceptor
+type statsStorage struct {
+	pb.StorageServer
+
+	backend   string
+	size      sizeFunc
+	latencies *latencies
+}
+
+func newStatsStorage(s pb.StorageServer, backend string, size sizeFunc, l *latencies) *statsStorage {
+	return &statsStorage{
+		StorageServer: s,
+		backend:       backend,
+		size:          size,
+		latencies:     l,
+	}
+}
+
+func (s *statsStorage) Stats(ctx context.Context, _ *pb.StorageStatsRequest) (response *pb.StorageStatsResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "Stats", trace.WithAttributes(
+		attribute.String("backend", s.backend),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("stats done", trace.WithAttributes(
+				attribute.Int64("row_count", int64(response.GetRowCount())),
+				attribute.Int64("size_bytes", int64(response.GetSizeBytes())),
+			))
+		}
+		span.End()
+	}()
+	response = &pb.StorageStatsResponse{
+		Backend:   s.backend,
+		Latencies: s.latencies.snapshot(),
+	}
+	if s.size != nil {
+		response.RowCount, response.SizeBytes, err = s.size(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return response, nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/ydb-platform/ydb-go-sdk/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
+)
+
+// errNotFound means there is no link for hash or url. Backends return it
+// from retried operations as non-retryable error, statusError turns it into
+// NotFound.
+var errNotFound = errors.New("link not found")
+
+// retryableReasons are causes of failures which may pass if the call is
+// repeated later
+var retryableReasons = map[pb.ErrorReason]bool{
+	pb.ErrorReason_ERROR_REASON_UNAVAILABLE:  true,
+	pb.ErrorReason_ERROR_REASON_TIMEOUT:      true,
+	pb.ErrorReason_ERROR_REASON_CHANGES_LOST: true,
+}
+
+// detailedError returns status error described by desc with ErrorDetail of
+// reason attached. Message of detail is shown to users, so it must not
+// reveal internals like database addresses.
+func detailedError(code codes.Code, reason pb.ErrorReason, message, desc string) error {
+	st, err := status.New(code, desc).WithDetails(&pb.ErrorDetail{
+		Reason:    reason,
+		Retryable: retryableReasons[reason],
+		Message:   message,
+	})
+	if err != nil {
+		return status.Error(code, desc)
+	}
+	return st.Err()
+}
+
+// collisionStatus turns collision error into AlreadyExists
+func collisionStatus(err error) error {
+	return detailedError(codes.AlreadyExists, pb.ErrorReason_ERROR_REASON_HASH_COLLISION,
+		"hash already used by another url", err.Error())
+}
+
+// statusError turns errors of backends into gRPC status errors, so callers
+// tell missing links, bad requests and unreachable databases apart instead of
+// getting Unknown. Status errors are kept as is.
+func statusError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errNotFound):
+		return detailedError(codes.NotFound, pb.ErrorReason_ERROR_REASON_NOT_FOUND, err.Error(), err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return detailedError(codes.DeadlineExceeded, pb.ErrorReason_ERROR_REASON_TIMEOUT,
+			"storage did not answer in time", err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.FromContextError(err).Err()
+	case errors.Is(err, driver.ErrBadConn),
+		errors.Is(err, redis.ErrClosed),
+		errors.As(err, &netErr),
+		ydb.IsTransportError(err),
+		ydb.IsOperationErrorUnavailable(err),
+		ydb.IsOperationErrorOverloaded(err):
+		return detailedError(codes.Unavailable, pb.ErrorReason_ERROR_REASON_UNAVAILABLE,
+			"database unavailable", err.Error())
+	default:
+		return err
+	}
+}
+
+// validate rejects requests without hash or url with InvalidArgument, so
+// backends do not look up or store empty keys
+func validate(request interface{}) error {
+	switch r := request.(type) {
+	case *pb.PutRequest:
+		if r.GetHash() == "" || r.GetUrl() == "" {
+			return invalidRequest("hash and url are required")
+		}
+	case *pb.GetRequest:
+		if r.GetHash() == "" {
+			return invalidRequest("hash is required")
+		}
+	case *pb.GetByURLRequest:
+		if r.GetUrl() == "" {
+			return invalidRequest("url is required")
+		}
+	case *pb.SetMetadataRequest:
+		if r.GetHash() == "" || r.GetUrl() == "" {
+			return invalidRequest("hash and url are required")
+		}
+	case *pb.DeleteRequest:
+		if r.GetHash() == "" {
+			return invalidRequest("hash is required")
+		}
+	case *pb.PutAliasRequest:
+		if r.GetHash() == "" {
+			return invalidRequest("hash is required")
+		}
+		if !aliasName.MatchString(r.GetAlias()) {
+			return invalidRequest(fmt.Sprintf("alias '%s' must be 1 to %d letters, digits, hyphens and underscores starting with letter or digit", r.GetAlias(), maxAliasLength))
+		}
+	case *pb.GetByAliasRequest:
+		if r.GetUser() == "" || r.GetAlias() == "" {
+			return invalidRequest("user and alias are required")
+		}
+	}
+	return nil
+}
+
+func invalidRequest(message string) error {
+	return detailedError(codes.InvalidArgument, pb.ErrorReason_ERROR_REASON_INVALID_REQUEST, message, message)
+}
+
+// statusStorage validates requests and converts errors of wrapped storage
+// with statusError. Wrapping storage instead of intercepting calls serves
+// REST gateway calls, which bypass gRPC server, the same way.
+type statusStorage struct {
+	pb.StorageServer
+}
+
+func newStatusStorage(s pb.StorageServer) *statusStorage {
+	return &statusStorage{
+		StorageServer: s,
+	}
+}
+
+func (s *statusStorage) Put(ctx context.Context, request *pb.PutRequest) (*pb.PutResponse, error) {
+	if err := validate(request); err != nil {
+		return nil, err
+	}
+	response, err := s.StorageServer.Put(ctx, request)
+	return response, statusError(err)
+}
+
+func (s *statusStorage) BatchPut(ctx context.Context, request *pb.BatchPutRequest) (*pb.BatchPutResponse, error) {
+	for _, l := range request.GetLinks() {
+		if err := validate(l); err != nil {
+			return nil, err
+		}
+	}
+	response, err := s.StorageServer.BatchPut(ctx, request)
+	return response, statusError(err)
+}
+
+func (s *statusStorage) PutStream(stream pb.Storage_PutStreamServer) error {
+	return statusError(s.StorageServer.PutStream(stream))
+}
+
+func (s *statusStorage) Import(stream pb.Storage_ImportServer) error {
+	return statusError(s.StorageServer.Import(stream))
+}
+
+func (s *statusStorage) Get(ctx context.Context, request *pb.GetRequest) (*pb.GetResponse, error) {
+	if err := validate(request); err != nil {
+		return nil, err
+	}
+	response, err := s.StorageServer.Get(ctx, request)
+	return response, statusError(err)
+}
+
+func (s *statusStorage) GetByURL(ctx context.Context, request *pb.GetByURLRequest) (*pb.GetByURLResponse, error) {
+	if err := validate(request); err != nil {
+		return nil, err
+	}
+	response, err := s.StorageServer.GetByURL(ctx, request)
+	return response, statusError(err)
+}
+
+func (s *statusStorage) Delete(ctx context.Context, request *pb.DeleteRequest) (*pb.DeleteResponse, error) {
+	if err := validate(request); err != nil {
+		return nil, err
+	}
+	response, err := s.StorageServer.Delete(ctx, request)
+	return response, statusError(err)
+}
+
+func (s *statusStorage) SetMetadata(ctx context.Context, request *pb.SetMetadataRequest) (*pb.SetMetadataResponse, error) {
+	if err := validate(request); err != nil {
+		return nil, err
+	}
+	response, err := s.StorageServer.SetMetadata(ctx, request)
+	return response, statusError(err)
+}
+
+// PutAlias is allowed to calls on behalf of user only, as aliases live in
+// namespaces of users
+func (s *statusStorage) PutAlias(ctx context.Context, request *pb.PutAliasRequest) (*pb.PutAliasResponse, error) {
+	if err := validate(request); err != nil {
+		return nil, err
+	}
+	if owner(ctx) == "" {
+		return nil, invalidRequest("alias is claimed on behalf of user only")
+	}
+	response, err := s.StorageServer.PutAlias(ctx, request)
+	return response, statusError(err)
+}
+
+func (s *statusStorage) GetByAlias(ctx context.Context, request *pb.GetByAliasRequest) (*pb.GetByAliasResponse, error) {
+	if err := validate(request); err != nil {
+		return nil, err
+	}
+	response, err := s.StorageServer.GetByAlias(ctx, request)
+	return response, statusError(err)
+}
+
+func (s *statusStorage) List(ctx context.Context, request *pb.ListRequest) (*pb.ListResponse, error) {
+	response, err := s.StorageServer.List(ctx, request)
+	return response, statusError(err)
+}
+
+func (s *statusStorage) Export(request *pb.ExportRequest, stream pb.Storage_ExportServer) error {
+	return statusError(s.StorageServer.Export(request, stream))
+}
+
+func (s *statusStorage) Stats(ctx context.Context, request *pb.StorageStatsRequest) (*pb.StorageStatsResponse, error) {
+	response, err := s.StorageServer.Stats(ctx, request)
+	return response, statusError(err)
+}
+
+func (s *statusStorage) WatchChanges(request *pb.WatchChangesRequest, stream pb.Storage_WatchChangesServer) error {
+	return statusError(s.StorageServer.WatchChanges(request, stream))
+}
@@ -3,24 +3,79 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"go.opentelemetry.io/otel"
-	"os"
-	"path"
+	"time"
 
 	"github.com/ydb-platform/ydb-go-sdk/v3"
 	"github.com/ydb-platform/ydb-go-sdk/v3/retry"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table/options"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table/types"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
 )
 
+// errCollision means that hash already points to another url
+var errCollision = errors.New("hash collision")
+
+// consistencies are values of Config.ReadConsistency
+var consistencies = map[string]pb.Consistency{
+	"strong": pb.Consistency_CONSISTENCY_STRONG,
+	"stale":  pb.Consistency_CONSISTENCY_STALE,
+}
+
+// isStale tells whether Get reads in stale read-only transaction
+func isStale(requested, fallback pb.Consistency) bool {
+	if requested == pb.Consistency_CONSISTENCY_UNSPECIFIED {
+		requested = fallback
+	}
+	return requested == pb.Consistency_CONSISTENCY_STALE
+}
+
+// urlIndex is global secondary index of urls table on url column, it is
+// created by migrations/0003_add_url_index.sql
+const urlIndex = "url_index"
+
+// userIndex is global secondary index of urls table on user_id column, it is
+// created by migrations/0005_add_user_index.sql
+const userIndex = "user_index"
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
 type storage struct {
 	pb.UnimplementedStorageServer
 
-	db     *sql.DB
-	prefix string
+	db      *sql.DB
+	table   ydbTable
+	queries queries
+	// consistency is applied to Get without explicit one
+	consistency pb.Consistency
+	// quota is max number of not expired links of one user, 0 is unlimited
+	quota int
+}
+
+// count returns number of not expired links of user if quota applies to
+// them, 0 otherwise
+func (s *storage) count(ctx context.Context, tx *sql.Tx, user string) (n int, err error) {
+	if user == "" || s.quota <= 0 {
+		return 0, nil
+	}
+	err = tx.QueryRowContext(ctx, s.queries.countUser, sql.Named("user_id", user)).Scan(&n)
+	return n, err
+}
+
+// scanLink reads row of get query, sql.ErrNoRows if there is no link
+func scanLink(row *sql.Row) (link storedLink, err error) {
+	err = row.Scan(&link.url, &link.deadline, &link.user, &link.createdAt, &link.title, &link.faviconURL)
+	return link, err
 }
 
 func (s *storage) Put(ctx context.Context, request *pb.PutRequest) (response *pb.PutResponse, err error) {
@@ -37,21 +92,206 @@ func (s *storage) Put(ctx context.Context, request *pb.PutRequest) (response *pb
 		}
 		span.End()
 	}()
+	var expiresAt *time.Time
+	if request.GetExpiresAt() != nil {
+		t := request.GetExpiresAt().AsTime()
+		expiresAt = &t
+		span.SetAttributes(attribute.String("expires_at", t.Format(time.RFC3339)))
+	}
+	user := owner(ctx)
+	created := false
 	err = retry.DoTx(ctx, s.db, func(ctx context.Context, tx *sql.Tx) (err error) {
-		_, err = tx.ExecContext(ctx, fmt.Sprintf(`
-			PRAGMA TablePathPrefix("%s");
-
-			DECLARE $hash AS Text;
-			DECLARE $url AS Text;
-
-			UPSERT INTO urls (hash, url) VALUES ($hash, $url); 
-		`, s.prefix), sql.Named("hash", request.GetHash()), sql.Named("url", request.GetUrl()))
+		link, err := scanLink(tx.QueryRowContext(ctx, s.queries.get, sql.Named("hash", request.GetHash())))
+		stored := false
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+		case err != nil:
+			return err
+		case link.url == nil:
+		case link.deadline != nil && link.deadline.Before(time.Now()):
+			// expired link releases its hash
+		case *link.url != request.GetUrl() || ownerOf(link.user) != user:
+			// non-retryable error
+			return fmt.Errorf("hash '%s' already used by url '%s': %w", request.GetHash(), *link.url, errCollision)
+		default:
+			stored = true
+		}
+		created = !stored
+		if !stored {
+			n, err := s.count(ctx, tx, user)
+			if err != nil {
+				return err
+			}
+			if err = checkQuota(ctx, user, s.quota, n, 1); err != nil {
+				// non-retryable error
+				return err
+			}
+		}
+		// the same link stored again keeps its creation time
+		createdAt := link.createdAt
+		if !stored {
+			now := time.Now()
+			createdAt = &now
+		}
+		_, err = tx.ExecContext(ctx, s.queries.put,
+			sql.Named("hash", request.GetHash()),
+			sql.Named("url", request.GetUrl()),
+			sql.Named("expires_at", expiresAt),
+			sql.Named("user_id", nullableOwner(user)),
+			sql.Named("created_at", createdAt),
+		)
 		return err
 	}, retry.WithDoTxRetryOptions(retry.WithIdempotent(true)))
+	if errors.Is(err, errCollision) {
+		return nil, collisionStatus(err)
+	}
 	if err != nil {
-		return nil, err
+		return nil, ownerStatus(err)
+	}
+	return &pb.PutResponse{Created: created}, nil
+}
+
+// BatchPut stores all links in a single transaction. Links which hashes
+// already point to another url are skipped and reported as collisions, links
+// already stored get new expiration like on Put.
+func (s *storage) BatchPut(ctx context.Context, request *pb.BatchPutRequest) (response *pb.BatchPutResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "BatchPut", trace.WithAttributes(
+		attribute.Int("count", len(request.GetLinks())),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("batch put done")
+		}
+		span.End()
+	}()
+	if len(request.GetLinks()) == 0 {
+		return &pb.BatchPutResponse{}, nil
+	}
+	hashes := make([]types.Value, 0, len(request.GetLinks()))
+	for _, l := range request.GetLinks() {
+		hashes = append(hashes, types.TextValue(l.GetHash()))
 	}
-	return &pb.PutResponse{}, nil
+	user := owner(ctx)
+	err = retry.DoTx(ctx, s.db, func(ctx context.Context, tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, s.queries.getBatch, sql.Named("hashes", types.ListValue(hashes...)))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		// used are links which hold hashes, link of another owner collides
+		// with any url
+		used := make(map[string]string, len(hashes))
+		// stored are creation times of links of user already stored, the
+		// same link stored again keeps it
+		stored := make(map[string]*time.Time, len(hashes))
+		for rows.Next() {
+			var (
+				hash, url, linkOwner sql.NullString
+				deadline, createdAt  sql.NullTime
+			)
+			if err = rows.Scan(&hash, &url, &deadline, &linkOwner, &createdAt); err != nil {
+				return err
+			}
+			if deadline.Valid && deadline.Time.Before(time.Now()) {
+				// expired link releases its hash
+				continue
+			}
+			if linkOwner.String != user {
+				url.String = ""
+			} else if createdAt.Valid {
+				stored[hash.String] = &createdAt.Time
+			} else {
+				stored[hash.String] = nil
+			}
+			used[hash.String] = url.String
+		}
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		results := make([]*pb.PutResult, 0, len(request.GetLinks()))
+		links := make([]types.Value, 0, len(request.GetLinks()))
+		added := 0
+		now := time.Now()
+		for _, l := range request.GetLinks() {
+			url, ok := used[l.GetHash()]
+			if ok && url != l.GetUrl() {
+				results = append(results, &pb.PutResult{
+					Hash:      l.GetHash(),
+					Collision: true,
+				})
+				continue
+			}
+			result := &pb.PutResult{
+				Hash: l.GetHash(),
+			}
+			results = append(results, result)
+			createdAt, isStored := stored[l.GetHash()]
+			if ok && !isStored {
+				// same link met earlier in this batch
+				continue
+			}
+			// link already stored is upserted once, with new expiration and
+			// its creation time
+			delete(stored, l.GetHash())
+			used[l.GetHash()] = l.GetUrl()
+			if !isStored {
+				createdAt = &now
+				added++
+				result.Created = true
+			}
+			var expiresAt *time.Time
+			if l.GetExpiresAt() != nil {
+				t := l.GetExpiresAt().AsTime()
+				expiresAt = &t
+			}
+			links = append(links, types.StructValue(
+				types.StructFieldValue("hash", types.TextValue(l.GetHash())),
+				types.StructFieldValue("url", types.TextValue(l.GetUrl())),
+				types.StructFieldValue("expires_at", types.NullableTimestampValueFromTime(expiresAt)),
+				types.StructFieldValue("user_id", types.NullableTextValue(nullableOwner(user))),
+				types.StructFieldValue("created_at", types.NullableTimestampValueFromTime(createdAt)),
+			))
+		}
+		n, err := s.count(ctx, tx, user)
+		if err != nil {
+			return err
+		}
+		// whole batch is rejected if its new links do not fit into quota
+		if err = checkQuota(ctx, user, s.quota, n, added); err != nil {
+			return err
+		}
+		if len(links) > 0 {
+			_, err = tx.ExecContext(ctx, s.queries.putBatch, sql.Named("links", types.ListValue(links...)))
+			if err != nil {
+				return err
+			}
+		}
+		response = &pb.BatchPutResponse{
+			Results: results,
+		}
+		return nil
+	}, retry.WithDoTxRetryOptions(retry.WithIdempotent(true)))
+	if err != nil {
+		return nil, ownerStatus(err)
+	}
+	return response, nil
+}
+
+// PutStream stores streamed links by batches of BatchPut
+func (s *storage) PutStream(stream pb.Storage_PutStreamServer) error {
+	return putStream(stream, s.BatchPut)
+}
+
+// Import loads streamed links by BulkUpsert
+func (s *storage) Import(stream pb.Storage_ImportServer) error {
+	db, err := ydb.Unwrap(s.db)
+	if err != nil {
+		return err
+	}
+	return bulkImport(stream, db, s.table)
 }
 
 func (s *storage) Get(ctx context.Context, request *pb.GetRequest) (response *pb.GetResponse, err error) {
@@ -69,38 +309,330 @@ func (s *storage) Get(ctx context.Context, request *pb.GetRequest) (response *pb
 		}
 		span.End()
 	}()
+	scan := func(row *sql.Row) error {
+		link, err := scanLink(row)
+		if errors.Is(err, sql.ErrNoRows) {
+			// non-retryable error
+			return fmt.Errorf("url for hash '%s': %w", request.GetHash(), errNotFound)
+		}
+		if err != nil {
+			return err
+		}
+		if link.url == nil {
+			// non-retryable error
+			return fmt.Errorf("url for hash '%s': %w", request.GetHash(), errNotFound)
+		}
+		response = &pb.GetResponse{
+			Url:      *link.url,
+			Owner:    ownerOf(link.user),
+			Metadata: link.metadata(),
+		}
+		if link.deadline != nil {
+			response.ExpiresAt = timestamppb.New(*link.deadline)
+		}
+		if link.createdAt != nil {
+			response.CreatedAt = timestamppb.New(*link.createdAt)
+		}
+		return nil
+	}
+	if isStale(request.GetConsistency(), s.consistency) {
+		span.SetAttributes(attribute.Bool("stale", true))
+		// single query in stale read-only transaction instead of interactive one
+		err = retry.Do(ctx, s.db, func(ctx context.Context, cc *sql.Conn) error {
+			return scan(cc.QueryRowContext(ydb.WithTxControl(ctx, table.StaleReadOnlyTxControl()),
+				s.queries.get, sql.Named("hash", request.GetHash()),
+			))
+		}, retry.WithDoRetryOptions(retry.WithIdempotent(true)))
+		return response, err
+	}
+	err = retry.DoTx(ctx, s.db, func(ctx context.Context, tx *sql.Tx) error {
+		return scan(tx.QueryRowContext(ctx, s.queries.get, sql.Named("hash", request.GetHash())))
+	}, retry.WithDoTxRetryOptions(retry.WithIdempotent(true)))
+	return response, err
+}
+
+// GetByURL looks link up by url with secondary index. Url may be shortened
+// more than once, any not expired link of calling user is returned then.
+func (s *storage) GetByURL(ctx context.Context, request *pb.GetByURLRequest) (response *pb.GetByURLResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "GetByURL", trace.WithAttributes(
+		attribute.String("url", request.GetUrl()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("get by url done", trace.WithAttributes(
+				attribute.String("hash", response.GetHash()),
+			))
+		}
+		span.End()
+	}()
+	user := owner(ctx)
+	err = retry.DoTx(ctx, s.db, func(ctx context.Context, tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, s.queries.getByURL, sql.Named("url", request.GetUrl()))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		response = nil
+		for rows.Next() {
+			var (
+				hash, linkOwner sql.NullString
+				expiresAt       sql.NullTime
+			)
+			if err = rows.Scan(&hash, &expiresAt, &linkOwner); err != nil {
+				return err
+			}
+			if expiresAt.Valid && expiresAt.Time.Before(time.Now()) {
+				continue
+			}
+			if user != "" && linkOwner.String != user {
+				continue
+			}
+			response = &pb.GetByURLResponse{
+				Hash: hash.String,
+			}
+			if expiresAt.Valid {
+				response.ExpiresAt = timestamppb.New(expiresAt.Time)
+			}
+			break
+		}
+		return rows.Err()
+	}, retry.WithDoTxRetryOptions(retry.WithIdempotent(true)))
+	if err != nil {
+		return nil, err
+	}
+	if response == nil {
+		return nil, fmt.Errorf("link to url '%s': %w", request.GetUrl(), errNotFound)
+	}
+	return response, nil
+}
+
+func (s *storage) Delete(ctx context.Context, request *pb.DeleteRequest) (response *pb.DeleteResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "Delete", trace.WithAttributes(
+		attribute.String("hash", request.GetHash()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("delete done")
+		}
+		span.End()
+	}()
+	user := owner(ctx)
+	err = retry.DoTx(ctx, s.db, func(ctx context.Context, tx *sql.Tx) (err error) {
+		if user != "" {
+			link, err := scanLink(tx.QueryRowContext(ctx, s.queries.get, sql.Named("hash", request.GetHash())))
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err = checkOwner(request.GetHash(), user, ownerOf(link.user)); err != nil {
+				// non-retryable error
+				return err
+			}
+		}
+		_, err = tx.ExecContext(ctx, s.queries.delete, sql.Named("hash", request.GetHash()))
+		return err
+	}, retry.WithDoTxRetryOptions(retry.WithIdempotent(true)))
+	if err != nil {
+		return nil, ownerStatus(err)
+	}
+	return &pb.DeleteResponse{}, nil
+}
+
+func (s *storage) List(ctx context.Context, request *pb.ListRequest) (response *pb.ListResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "List", trace.WithAttributes(
+		attribute.Int64("page_size", int64(request.GetPageSize())),
+		attribute.String("page_token", request.GetPageToken()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("list done", trace.WithAttributes(
+				attribute.Int("count", len(response.GetLinks())),
+				attribute.String("next_page_token", response.GetNextPageToken()),
+			))
+		}
+		span.End()
+	}()
+	pageSize := uint64(request.GetPageSize())
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	} else if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	query, args := s.queries.list, []interface{}{sql.Named("token", request.GetPageToken()), sql.Named("limit", pageSize)}
+	if user := owner(ctx); user != "" {
+		query, args = s.queries.listByUser, append(args, sql.Named("user_id", user))
+	}
 	err = retry.DoTx(ctx, s.db, func(ctx context.Context, tx *sql.Tx) error {
-		row := tx.QueryRowContext(ctx, fmt.Sprintf(`
-			PRAGMA TablePathPrefix("%s");
+		rows, err := tx.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		response = &pb.ListResponse{}
+		for rows.Next() {
+			var (
+				hash, url         sql.NullString
+				title, faviconURL *string
+			)
+			if err = rows.Scan(&hash, &url, &title, &faviconURL); err != nil {
+				return err
+			}
+			response.Links = append(response.Links, &pb.Link{
+				Hash:     hash.String,
+				Url:      url.String,
+				Metadata: metadataOf(title, faviconURL),
+			})
+		}
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		if uint64(len(response.Links)) == pageSize {
+			response.NextPageToken = response.Links[len(response.Links)-1].GetHash()
+		}
+		return nil
+	}, retry.WithDoTxRetryOptions(retry.WithIdempotent(true)))
+	return response, err
+}
 
-			DECLARE $hash AS Text;
+// SetMetadata updates link only if it still points to url, link replaced or
+// deleted since metadata was fetched is left as is
+func (s *storage) SetMetadata(ctx context.Context, request *pb.SetMetadataRequest) (response *pb.SetMetadataResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "SetMetadata", trace.WithAttributes(
+		attribute.String("hash", request.GetHash()),
+		attribute.String("url", request.GetUrl()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("set metadata done")
+		}
+		span.End()
+	}()
+	err = retry.DoTx(ctx, s.db, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, s.queries.setMetadata,
+			sql.Named("hash", request.GetHash()),
+			sql.Named("url", request.GetUrl()),
+			sql.Named("title", request.GetMetadata().GetTitle()),
+			sql.Named("favicon_url", request.GetMetadata().GetFaviconUrl()),
+		)
+		return err
+	}, retry.WithDoTxRetryOptions(retry.WithIdempotent(true)))
+	if err != nil {
+		return nil, err
+	}
+	return &pb.SetMetadataResponse{}, nil
+}
 
-			SELECT url FROM urls WHERE hash = $hash; 
-		`, s.prefix), sql.Named("hash", request.GetHash()))
-		var url sql.NullString
-		if err := row.Scan(&url); err != nil {
+func (s *storage) PutAlias(ctx context.Context, request *pb.PutAliasRequest) (response *pb.PutAliasResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "PutAlias", trace.WithAttributes(
+		attribute.String("alias", request.GetAlias()),
+		attribute.String("hash", request.GetHash()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("put alias done")
+		}
+		span.End()
+	}()
+	user := owner(ctx)
+	err = retry.DoTx(ctx, s.db, func(ctx context.Context, tx *sql.Tx) error {
+		link, err := scanLink(tx.QueryRowContext(ctx, s.queries.get, sql.Named("hash", request.GetHash())))
+		if errors.Is(err, sql.ErrNoRows) || err == nil && link.url == nil {
+			// non-retryable error
+			return fmt.Errorf("url for hash '%s': %w", request.GetHash(), errNotFound)
+		}
+		if err != nil {
 			return err
 		}
-		if !url.Valid {
+		if err = checkAliasOwner(request.GetHash(), user, ownerOf(link.user)); err != nil {
 			// non-retryable error
-			return fmt.Errorf("url for hash '%s' not found", request.GetHash())
+			return err
 		}
-		response = &pb.GetResponse{
-			Url: url.String,
+		_, err = tx.ExecContext(ctx, s.queries.putAlias,
+			sql.Named("user_id", user),
+			sql.Named("alias", request.GetAlias()),
+			sql.Named("hash", request.GetHash()),
+		)
+		return err
+	}, retry.WithDoTxRetryOptions(retry.WithIdempotent(true)))
+	if err != nil {
+		return nil, ownerStatus(err)
+	}
+	return &pb.PutAliasResponse{}, nil
+}
+
+func (s *storage) GetByAlias(ctx context.Context, request *pb.GetByAliasRequest) (response *pb.GetByAliasResponse, err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "GetByAlias", trace.WithAttributes(
+		attribute.String("user", request.GetUser()),
+		attribute.String("alias", request.GetAlias()),
+	))
+	defer func() {
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("get by alias done", trace.WithAttributes(
+				attribute.String("hash", response.GetHash()),
+				attribute.String("url", response.GetUrl()),
+			))
 		}
-		return row.Err()
+		span.End()
+	}()
+	err = retry.DoTx(ctx, s.db, func(ctx context.Context, tx *sql.Tx) error {
+		var (
+			hash, url string
+			expiresAt sql.NullTime
+		)
+		err := tx.QueryRowContext(ctx, s.queries.getByAlias,
+			sql.Named("user_id", request.GetUser()),
+			sql.Named("alias", request.GetAlias()),
+		).Scan(&hash, &url, &expiresAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			// non-retryable error
+			return aliasNotFound(request.GetUser(), request.GetAlias())
+		}
+		if err != nil {
+			return err
+		}
+		response = &pb.GetByAliasResponse{
+			Hash: hash,
+			Url:  url,
+		}
+		if expiresAt.Valid {
+			response.ExpiresAt = timestamppb.New(expiresAt.Time)
+		}
+		return nil
 	}, retry.WithDoTxRetryOptions(retry.WithIdempotent(true)))
 	return response, err
 }
 
-func initSchema(ctx context.Context, db *sql.DB, prefix string) (err error) {
-	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "initSchema")
+// initTTL makes YDB purge links ttl after their expiration, negative ttl
+// disables purge. Expired links are not served anyway, purge only frees space.
+// Links without expiration have NULL expires_at and are never purged.
+func initTTL(ctx context.Context, db *sql.DB, table ydbTable, ttl time.Duration) (err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "initTTL", trace.WithAttributes(
+		attribute.String("ttl", ttl.String()),
+	))
 	defer func() {
 		if err != nil {
 			span.SetAttributes(attribute.Bool("error", true))
 			span.RecordError(err)
-		} else {
-			span.AddEvent("schema prepared")
 		}
 		span.End()
 	}()
@@ -116,36 +648,102 @@ func initSchema(ctx context.Context, db *sql.DB, prefix string) (err error) {
 		}
 		defer s.Close(ctx)
 
-		_, err = s.DescribeTable(ctx, path.Join(prefix, "urls"))
-		if err == nil {
+		tablePath := table.path()
+		desc, err := s.DescribeTable(ctx, tablePath)
+		if err != nil {
+			return err
+		}
+
+		var opt options.AlterTableOption
+		switch current := desc.TimeToLiveSettings; {
+		case ttl < 0 && current == nil:
 			return nil
+		case ttl < 0:
+			opt = options.WithDropTimeToLive()
+		default:
+			settings := options.NewTTLSettings().ColumnDateType("expires_at").ExpireAfter(ttl)
+			if current != nil && current.ColumnName == settings.ColumnName &&
+				current.Mode == settings.Mode && current.ExpireAfterSeconds == settings.ExpireAfterSeconds {
+				return nil
+			}
+			opt = options.WithSetTimeToLiveSettings(settings)
+		}
+		if err = s.AlterTable(ctx, tablePath, opt); err != nil {
+			return err
 		}
+		span.AddEvent("ttl altered")
+		return nil
+	}, retry.WithDoRetryOptions(retry.WithIdempotent(true)))
+}
 
-		_, err = cc.ExecContext(
-			ydb.WithQueryMode(ctx, ydb.SchemeQueryMode),
-			fmt.Sprintf(`
-				PRAGMA TablePathPrefix("%s");
-
-				CREATE TABLE urls (
-					hash Text,
-					url Text,
-					PRIMARY KEY (
-						hash
-					)
-				) WITH (
-					AUTO_PARTITIONING_BY_LOAD = ENABLED
-				);
-			`, prefix),
-		)
+// tableSize returns estimates of rows and size of links table which YDB keeps
+// in table statistics, they lag behind recent changes
+func tableSize(ctx context.Context, db *sql.DB, table ydbTable) (rows, bytes uint64, err error) {
+	err = retry.Do(ctx, db, func(ctx context.Context, cc *sql.Conn) error {
+		db, err := ydb.Unwrap(cc)
+		if err != nil {
+			return err
+		}
+
+		s, err := db.Table().CreateSession(ctx)
+		if err != nil {
+			return err
+		}
+		defer s.Close(ctx)
+
+		desc, err := s.DescribeTable(ctx, table.path(), options.WithTableStats())
 		if err != nil {
-			_, _ = fmt.Fprintf(os.Stderr, "create urls table failed: %v", err)
 			return err
 		}
+		if desc.Stats != nil {
+			rows, bytes = desc.Stats.RowsEstimate, desc.Stats.StoreSize
+		}
 		return nil
 	}, retry.WithDoRetryOptions(retry.WithIdempotent(true)))
+	return rows, bytes, err
 }
 
-func newStorage(ctx context.Context, db *sql.DB, prefix string) (_ *storage, err error) {
+// purgeDeleted hard-deletes links soft deleted before given time by batches
+// of maintenanceBatchSize, each batch in its own transaction
+func purgeDeleted(ctx context.Context, db *sql.DB, q queries, before time.Time) (purged uint64, err error) {
+	for {
+		var n int
+		err = retry.DoTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+			rows, err := tx.QueryContext(ctx, q.purge, sql.Named("before", before), sql.Named("limit", uint64(maintenanceBatchSize)))
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			hashes := make([]types.Value, 0, maintenanceBatchSize)
+			for rows.Next() {
+				var hash string
+				if err = rows.Scan(&hash); err != nil {
+					return err
+				}
+				hashes = append(hashes, types.TextValue(hash))
+			}
+			if err = rows.Err(); err != nil {
+				return err
+			}
+			n = len(hashes)
+			if n == 0 {
+				return nil
+			}
+			_, err = tx.ExecContext(ctx, q.deleteBatch, sql.Named("hashes", types.ListValue(hashes...)))
+			return err
+		}, retry.WithDoTxRetryOptions(retry.WithIdempotent(true)))
+		if err != nil {
+			return purged, err
+		}
+		purged += uint64(n)
+		batchRemoved(ctx, n)
+		if n < maintenanceBatchSize {
+			return purged, nil
+		}
+	}
+}
+
+func newStorage(ctx context.Context, db *sql.DB, table ydbTable, ttl time.Duration, recreate bool, consistency pb.Consistency, quota int) (_ *storage, err error) {
 	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(ctx, "newStorage")
 	defer func() {
 		if err != nil {
@@ -155,12 +753,19 @@ func newStorage(ctx context.Context, db *sql.DB, prefix string) (_ *storage, err
 		span.End()
 	}()
 
-	if err = initSchema(ctx, db, prefix); err != nil {
+	if err = initSchema(ctx, db, table, recreate); err != nil {
+		return nil, err
+	}
+
+	if err = initTTL(ctx, db, table, ttl); err != nil {
 		return nil, err
 	}
 
 	return &storage{
-		db:     db,
-		prefix: prefix,
+		db:          db,
+		table:       table,
+		queries:     newQueries(table),
+		consistency: consistency,
+		quota:       quota,
 	}, nil
 }
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	pb "github.com/asmyasnikov/webinar-jaeger/server/pb"
+)
+
+// streamBatchSize is number of links stored by one BatchPut of PutStream
+const streamBatchSize = 500
+
+type batchPutFunc func(ctx context.Context, request *pb.BatchPutRequest) (*pb.BatchPutResponse, error)
+
+// putStream receives links from client and stores them with batchPut by
+// streamBatchSize, so an import takes one transaction per batch instead of
+// one per link. Batches stored before a failure are not rolled back.
+func putStream(stream pb.Storage_PutStreamServer, batchPut batchPutFunc) (err error) {
+	ctx, span := otel.GetTracerProvider().Tracer(applicationID).Start(stream.Context(), "PutStream")
+	var (
+		links   = make([]*pb.PutRequest, 0, streamBatchSize)
+		results []*pb.PutResult
+		batches int
+	)
+	defer func() {
+		span.SetAttributes(
+			attribute.Int("count", len(results)+len(links)),
+			attribute.Int("batches", batches),
+		)
+		if err != nil {
+			span.SetAttributes(attribute.Bool("error", true))
+			span.RecordError(err)
+		} else {
+			span.AddEvent("put stream done")
+		}
+		span.End()
+	}()
+
+	flush := func() error {
+		if len(links) == 0 {
+			return nil
+		}
+		response, err := batchPut(ctx, &pb.BatchPutRequest{
+			Links: links,
+		})
+		if err != nil {
+			return err
+		}
+		batches++
+		results = append(results, response.GetResults()...)
+		links = make([]*pb.PutRequest, 0, streamBatchSize)
+		span.AddEvent("batch stored", trace.WithAttributes(
+			attribute.Int("stored", len(results)),
+		))
+		return nil
+	}
+
+	for {
+		link, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		links = append(links, link)
+		if len(links) == streamBatchSize {
+			if err = flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err = flush(); err != nil {
+		return err
+	}
+
+	return stream.SendAndClose(&pb.BatchPutResponse{
+		Results: results,
+	})
+}